@@ -0,0 +1,310 @@
+// Package bench compares CBE against other ways of framing
+// sequences of byte strings,
+// in terms of both encoded size and encoding/decoding speed.
+//
+// The package defines a small Codec interface and a set of
+// representative Datasets.
+// Codecs for the standard library's encoding/gob and encoding/json,
+// for protobuf-style varint length-prefixed framing,
+// and for CBOR byte strings and MessagePack bin items are included;
+// codecs for other formats can be plugged in by implementing Codec.
+//
+// Run the speed comparison with "go test -bench . ./bench",
+// and print a size comparison with "go test -v -run Sizes ./bench".
+//
+// Early unstable prototype code.
+//
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"text/tabwriter"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Codec frames a sequence of byte-string records into one buffer.
+type Codec interface {
+	Name() string
+	Encode(dst []byte, recs [][]byte) ([]byte, error)
+	Decode(buf []byte) ([][]byte, error)
+}
+
+// Dataset is a named sequence of records to encode.
+type Dataset struct {
+	Name string
+	Recs [][]byte
+}
+
+// The codecs included in this package.
+var Codecs = []Codec{CBE{}, Varint{}, CBOR{}, Msgpack{}, Gob{}, JSON{}}
+
+// Returns a set of representative datasets,
+// generated deterministically so that results are comparable across runs.
+func Datasets() []Dataset {
+	rnd := rand.New(rand.NewSource(1))
+	gen := func(name string, n int, size func() int) Dataset {
+		ds := Dataset{Name: name}
+		for i := 0; i < n; i++ {
+			b := make([]byte, size())
+			rnd.Read(b)
+			ds.Recs = append(ds.Recs, b)
+		}
+		return ds
+	}
+
+	// Small integers as minimal big-endian byte strings
+	ints := Dataset{Name: "integers"}
+	for i := 0; i < 10000; i++ {
+		v := rnd.Uint64() >> uint(rnd.Intn(64))
+		b := binary.BigEndian.AppendUint64(nil, v)
+		for len(b) > 0 && b[0] == 0 { // trim leading 0 bytes
+			b = b[1:]
+		}
+		ints.Recs = append(ints.Recs, b)
+	}
+
+	// Short ASCII strings such as map keys and identifiers
+	words := Dataset{Name: "words"}
+	for i := 0; i < 10000; i++ {
+		w := make([]byte, 1+rnd.Intn(12))
+		for j := range w {
+			w[j] = byte('a' + rnd.Intn(26))
+		}
+		words.Recs = append(words.Recs, w)
+	}
+
+	return []Dataset{
+		ints, words,
+		gen("records", 1000, func() int { return 20 + rnd.Intn(500) }),
+		gen("large", 4, func() int { return 1 << 20 }),
+	}
+}
+
+// Write a table comparing the encoded sizes of each dataset
+// under each codec to w.
+func WriteSizes(w io.Writer, codecs []Codec, datasets []Dataset) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "dataset\traw\t")
+	for _, c := range codecs {
+		fmt.Fprintf(tw, "%s\t", c.Name())
+	}
+	fmt.Fprintln(tw)
+	for _, ds := range datasets {
+		raw := 0
+		for _, r := range ds.Recs {
+			raw += len(r)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t", ds.Name, raw)
+		for _, c := range codecs {
+			b, err := c.Encode(nil, ds.Recs)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "%d\t", len(b))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// CBE frames each record as a CBE blob.
+type CBE struct{}
+
+func (CBE) Name() string { return "cbe" }
+
+func (CBE) Encode(dst []byte, recs [][]byte) ([]byte, error) {
+	for _, r := range recs {
+		dst = cbe.Encode(dst, r)
+	}
+	return dst, nil
+}
+
+func (CBE) Decode(buf []byte) (recs [][]byte, err error) {
+	for len(buf) > 0 {
+		var r []byte
+		if r, buf, err = cbe.Decode(buf); err != nil {
+			return nil, err
+		}
+		recs = append(recs, r)
+	}
+	return recs, nil
+}
+
+// Varint frames each record with a varint length prefix,
+// as in protobuf's length-delimited fields.
+type Varint struct{}
+
+func (Varint) Name() string { return "varint" }
+
+func (Varint) Encode(dst []byte, recs [][]byte) ([]byte, error) {
+	for _, r := range recs {
+		dst = binary.AppendUvarint(dst, uint64(len(r)))
+		dst = append(dst, r...)
+	}
+	return dst, nil
+}
+
+func (Varint) Decode(buf []byte) (recs [][]byte, err error) {
+	for len(buf) > 0 {
+		n, l := binary.Uvarint(buf)
+		if l <= 0 || n > uint64(len(buf)-l) {
+			return nil, errors.New("invalid varint framing")
+		}
+		buf = buf[l:]
+		recs = append(recs, buf[:n])
+		buf = buf[n:]
+	}
+	return recs, nil
+}
+
+// CBOR frames each record as a CBOR byte string (major type 2)
+// with the shortest length encoding, as CBOR's deterministic encoding
+// requires.
+type CBOR struct{}
+
+func (CBOR) Name() string { return "cbor" }
+
+func (CBOR) Encode(dst []byte, recs [][]byte) ([]byte, error) {
+	for _, r := range recs {
+		n := uint64(len(r))
+		switch {
+		case n < 24:
+			dst = append(dst, 0x40|byte(n))
+		case n <= 0xff:
+			dst = append(dst, 0x58, byte(n))
+		case n <= 0xffff:
+			dst = binary.BigEndian.AppendUint16(append(dst, 0x59),
+				uint16(n))
+		case n <= 0xffffffff:
+			dst = binary.BigEndian.AppendUint32(append(dst, 0x5a),
+				uint32(n))
+		default:
+			dst = binary.BigEndian.AppendUint64(append(dst, 0x5b), n)
+		}
+		dst = append(dst, r...)
+	}
+	return dst, nil
+}
+
+func (CBOR) Decode(buf []byte) (recs [][]byte, err error) {
+	for len(buf) > 0 {
+		c := buf[0]
+		buf = buf[1:]
+		var n uint64
+		switch info := c & 0x1f; {
+		case c>>5 != 2:
+			return nil, errCBOR
+		case info < 24:
+			n = uint64(info)
+		case info <= 27:
+			l := 1 << (info - 24)
+			if len(buf) < l {
+				return nil, errCBOR
+			}
+			var b8 [8]byte
+			copy(b8[8-l:], buf)
+			n, buf = binary.BigEndian.Uint64(b8[:]), buf[l:]
+		default:
+			return nil, errCBOR
+		}
+		if n > uint64(len(buf)) {
+			return nil, errCBOR
+		}
+		recs = append(recs, buf[:n])
+		buf = buf[n:]
+	}
+	return recs, nil
+}
+
+// Msgpack frames each record as a MessagePack bin item
+// with the shortest length encoding.
+type Msgpack struct{}
+
+func (Msgpack) Name() string { return "msgpack" }
+
+func (Msgpack) Encode(dst []byte, recs [][]byte) ([]byte, error) {
+	for _, r := range recs {
+		n := len(r)
+		switch {
+		case n <= 0xff:
+			dst = append(dst, 0xc4, byte(n))
+		case n <= 0xffff:
+			dst = binary.BigEndian.AppendUint16(append(dst, 0xc5),
+				uint16(n))
+		case uint64(n) <= 0xffffffff:
+			dst = binary.BigEndian.AppendUint32(append(dst, 0xc6),
+				uint32(n))
+		default:
+			return nil, errors.New("record too long for MessagePack")
+		}
+		dst = append(dst, r...)
+	}
+	return dst, nil
+}
+
+func (Msgpack) Decode(buf []byte) (recs [][]byte, err error) {
+	for len(buf) > 0 {
+		c := buf[0]
+		buf = buf[1:]
+		if c < 0xc4 || c > 0xc6 {
+			return nil, errMsgpack
+		}
+		l := 1 << (c - 0xc4)
+		if len(buf) < l {
+			return nil, errMsgpack
+		}
+		var b4 [4]byte
+		copy(b4[4-l:], buf)
+		n := uint64(binary.BigEndian.Uint32(b4[:]))
+		buf = buf[l:]
+		if n > uint64(len(buf)) {
+			return nil, errMsgpack
+		}
+		recs = append(recs, buf[:n])
+		buf = buf[n:]
+	}
+	return recs, nil
+}
+
+// Gob encodes the records as a [][]byte with encoding/gob.
+type Gob struct{}
+
+func (Gob) Name() string { return "gob" }
+
+func (Gob) Encode(dst []byte, recs [][]byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	err := gob.NewEncoder(buf).Encode(recs)
+	return buf.Bytes(), err
+}
+
+func (Gob) Decode(buf []byte) (recs [][]byte, err error) {
+	err = gob.NewDecoder(bytes.NewReader(buf)).Decode(&recs)
+	return recs, err
+}
+
+// JSON encodes the records as an array of base64 strings
+// with encoding/json.
+type JSON struct{}
+
+func (JSON) Name() string { return "json" }
+
+func (JSON) Encode(dst []byte, recs [][]byte) ([]byte, error) {
+	b, err := json.Marshal(recs)
+	return append(dst, b...), err
+}
+
+func (JSON) Decode(buf []byte) (recs [][]byte, err error) {
+	err = json.Unmarshal(buf, &recs)
+	return recs, err
+}
+
+var errCBOR = errors.New("invalid CBOR byte-string framing")
+var errMsgpack = errors.New("invalid MessagePack bin framing")
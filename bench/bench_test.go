@@ -0,0 +1,102 @@
+package bench
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/conv"
+)
+
+var datasets = Datasets()
+
+// The CBOR and MessagePack codecs must produce items
+// that package conv converts to the same blobs as the CBE codec.
+func TestInterop(t *testing.T) {
+	for _, c := range []struct {
+		codec Codec
+		conv  func(*cbe.Encoder, io.Reader, bool) error
+	}{
+		{CBOR{}, conv.CBORToCBE},
+		{Msgpack{}, conv.MsgpackToCBE},
+	} {
+		for _, ds := range datasets {
+			in, _ := c.codec.Encode(nil, ds.Recs)
+			want, _ := CBE{}.Encode(nil, ds.Recs)
+			var out bytes.Buffer
+			err := c.conv(cbe.NewEncoder(&out), bytes.NewReader(in), true)
+			if err != nil || !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("%s %s: converted to %v bytes, %v",
+					c.codec.Name(), ds.Name, out.Len(), err)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, ds := range datasets {
+		for _, c := range Codecs {
+			b, err := c.Encode(nil, ds.Recs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recs, err := c.Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != len(ds.Recs) {
+				t.Fatalf("%s %s: decoded %v records", c.Name(),
+					ds.Name, len(recs))
+			}
+			for i := range recs {
+				if !bytes.Equal(recs[i], ds.Recs[i]) {
+					t.Errorf("%s %s: wrong record %v",
+						c.Name(), ds.Name, i)
+				}
+			}
+		}
+	}
+}
+
+func TestSizes(t *testing.T) {
+	if testing.Verbose() {
+		if err := WriteSizes(os.Stdout, Codecs, datasets); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, ds := range datasets {
+		for _, c := range Codecs {
+			b.Run(ds.Name+"/"+c.Name(), func(b *testing.B) {
+				var buf []byte
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					buf, _ = c.Encode(buf[:0], ds.Recs)
+				}
+				b.SetBytes(int64(len(buf)))
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, ds := range datasets {
+		for _, c := range Codecs {
+			buf, err := c.Encode(nil, ds.Recs)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(ds.Name+"/"+c.Name(), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(buf)))
+				for i := 0; i < b.N; i++ {
+					c.Decode(buf)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package cbe
+
+import (
+	"io"
+)
+
+// Allocator allocates the buffers a Decoder uses for decoded content.
+type Allocator interface {
+
+	// Alloc returns a byte slice of length n.
+	Alloc(n int) []byte
+}
+
+// Make the Decoder allocate the content buffers it returns via a,
+// or via the Go heap as usual if a is nil.
+func (d *Decoder) SetAllocator(a Allocator) {
+	d.alloc = a
+}
+
+// Decode a blob into a byte slice allocated via the Decoder's Allocator.
+// Reads content directly into the destination buffer,
+// growing it at most MinChunkLen bytes ahead of the content read so far,
+// so a truncated blob cannot claim more memory than its header declares
+// and allocating exactly once for small single-chunk blobs.
+func (d *Decoder) allocBytes() ([]byte, error) {
+	buf := []byte{}
+	for first := true; ; first = false {
+		n, part, err := d.chunk(int64(len(buf)))
+		if err != nil {
+			if !first {
+				err = d.truncated(err)
+			}
+			return nil, err
+		}
+		for n > 0 {
+			l := n
+			if l > MinChunkLen {
+				l = MinChunkLen
+			}
+
+			// Grow the buffer if needed, at least doubling its size
+			// but not beyond the end of the final chunk
+			start := len(buf)
+			if start+l > cap(buf) {
+				c := 2 * cap(buf)
+				if c < start+l {
+					c = start + l
+				}
+				if !part && c > start+n {
+					c = start + n
+				}
+				nbuf := d.alloc.Alloc(c)
+				copy(nbuf, buf)
+				buf = nbuf[:start]
+			}
+
+			buf = buf[:start+l]
+			if _, err := io.ReadFull(d.r, buf[start:]); err != nil {
+				return nil, d.truncated(err)
+			}
+			n -= l
+		}
+		if !part {
+			return buf, nil
+		}
+	}
+}
+
+// Arena is an Allocator that carves many small allocations
+// out of a few large blocks,
+// so that all the content decoded while serving one request, for example,
+// can be released together in one operation.
+//
+// An Arena is not safe for concurrent use by multiple goroutines.
+//
+type Arena struct {
+	blockLen int
+	blocks   [][]byte // regular blocks allocated so far
+	large    [][]byte // dedicated blocks for large allocations
+	cur      int      // number of regular blocks in use
+	free     []byte   // unallocated remainder of the current block
+}
+
+// Default block length used by an Arena.
+const DefaultArenaBlockLen = 64 * 1024
+
+// Create a new Arena that allocates memory in blocks of blockLen bytes,
+// or DefaultArenaBlockLen if blockLen is not positive.
+// Allocations larger than a quarter of the block length
+// get their own dedicated blocks.
+func NewArena(blockLen int) *Arena {
+	if blockLen <= 0 {
+		blockLen = DefaultArenaBlockLen
+	}
+	return &Arena{blockLen: blockLen}
+}
+
+// Allocate a byte slice of length n from the arena.
+func (a *Arena) Alloc(n int) []byte {
+	if n > a.blockLen/4 { // large allocation: dedicated block
+		b := make([]byte, n)
+		a.large = append(a.large, b)
+		return b
+	}
+	if n > len(a.free) { // move on to the next block
+		if a.cur < len(a.blocks) {
+			a.free = a.blocks[a.cur]
+			clear(a.free)
+		} else {
+			a.free = make([]byte, a.blockLen)
+			a.blocks = append(a.blocks, a.free)
+		}
+		a.cur++
+	}
+	b := a.free[:n:n]
+	a.free = a.free[n:]
+	return b
+}
+
+// Reset the arena, making all of its memory available for reuse
+// by subsequent allocations.
+// The caller must no longer use any slice previously allocated from it.
+func (a *Arena) Reset() {
+	a.large = nil
+	a.cur = 0
+	a.free = nil
+}
+
+// Free all of the arena's memory, releasing it to the garbage collector.
+// The caller must no longer use any slice previously allocated from it.
+func (a *Arena) Free() {
+	a.blocks = nil
+	a.Reset()
+}
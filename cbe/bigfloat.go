@@ -0,0 +1,164 @@
+//go:build !tinygo && !cbe_tiny
+
+package cbe
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// Rationals and arbitrary-precision floats are encoded as a nested blob
+// containing integer blobs as SignedInt and Int64 encode them.
+// A big.Rat's blob contains its numerator and its positive denominator,
+// in lowest terms.
+// A big.Float's blob contains a mantissa m and an exponent x,
+// such that the value is m times 2 to the power x,
+// with m odd or else both zero so that each value has one encoding,
+// followed by the unsigned precision of the big.Float in bits.
+// Infinities cannot be encoded, and negative zero encodes as zero.
+// A strict Decoder rejects rationals not in lowest terms
+// and floats with an even or zero mantissa other than zero itself.
+
+// Encode a rational number as a nested blob.
+func (e *Encoder) Rat(v *big.Rat) error {
+	return e.nested(func(ne *Encoder) error {
+		if err := ne.SignedInt(v.Num()); err != nil {
+			return err
+		}
+		return ne.UnsignedInt(v.Denom())
+	})
+}
+
+// Encode an arbitrary-precision float as a nested blob.
+// Returns an error if v is infinite.
+func (e *Encoder) Float(v *big.Float) error {
+	if v.IsInf() {
+		return errInfFloat
+	}
+	m, x := new(big.Int), 0
+	if v.Sign() != 0 {
+		p := int(v.MinPrec())
+		x = v.MantExp(nil) - p
+		new(big.Float).SetMantExp(v, -x).Int(m) // exact: m has p bits
+	}
+	return e.nested(func(ne *Encoder) error {
+		if err := ne.SignedInt(m); err != nil {
+			return err
+		}
+		if err := ne.Int64(int64(x)); err != nil {
+			return err
+		}
+		return ne.Uint64(uint64(v.Prec()))
+	})
+}
+
+// Encode the blobs that enc writes as the content of one blob.
+func (e *Encoder) nested(enc func(*Encoder) error) error {
+	var buf bytes.Buffer
+	if err := enc(NewEncoder(&buf)); err != nil {
+		return err
+	}
+	return e.Bytes(buf.Bytes())
+}
+
+// Decode a rational number encoded by Encoder.Rat into v.
+func (d *Decoder) Rat(v *big.Rat) error {
+	nd, err := d.nested()
+	if err != nil {
+		return err
+	}
+	num, den := new(big.Int), new(big.Int)
+	if err := nd.SignedInt(num); err != nil {
+		return truncated(err)
+	}
+	if err := nd.UnsignedInt(den); err != nil {
+		return truncated(err)
+	}
+	if err := nd.end(); err != nil {
+		return err
+	}
+	if den.Sign() == 0 {
+		return errBigSyntax
+	}
+	if d.strict && new(big.Int).GCD(nil, nil, num, den).Cmp(one) != 0 {
+		return errBigCanon
+	}
+	v.SetFrac(num, den)
+	return nil
+}
+
+// Decode an arbitrary-precision float encoded by Encoder.Float into v,
+// setting v's precision to the encoded precision.
+func (d *Decoder) Float(v *big.Float) error {
+	nd, err := d.nested()
+	if err != nil {
+		return err
+	}
+	m := new(big.Int)
+	if err := nd.SignedInt(m); err != nil {
+		return truncated(err)
+	}
+	x, err := nd.Int64()
+	if err != nil {
+		return truncated(err)
+	}
+	prec, err := nd.Uint64()
+	if err != nil {
+		return truncated(err)
+	}
+	if err := nd.end(); err != nil {
+		return err
+	}
+	if prec > big.MaxPrec || x < -1<<32 || x > 1<<32 {
+		return errRange
+	}
+	if uint64(m.BitLen()) > prec {
+		return errBigSyntax
+	}
+	if d.strict && (m.Sign() == 0 && x != 0 ||
+		m.Sign() != 0 && m.Bit(0) == 0) {
+		return errBigCanon
+	}
+	if m.Sign() == 0 {
+		v.SetInt64(0).SetPrec(uint(prec))
+		return nil
+	}
+	z := new(big.Float).SetInt(m)
+	z.SetMantExp(z, int(x))
+	if z.IsInf() || z.Sign() == 0 { // exponent out of big.Float's range
+		return errRange
+	}
+	v.SetPrec(uint(prec)).Set(z)
+	return nil
+}
+
+// Decode the next blob as a nested blob stream,
+// to be decoded with the same strictness as d.
+func (d *Decoder) nested() (*Decoder, error) {
+	b, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	nd := NewDecoder(bytes.NewReader(b))
+	nd.strict = d.strict
+	return nd, nil
+}
+
+// Check that a nested blob stream has been consumed entirely.
+func (d *Decoder) end() error {
+	if _, _, err := d.NextLen(); err != EOF {
+		return errBigSyntax
+	}
+	return nil
+}
+
+var one = big.NewInt(1)
+
+var errInfFloat = errors.New("cannot encode an infinite big.Float")
+var errBigSyntax = coerr.New(coerr.Syntax, "cbe", -1,
+	"invalid rational or float encoding")
+var errBigCanon = coerr.New(coerr.NonCanonical, "cbe", -1,
+	"rational or float not in canonical form")
@@ -0,0 +1,87 @@
+//go:build !tinygo && !cbe_tiny
+
+package cbe
+
+import (
+	"math/big"
+)
+
+var minusOne = big.NewInt(-1)
+
+// Encode the absolute value of a big.Int
+// as a big-endian unsigned integer blob.
+func (e *Encoder) UnsignedInt(v *big.Int) error {
+	return e.Bytes(v.Bytes())
+}
+
+// Encode a big.Int as a big-endian zigzag-encoded signed integer blob.
+func (e *Encoder) SignedInt(v *big.Int) error {
+	u := &big.Int{}
+	if v.Sign() >= 0 {
+		u.Lsh(v, 1)
+	} else {
+		u.Sub(minusOne, v)
+		u.Lsh(u, 1)
+		u.SetBit(u, 0, 1)
+	}
+	return e.Bytes(u.Bytes())
+}
+
+// Decode a blob as a big-endian unsigned integer,
+// placing its value into the designated big.Int.
+func (d *Decoder) UnsignedInt(v *big.Int) error {
+	b, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := d.minimal(b); err != nil {
+		return err
+	}
+	v.SetBytes(b)
+	return nil
+}
+
+// Decode a blob as a big-endian zigzag-encoded signed integer,
+// placing its value into the designated big.Int.
+func (d *Decoder) SignedInt(v *big.Int) error {
+	if err := d.UnsignedInt(v); err != nil {
+		return err
+	}
+
+	// pull the sign out of the least-significant bit
+	sign := v.Bit(0)
+
+	// zigzag-decode: 0 -> 0, 1 -> -1, 2 -> 1, 3 -> -2, etc.
+	v.Rsh(v, 1)
+	if sign != 0 {
+		v.Sub(minusOne, v)
+	}
+
+	return nil
+}
+
+// Marshal big.Int, big.Rat, and big.Float values for Marshal.
+func marshalBig(e *Encoder, v interface{}) error {
+	switch v := v.(type) {
+	case *big.Int:
+		return e.SignedInt(v)
+	case *big.Rat:
+		return e.Rat(v)
+	case *big.Float:
+		return e.Float(v)
+	}
+	return errUnsupported
+}
+
+// Unmarshal big.Int, big.Rat, and big.Float values for Unmarshal.
+func unmarshalBig(d *Decoder, v interface{}) error {
+	switch v := v.(type) {
+	case *big.Int:
+		return d.SignedInt(v)
+	case *big.Rat:
+		return d.Rat(v)
+	case *big.Float:
+		return d.Float(v)
+	}
+	return errUnsupported
+}
@@ -0,0 +1,121 @@
+//go:build !tinygo && !cbe_tiny
+
+package cbe
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/bford/cofo/coerr"
+)
+
+func TestBigInt(t *testing.T) {
+	for _, s := range []string{"0", "1", "-1", "-64", "64",
+		"123456789012345678901234567890",
+		"-123456789012345678901234567890"} {
+
+		v, _ := new(big.Int).SetString(s, 10)
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := new(big.Int)
+		if err := Unmarshal(b, u); err != nil {
+			t.Fatal(err)
+		}
+		if u.Cmp(v) != 0 {
+			t.Errorf("big.Int %v decoded as %v", v, u)
+		}
+
+		// Small values must encode identically to Int64
+		if v.IsInt64() && !bytes.Equal(b, AppendInt64(nil, v.Int64())) {
+			t.Errorf("big.Int %v encoded differently from int64", v)
+		}
+	}
+}
+
+func TestBigIntStrict(t *testing.T) {
+	blob := Encode(nil, []byte{0, 1})
+	dec := NewDecoder(bytes.NewReader(blob))
+	v := new(big.Int)
+	if err := dec.UnsignedInt(v); err != nil || v.Int64() != 1 ||
+		!dec.NonCanonical() {
+		t.Errorf("non-strict UnsignedInt gave %v, %v", v, err)
+	}
+	dec = NewDecoder(bytes.NewReader(blob))
+	dec.SetStrict(true)
+	if err := dec.SignedInt(v); !errors.Is(err, coerr.NonCanonical) {
+		t.Errorf("strict SignedInt gave %v", err)
+	}
+}
+
+func TestBigRatFloat(t *testing.T) {
+	for _, s := range []string{"0", "1", "-1", "1/3", "-22/7",
+		"123456789012345678901234567890/7"} {
+		v, _ := new(big.Rat).SetString(s)
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := new(big.Rat)
+		if err := Unmarshal(b, u); err != nil || u.Cmp(v) != 0 {
+			t.Errorf("big.Rat %v decoded as %v, %v", v, u, err)
+		}
+	}
+
+	huge := new(big.Float).SetMantExp(big.NewFloat(1.5), 1<<20)
+	third := new(big.Float).SetPrec(200)
+	third.Quo(big.NewFloat(1), big.NewFloat(3))
+	for _, v := range []*big.Float{
+		new(big.Float), big.NewFloat(0), big.NewFloat(1),
+		big.NewFloat(-0.375), big.NewFloat(1e300), big.NewFloat(-1e-300),
+		third, huge,
+	} {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := new(big.Float)
+		d := NewDecoder(bytes.NewReader(b))
+		d.SetStrict(true)
+		if err := d.Float(u); err != nil || u.Cmp(v) != 0 ||
+			u.Prec() != v.Prec() {
+			t.Errorf("big.Float %v decoded as %v, %v", v, u, err)
+		}
+	}
+	inf := new(big.Float).SetInf(false)
+	if _, err := Marshal(inf); err == nil {
+		t.Errorf("encoding an infinite big.Float succeeded")
+	}
+
+	// Only canonical forms are strict, and denominators must be nonzero
+	enc := func(num, den int64) []byte {
+		var inner bytes.Buffer
+		e := NewEncoder(&inner)
+		e.Int64(num)
+		e.Uint64(uint64(den))
+		return Encode(nil, inner.Bytes())
+	}
+	for _, c := range []struct {
+		b      []byte
+		strict bool
+		kind   error
+	}{
+		{enc(2, 4), true, ErrNonCanonical},
+		{enc(1, 0), false, coerr.Syntax},
+		{Encode(nil, []byte{2, 2, 2}), false, coerr.Syntax}, // 3 blobs
+	} {
+		d := NewDecoder(bytes.NewReader(c.b))
+		d.SetStrict(c.strict)
+		if err := d.Rat(new(big.Rat)); !errors.Is(err, c.kind) {
+			t.Errorf("decoding rational %x gave %v", c.b, err)
+		}
+	}
+	d := NewDecoder(bytes.NewReader(enc(2, 4)))
+	v := new(big.Rat)
+	if err := d.Rat(v); err != nil || v.Cmp(big.NewRat(1, 2)) != 0 {
+		t.Errorf("non-strict rational decoded as %v, %v", v, err)
+	}
+}
@@ -0,0 +1,111 @@
+package cbe
+
+// Builder composes a tree of nested blobs in one in-memory buffer,
+// encoding each blob in place as it is added.
+// A child Builder collects the blobs comprising one nested blob,
+// and encodes them as the content of that blob when closed.
+// For example:
+//
+//	b := NewBuilder(nil)
+//	b.String("name")
+//	c := b.Child()
+//	c.Uint64(1)
+//	c.Uint64(2)
+//	c.Close()
+//	enc := b.Result()
+//
+// A Builder must not be used while a child of it is open,
+// nor a child after it is closed.
+type Builder struct {
+	buf    *[]byte  // encoding buffer shared by a builder and its children
+	parent *Builder // builder that opened this child, or nil if top-level
+	start  int      // offset in *buf of this child's reserved header
+	child  *Builder // child currently open, if any
+}
+
+// Room reserved for a child's header, which is at most 4 bytes
+// unless the child's content must be split into chunks.
+const builderHeaderLen = 4
+
+// Create a Builder that appends encoded blobs to dst.
+func NewBuilder(dst []byte) *Builder {
+	return &Builder{buf: &dst}
+}
+
+// Return the buffer as extended with the blobs built so far.
+func (b *Builder) Result() []byte {
+	b.check()
+	return *b.buf
+}
+
+// Append a blob containing the byte slice p.
+func (b *Builder) Bytes(p []byte) {
+	b.check()
+	*b.buf = Encode(*b.buf, p)
+}
+
+// Append a blob containing the UTF-8 string s.
+func (b *Builder) String(s string) {
+	b.Bytes([]byte(s))
+}
+
+// Append a big-endian unsigned integer blob.
+func (b *Builder) Uint64(v uint64) {
+	b.check()
+	*b.buf = AppendUint64(*b.buf, v)
+}
+
+// Append a big-endian zigzag-encoded signed integer blob.
+func (b *Builder) Int64(v int64) {
+	b.check()
+	*b.buf = AppendInt64(*b.buf, v)
+}
+
+// Open a child Builder whose blobs become the content of a nested blob,
+// which Close appends to b.
+func (b *Builder) Child() *Builder {
+	b.check()
+	c := &Builder{buf: b.buf, parent: b, start: len(*b.buf)}
+	var h [builderHeaderLen]byte
+	*b.buf = append(*b.buf, h[:]...)
+	b.child = c
+	return c
+}
+
+// Close a child Builder, encoding the blobs it holds
+// as the content of one blob in its parent.
+// Usually moves the content only to close up unused header space,
+// but copies it if it must be split into chunks.
+// Does nothing on a top-level Builder or a child already closed.
+func (b *Builder) Close() {
+	if b.parent == nil || b.parent.child != b {
+		return
+	}
+	b.check()
+	buf := *b.buf
+	content := buf[b.start+builderHeaderLen:]
+	n := len(content)
+	if n > MaxChunkLen {
+		*b.buf = Encode(buf[:b.start], append([]byte(nil), content...))
+	} else {
+		var hb [builderHeaderLen]byte
+		h := hb[:0]
+		if n != 1 || content[0] >= 0x80 {
+			h = AppendHeader(h, n)
+		}
+		copy(buf[b.start+len(h):], content)
+		copy(buf[b.start:], h)
+		*b.buf = buf[:b.start+len(h)+n]
+	}
+	b.parent.child = nil
+}
+
+// Panic if b has an open child or is a closed child.
+func (b *Builder) check() {
+	if b.child != nil {
+		panic("cbe: Builder used while a child is open")
+	}
+	if b.parent != nil && b.parent.child != b {
+		panic("cbe: Builder used after Close")
+	}
+}
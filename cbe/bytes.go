@@ -0,0 +1,171 @@
+package cbe
+
+import (
+	"hash"
+)
+
+// BytesDecoder decodes a series of blobs from an in-memory byte slice,
+// such as a memory-mapped file,
+// returning sub-slices of the input rather than copying content
+// wherever the blob was encoded in only one chunk.
+// Its methods mirror those of Decoder.
+type BytesDecoder struct {
+	buf      []byte
+	pos      int       // offset in buf of the next blob
+	strict   bool      // whether to reject non-canonical chunkings
+	nonCanon bool      // a non-canonical chunking has been accepted
+	sum      hash.Hash // per-blob checksum to verify, or nil
+}
+
+// Create a BytesDecoder that decodes blobs from buf.
+// Content returned by the decoder may alias buf,
+// so the caller must not modify buf while the content is in use.
+func NewBytesDecoder(buf []byte) *BytesDecoder {
+	return &BytesDecoder{buf: buf}
+}
+
+// Return the portion of the input following the blobs decoded so far.
+func (d *BytesDecoder) Remaining() []byte {
+	return d.buf[d.pos:]
+}
+
+// Return the offset in the input of the next blob.
+func (d *BytesDecoder) Offset() int {
+	return d.pos
+}
+
+// Set whether the decoder accepts only canonically encoded blobs
+// and minimal integers, as described for Decoder.SetStrict.
+func (d *BytesDecoder) SetStrict(strict bool) {
+	d.strict = strict
+}
+
+// Report whether the decoder has accepted any non-canonically chunked blob
+// or non-minimal integer, clearing the flag, as Decoder.NonCanonical does.
+func (d *BytesDecoder) NonCanonical() bool {
+	nc := d.nonCanon
+	d.nonCanon = false
+	return nc
+}
+
+// Locate the next blob, returning the offset just past it,
+// its total content length, and whether it is chunked.
+// Returns EOF at the end of the input
+// or an error of kind coerr.Truncated if the blob is incomplete.
+// The returned error is of kind coerr.NonCanonical
+// if the decoder is strict and the blob is not canonically chunked.
+func (d *BytesDecoder) span() (end int, n int64, chunked bool, err error) {
+	end = d.pos
+	if end == len(d.buf) {
+		return 0, 0, false, EOF
+	}
+	for first := true; ; first = false {
+		ofs, l, part, err := decodeHeader(d.buf[end:])
+		if err != nil || len(d.buf)-end-ofs < l {
+			return 0, 0, false, errTruncated.At(int64(end))
+		}
+		if err := checkChunk(l, part, !first); err != nil {
+			if d.strict {
+				return 0, 0, false, at(err, int64(end))
+			}
+			d.nonCanon = true
+		}
+		end += ofs + l
+		n += int64(l)
+		if !part {
+			return end, n, !first, nil
+		}
+	}
+}
+
+// Report the content length of the next blob without consuming it.
+// Unlike Decoder.NextLen, always returns the exact length
+// of chunked blobs as well, together with true.
+func (d *BytesDecoder) NextLen() (int64, bool, error) {
+	_, n, _, err := d.span()
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+// Skip past the next blob, returning the length of its content.
+// Never allocates.
+func (d *BytesDecoder) Skip() (int64, error) {
+	end, n, _, err := d.span()
+	if err != nil {
+		return 0, err
+	}
+	if err := d.consume(end); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Decode the next blob,
+// returning a sub-slice of the input containing its content
+// unless the blob is chunked,
+// in which case its content is concatenated into a fresh byte slice.
+func (d *BytesDecoder) Bytes() ([]byte, error) {
+	end, _, chunked, err := d.span()
+	if err != nil {
+		return nil, err
+	}
+	b := d.buf[d.pos:end]
+	var content []byte
+	if !chunked {
+		ofs, _, _, _ := decodeHeader(b)
+		content = b[ofs:len(b):len(b)]
+	} else {
+		content, _, _ = Decode(b)
+	}
+	if err := d.consume(end); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// Decode a blob into a UTF-8 string.
+// Allocates a copy of the content as Go strings are immutable.
+func (d *BytesDecoder) String() (string, error) {
+	b, err := d.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Decode a blob as a big-endian unsigned integer.
+// Returns an error if the decoded value is too large for a uint64.
+// Never allocates.
+func (d *BytesDecoder) Uint64() (uint64, error) {
+	end, n, chunked, err := d.span()
+	if err != nil {
+		return 0, err
+	}
+	if chunked || n > 8 {
+		return 0, errUint64Range.At(int64(d.pos))
+	}
+	v, _, _ := DecodeUint64(d.buf[d.pos:end])
+	if n > 0 && d.buf[end-int(n)] == 0 {
+		if d.strict {
+			return 0, errIntCanon.At(int64(d.pos))
+		}
+		d.nonCanon = true
+	}
+	if err := d.consume(end); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// Decode a blob as a big-endian zigzag-encoded signed integer.
+// Returns an error if the decoded value is too large for an int64.
+// Never allocates.
+func (d *BytesDecoder) Int64() (int64, error) {
+	v, err := d.Uint64()
+	if err != nil {
+		return 0, err
+	}
+	return unzigzag(v), nil
+}
@@ -1,4 +1,4 @@
-// Package cbe-go implements Composable Binary Encoding (CBE),
+// Package cbe implements Composable Binary Encoding (CBE),
 // which efficiently embeds one arbitrary-length binary string in another
 // so that a decoder can efficiently find the embedded string's length.
 //
@@ -9,20 +9,34 @@
 // The plain functions Encode and Decode operate on
 // contiguous in-memory byte slices,
 // and do not support streaming.
-// The Encode and Decode types provide stream-oriented encoding and decoding,
+// The Encoder and Decoder types provide stream-oriented encoding and decoding,
 // supporting arbitrary-length byte strings including infinite streams.
+// The BytesDecoder type offers the Decoder's methods
+// on a series of blobs in a contiguous byte slice,
+// returning content in place without copying.
+//
+// Builds with the tinygo or cbe_tiny build tag
+// omit the big.Int, big.Rat, and big.Float methods
+// to avoid depending on math/big,
+// omit Dump to avoid depending on fmt,
+// and replace the Decoder's bufio buffering with unbuffered header reads,
+// for microcontroller targets where these dependencies are too heavy.
+// The slice-level functions Encode, Decode, AppendUint64, and DecodeUint64
+// never allocate provided dst has sufficient spare capacity,
+// and neither does encoding small blobs of less than 64 bytes
+// or integers with an existing Encoder.
 //
 package cbe
 
 import (
-	"bytes"
 	"io"
+
+	"github.com/bford/cofo/coerr"
 )
 
 // Encode a byte slice src and append its CBE encoding to slice dst.
 // Allocates and returns a new destination buffer
 // if the blob-encoded data does not fit into dst.
-//
 func Encode(dst, src []byte) []byte {
 
 	// 1-byte header encoding
@@ -50,16 +64,100 @@ func Encode(dst, src []byte) []byte {
 		return append(dst, src...)
 	}
 
-	// For really large encodes, fall back on the general Encoder.
+	// For really large encodes, split the content into partial chunks.
 	// Use maximum-size chunks since everything's in-memory anyway.
-	buf := bytes.NewBuffer(dst)
-	enc := NewEncoder(buf)
-	enc.SetChunkLen(MaxChunkLen)
-	err := enc.Bytes(src)
-	if err != nil {
-		panic("encoding error: " + err.Error())
-	}
-	return buf.Bytes()
+	for len(src) > MaxChunkLen {
+		n = MaxChunkLen - 16448
+		dst = append(dst, 0x81, 0x40+byte(n>>16), byte(n>>8), byte(n))
+		dst = append(dst, src[:MaxChunkLen]...)
+		src = src[MaxChunkLen:]
+	}
+	return Encode(dst, src) // final chunk
+}
+
+// Append to dst only the header of a blob with contentLen bytes of content,
+// which the caller must then write immediately after the header,
+// for example from a buffer held elsewhere to avoid copying it.
+// The content must fit in a single chunk of at most MaxChunkLen bytes;
+// AppendHeader panics otherwise.
+// A one-byte blob whose content byte is less than 0x80
+// has no header but is encoded as the content byte itself,
+// so the header that AppendHeader returns for contentLen 1
+// is valid only for content bytes of 0x80 or more.
+func AppendHeader(dst []byte, contentLen int) []byte {
+	n := contentLen
+	switch {
+	case n < 0:
+		panic("negative content length")
+	case n < 64:
+		return append(dst, byte(128+n))
+	case n < 16448:
+		n -= 64
+		return append(dst, 0xc0+byte(n>>8), byte(n))
+	case n <= MaxChunkLen:
+		n -= 16448
+		return append(dst, 0x81, 0x00+byte(n>>16), byte(n>>8), byte(n))
+	}
+	panic("content too long for a single chunk")
+}
+
+// Append to buf space for the header of a blob of up to MaxChunkLen bytes,
+// after which the caller appends the blob's content directly
+// and then calls FinishHeader to fill in the header.
+// This avoids moving the content to make room for a header
+// whose length is not known until the content is complete.
+func ReserveHeader(buf []byte) []byte {
+	return append(buf, 0, 0, 0, 0)
+}
+
+// Fill in the header of a blob whose contentLen bytes of content
+// end buf, following space reserved by ReserveHeader,
+// and return the sub-slice of buf holding the blob's complete encoding.
+// Since headers vary in length,
+// the encoding starts up to four bytes after the reserved space,
+// so write the returned slice to its destination
+// rather than assuming the blob begins where the space was reserved.
+// Panics if contentLen exceeds MaxChunkLen
+// or buf is too short to hold the content and reserved space.
+func FinishHeader(buf []byte, contentLen int) []byte {
+	start := len(buf) - contentLen - 4
+	if start < 0 {
+		panic("buffer too short for reserved header and content")
+	}
+	var hb [4]byte
+	hdr := AppendHeader(hb[:0], contentLen)
+	if contentLen == 1 && buf[len(buf)-1] < 0x80 {
+		hdr = hdr[:0] // the content byte is its own header
+	}
+	start += 4 - len(hdr)
+	copy(buf[start:], hdr)
+	return buf[start:]
+}
+
+// Return the total length of the headers in the encoding
+// that Encode produces for content of length n,
+// including the headers of all chunks if the content is chunked.
+// For content of exactly one byte, returns 1, an overestimate
+// if the byte is less than 0x80 and thus encoded without a header.
+func HeaderLen(n int) int {
+	switch {
+	case n < 64:
+		return 1
+	case n < 16448:
+		return 2
+	case n <= MaxChunkLen:
+		return 4
+	}
+	partial := (n - 1) / MaxChunkLen // number of partial chunks
+	return 4*partial + HeaderLen(n-partial*MaxChunkLen)
+}
+
+// Return the total length of the encoding
+// that Encode produces for content of length n.
+// Like HeaderLen, overestimates by one byte
+// for a single content byte less than 0x80.
+func EncodedLen(n int) int {
+	return HeaderLen(n) + n
 }
 
 // Decode a blob header from the start of a byte slice.
@@ -73,7 +171,6 @@ func Encode(dst, src []byte) []byte {
 // which may require the decoder to handle multi-part streaming encodings.
 // On see a blob header for a large blob, this function returns an error.
 // To decode large blobs of 16KiB or more, use the streaming-capable Decoder.
-//
 func decodeHeader(buf []byte) (dataOfs, dataLen int, part bool, err error) {
 
 	// 1-byte headers
@@ -120,10 +217,10 @@ func decodeHeader(buf []byte) (dataOfs, dataLen int, part bool, err error) {
 // When decoding a large blob that was encoded into multiple chunks,
 // copying is necessary to concatenate the chunk payloads into one,
 // so Decode returns a fresh byte slice containing this concatenated content.
+// DecodeChunks instead returns the chunks' content in place.
 //
 // This function returns EOF if the provided byte string
 // does not contain a complete blob.
-//
 func Decode(buf []byte) (content, remainder []byte, err error) {
 	for {
 		ofs, n, part, err := decodeHeader(buf)
@@ -147,5 +244,64 @@ func Decode(buf []byte) (content, remainder []byte, err error) {
 	}
 }
 
+// Decode all the blobs in a byte slice containing a series of them,
+// returning a slice of their contents.
+// As with Decode, the contents of blobs encoded in only one chunk
+// are sub-slices of buf rather than copies.
+// Returns an error of kind ErrTruncated
+// if buf ends within a blob.
+func DecodeAll(buf []byte) ([][]byte, error) {
+	var contents [][]byte
+	d := NewBytesDecoder(buf)
+	for {
+		b, err := d.Bytes()
+		if err == EOF {
+			return contents, nil
+		} else if err != nil {
+			return nil, err
+		}
+		contents = append(contents, b)
+	}
+}
+
+// Verify that blob is exactly the canonical encoding of its content,
+// the unique encoding that Encode produces for that content,
+// as a signature scheme may require to rule out alternate encodings
+// of the same content.
+// Returns an error of kind ErrNonCanonical if the blob is chunked
+// other than canonically, as defined for Decoder.SetStrict,
+// of kind ErrTruncated if blob is empty or ends within the blob,
+// or of kind coerr.Syntax if blob contains data after the blob.
+func Verify(blob []byte) error {
+	d := NewBytesDecoder(blob)
+	d.SetStrict(true)
+	if _, err := d.Skip(); err == EOF {
+		return errTruncated.At(0)
+	} else if err != nil {
+		return err
+	}
+	if d.pos != len(blob) {
+		return errTrailingData.At(int64(d.pos))
+	}
+	return nil
+}
+
 var EOF = io.EOF
 
+// Errors that the Decoder types return on malformed input
+// are of type *coerr.Error, recording the input offset
+// of the chunk or integer at which decoding failed.
+// Match them by kind with errors.Is, as in
+// errors.Is(err, cbe.ErrTruncated),
+// to distinguish input that ends prematurely from corrupt input.
+// The Decoder returns EOF only at the end of the input between blobs,
+// whereas the slice-level functions such as Decode also return EOF
+// for input ending within a blob, indicating that more is needed.
+var (
+	ErrTruncated    error = coerr.Truncated    // input ends within a blob
+	ErrTooLong      error = coerr.TooLarge     // blob or integer too large
+	ErrNonCanonical error = coerr.NonCanonical // strict mode violation
+)
+
+var errTrailingData = coerr.New(coerr.Syntax, "cbe", -1,
+	"data follows the blob")
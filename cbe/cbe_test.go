@@ -1,9 +1,25 @@
 package cbe
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
 	"math/rand"
+	"net/netip"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/bford/cofo/coerr"
 )
 
 type testCase struct {
@@ -73,9 +89,8 @@ var testCases = []testCase{
 	// 4210750-byte (#27)
 	bigTestCase(4210750, -1, []byte{0x81, 0x3f, 0xff, 0xfe}),
 
-	// 4210751-byte (#27)
-	//bigTestCase(4210751, -1, []byte{0x81,0x3f,0xff, 0xff}),
-	// XXX Encoder breaks it into 2 chunks; need to account for that...
+	// 4210751-byte, largest single-chunk blob (#28)
+	bigTestCase(4210751, -1, []byte{0x81, 0x3f, 0xff, 0xff}),
 }
 
 // XXX tests could be better: e.g., need error cases too...
@@ -98,11 +113,6 @@ func TestEncode(t *testing.T) {
 	var accx, accy []byte
 	for i, st := range testCases {
 
-		// Skip tests on large blobs requiring the streaming encoder
-		if len(st.data) >= 16448 {
-			continue
-		}
-
 		// Test encoding into a fresh buffer
 		blob := Encode(nil, st.data)
 		if bytes.Compare(blob, st.blob) != 0 {
@@ -156,6 +166,182 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+func TestDecodeAll(t *testing.T) {
+	var acc []byte
+	for _, st := range testCases {
+		acc = append(acc, st.blob...)
+	}
+	contents, err := DecodeAll(acc)
+	if err != nil || len(contents) != len(testCases) {
+		t.Fatalf("DecodeAll gave %v blobs, %v", len(contents), err)
+	}
+	for i, st := range testCases {
+		if !bytes.Equal(contents[i], st.data) {
+			t.Errorf("incorrect decode in case %v", i)
+		}
+	}
+	if contents, err := DecodeAll(nil); err != nil || len(contents) != 0 {
+		t.Errorf("DecodeAll of empty input gave %v, %v", contents, err)
+	}
+	if _, err := DecodeAll(acc[:len(acc)-1]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("DecodeAll of truncated input gave %v", err)
+	}
+}
+
+func TestScanBlobs(t *testing.T) {
+	var in []byte
+	for _, st := range testCases {
+		in = append(in, st.blob...)
+	}
+	var buf bytes.Buffer
+	NewEncoder(&buf).ReadFrom(bytes.NewReader(make([]byte, 3*MinChunkLen)))
+	chunked := buf.Bytes()
+	in = append(in, chunked...)
+
+	sc := bufio.NewScanner(iotest.HalfReader(bytes.NewReader(in)))
+	sc.Buffer(nil, 2*MaxChunkLen)
+	sc.Split(ScanBlobs)
+	for i, st := range testCases {
+		if !sc.Scan() || !bytes.Equal(sc.Bytes(), st.blob) {
+			t.Fatalf("scan of case %v failed: %v", i, sc.Err())
+		}
+	}
+	if !sc.Scan() || !bytes.Equal(sc.Bytes(), chunked) {
+		t.Errorf("scan of chunked blob failed: %v", sc.Err())
+	}
+	if sc.Scan() || sc.Err() != nil {
+		t.Errorf("scan at end gave %v", sc.Err())
+	}
+
+	sc = bufio.NewScanner(bytes.NewReader([]byte{0x83, 'a', 'b', 'c', 0x85}))
+	sc.Split(ScanBlobs)
+	if !sc.Scan() || sc.Scan() || !errors.Is(sc.Err(), coerr.Truncated) {
+		t.Errorf("scan of truncated blob gave %v", sc.Err())
+	}
+}
+
+func TestDecodeChunks(t *testing.T) {
+	data := make([]byte, 3*MinChunkLen)
+	rand.New(rand.NewSource(3)).Read(data)
+	var buf bytes.Buffer
+	NewEncoder(&buf).ReadFrom(bytes.NewReader(data))
+	enc := Encode(buf.Bytes(), []byte("tail"))
+
+	chunks, rest, err := DecodeChunks(nil, enc)
+	if err != nil || len(chunks) != 3 || string(rest) != "\x84tail" {
+		t.Fatalf("DecodeChunks gave %v chunks, %x, %v",
+			len(chunks), rest, err)
+	}
+	if &chunks[1][0] != &enc[4+MinChunkLen+4] {
+		t.Errorf("DecodeChunks copied content")
+	}
+	if _, _, err := DecodeChunks(nil, enc[:100]); err != EOF {
+		t.Errorf("DecodeChunks of truncated input gave %v", err)
+	}
+	chunks, _, _ = DecodeChunks(chunks[:0], Encode(nil, []byte("x")))
+	if len(chunks) != 1 || string(chunks[0]) != "x" {
+		t.Errorf("DecodeChunks of single chunk gave %q", chunks)
+	}
+
+	// Read the chunks through a ChunksReader
+	bd := NewBytesDecoder(enc)
+	chunks, err = bd.Chunks(nil)
+	if err != nil || len(chunks) != 3 {
+		t.Fatalf("BytesDecoder.Chunks gave %v chunks, %v", len(chunks), err)
+	}
+	if s, err := bd.String(); s != "tail" || err != nil {
+		t.Errorf("decode after Chunks gave %q, %v", s, err)
+	}
+	r := NewChunksReader(chunks)
+	if r.Size() != int64(len(data)) {
+		t.Errorf("ChunksReader size %v", r.Size())
+	}
+	if err := iotest.TestReader(r, data); err != nil {
+		t.Error(err)
+	}
+	r.Seek(int64(MinChunkLen)-5, io.SeekStart)
+	var out bytes.Buffer
+	if n, err := r.WriteTo(&out); err != nil ||
+		n != int64(2*MinChunkLen+5) ||
+		!bytes.Equal(out.Bytes(), data[MinChunkLen-5:]) {
+		t.Errorf("WriteTo gave %v, %v", n, err)
+	}
+	if err := iotest.TestReader(NewChunksReader(nil), nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for i, st := range testCases {
+		if err := Verify(st.blob); err != nil {
+			t.Errorf("case %v: Verify gave %v", i, err)
+		}
+	}
+	var buf bytes.Buffer
+	NewEncoder(&buf).ReadFrom(bytes.NewReader(make([]byte, MinChunkLen+1)))
+	for _, c := range []struct {
+		blob []byte
+		kind error
+	}{
+		{Encode(nil, make([]byte, 2*MaxChunkLen+1)), nil},
+		{buf.Bytes(), ErrNonCanonical},
+		{nil, ErrTruncated},
+		{[]byte{0x83, 'a'}, ErrTruncated},
+		{[]byte{0x81, 0xff, 0x00}, coerr.Syntax},
+	} {
+		if err := Verify(c.blob); !errors.Is(err, c.kind) ||
+			(c.kind == nil) != (err == nil) {
+			t.Errorf("Verify(%.8x) gave %v, want %v", c.blob, err, c.kind)
+		}
+	}
+}
+
+func TestReserveHeader(t *testing.T) {
+	for i, st := range testCases {
+		buf := ReserveHeader([]byte("prefix"))
+		buf = append(buf, st.data...)
+		b := FinishHeader(buf, len(st.data))
+		if !bytes.Equal(b, st.blob) {
+			t.Errorf("FinishHeader in case %v gave %v bytes",
+				i, len(b))
+		}
+	}
+}
+
+func TestEncodedLen(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 63, 64, 16447, 16448, MaxChunkLen,
+		MaxChunkLen + 1, 2 * MaxChunkLen, 2*MaxChunkLen + 16448} {
+		content := bytes.Repeat([]byte{0xff}, n)
+		enc := Encode(nil, content)
+		if got := EncodedLen(n); got != len(enc) {
+			t.Errorf("EncodedLen(%v) = %v, want %v", n, got, len(enc))
+		}
+		if got := HeaderLen(n); got != len(enc)-n {
+			t.Errorf("HeaderLen(%v) = %v, want %v", n, got, len(enc)-n)
+		}
+	}
+	if got := len(Encode(nil, []byte{0x7f})); EncodedLen(1) != got+1 {
+		t.Errorf("EncodedLen(1) is not an overestimate by one")
+	}
+}
+
+func TestAppendHeader(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 63, 64, 16447, 16448, MaxChunkLen} {
+		content := bytes.Repeat([]byte{0xff}, n)
+		want := Encode(nil, content)
+		got := append(AppendHeader([]byte("x"), n), content...)
+		if !bytes.Equal(got[1:], want) || got[0] != 'x' {
+			t.Errorf("AppendHeader(%v) gave %x", n, got[:len(got)-n])
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("AppendHeader accepted an oversized chunk")
+		}
+	}()
+	AppendHeader(nil, MaxChunkLen+1)
+}
+
 func TestEncoder(t *testing.T) {
 	var buf bytes.Buffer
 	var ref []byte
@@ -179,6 +365,263 @@ func TestEncoder(t *testing.T) {
 	}
 }
 
+func TestChunk(t *testing.T) {
+	content := bytes.Repeat([]byte("c"), MaxChunkLen+MinChunkLen+1)
+	for _, c := range []struct {
+		bounds []int // content offsets at which partial chunks end
+		canon  bool
+	}{
+		{[]int{MaxChunkLen}, true},
+		{[]int{MinChunkLen, 2 * MinChunkLen}, false},
+		{[]int{MaxChunkLen, MaxChunkLen + MinChunkLen}, false},
+		{nil, false}, // final chunk too long
+	} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetChecksum(NewCRC32C())
+		start := 0
+		for _, end := range c.bounds {
+			err := enc.Chunk(content[start:end], true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start = end
+		}
+		if len(content)-start > MaxChunkLen {
+			func() {
+				defer func() { recover() }()
+				enc.Chunk(content[start:], false)
+				t.Errorf("Chunk of %v bytes did not panic",
+					len(content)-start)
+			}()
+			continue
+		}
+		if err := enc.Chunk(content[start:], false); err != nil {
+			t.Fatal(err)
+		}
+		canon := bytes.HasPrefix(buf.Bytes(), Encode(nil, content))
+		if canon != c.canon {
+			t.Errorf("chunks at %v: canonical %v", c.bounds, canon)
+		}
+		dec := NewDecoder(&buf)
+		dec.SetChecksum(NewCRC32C())
+		dec.SetStrict(c.canon)
+		if b, err := dec.Bytes(); err != nil || !bytes.Equal(b, content) {
+			t.Errorf("chunks at %v decoded incorrectly: %v",
+				c.bounds, err)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	// Write in odd-sized pieces
+	for _, l := range []int{0, 1, 63, 64, 16447, 16448, 16449,
+		2 * MinChunkLen, 3*MinChunkLen + 7} {
+		data := make([]byte, l)
+		rand.New(rand.NewSource(int64(l))).Read(data)
+
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		w := enc.Writer()
+		for p := data; len(p) > 0; {
+			n := 1000
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := NewDecoder(&buf).Bytes()
+		if err != nil || !bytes.Equal(b, data) {
+			t.Errorf("Writer of %v bytes decoded to %v bytes, %v",
+				l, len(b), err)
+		}
+		if _, err := w.Write([]byte("x")); err == nil {
+			t.Errorf("Write after Close succeeded")
+		}
+	}
+}
+
+func TestEncodePipe(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 3*MinChunkLen + 5} {
+		content := bytes.Repeat([]byte{0xa5}, n)
+		w, r := EncodePipe()
+		go func() {
+			for b := content; len(b) > 0; b = b[len(b)/2+1:] {
+				w.Write(b[:len(b)/2+1])
+			}
+			w.Close()
+		}()
+		enc, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, rest, err := Decode(enc)
+		if err != nil || len(rest) != 0 || !bytes.Equal(b, content) {
+			t.Errorf("EncodePipe of %v bytes gave %v-byte blob, %v",
+				n, len(b), err)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	big := bytes.Repeat([]byte{0xaa}, MaxChunkLen)
+	for _, c := range []struct {
+		build func(b *Builder)
+		want  []byte
+	}{
+		{func(b *Builder) { b.Child().Close() }, Encode(nil, nil)},
+		{func(b *Builder) {
+			c := b.Child()
+			c.Uint64(5)
+			c.Close()
+		}, []byte{5}},
+		{func(b *Builder) {
+			c := b.Child()
+			c.Uint64(0xff)
+			c.Close()
+		}, Encode(nil, []byte{0x81, 0xff})},
+		{func(b *Builder) {
+			b.String("a")
+			c := b.Child()
+			c.Int64(-1)
+			d := c.Child()
+			d.Bytes(make([]byte, 100))
+			d.Close()
+			c.Close()
+			b.Uint64(7)
+		}, func() []byte {
+			inner := Encode(nil, make([]byte, 100))
+			mid := Encode(AppendInt64(nil, -1), inner)
+			return AppendUint64(Encode(Encode(nil, []byte("a")), mid), 7)
+		}()},
+		{func(b *Builder) { // large enough to need chunking
+			c := b.Child()
+			c.Bytes(big)
+			c.Close()
+		}, Encode(nil, Encode(nil, big))},
+	} {
+		b := NewBuilder([]byte("x"))
+		c.build(b)
+		if got := b.Result(); !bytes.Equal(got[1:], c.want) {
+			t.Errorf("built %.40x, want %.40x", got[1:], c.want)
+		}
+	}
+
+	b := NewBuilder(nil)
+	c := b.Child()
+	c.Close()
+	c.Close() // no effect
+	defer func() {
+		if recover() == nil {
+			t.Errorf("use of closed child did not panic")
+		}
+	}()
+	c.Uint64(1)
+}
+
+func TestReadFromN(t *testing.T) {
+	for _, l := range []int{0, 1, 2, 63, 64, 16448, MaxChunkLen,
+		MaxChunkLen + 1, 2*MaxChunkLen + 5} {
+		for _, b := range []byte{0x05, 0xa5} {
+			content := bytes.Repeat([]byte{b}, l)
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			n, err := enc.ReadFromN(bytes.NewReader(content), int64(l))
+			if err != nil || n != int64(l) {
+				t.Errorf("ReadFromN(%v) gave %v, %v", l, n, err)
+			}
+			if !bytes.Equal(buf.Bytes(), Encode(nil, content)) {
+				t.Errorf("ReadFromN(%v) of %x encoded differently",
+					l, b)
+			}
+		}
+	}
+
+	// Checksums match those of Bytes
+	var want, got bytes.Buffer
+	e1, e2 := NewEncoder(&want), NewEncoder(&got)
+	e1.SetChecksum(NewCRC32C())
+	e2.SetChecksum(NewCRC32C())
+	for _, content := range [][]byte{{0x80}, []byte("checksummed")} {
+		e1.Bytes(content)
+		e2.ReadFromN(bytes.NewReader(content), int64(len(content)))
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("ReadFromN checksums differ")
+	}
+
+	enc := NewEncoder(io.Discard)
+	if _, err := enc.ReadFromN(strings.NewReader("short"), 6); err !=
+		io.ErrUnexpectedEOF {
+		t.Errorf("ReadFromN of short input gave %v", err)
+	}
+}
+
+// fromWriter records whether io.Copy passed it an os.File to read from,
+// as a net.Conn would for sendfile.
+type fromWriter struct {
+	bytes.Buffer
+	files int
+}
+
+func (w *fromWriter) ReadFrom(r io.Reader) (int64, error) {
+	if lr, ok := r.(*io.LimitedReader); ok {
+		if _, ok := lr.R.(*os.File); ok {
+			w.files++
+		}
+	}
+	return w.Buffer.ReadFrom(r)
+}
+
+func TestReadFromFile(t *testing.T) {
+	content := make([]byte, 3*MinChunkLen+5)
+	rand.New(rand.NewSource(1)).Read(content)
+	name := t.TempDir() + "/content"
+	if err := os.WriteFile(name, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, skip := range []int{0, 5, len(content) - 1, len(content)} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Seek(int64(skip), io.SeekStart)
+		var w fromWriter
+		n, err := NewEncoder(&w).ReadFromN(f, int64(len(content)-skip))
+		f.Close()
+		if err != nil || n != int64(len(content)-skip) {
+			t.Errorf("ReadFromN file at %v gave %v, %v",
+				skip, n, err)
+		}
+		if w.files == 0 && skip < len(content)-1 {
+			t.Errorf("ReadFromN file at %v did not pass through",
+				skip)
+		}
+		b, err := NewDecoder(&w).Bytes()
+		if err != nil || !bytes.Equal(b, content[skip:]) {
+			t.Errorf("ReadFromN file at %v decoded incorrectly: %v",
+				skip, err)
+		}
+	}
+
+	// ReadFrom streams files whose reported size is not their length
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if n, err := NewEncoder(&buf).ReadFrom(f); err != nil || n == 0 {
+		t.Errorf("ReadFrom /proc/self/status gave %v, %v", n, err)
+	}
+}
+
 func TestDecoder(t *testing.T) {
 
 	// Decode each test case individually
@@ -209,4 +652,1777 @@ func TestDecoder(t *testing.T) {
 				i, len(st.data))
 		}
 	}
+
+	// Decode through buffers of various sizes
+	for _, size := range []int{-1, 16, MaxChunkLen + 4} {
+		dec := NewDecoderSize(iotest.HalfReader(bytes.NewReader(acc)),
+			size)
+		for i, st := range testCases {
+			b, err := dec.Bytes()
+			if err != nil || !bytes.Equal(b, st.data) {
+				t.Errorf("buffer size %v: incorrect decode in case %v",
+					size, i)
+			}
+		}
+	}
+	br := bufio.NewReaderSize(bytes.NewReader(acc), 1024)
+	if NewDecoderSize(br, 1024).r != br {
+		t.Errorf("NewDecoderSize did not use a large enough bufio.Reader")
+	}
+
+	// Append all the test cases to one buffer
+	dec = NewDecoder(bytes.NewReader(acc))
+	var all, want []byte
+	for _, st := range testCases {
+		var err error
+		if all, err = dec.BytesAppend(all); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, st.data...)
+	}
+	if !bytes.Equal(all, want) {
+		t.Errorf("incorrect BytesAppend")
+	}
+
+	// Skip every other test case
+	dec = NewDecoder(bytes.NewReader(acc))
+	for i, st := range testCases {
+		if i%2 == 0 {
+			n, err := dec.Skip()
+			if err != nil || n != int64(len(st.data)) {
+				t.Errorf("Skip case %v gave %v, %v", i, n, err)
+			}
+			continue
+		}
+		if b, err := dec.Bytes(); err != nil || !bytes.Equal(b, st.data) {
+			t.Errorf("incorrect decode after Skip in case %v", i)
+		}
+	}
+	if _, err := dec.Skip(); err != io.EOF {
+		t.Errorf("Skip at end gave %v", err)
+	}
+	dec = NewDecoder(bytes.NewReader([]byte{0x85, 1}))
+	if _, err := dec.Skip(); !errors.Is(err, coerr.Truncated) {
+		t.Errorf("Skip of truncated blob gave %v", err)
+	}
+}
+
+func TestBytesDecoder(t *testing.T) {
+	var acc []byte
+	for _, st := range testCases {
+		acc = append(acc, st.blob...)
+	}
+	big := bytes.Repeat([]byte("m"), 2*MaxChunkLen+3)
+	acc = Encode(acc, big)
+	acc = AppendInt64(acc, -12345)
+
+	for round := 0; round < 2; round++ {
+		dec := NewBytesDecoder(acc)
+		for i, st := range testCases {
+			n, ok, err := dec.NextLen()
+			if err != nil || !ok || n != int64(len(st.data)) {
+				t.Errorf("NextLen case %v gave %v, %v, %v",
+					i, n, ok, err)
+			}
+			if round == 1 {
+				if _, err := dec.Skip(); err != nil {
+					t.Error(err)
+				}
+				continue
+			}
+			ofs := dec.Offset()
+			b, err := dec.Bytes()
+			if err != nil || !bytes.Equal(b, st.data) {
+				t.Errorf("incorrect decode in case %v", i)
+			}
+			if len(b) > 0 && &b[0] != &acc[ofs+len(st.blob)-len(b)] {
+				t.Errorf("case %v content was copied", i)
+			}
+		}
+		if n, ok, err := dec.NextLen(); err != nil || !ok ||
+			n != int64(len(big)) {
+			t.Errorf("NextLen of chunked blob gave %v, %v, %v",
+				n, ok, err)
+		}
+		if round == 0 {
+			b, err := dec.Bytes()
+			if err != nil || !bytes.Equal(b, big) {
+				t.Errorf("incorrect decode of chunked blob")
+			}
+		} else if _, err := dec.Skip(); err != nil {
+			t.Error(err)
+		}
+		if v, err := dec.Int64(); err != nil || v != -12345 {
+			t.Errorf("Int64 gave %v, %v", v, err)
+		}
+		if _, err := dec.Bytes(); err != io.EOF {
+			t.Errorf("Bytes at end gave %v", err)
+		}
+	}
+
+	dec := NewBytesDecoder([]byte{0x85, 1, 2})
+	if _, err := dec.Skip(); !errors.Is(err, coerr.Truncated) {
+		t.Errorf("Skip of truncated blob gave %v", err)
+	}
+	if len(dec.Remaining()) != 3 {
+		t.Errorf("failed Skip consumed input")
+	}
+}
+
+func TestReaderAtDecoder(t *testing.T) {
+	var acc []byte
+	for _, st := range testCases {
+		acc = append(acc, st.blob...)
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	big := bytes.Repeat([]byte("a"), 2*MinChunkLen+1)
+	enc.ReadFrom(bytes.NewReader(big))
+	acc = append(acc, buf.Bytes()...)
+	acc = Encode(acc, []byte("last"))
+	want := [][]byte{}
+	for _, st := range testCases {
+		want = append(want, st.data)
+	}
+	want = append(want, big, []byte("last"))
+
+	// Scan a prefix ending within the final blob, then all of it
+	dec := NewReaderAtDecoder(bytes.NewReader(acc[:len(acc)-1]))
+	if err := dec.Scan(); !errors.Is(err, coerr.Truncated) ||
+		dec.Len() != len(want)-1 {
+		t.Errorf("Scan of truncated input gave %v, %v blobs",
+			err, dec.Len())
+	}
+	dec.r = bytes.NewReader(acc) // the file has grown
+	if err := dec.Scan(); err != nil || dec.Len() != len(want) {
+		t.Fatalf("Scan gave %v, %v blobs", err, dec.Len())
+	}
+
+	// Access the blobs in reverse order
+	for i := len(want) - 1; i >= 0; i-- {
+		if _, n := dec.Blob(i); n != int64(len(want[i])) {
+			t.Errorf("blob %v has length %v", i, n)
+		}
+		b, err := dec.Bytes(i)
+		if err != nil || !bytes.Equal(b, want[i]) {
+			t.Errorf("incorrect decode of blob %v: %v", i, err)
+		}
+		b, err = io.ReadAll(dec.Reader(i))
+		if err != nil || !bytes.Equal(b, want[i]) {
+			t.Errorf("incorrect stream of blob %v: %v", i, err)
+		}
+	}
+	if off, _ := dec.Blob(len(want) - 1); off != int64(len(acc)-5) {
+		t.Errorf("last blob at offset %v", off)
+	}
+}
+
+func TestMerkle(t *testing.T) {
+	leaf := func(b []byte) []byte {
+		s := sha256.Sum256(append([]byte{0}, b...))
+		return s[:]
+	}
+	node := func(l, r []byte) []byte {
+		s := sha256.Sum256(append(append([]byte{1}, l...), r...))
+		return s[:]
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 100, MinChunkLen, 2 * MinChunkLen,
+		3*MinChunkLen + 5} {
+		for _, first := range []byte{'a', 0xff} {
+			data := make([]byte, n)
+			rnd.Read(data)
+			if n > 0 {
+				data[n-1] = first
+			}
+
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.String("prefix")
+			tree, err := enc.MerkleReadFrom(bytes.NewReader(data),
+				sha256.New)
+			if err != nil {
+				t.Fatalf("MerkleReadFrom: %v", err)
+			}
+			enc.String("suffix")
+			if tree.Len() != int64(n) {
+				t.Errorf("tree length %v, want %v", tree.Len(), n)
+			}
+
+			// Check the root against a direct computation
+			var want []byte
+			c := MinChunkLen
+			switch n {
+			case 0:
+				s := sha256.Sum256(nil)
+				want = s[:]
+			case 3*MinChunkLen + 5:
+				want = node(node(leaf(data[:c]), leaf(data[c:2*c])),
+					node(leaf(data[2*c:3*c]), leaf(data[3*c:])))
+			case 2 * MinChunkLen:
+				want = node(leaf(data[:c]), leaf(data[c:]))
+			default:
+				want = leaf(data)
+			}
+			root := tree.Root()
+			if !bytes.Equal(root, want) {
+				t.Errorf("length %v: wrong root", n)
+			}
+
+			// Locate the blob and tree and verify ranges of content
+			enc2 := buf.Bytes()
+			dec := NewReaderAtDecoder(bytes.NewReader(enc2))
+			if err := dec.Scan(); err != nil || dec.Len() != 4 {
+				t.Fatalf("Scan gave %v, %v blobs", err, dec.Len())
+			}
+			b, _ := dec.Bytes(2)
+			tree2, err := DecodeMerkleTree(b, sha256.New)
+			if err != nil || tree2.Verify(root) != nil {
+				t.Fatalf("length %v: bad tree: %v", n, err)
+			}
+			off, _ := dec.Blob(1)
+			mr := tree2.NewReader(bytes.NewReader(enc2), off)
+			got, err := io.ReadAll(io.NewSectionReader(mr, 0, mr.Size()))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("length %v: wrong content: %v", n, err)
+			}
+			for i := 0; i < 10 && n > 0; i++ {
+				lo := rnd.Intn(n)
+				hi := lo + rnd.Intn(n-lo+1)
+				p := make([]byte, hi-lo)
+				k, err := mr.ReadAt(p, int64(lo))
+				if k != hi-lo || err != nil ||
+					!bytes.Equal(p, data[lo:hi]) {
+					t.Errorf("ReadAt(%v, %v) gave %v, %v",
+						hi-lo, lo, k, err)
+				}
+			}
+			if n < 2*MinChunkLen {
+				continue
+			}
+
+			// Corrupt the second chunk, leaving the first readable
+			enc2[int(off)+4+MinChunkLen+4+10] ^= 1
+			p := make([]byte, 10)
+			if _, err := mr.ReadAt(p, 0); err != nil {
+				t.Errorf("ReadAt of intact chunk gave %v", err)
+			}
+			if _, err := mr.ReadAt(p, int64(MinChunkLen)+5); err !=
+				ErrChecksum {
+				t.Errorf("ReadAt of corrupt chunk gave %v", err)
+			}
+		}
+	}
+
+	// A tree that does not match the root fails verification
+	tree, _ := NewEncoder(io.Discard).MerkleReadFrom(
+		strings.NewReader("abc"), sha256.New)
+	if err := tree.Verify(make([]byte, 32)); err != ErrChecksum {
+		t.Errorf("Verify of wrong root gave %v", err)
+	}
+	if _, err := DecodeMerkleTree(Encode(nil, []byte("x")),
+		sha256.New); err == nil {
+		t.Errorf("DecodeMerkleTree accepted malformed tree")
+	}
+}
+
+func TestNextLen(t *testing.T) {
+	var acc []byte
+	for _, st := range testCases {
+		acc = append(acc, st.blob...)
+	}
+	dec := NewDecoder(bytes.NewReader(acc))
+	for i, st := range testCases {
+		for j := 0; j < 2; j++ { // peeking twice consumes nothing
+			n, ok, err := dec.NextLen()
+			if err != nil || !ok || n != int64(len(st.data)) {
+				t.Errorf("NextLen case %v gave %v, %v, %v",
+					i, n, ok, err)
+			}
+		}
+		if b, err := dec.Bytes(); err != nil || !bytes.Equal(b, st.data) {
+			t.Errorf("incorrect decode after NextLen in case %v", i)
+		}
+	}
+	if _, _, err := dec.NextLen(); err != io.EOF {
+		t.Errorf("NextLen at end gave %v", err)
+	}
+
+	// A chunked blob reports only its first chunk
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	big := bytes.Repeat([]byte("c"), 3*MinChunkLen)
+	if _, err := enc.ReadFrom(bytes.NewReader(big)); err != nil {
+		t.Fatal(err)
+	}
+	dec = NewDecoder(&buf)
+	n, ok, err := dec.NextLen()
+	if err != nil || ok || n < int64(MinChunkLen) || n >= int64(len(big)) {
+		t.Errorf("NextLen of chunked blob gave %v, %v, %v", n, ok, err)
+	}
+	if n, err := dec.Skip(); err != nil || n != int64(len(big)) {
+		t.Errorf("Skip after NextLen gave %v, %v", n, err)
+	}
+}
+
+// seekReader counts the bytes read from an io.ReadSeeker,
+// hiding any other methods such as ReadByte.
+type seekReader struct {
+	r    io.ReadSeeker
+	read int
+	fail bool // whether Seek fails, as on a pipe
+}
+
+func (s *seekReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.read += n
+	return n, err
+}
+
+func (s *seekReader) Seek(off int64, whence int) (int64, error) {
+	if s.fail {
+		return 0, errors.New("illegal seek")
+	}
+	return s.r.Seek(off, whence)
+}
+
+func TestSkipSeek(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	big := bytes.Repeat([]byte("s"), 2*MaxChunkLen+5)
+	enc.Bytes(big[:MinChunkLen])
+	enc.ReadFrom(bytes.NewReader(big))
+	enc.Bytes(big[:4*seekSkipLen])
+	enc.String("after")
+
+	for _, fail := range []bool{false, true} {
+		sr := &seekReader{r: bytes.NewReader(buf.Bytes()), fail: fail}
+		dec := NewDecoder(sr)
+		for _, l := range []int{MinChunkLen, len(big), 4 * seekSkipLen} {
+			if n, err := dec.Skip(); err != nil || n != int64(l) {
+				t.Errorf("Skip gave %v, %v, want %v", n, err, l)
+			}
+		}
+		if s, err := dec.String(); err != nil || s != "after" {
+			t.Errorf("decode after Skip gave %q, %v", s, err)
+		}
+		if sr.read > buf.Len()/2 != fail {
+			t.Errorf("Skip with failing seek %v read %v bytes",
+				fail, sr.read)
+		}
+	}
+
+	// Seeking past the end of truncated input
+	sr := &seekReader{r: bytes.NewReader(buf.Bytes()[:MinChunkLen+100])}
+	dec := NewDecoder(sr)
+	dec.Skip()
+	if _, err := dec.Skip(); !errors.Is(err, coerr.Truncated) {
+		t.Errorf("Skip of truncated blob gave %v", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetChecksum(NewCRC32C())
+	big := bytes.Repeat([]byte("r"), 3*MinChunkLen+5)
+	for _, st := range testCases {
+		enc.Bytes(st.data)
+	}
+	enc.ReadFrom(bytes.NewReader(big))
+	enc.Bytes([]byte("after"))
+
+	dec := NewDecoder(&buf)
+	dec.SetChecksum(NewCRC32C())
+	for i, st := range testCases {
+		if err := iotest.TestReader(dec.Reader(), st.data); err != nil {
+			t.Errorf("case %v: %v", i, err)
+		}
+	}
+	b, err := io.ReadAll(dec.Reader())
+	if err != nil || !bytes.Equal(b, big) {
+		t.Errorf("chunked blob: read %v bytes, %v", len(b), err)
+	}
+	if s, err := dec.String(); err != nil || s != "after" {
+		t.Errorf("decode after Reader gave %q, %v", s, err)
+	}
+	if _, err := dec.Reader().Read(make([]byte, 1)); !errors.Is(err,
+		coerr.Truncated) {
+		t.Errorf("Reader at end gave %v", err)
+	}
+
+	dec = NewDecoder(bytes.NewReader([]byte{0x85, 1, 2}))
+	if b, err := io.ReadAll(dec.Reader()); !errors.Is(err, coerr.Truncated) {
+		t.Errorf("truncated blob gave %x, %v", b, err)
+	}
+}
+
+func TestResync(t *testing.T) {
+	marker := []byte("0123456789abcdef")
+	var in []byte
+	in = Encode(in, []byte("one"))
+	in = append(in, 0xc5, 0x01, 2, 3) // header of an over-long blob
+	in = Encode(in, marker)
+	in = Encode(in, []byte("two"))
+	dec := NewDecoder(bytes.NewReader(in))
+	dec.SetMaxBlobLen(10)
+	if s, err := dec.String(); err != nil || s != "one" {
+		t.Errorf("decode before corruption gave %q, %v", s, err)
+	}
+	if _, err := dec.String(); !errors.Is(err, coerr.TooLarge) {
+		t.Errorf("decode of corruption gave %v", err)
+	}
+	if n, err := dec.Resync(marker); err != nil || n != 2 {
+		t.Errorf("Resync gave %v, %v", n, err)
+	}
+	if s, err := dec.String(); err != nil || s != "two" {
+		t.Errorf("decode after Resync gave %q, %v", s, err)
+	}
+	if n, err := dec.Resync(marker); err != io.EOF || n != 0 {
+		t.Errorf("Resync at end gave %v, %v", n, err)
+	}
+
+	// Resynchronize on the next blob whose checksum verifies
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetChecksum(NewCRC32C())
+	for _, s := range []string{"one", "two", "three"} {
+		enc.String(s)
+	}
+	in = buf.Bytes()
+	in[5+4] = 0xc5 // header of "two"
+	dec = NewDecoder(bufio.NewReader(bytes.NewReader(in)))
+	dec.SetChecksum(NewCRC32C())
+	dec.SetMaxBlobLen(10)
+	dec.String()
+	if _, err := dec.String(); !errors.Is(err, coerr.TooLarge) {
+		t.Errorf("decode of corruption gave %v", err)
+	}
+	if n, err := dec.Resync(nil); err != nil || n != 2+5 {
+		t.Errorf("Resync by checksum gave %v, %v", n, err)
+	}
+	if s, err := dec.String(); err != nil || s != "three" {
+		t.Errorf("decode after Resync by checksum gave %q, %v", s, err)
+	}
+	if n, err := dec.Resync(nil); err != io.EOF || n != 0 {
+		t.Errorf("Resync by checksum at end gave %v, %v", n, err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	canonical := func(n int) []byte {
+		return Encode(nil, make([]byte, n))
+	}
+	streamed := func(n, chunkLen int) []byte {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetChunkLen(chunkLen)
+		enc.ReadFrom(bytes.NewReader(make([]byte, n)))
+		return buf.Bytes()
+	}
+	for i, c := range []struct {
+		blob []byte
+		ok   bool
+	}{
+		{canonical(0), true},
+		{canonical(MaxChunkLen), true},
+		{canonical(2 * MaxChunkLen), true},
+		{canonical(2*MaxChunkLen + 1), true},
+		{streamed(2*MaxChunkLen+1, MaxChunkLen), true},
+		{streamed(MinChunkLen+1, MinChunkLen), false},
+		{streamed(MaxChunkLen, MaxChunkLen), false}, // empty final chunk
+		{streamed(MaxChunkLen+1, MinChunkLen), false},
+	} {
+		for _, strict := range []bool{false, true} {
+			dec := NewDecoder(bytes.NewReader(c.blob))
+			dec.SetStrict(strict)
+			_, err := dec.Skip()
+			if ok := c.ok || !strict; ok != (err == nil) ||
+				(err != nil && !errors.Is(err, coerr.NonCanonical)) {
+				t.Errorf("case %v strict %v: Decoder gave %v",
+					i, strict, err)
+			}
+			if !strict && dec.NonCanonical() == c.ok {
+				t.Errorf("case %v: Decoder NonCanonical gave %v",
+					i, !c.ok)
+			}
+
+			bdec := NewBytesDecoder(c.blob)
+			bdec.SetStrict(strict)
+			_, err = bdec.Bytes()
+			if ok := c.ok || !strict; ok != (err == nil) ||
+				(err != nil && !errors.Is(err, coerr.NonCanonical)) {
+				t.Errorf("case %v strict %v: BytesDecoder gave %v",
+					i, strict, err)
+			}
+			if !strict && bdec.NonCanonical() == c.ok {
+				t.Errorf("case %v: BytesDecoder NonCanonical gave %v",
+					i, !c.ok)
+			}
+		}
+
+		// Normalizing yields the canonical encoding of the content
+		content, _ := NewDecoder(bytes.NewReader(c.blob)).Bytes()
+		in := append(append([]byte{0x83, 'a', 'b', 'c'}, c.blob...),
+			0x81, 0xff)
+		want := append(append([]byte{0x83, 'a', 'b', 'c'},
+			Encode(nil, content)...), 0x81, 0xff)
+		var out bytes.Buffer
+		if err := Normalize(&out, bytes.NewReader(in)); err != nil ||
+			!bytes.Equal(out.Bytes(), want) {
+			t.Errorf("case %v: Normalize gave %v bytes, %v",
+				i, out.Len(), err)
+		}
+	}
+	if err := Normalize(io.Discard, bytes.NewReader(
+		streamed(2*MinChunkLen, MinChunkLen)[:MinChunkLen+10])); !errors.Is(
+		err, ErrTruncated) {
+		t.Errorf("Normalize of truncated input gave %v", err)
+	}
+}
+
+func TestStrictInt(t *testing.T) {
+	for i, c := range []struct {
+		blob []byte
+		v    uint64
+		ok   bool
+	}{
+		{Encode(nil, nil), 0, true},
+		{Encode(nil, []byte{1, 0}), 256, true},
+		{Encode(nil, []byte{0}), 0, false},
+		{Encode(nil, []byte{0, 1}), 1, false},
+		{Encode(nil, []byte{0, 0, 0, 0, 0, 0, 0, 0}), 0, false},
+	} {
+		for _, strict := range []bool{false, true} {
+			ok := c.ok || !strict
+			dec := NewDecoder(bytes.NewReader(c.blob))
+			dec.SetStrict(strict)
+			v, err := dec.Uint64()
+			if ok != (err == nil) || (ok && v != c.v) ||
+				(err != nil && !errors.Is(err, coerr.NonCanonical)) {
+				t.Errorf("case %v strict %v: Decoder gave %v, %v",
+					i, strict, v, err)
+			}
+			if !strict && dec.NonCanonical() == c.ok {
+				t.Errorf("case %v: Decoder NonCanonical gave %v",
+					i, !c.ok)
+			}
+
+			bdec := NewBytesDecoder(c.blob)
+			bdec.SetStrict(strict)
+			v, err = bdec.Uint64()
+			if ok != (err == nil) || (ok && v != c.v) ||
+				(err != nil && !errors.Is(err, coerr.NonCanonical)) {
+				t.Errorf("case %v strict %v: BytesDecoder gave %v, %v",
+					i, strict, v, err)
+			}
+			if !strict && bdec.NonCanonical() == c.ok {
+				t.Errorf("case %v: BytesDecoder NonCanonical gave %v",
+					i, !c.ok)
+			}
+		}
+	}
+}
+
+func TestMaxBlobLen(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Bytes(make([]byte, 100))
+	enc.ReadFrom(bytes.NewReader(make([]byte, 3*MinChunkLen)))
+	input := buf.Bytes()
+
+	for _, c := range []struct {
+		max   int64
+		short bool // whether the short blob is within the limit
+		long  bool // whether the chunked blob is within the limit
+	}{
+		{0, true, true},
+		{99, false, false},
+		{100, true, false},
+		{2 * int64(MinChunkLen), true, false},
+		{3 * int64(MinChunkLen), true, true},
+	} {
+		for _, f := range []struct {
+			name string
+			dec  func(d *Decoder) error
+		}{
+			{"Bytes", func(d *Decoder) error {
+				_, err := d.Bytes()
+				return err
+			}},
+			{"Skip", func(d *Decoder) error {
+				_, err := d.Skip()
+				return err
+			}},
+			{"Arena", func(d *Decoder) error {
+				d.SetAllocator(NewArena(1024))
+				_, err := d.Bytes()
+				return err
+			}},
+		} {
+			dec := NewDecoder(bytes.NewReader(input))
+			dec.SetMaxBlobLen(c.max)
+			err := f.dec(dec)
+			if c.short != (err == nil) ||
+				(err != nil && !errors.Is(err, coerr.TooLarge)) {
+				t.Errorf("%s limit %v short blob gave %v",
+					f.name, c.max, err)
+			}
+			if err != nil {
+				continue
+			}
+			err = f.dec(dec)
+			if c.long != (err == nil) ||
+				(err != nil && !errors.Is(err, coerr.TooLarge)) {
+				t.Errorf("%s limit %v long blob gave %v",
+					f.name, c.max, err)
+			}
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	zone := time.FixedZone("X", -5*3600)
+	times := []time.Time{
+		time.Unix(0, 0),
+		time.Date(2024, 2, 29, 12, 30, 15, 123456789, zone),
+		time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Unix(0, -1<<63),
+		time.Unix(0, 1<<63-1),
+	}
+	durs := []time.Duration{0, time.Nanosecond, -time.Hour, 1<<63 - 1}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, tm := range times {
+		if err := enc.Time(tm); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, d := range durs {
+		enc.Duration(d)
+	}
+	late := time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := enc.Time(late); !errors.Is(err, coerr.TooLarge) {
+		t.Errorf("encoding out-of-range time gave %v", err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	bdec := NewBytesDecoder(buf.Bytes())
+	for _, tm := range times {
+		got, err := dec.Time()
+		bgot, berr := bdec.Time()
+		if err != nil || berr != nil || !got.Equal(tm) || !bgot.Equal(tm) ||
+			got.Location() != time.UTC {
+			t.Errorf("time %v decoded as %v, %v", tm, got, err)
+		}
+	}
+	for _, d := range durs {
+		got, err := dec.Duration()
+		bgot, berr := bdec.Duration()
+		if err != nil || berr != nil || got != d || bgot != d {
+			t.Errorf("duration %v decoded as %v, %v", d, got, err)
+		}
+	}
+
+	// Times are plain integer blobs
+	buf.Reset()
+	enc.Time(time.Unix(1, 0))
+	if !bytes.Equal(buf.Bytes(), AppendInt64(nil, 1e9)) {
+		t.Errorf("time encoded as %x", buf.Bytes())
+	}
+}
+
+func TestField(t *testing.T) {
+	fields := []struct {
+		tag     uint64
+		content string
+	}{{1, "one"}, {300, ""}, {1 << 40, "x"}}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, f := range fields {
+		if err := enc.Field(f.tag, []byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it := NewDecoder(bytes.NewReader(buf.Bytes())).Fields()
+	bdec := NewBytesDecoder(buf.Bytes())
+	i := 0
+	for ; it.Next(); i++ {
+		if it.Tag() != fields[i].tag ||
+			string(it.Content()) != fields[i].content {
+			t.Errorf("field %v: got %v %q", i, it.Tag(), it.Content())
+		}
+		tag, content, err := bdec.Field()
+		if err != nil || tag != fields[i].tag ||
+			string(content) != fields[i].content {
+			t.Errorf("field %v: BytesDecoder gave %v %q, %v",
+				i, tag, content, err)
+		}
+	}
+	if it.Err() != nil || i != len(fields) {
+		t.Errorf("iterated over %v fields, %v", i, it.Err())
+	}
+
+	// A tag without content is truncated
+	dec := NewDecoder(bytes.NewReader(AppendUint64(nil, 9)))
+	if _, _, err := dec.Field(); !errors.Is(err, coerr.Truncated) {
+		t.Errorf("truncated field gave %v", err)
+	}
+}
+
+func TestDict(t *testing.T) {
+	in := []string{"temp", "host", "", "x", "temp", "host", "temp",
+		strings.Repeat("y", MaxDictEntryLen+1), "load", "temp", "load"}
+	var buf bytes.Buffer
+	enc := NewDictEncoder(NewEncoder(&buf), 2)
+	plain := 0
+	for _, s := range in {
+		if err := enc.String(s); err != nil {
+			t.Fatal(err)
+		}
+		plain += len(Encode(nil, []byte(s)))
+	}
+	if buf.Len() >= plain {
+		t.Errorf("dictionary encoding took %v bytes, plain %v",
+			buf.Len(), plain)
+	}
+
+	dec := NewDictDecoder(NewDecoder(bytes.NewReader(buf.Bytes())), 2)
+	for _, want := range in {
+		if s, err := dec.String(); err != nil || s != want {
+			t.Errorf("decoded %q, %v, want %q", s, err, want)
+		}
+	}
+	if _, err := dec.String(); err != EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	// A decoder with a smaller dictionary must reject excess entries.
+	dec = NewDictDecoder(NewDecoder(bytes.NewReader(buf.Bytes())), 1)
+	var err error
+	for err == nil {
+		_, err = dec.String()
+	}
+	if !errors.Is(err, coerr.Syntax) {
+		t.Errorf("excess entry gave %v", err)
+	}
+
+	for _, b := range [][]byte{{}, {3}, {dictRef, 0}, {dictRef, 5},
+		{dictDefine, 'a'}} {
+		dec := NewDictDecoder(NewDecoder(bytes.NewReader(
+			Encode(nil, b))), 0)
+		if _, err := dec.Bytes(); !errors.Is(err, coerr.Syntax) {
+			t.Errorf("blob %x gave %v", b, err)
+		}
+	}
+}
+
+func TestFixed(t *testing.T) {
+	u := [16]byte{0xde, 0xad, 0xbe, 0xef, 15: 1}
+	a4 := netip.MustParseAddr("192.0.2.1")
+	a6 := netip.MustParseAddr("2001:db8::1")
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UUID(u)
+	enc.Bytes(make([]byte, 32))
+	enc.Addr(a4)
+	enc.Addr(a6)
+	enc.Bytes(make([]byte, 15)) // wrong lengths
+	enc.Bytes(make([]byte, 17))
+	enc.Bytes(make([]byte, 5))
+	enc.String("after")
+	if err := enc.Addr(netip.Addr{}); err == nil {
+		t.Errorf("encoding an invalid address succeeded")
+	}
+	in := buf.Bytes()
+
+	dec, bdec := NewDecoder(bytes.NewReader(in)), NewBytesDecoder(in)
+	if v, err := dec.UUID(); err != nil || v != u {
+		t.Errorf("UUID gave %x, %v", v, err)
+	}
+	if v, err := bdec.UUID(); err != nil || v != u {
+		t.Errorf("BytesDecoder UUID gave %x, %v", v, err)
+	}
+	if b, err := dec.FixedBytes(32); err != nil || len(b) != 32 {
+		t.Errorf("FixedBytes gave %v, %v", len(b), err)
+	}
+	if b, err := bdec.FixedBytes(32); err != nil || len(b) != 32 {
+		t.Errorf("BytesDecoder FixedBytes gave %v, %v", len(b), err)
+	}
+	for _, want := range []netip.Addr{a4, a6} {
+		if a, err := dec.Addr(); err != nil || a != want {
+			t.Errorf("Addr gave %v, %v", a, err)
+		}
+		if a, err := bdec.Addr(); err != nil || a != want {
+			t.Errorf("BytesDecoder Addr gave %v, %v", a, err)
+		}
+	}
+	_, err1 := dec.UUID()
+	_, err2 := dec.UUID()
+	_, err3 := dec.Addr()
+	_, berr1 := bdec.UUID()
+	_, berr2 := bdec.UUID()
+	_, berr3 := bdec.Addr()
+	for i, err := range []error{err1, err3, berr1, berr3} {
+		if !errors.Is(err, coerr.Syntax) {
+			t.Errorf("short blob %v gave %v", i, err)
+		}
+	}
+	for i, err := range []error{err2, berr2} {
+		if !errors.Is(err, ErrTooLong) {
+			t.Errorf("long blob %v gave %v", i, err)
+		}
+	}
+	if s, err := dec.String(); err != nil || s != "after" {
+		t.Errorf("decode after wrong lengths gave %q, %v", s, err)
+	}
+	if s, err := bdec.String(); err != nil || s != "after" {
+		t.Errorf("BytesDecoder after wrong lengths gave %q, %v", s, err)
+	}
+
+	// A chunked blob is refused at its first chunk beyond the length,
+	// with or without a checksum
+	for _, sum := range []bool{false, true} {
+		buf.Reset()
+		enc := NewEncoder(&buf)
+		if sum {
+			enc.SetChecksum(NewCRC32C())
+		}
+		enc.ReadFrom(bytes.NewReader(make([]byte, 3*MinChunkLen)))
+		enc.String("after")
+		dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+		if sum {
+			dec.SetChecksum(NewCRC32C())
+		}
+		if _, err := dec.FixedBytes(MinChunkLen + 1); !errors.Is(err,
+			ErrTooLong) {
+			t.Errorf("chunked FixedBytes gave %v", err)
+		}
+		if s, err := dec.String(); err != nil || s != "after" {
+			t.Errorf("decode after chunked blob gave %q, %v", s, err)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	vals := []interface{}{[]byte{1, 2, 3}, "hello", uint64(1 << 40),
+		int64(-12345), int(-1), uint32(7)}
+	for i, v := range vals {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Unmarshal into a fresh value of the same type
+		p := reflect.New(reflect.TypeOf(v))
+		if err := Unmarshal(b, p.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p.Elem().Interface(), v) {
+			t.Errorf("incorrect unmarshal in case %v", i)
+		}
+	}
+
+	// Trailing data and out-of-range values should be rejected
+	var s string
+	if err := Unmarshal([]byte{'a', 'b'}, &s); err == nil {
+		t.Error("unmarshal accepted trailing data")
+	}
+	var u uint32
+	if err := Unmarshal(Encode(nil, []byte{1, 0, 0, 0, 0}), &u); err == nil {
+		t.Error("unmarshal accepted out-of-range integer")
+	}
+}
+
+func TestInt(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 63, -64, 64, -65, 1 << 40,
+		-1 << 63, 1<<63 - 1} {
+
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Int64(v); err != nil {
+			t.Fatal(err)
+		}
+		b := AppendInt64(nil, v)
+		if !bytes.Equal(b, buf.Bytes()) {
+			t.Errorf("AppendInt64(%v) differs from Encoder.Int64", v)
+		}
+		d, rest, err := DecodeInt64(append(b, 0x42))
+		if err != nil || d != v || !bytes.Equal(rest, []byte{0x42}) {
+			t.Errorf("DecodeInt64 of %v got %v, %v, %v", v, d, rest, err)
+		}
+	}
+	if _, _, err := DecodeUint64(Encode(nil, make([]byte, 9))); err == nil {
+		t.Error("DecodeUint64 accepted a 9-byte integer")
+	}
+}
+
+// Check the allocation-free fast paths documented in the package overview.
+func TestLEB128(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 300, 1 << 63,
+		math.MaxUint64} {
+		varint := binary.AppendUvarint(nil, v)
+		blob, rest, err := LEB128ToBlob(nil, append(varint, 7))
+		if err != nil || !bytes.Equal(blob, AppendUint64(nil, v)) ||
+			!bytes.Equal(rest, []byte{7}) {
+			t.Errorf("LEB128ToBlob(%v) gave %x, %x, %v",
+				v, blob, rest, err)
+		}
+		back, rest, err := BlobToLEB128(nil, append(blob, 7))
+		if err != nil || !bytes.Equal(back, varint) ||
+			!bytes.Equal(rest, []byte{7}) {
+			t.Errorf("BlobToLEB128(%v) gave %x, %x, %v",
+				v, back, rest, err)
+		}
+	}
+
+	// Zigzag varints convert to signed integer blobs
+	for _, v := range []int64{0, -1, 1, -300, math.MinInt64, math.MaxInt64} {
+		blob, _, err := LEB128ToBlob(nil, binary.AppendVarint(nil, v))
+		if i, _, _ := DecodeInt64(blob); err != nil || i != v {
+			t.Errorf("zigzag varint %v converted to %v, %v",
+				v, i, err)
+		}
+	}
+
+	if blob, _, err := LEB128ToBlob(nil, []byte{0x81, 0x80, 0x00}); err !=
+		nil || !bytes.Equal(blob, []byte{1}) {
+		t.Errorf("padded varint gave %x, %v", blob, err)
+	}
+	if _, _, err := LEB128ToBlob(nil, []byte{0x80}); err != io.EOF {
+		t.Errorf("truncated varint gave %v", err)
+	}
+	overlong := bytes.Repeat([]byte{0xff}, 11)
+	if _, _, err := LEB128ToBlob(nil, overlong); !errors.Is(err,
+		coerr.TooLarge) {
+		t.Errorf("overlong varint gave %v", err)
+	}
+	if _, _, err := BlobToLEB128(nil,
+		[]byte{0x89, 1, 2, 3, 4, 5, 6, 7, 8, 9}); !errors.Is(err,
+		coerr.TooLarge) {
+		t.Errorf("9-byte integer blob gave %v", err)
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	small := []byte("hello, world")
+	medium := bytes.Repeat([]byte{0x5a}, 1000)
+	dst := make([]byte, 0, 2000)
+	blob := Encode(nil, medium)
+	ints := AppendUint64(nil, 1<<40)
+	enc := NewEncoder(io.Discard)
+	br := bytes.NewReader(blob)
+	dec := NewDecoder(struct{ io.Reader }{br}) // hide br's ReadByte
+
+	cases := []struct {
+		name string
+		f    func()
+	}{
+		{"Encode", func() { Encode(dst, medium) }},
+		{"Decode", func() { Decode(blob) }},
+		{"AppendUint64", func() { AppendUint64(dst, 1<<40) }},
+		{"AppendInt64", func() { AppendInt64(dst, -1<<40) }},
+		{"DecodeUint64", func() { DecodeUint64(ints) }},
+		{"Encoder.Bytes", func() { enc.Bytes(small) }},
+		{"Encoder.Uint64", func() { enc.Uint64(1 << 40) }},
+		{"Encoder.Int64", func() { enc.Int64(-1 << 40) }},
+		{"Decoder.Skip", func() { br.Reset(blob); dec.Skip() }},
+		{"BytesDecoder.Bytes", func() { NewBytesDecoder(blob).Bytes() }},
+		{"Decoder.BytesAppend", func() {
+			br.Reset(blob)
+			dst, _ = dec.BytesAppend(dst[:0])
+		}},
+		{"Decoder.Uint64", func() { br.Reset(ints); dec.Uint64() }},
+		{"Encoder.ReadFrom", func() {
+			br.Reset(medium)
+			enc.ReadFrom(br)
+		}},
+		{"BytesDecoder.Uint64", func() { NewBytesDecoder(ints).Uint64() }},
+	}
+	for _, c := range cases {
+		if n := testing.AllocsPerRun(100, c.f); n != 0 {
+			t.Errorf("%s allocates %v times per call", c.name, n)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	content := bytes.Repeat([]byte{0x5a}, 100)
+	dst := make([]byte, 0, 200)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	for i := 0; i < b.N; i++ {
+		Encode(dst, content)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	blob := Encode(nil, bytes.Repeat([]byte{0x5a}, 100))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(blob)))
+	for i := 0; i < b.N; i++ {
+		Decode(blob)
+	}
+}
+
+func BenchmarkEncoderBytes(b *testing.B) {
+	content := bytes.Repeat([]byte{0x5a}, 100)
+	enc := NewEncoder(io.Discard)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	for i := 0; i < b.N; i++ {
+		enc.Bytes(content)
+	}
+}
+
+func BenchmarkDecoderBytes(b *testing.B) {
+	blob := Encode(nil, bytes.Repeat([]byte{0x5a}, 100))
+	r := bytes.NewReader(blob)
+	dec := NewDecoder(r)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(blob)))
+	for i := 0; i < b.N; i++ {
+		r.Reset(blob)
+		dec.Bytes()
+	}
+}
+
+func TestArena(t *testing.T) {
+	var acc []byte
+	for _, st := range testCases {
+		acc = append(acc, st.blob...)
+	}
+
+	a := NewArena(1024)
+	for round := 0; round < 2; round++ {
+		dec := NewDecoder(bytes.NewReader(acc))
+		dec.SetAllocator(a)
+		for i, st := range testCases {
+			b, err := dec.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, st.data) {
+				t.Errorf("incorrect arena decode in case %v", i)
+			}
+		}
+		a.Reset()
+	}
+	a.Free()
+
+	// An empty blob decodes to an empty slice, not nil, as on the heap
+	dec := NewDecoder(bytes.NewReader(Encode(nil, nil)))
+	dec.SetAllocator(NewArena(0))
+	if b, err := dec.Bytes(); err != nil || b == nil {
+		t.Errorf("empty arena blob gave %v, %v", b, err)
+	}
+
+	// A truncated blob allocates only in proportion to its content
+	var ca countAlloc
+	hdr := AppendHeader(nil, MaxChunkLen)
+	dec = NewDecoder(bytes.NewReader(append(hdr, make([]byte, 10)...)))
+	dec.SetAllocator(&ca)
+	if _, err := dec.Bytes(); err == nil {
+		t.Error("truncated arena blob decoded")
+	}
+	if ca.n > MinChunkLen {
+		t.Errorf("truncated arena blob allocated %v bytes", ca.n)
+	}
+}
+
+// countAlloc counts the bytes allocated through it.
+type countAlloc struct{ n int }
+
+func (ca *countAlloc) Alloc(n int) []byte {
+	ca.n += n
+	return make([]byte, n)
+}
+
+func TestTransform(t *testing.T) {
+	ts := []ChunkTransform{FlateTransform{Level: 5}, ChecksumTransform{}}
+	var buf bytes.Buffer
+	te := NewTransformEncoder(NewEncoder(&buf), ts...)
+	te.SetChunkLen(1000)
+	for _, st := range testCases {
+		if err := te.Bytes(st.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	td := NewTransformDecoder(NewDecoder(bytes.NewReader(buf.Bytes())), ts...)
+	for i, st := range testCases {
+		b, err := td.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, st.data) {
+			t.Errorf("incorrect transform decode in case %v", i)
+		}
+	}
+
+	// Corruption within a chunk should be detected by the checksum
+	buf.Reset()
+	te = NewTransformEncoder(NewEncoder(&buf), ChecksumTransform{})
+	if err := te.Bytes([]byte("some data to be corrupted")); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	b[10] ^= 0x01
+	td = NewTransformDecoder(NewDecoder(bytes.NewReader(b)),
+		ChecksumTransform{})
+	if _, err := td.Bytes(); err != ErrChecksum {
+		t.Errorf("expected checksum error but got %v", err)
+	}
+
+	// Parallel transforms produce the same output in the same order
+	rnd := rand.New(rand.NewSource(2))
+	for _, n := range []int{0, 999, 1000, 10500} {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(rnd.Intn(4))
+		}
+		buf.Reset()
+		te = NewTransformEncoder(NewEncoder(&buf), ts...)
+		te.SetChunkLen(1000)
+		te.Bytes(data)
+		want := append([]byte{}, buf.Bytes()...)
+		for _, workers := range []int{1, 3, 8} {
+			for _, at := range []bool{false, true} {
+				buf.Reset()
+				te.SetWorkers(workers)
+				var k int64
+				var err error
+				if at {
+					k, err = te.ReadFromAt(bytes.NewReader(data),
+						int64(n))
+				} else {
+					k, err = te.ReadFrom(bytes.NewReader(data))
+				}
+				if err != nil || k != int64(n) ||
+					!bytes.Equal(buf.Bytes(), want) {
+					t.Errorf("length %v, %v workers, ReaderAt %v: "+
+						"gave %v, %v", n, workers, at, k, err)
+				}
+			}
+		}
+	}
+	te.SetWorkers(4)
+	if _, err := te.ReadFromAt(bytes.NewReader(make([]byte, 5000)),
+		5001); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFromAt of short input gave %v", err)
+	}
+	te = NewTransformEncoder(NewEncoder(&limitWriter{n: 300}), ts...)
+	te.SetChunkLen(1000)
+	te.SetWorkers(4)
+	if _, err := te.ReadFrom(bytes.NewReader(make([]byte, 100000))); err !=
+		io.ErrShortWrite {
+		t.Errorf("ReadFrom to failing writer gave %v", err)
+	}
+}
+
+func TestPadTransform(t *testing.T) {
+	for _, size := range []int{1, 2, 64, 100, 16500} {
+		if n := (PadTransform{size}).ChunkLen(); EncodedLen(n) > size ||
+			EncodedLen(n+1) <= size {
+			t.Errorf("ChunkLen for size %v gave %v", size, n)
+		}
+	}
+
+	pad := PadTransform{Size: 100}
+	data := bytes.Repeat([]byte("p"), 250)
+	var buf bytes.Buffer
+	te := NewTransformEncoder(NewEncoder(&buf), pad)
+	te.SetChunkLen(pad.ChunkLen())
+	if err := te.Bytes(data); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := DecodeAll(buf.Bytes())
+	if err != nil || len(chunks) != 4 {
+		t.Fatalf("padded blob has %v chunks, %v", len(chunks), err)
+	}
+	for _, c := range chunks[:3] {
+		if len(c) != pad.Size {
+			t.Errorf("padded chunk of %v bytes", len(c))
+		}
+	}
+	td := NewTransformDecoder(NewDecoder(&buf), pad)
+	if b, err := td.Bytes(); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("padded decode gave %v", err)
+	}
+
+	if _, err := pad.Encode(nil, make([]byte, 99)); err == nil {
+		t.Errorf("padding an over-long chunk succeeded")
+	}
+	bad, _ := pad.Encode(nil, []byte("x"))
+	bad[50] = 1
+	if _, err := pad.Decode(nil, bad); !errors.Is(err, coerr.Syntax) {
+		t.Errorf("decoding non-zero padding gave %v", err)
+	}
+	if _, err := pad.Decode(nil, bad[:50]); !errors.Is(err, coerr.Syntax) {
+		t.Errorf("decoding short padding gave %v", err)
+	}
+}
+
+// limitWriter accepts n bytes and then writes short.
+type limitWriter struct {
+	n int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		p = p[:w.n]
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestEncoderErr(t *testing.T) {
+	enc := NewEncoder(&limitWriter{n: 5})
+	if err := enc.String("abc"); err != nil || enc.Err() != nil {
+		t.Errorf("encoding gave %v, %v", err, enc.Err())
+	}
+	if err := enc.String("abcdef"); err != io.ErrShortWrite {
+		t.Errorf("short write gave %v", err)
+	}
+	w := enc.Writer()
+	if _, err := w.Write([]byte("x")); err != io.ErrShortWrite {
+		t.Errorf("Writer after failure gave %v", err)
+	}
+	if err := enc.Uint64(1); err != io.ErrShortWrite ||
+		enc.Err() != io.ErrShortWrite ||
+		enc.Flush() != io.ErrShortWrite {
+		t.Errorf("Uint64 after failure gave %v, %v", err, enc.Err())
+	}
+
+	// Read errors are sticky only once part of a blob has been written
+	errRead := errors.New("read failed")
+	enc = NewEncoder(io.Discard)
+	if _, err := enc.ReadFrom(iotest.ErrReader(errRead)); err != errRead ||
+		enc.Err() != nil {
+		t.Errorf("failed initial read gave %v, %v", err, enc.Err())
+	}
+	in := io.MultiReader(bytes.NewReader(make([]byte, 2*MinChunkLen)),
+		iotest.ErrReader(errRead))
+	if _, err := enc.ReadFrom(in); err != errRead || enc.Err() != errRead {
+		t.Errorf("failed later read gave %v, %v", err, enc.Err())
+	}
+	enc = NewEncoder(io.Discard)
+	_, err := enc.ReadFromN(strings.NewReader("abc"), 4)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || enc.Err() != err {
+		t.Errorf("ReadFromN of short input gave %v", err)
+	}
+
+	// Flush propagates to a buffered writer
+	var buf bytes.Buffer
+	enc = NewEncoder(bufio.NewWriter(&buf))
+	enc.String("hi")
+	if buf.Len() != 0 {
+		t.Errorf("output not buffered")
+	}
+	if err := enc.Flush(); err != nil ||
+		!bytes.Equal(buf.Bytes(), Encode(nil, []byte("hi"))) {
+		t.Errorf("Flush gave %x, %v", buf.Bytes(), err)
+	}
+	enc = NewEncoder(bufio.NewWriter(&limitWriter{n: 1}))
+	enc.String("hi")
+	if err := enc.Flush(); err != io.ErrShortWrite ||
+		enc.String("again") != io.ErrShortWrite {
+		t.Errorf("failed Flush gave %v", err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, b := range [][]byte{
+		{0x81}, {0xc0}, {0x83, 'a'}, {0x81, 0x00, 0x00},
+		append([]byte{0x81, 0x40, 0x00, 0x00}, make([]byte, 16448)...),
+	} {
+		_, err := NewDecoder(bytes.NewReader(b)).Bytes()
+		if !errors.Is(err, coerr.Truncated) ||
+			!errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("decoding %x gave %v", b[:4], err)
+		}
+	}
+	if _, err := NewDecoder(bytes.NewReader(nil)).Bytes(); err != io.EOF {
+		t.Errorf("decoding empty input gave %v", err)
+	}
+	if _, err := NewDecoder(bytes.NewReader(
+		[]byte{0x89, 1, 2, 3, 4, 5, 6, 7, 8, 9})).Uint64(); !errors.Is(err,
+		coerr.TooLarge) {
+		t.Errorf("decoding large integer gave %v", err)
+	}
+
+	// Errors record the offset of the offending chunk
+	partial := append([]byte{0x81, 0x40, 0x00, 0x00}, make([]byte, 16448)...)
+	for _, c := range []struct {
+		input  []byte
+		kind   error
+		offset int64
+	}{
+		{[]byte{0x83, 'a', 'b', 'c', 0x83, 'a'}, ErrTruncated, 4},
+		{append([]byte{1, 2}, partial...), ErrTruncated, 6 + 16448},
+		{append([]byte{1, 2}, partial...), ErrNonCanonical, 2},
+		{[]byte{7, 0x89, 1, 2, 3, 4, 5, 6, 7, 8, 9}, ErrTooLong, 1},
+	} {
+		dec := NewDecoder(bytes.NewReader(c.input))
+		dec.SetStrict(c.kind == ErrNonCanonical)
+		bdec := NewBytesDecoder(c.input)
+		bdec.SetStrict(c.kind == ErrNonCanonical)
+		var err, berr error
+		for err == nil {
+			_, err = dec.Uint64()
+		}
+		for berr == nil {
+			_, berr = bdec.Uint64()
+		}
+		for _, err := range []error{err, berr} {
+			var ce *coerr.Error
+			if !errors.Is(err, c.kind) || !errors.As(err, &ce) ||
+				ce.Offset != c.offset {
+				t.Errorf("decoding %x gave %v, want %v at %v",
+					c.input[:6], err, c.kind, c.offset)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	var buf bytes.Buffer
+	var es, ds Stats
+	enc := NewEncoder(&buf)
+	enc.SetStats(&es)
+	enc.Uint64(5)
+	enc.String("hello")
+	enc.ReadFrom(bytes.NewReader(make([]byte, 2*MinChunkLen+3)))
+	want := Stats{Blobs: 3, Chunks: 5, Header: 0 + 1 + 4 + 4 + 1,
+		Content: 1 + 5 + 2*int64(MinChunkLen) + 3}
+	if es != want || es.Header+es.Content != int64(buf.Len()) {
+		t.Errorf("Encoder stats %+v, want %+v", es, want)
+	}
+
+	dec := NewDecoder(&buf)
+	dec.SetStats(&ds)
+	dec.Uint64()
+	dec.String()
+	dec.Skip()
+	if ds != want {
+		t.Errorf("Decoder stats %+v, want %+v", ds, want)
+	}
+	if o := ds.Overhead(); o != 10.0/float64(10+want.Content) {
+		t.Errorf("Overhead gave %v", o)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	n := int64(2*MinChunkLen + 5)
+	content := make([]byte, n)
+	want := []int64{int64(MinChunkLen), int64(2 * MinChunkLen), n}
+	var got []int64
+	progress := func(n int64) { got = append(got, n) }
+	check := func(what string, want []int64) {
+		t.Helper()
+		ok := len(got) == len(want)
+		for i := 0; ok && i < len(got); i++ {
+			ok = got[i] == want[i]
+		}
+		if !ok {
+			t.Errorf("%v progress gave %v, want %v", what, got, want)
+		}
+		got = nil
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetProgress(progress)
+	enc.ReadFrom(bytes.NewReader(content))
+	check("ReadFrom", want)
+	w := enc.Writer()
+	w.Write(content)
+	w.Close()
+	check("Writer", want)
+	enc.ReadFromN(bytes.NewReader(content), n)
+	check("ReadFromN", []int64{n})
+	enc.String("x")
+	check("String", nil)
+
+	dec := NewDecoder(&buf)
+	dec.SetProgress(progress)
+	dec.WriteTo(io.Discard)
+	check("WriteTo", want)
+	io.Copy(io.Discard, dec.Reader())
+	check("Reader", want)
+	dec.WriteTo(io.Discard)
+	check("WriteTo", []int64{n})
+}
+
+func TestTee(t *testing.T) {
+	big := bytes.Repeat([]byte("t"), 2*MinChunkLen+3)
+	for _, sum := range []bool{false, true} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		if sum {
+			e.SetChecksum(NewCRC32C())
+		}
+		tee := sha256.New()
+		e.SetTee(tee)
+		e.Bytes([]byte("a"))
+		e.Bytes([]byte{0xff})
+		e.String("hello")
+		e.Uint64(12345)
+		e.ReadFrom(bytes.NewReader(big))
+		e.ReadFromN(bytes.NewReader([]byte{0x80}), 1)
+		w := e.Writer()
+		w.Write(big)
+		w.Close()
+		e.Bytes(big)
+		want := sha256.Sum256(buf.Bytes())
+		if !bytes.Equal(tee.Sum(nil), want[:]) {
+			t.Errorf("Encoder tee digest mismatch, checksum %v", sum)
+		}
+
+		// Consume the blobs in various ways through a decoder tee
+		br := bytes.NewReader(buf.Bytes())
+		d := NewDecoder(struct{ io.Reader }{br})
+		if sum {
+			d.SetChecksum(NewCRC32C())
+		}
+		tee.Reset()
+		d.SetTee(tee)
+		d.NextLen()
+		d.Bytes()
+		d.NextLen()
+		d.Skip()
+		d.String()
+		d.Uint64()
+		d.WriteTo(io.Discard)
+		d.Skip()
+		io.Copy(io.Discard, d.Reader())
+		b, err := d.Bytes()
+		if err != nil || !bytes.Equal(b, big) {
+			t.Fatalf("tee decode gave %v", err)
+		}
+		if !bytes.Equal(tee.Sum(nil), want[:]) {
+			t.Errorf("Decoder tee digest mismatch, checksum %v", sum)
+		}
+
+		// Removing the tee stops the hashing
+		d.SetTee(nil)
+		if _, ok := d.r.(*teeReader); ok {
+			t.Errorf("SetTee(nil) left the tee in place")
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetChecksum(NewCRC32C())
+	big := bytes.Repeat([]byte("y"), 3*MinChunkLen)
+	e.Bytes([]byte("a"))
+	e.String("hello")
+	e.Uint64(12345)
+	e.Bytes(big)
+	e.Bytes(nil)
+	enc := append([]byte{}, buf.Bytes()...)
+
+	// Each blob is followed by a 4-byte CRC blob
+	if want := 1 + 6 + 3 + 3*MinChunkLen + 4 + 1 + 5*5; len(enc) != want {
+		t.Errorf("encoded %v bytes, want %v", len(enc), want)
+	}
+
+	for _, alloc := range []bool{false, true} {
+		d := NewDecoder(bytes.NewReader(enc))
+		d.SetChecksum(NewCRC32C())
+		if alloc {
+			d.SetAllocator(NewArena(0))
+		}
+		a, err1 := d.Bytes()
+		s, err2 := d.String()
+		u, err3 := d.Uint64()
+		b, err4 := d.Bytes()
+		z, err5 := d.Bytes()
+		if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+			t.Fatal(err)
+		}
+		if string(a) != "a" || s != "hello" || u != 12345 ||
+			!bytes.Equal(b, big) || len(z) != 0 {
+			t.Error("checksummed blobs decoded incorrectly")
+		}
+	}
+
+	// Corrupting a content byte or checksum byte must be detected
+	for _, i := range []int{0, 3, 7, 1000, len(enc) - 1} {
+		bad := append([]byte{}, enc...)
+		bad[i] ^= 0x10
+		d := NewDecoder(bytes.NewReader(bad))
+		d.SetChecksum(NewCRC32C())
+		var err error
+		for err == nil {
+			_, err = d.Bytes()
+		}
+		if err != ErrChecksum {
+			t.Errorf("corrupting byte %v gave %v", i, err)
+		}
+
+		bd := NewBytesDecoder(bad)
+		bd.SetChecksum(NewCRC32C())
+		for err = nil; err == nil; {
+			_, err = bd.Skip()
+		}
+		if err != ErrChecksum {
+			t.Errorf("BytesDecoder corrupting byte %v gave %v", i, err)
+		}
+	}
+
+	// BytesDecoder verifies checksums too
+	bd := NewBytesDecoder(enc)
+	bd.SetChecksum(NewCRC32C())
+	a, err1 := bd.Bytes()
+	s, err2 := bd.String()
+	u, err3 := bd.Uint64()
+	b, err4 := bd.Bytes()
+	n, err5 := bd.Skip()
+	_, err6 := bd.Skip()
+	if err := errors.Join(err1, err2, err3, err4, err5); err != nil ||
+		err6 != io.EOF {
+		t.Fatal(err, err6)
+	}
+	if string(a) != "a" || s != "hello" || u != 12345 ||
+		!bytes.Equal(b, big) || n != 0 {
+		t.Error("checksummed blobs decoded incorrectly by BytesDecoder")
+	}
+}
+
+func TestSyncEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetChecksum(NewCRC32C())
+	se := NewSyncEncoder(e)
+
+	// Concurrent writers, one streaming multi-chunk blobs
+	big := bytes.Repeat([]byte("b"), 3*MinChunkLen)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				var err error
+				switch g {
+				case 0:
+					_, err = se.ReadFrom(bytes.NewReader(big))
+				case 1:
+					err = se.Do(func(e *Encoder) error {
+						if err := e.Uint64(uint64(i)); err != nil {
+							return err
+						}
+						return e.String("pair")
+					})
+				default:
+					err = se.Bytes([]byte{byte(g), byte(i)})
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	d := NewDecoder(&buf)
+	d.SetChecksum(NewCRC32C())
+	counts := map[string]int{}
+	for {
+		b, err := d.Bytes()
+		if err == EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case bytes.Equal(b, big):
+			counts["big"]++
+		case len(b) == 2:
+			counts["small"]++
+		case len(b) <= 1:
+			s, err := d.String()
+			if err != nil || s != "pair" {
+				t.Fatalf("pair split: %q, %v", s, err)
+			}
+			counts["pair"]++
+		default:
+			t.Fatalf("unexpected blob of %d bytes", len(b))
+		}
+	}
+	if counts["big"] != 50 || counts["small"] != 100 || counts["pair"] != 50 {
+		t.Errorf("counts %v", counts)
+	}
+}
+
+func TestNumberFormat(t *testing.T) {
+	for _, c := range []struct {
+		f       NumberFormat
+		v       int64
+		content string // hex
+	}{
+		{NumberFormat{}, -2, "03"}, // zigzag, as Int64
+		{NumberFormat{}, 300, "0258"},
+		{NumberFormat{LittleEndian: true}, 300, "5802"},
+		{NumberFormat{TwosComplement: true}, 0, ""},
+		{NumberFormat{TwosComplement: true}, -1, "ff"},
+		{NumberFormat{TwosComplement: true}, 127, "7f"},
+		{NumberFormat{TwosComplement: true}, 128, "0080"},
+		{NumberFormat{TwosComplement: true}, -129, "ff7f"},
+		{NumberFormat{TwosComplement: true, LittleEndian: true}, -129, "7fff"},
+		{NumberFormat{TwosComplement: true, Width: 4}, -2, "fffffffe"},
+		{NumberFormat{TwosComplement: true, Width: 4, LittleEndian: true},
+			-2, "feffffff"},
+		{NumberFormat{Width: 2}, 1, "0002"},
+		{NumberFormat{TwosComplement: true, Width: 8}, math.MinInt64,
+			"8000000000000000"},
+	} {
+		b, err := c.f.AppendInt64(nil, c.v)
+		if err != nil {
+			t.Fatalf("%+v %v: %v", c.f, c.v, err)
+		}
+		content, _, _ := Decode(b)
+		if hex.EncodeToString(content) != c.content {
+			t.Errorf("%+v %v: encoded %x, want %s", c.f, c.v, content,
+				c.content)
+		}
+		v, rest, err := c.f.DecodeInt64(b)
+		if err != nil || v != c.v || len(rest) != 0 {
+			t.Errorf("%+v %v: decoded %v, %v", c.f, c.v, v, err)
+		}
+
+		var buf bytes.Buffer
+		if err := c.f.EncodeInt64(NewEncoder(&buf), c.v); err != nil ||
+			!bytes.Equal(buf.Bytes(), b) {
+			t.Errorf("%+v %v: EncodeInt64 gave %x, %v", c.f, c.v,
+				buf.Bytes(), err)
+		}
+		if v, err := c.f.ReadInt64(NewDecoder(&buf)); err != nil || v != c.v {
+			t.Errorf("%+v %v: ReadInt64 gave %v, %v", c.f, c.v, v, err)
+		}
+	}
+
+	le := &NumberFormat{LittleEndian: true, Width: 2}
+	b, err := le.AppendUint64(nil, 0x1234)
+	if err != nil || !bytes.Equal(b, []byte{0x82, 0x34, 0x12}) {
+		t.Errorf("AppendUint64 gave %x, %v", b, err)
+	}
+	_, err = le.AppendUint64(nil, 0x10000)
+	if !errors.Is(err, coerr.TooLarge) {
+		t.Errorf("oversized value gave %v", err)
+	}
+	_, _, err = le.DecodeUint64([]byte{0x81, 0x80})
+	if !errors.Is(err, coerr.Syntax) {
+		t.Errorf("wrong width gave %v", err)
+	}
+
+	// Fixed-point decimals
+	dec := &NumberFormat{Scale: 2, TwosComplement: true}
+	for _, c := range []struct {
+		in, out string
+		err     error
+	}{
+		{"12.5", "12.50", nil},
+		{"-0.07", "-0.07", nil},
+		{"+3", "3.00", nil},
+		{"0", "0.00", nil},
+		{"1.234", "", coerr.TooLarge},
+		{"1e5", "", coerr.Syntax},
+		{"1.", "", coerr.Syntax},
+		{"--1", "", coerr.Syntax},
+		{"999999999999999999999", "", coerr.TooLarge},
+	} {
+		var buf bytes.Buffer
+		err := dec.EncodeDecimal(NewEncoder(&buf), c.in)
+		if !errors.Is(err, c.err) {
+			t.Errorf("EncodeDecimal(%q) gave %v", c.in, err)
+		}
+		if err != nil {
+			continue
+		}
+		if s, err := dec.ReadDecimal(NewDecoder(&buf)); err != nil || s != c.out {
+			t.Errorf("%q decoded as %q, %v", c.in, s, err)
+		}
+	}
+}
+
+// typedPoint is a structured value for testing the typed codec.
+type typedPoint struct {
+	X, Y int32
+	Tags []string
+}
+
+func (p typedPoint) MarshalCBE(e *Encoder) error {
+	if err := EncodeTo(e, p.X); err != nil {
+		return err
+	}
+	if err := EncodeTo(e, p.Y); err != nil {
+		return err
+	}
+	return EncodeList(e, p.Tags, EncodeTo[string])
+}
+
+func (p *typedPoint) UnmarshalCBE(d *Decoder) (err error) {
+	if p.X, err = DecodeFrom[int32](d); err != nil {
+		return err
+	}
+	if p.Y, err = DecodeFrom[int32](d); err != nil {
+		return err
+	}
+	p.Tags, err = DecodeList(d, DecodeFrom[string])
+	return err
+}
+
+func TestTyped(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	EncodeTo(e, true)
+	EncodeTo(e, int8(-128))
+	EncodeTo(e, uint16(65535))
+	EncodeTo(e, "text")
+	EncodeTo(e, []byte{1, 2})
+	EncodeList(e, [][]uint32{{1, 2}, {}, {3}},
+		func(e *Encoder, l []uint32) error {
+			return EncodeList(e, l, EncodeTo[uint32])
+		})
+	pts := []typedPoint{{1, -2, []string{"a"}}, {3, 4, nil}}
+	EncodeList(e, pts, EncodeMarshaler[typedPoint])
+	EncodeTo(e, uint64(256))
+	EncodeTo(e, uint64(2))
+
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	b, err1 := DecodeFrom[bool](d)
+	i, err2 := DecodeFrom[int8](d)
+	u, err3 := DecodeFrom[uint16](d)
+	s, err4 := DecodeFrom[string](d)
+	bs, err5 := DecodeFrom[[]byte](d)
+	ll, err6 := DecodeList(d, func(d *Decoder) ([]uint32, error) {
+		return DecodeList(d, DecodeFrom[uint32])
+	})
+	ps, err7 := DecodeList(d, DecodeUnmarshaler[typedPoint])
+	if err := errors.Join(err1, err2, err3, err4, err5, err6,
+		err7); err != nil {
+		t.Fatal(err)
+	}
+	if !b || i != -128 || u != 65535 || s != "text" ||
+		!bytes.Equal(bs, []byte{1, 2}) ||
+		!reflect.DeepEqual(ll, [][]uint32{{1, 2}, {}, {3}}) ||
+		!reflect.DeepEqual(ps, []typedPoint{{1, -2, []string{"a"}},
+			{3, 4, []string{}}}) {
+		t.Errorf("typed decode gave %v %v %v %q %v %v %v",
+			b, i, u, s, bs, ll, ps)
+	}
+
+	// Out-of-range values are rejected
+	if _, err := DecodeFrom[uint8](d); !errors.Is(err, ErrTooLong) {
+		t.Errorf("decoding 256 as uint8 gave %v", err)
+	}
+	if _, err := DecodeFrom[bool](d); !errors.Is(err, ErrTooLong) {
+		t.Errorf("decoding 2 as bool gave %v", err)
+	}
 }
@@ -0,0 +1,151 @@
+package cbe
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// Decode a blob from the start of a byte slice like Decode,
+// but rather than concatenating the content of a chunked blob
+// into a fresh byte slice, append to dst a sub-slice of buf
+// for the content of each non-empty chunk, and return the extended slice.
+// Thus decoding a huge chunked blob from a memory-mapped file
+// copies none of its content.
+// Wrap the result in a ChunksReader to read or seek within the content.
+// Like Decode, returns EOF if buf does not contain a complete blob.
+func DecodeChunks(dst [][]byte, buf []byte) (chunks [][]byte,
+	remainder []byte, err error) {
+
+	chunks = dst
+	for {
+		ofs, n, part, err := decodeHeader(buf)
+		if err != nil {
+			return dst, nil, err
+		}
+		if len(buf) < ofs+n {
+			return dst, nil, EOF
+		}
+		if n > 0 {
+			chunks = append(chunks, buf[ofs:ofs+n:ofs+n])
+		}
+		buf = buf[ofs+n:]
+		if !part {
+			return chunks, buf, nil
+		}
+	}
+}
+
+// Decode the next blob like Bytes,
+// but append to dst a sub-slice of the input for each non-empty chunk,
+// as DecodeChunks does, so that chunked content is never copied.
+func (d *BytesDecoder) Chunks(dst [][]byte) ([][]byte, error) {
+	end, _, _, err := d.span()
+	if err != nil {
+		return dst, err
+	}
+	chunks, _, _ := DecodeChunks(dst, d.buf[d.pos:end])
+	if err := d.consume(end); err != nil {
+		return dst, err
+	}
+	return chunks, nil
+}
+
+// ChunksReader reads the concatenation of a list of byte slices,
+// such as the chunks that DecodeChunks returns,
+// implementing io.Reader, io.ReaderAt, io.Seeker, and io.WriterTo.
+type ChunksReader struct {
+	chunks [][]byte
+	ends   []int64 // offset just past each chunk in the concatenation
+	pos    int64   // current read offset
+}
+
+// Create a ChunksReader over the concatenation of chunks.
+func NewChunksReader(chunks [][]byte) *ChunksReader {
+	r := &ChunksReader{chunks: chunks, ends: make([]int64, len(chunks))}
+	end := int64(0)
+	for i, c := range chunks {
+		end += int64(len(c))
+		r.ends[i] = end
+	}
+	return r
+}
+
+// Return the total length of the chunks.
+func (r *ChunksReader) Size() int64 {
+	if len(r.ends) == 0 {
+		return 0
+	}
+	return r.ends[len(r.ends)-1]
+}
+
+// Return the index of the chunk containing offset off,
+// or the number of chunks if off is at or beyond the end.
+func (r *ChunksReader) find(off int64) int {
+	return sort.Search(len(r.ends), func(i int) bool {
+		return r.ends[i] > off
+	})
+}
+
+// Read from the current offset.
+func (r *ChunksReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Read from offset off, without changing the current offset.
+func (r *ChunksReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errChunksOffset
+	}
+	tot := 0
+	for i := r.find(off); i < len(r.chunks) && len(p) > 0; i++ {
+		c := r.chunks[i]
+		l := copy(p, c[len(c)-int(r.ends[i]-off):])
+		tot += l
+		off += int64(l)
+		p = p[l:]
+	}
+	if len(p) > 0 {
+		return tot, io.EOF
+	}
+	return tot, nil
+}
+
+// Set the offset for the next Read, as described for io.Seeker.
+func (r *ChunksReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	case io.SeekStart:
+	default:
+		return 0, errors.New("cbe: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errChunksOffset
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Write the content from the current offset to w, chunk by chunk.
+func (r *ChunksReader) WriteTo(w io.Writer) (n int64, err error) {
+	for i := r.find(r.pos); i < len(r.chunks); i++ {
+		c := r.chunks[i]
+		l, err := w.Write(c[len(c)-int(r.ends[i]-r.pos):])
+		n += int64(l)
+		r.pos += int64(l)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+var errChunksOffset = errors.New("cbe: negative offset")
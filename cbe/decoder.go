@@ -1,80 +1,218 @@
 package cbe
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
-	"errors"
+	"hash"
 	"io"
-	"math/big"
-	"strings"
-)
 
-var minusOne = big.NewInt(-1)
+	"github.com/bford/cofo/coerr"
+)
 
 // Decoder decodes a series of blobs from an input stream.
 type Decoder struct {
-	r *bufio.Reader
+	r        byteReader
+	seeker   io.Seeker // underlying input if seekable, for Skip
+	alloc    Allocator // allocator for decoded content, or nil
+	sum      hash.Hash // per-blob checksum to verify, or nil
+	max      int64     // maximum content length of a blob, or 0 for none
+	strict   bool      // whether to reject non-canonical chunkings
+	nonCanon bool      // a non-canonical chunking has been accepted
+	stats    *Stats    // framing statistics to update, or nil
+
+	progress func(n int64) // called after each streamed chunk, or nil
+
+	off int64 // input offset following the last header decoded and its content
+	pos int64 // input offset of the last header decoded, for errors
+
+	peeked bool // a header has been decoded by NextLen but not consumed
+	peekN  int  // content length in the peeked header
+	peekP  bool // whether the peeked header is of a partial chunk
+}
+
+// byteReader is the input interface the Decoder needs.
+type byteReader interface {
+	io.Reader
+	io.ByteScanner
 }
 
 // Create a new Decoder that reads and decodes blobs from r.
 // Introduces buffering on r if r is not already a bufio.Reader.
 func NewDecoder(r io.Reader) *Decoder {
-	d := &Decoder{}
-	if br, ok := r.(*bufio.Reader); ok {
-		d.r = br
-	} else {
-		d.r = bufio.NewReader(r)
-	}
+	return NewDecoderSize(r, 0)
+}
+
+// Create a new Decoder like NewDecoder
+// whose buffer on r holds at least size bytes,
+// such as MaxChunkLen or more to read large chunks from disk
+// with fewer system calls.
+// If r is a bufio.Reader of at least that size, it is used directly.
+// A size of 0 or less selects the bufio package's default.
+// Tiny builds ignore size, as they read r without a buffer.
+func NewDecoderSize(r io.Reader, size int) *Decoder {
+	d := &Decoder{r: newByteReader(r, size)}
+	d.seeker, _ = r.(io.Seeker)
 	return d
 }
 
-// Decode the header of the next blob or chunk.
+// Report the content length of the next blob without consuming it,
+// so that the caller can allocate a buffer or decide to Skip the blob.
+// Only the blob's header is read from the input.
+// If the blob is chunked, NextLen returns the length of its first chunk
+// and false, indicating that the blob's content is at least that long.
+// Otherwise NextLen returns the exact content length and true.
+func (d *Decoder) NextLen() (int64, bool, error) {
+	if !d.peeked {
+		n, part, err := d.header()
+		if err != nil {
+			return 0, false, err
+		}
+		d.peeked, d.peekN, d.peekP = true, n, part
+	}
+	return int64(d.peekN), !d.peekP, nil
+}
+
+// Limit the content length of the blobs the Decoder accepts to n bytes,
+// counting all the chunks of a chunked blob,
+// so that input from an untrusted source cannot make the Decoder
+// buffer an unbounded amount of content.
+// Decoding a longer blob returns an error of kind coerr.TooLarge,
+// after which the Decoder is positioned within the blob
+// and cannot usefully decode further blobs.
+// WriteTo reports the error only after writing the content
+// of any preceding chunks within the limit.
+// The limit does not apply to checksum blobs.
+// A limit of 0 or less disables the check.
+func (d *Decoder) SetMaxBlobLen(n int64) {
+	d.max = n
+}
+
+// Set whether the Decoder accepts only canonically encoded blobs,
+// returning an error of kind coerr.NonCanonical on any other.
+// Every blob has only one canonical encoding,
+// so that parties hashing or signing encoded data
+// can rely on reproducing it byte for byte.
+// A blob is canonical if it consists of a single chunk
+// when its content fits in one, and otherwise
+// of partial chunks of exactly MaxChunkLen bytes
+// followed by a non-empty final chunk, as produced by Encode.
+// The Encoder produces canonical blobs when streaming
+// only if its chunk length is set to MaxChunkLen.
+//
+// A strict Decoder also rejects integer blobs that are not minimal,
+// that is, whose content begins with a zero byte,
+// when decoding them with Uint64, Int64, UnsignedInt, or SignedInt,
+// so that each integer likewise has only one accepted encoding.
+func (d *Decoder) SetStrict(strict bool) {
+	d.strict = strict
+}
+
+// Report whether the Decoder has accepted any non-canonically chunked blob
+// or non-minimal integer, as defined for SetStrict, since it was created
+// or since the last call to NonCanonical, which clears the flag.
+// A Decoder that is not strict thus tolerates non-canonical input
+// while letting the caller detect it, for example to re-encode the input
+// with Normalize.
+func (d *Decoder) NonCanonical() bool {
+	nc := d.nonCanon
+	d.nonCanon = false
+	return nc
+}
+
+// Decode the header of the next chunk of a blob
+// whose preceding chunks contained tot bytes of content,
+// enforcing the Decoder's blob length limit and strictness.
+func (d *Decoder) chunk(tot int64) (n int, part bool, err error) {
+	n, part, err = d.header()
+	if err != nil {
+		return 0, false, err
+	}
+	if d.max > 0 && tot+int64(n) > d.max {
+		return 0, false, at(errBlobLen, d.pos)
+	}
+	if cerr := checkChunk(n, part, tot > 0); cerr != nil {
+		if d.strict {
+			return n, part, at(cerr, d.pos)
+		}
+		d.nonCanon = true
+	}
+	return n, part, nil
+}
+
+// Check that a chunk of length n is permitted in a canonical blob,
+// where more indicates that the chunk follows partial chunks.
+func checkChunk(n int, part, more bool) error {
+	if part && n != MaxChunkLen {
+		return errPartialLen
+	}
+	if !part && more && n == 0 {
+		return errEmptyFinal
+	}
+	return nil
+}
+
+// Decode the header of the next blob or chunk,
+// tracking the input offset for error reports.
 func (d *Decoder) header() (n int, part bool, err error) {
+	if d.peeked {
+		d.peeked = false
+		return d.peekN, d.peekP, nil
+	}
+	d.pos = d.off
+	hlen, n, part, err := d.readHeader()
+	if err != nil {
+		return 0, false, err
+	}
+	d.off += int64(hlen + n)
+	d.stats.count(hlen, n, part)
+	return n, part, nil
+}
+
+// Read the header of the next blob or chunk,
+// returning the lengths of the header and content.
+func (d *Decoder) readHeader() (hlen, n int, part bool, err error) {
 	var h [4]byte
 
 	// first header byte
 	h[0], err = d.r.ReadByte()
 	if err != nil {
-		return 0, false, err
+		return 0, 0, false, err
 	}
 	if h[0] < 0x80 {
 		d.r.UnreadByte() // the header is also the 1-byte content
-		return 1, false, nil
+		return 0, 1, false, nil
 	}
 	if h[0] < 0xc0 && h[0] != 0x81 { // up to 64 bytes of content
-		return int(h[0] - 0x80), false, nil
+		return 1, int(h[0] - 0x80), false, nil
 	}
 
 	// second header byte
 	h[1], err = d.r.ReadByte()
 	if err != nil {
-		return 0, false, err
+		return 0, 0, false, d.truncated(err)
 	}
 	if h[0] == 0x81 && h[1] >= 0x80 {
 		d.r.UnreadByte() // 1-byte actual content
-		return 1, false, nil
+		return 1, 1, false, nil
 	}
 	if h[0] >= 0xc0 { // up to 16447 bytes of content
-		return 64 + int(h[0]&0x3f)<<8 + int(h[1]), false, nil
+		return 2, 64 + int(h[0]&0x3f)<<8 + int(h[1]), false, nil
 	}
 
 	// third header byte
 	h[2], err = d.r.ReadByte()
 	if err != nil {
-		return 0, false, err
+		return 0, 0, false, d.truncated(err)
 	}
 
 	// fourth header byte
 	h[3], err = d.r.ReadByte()
 	if err != nil {
-		return 0, false, err
+		return 0, 0, false, d.truncated(err)
 	}
 	if h[1] < 0x40 { // 4-byte header of final large chunk
-		return 16448 + int(h[1]&0x3f)<<16 + int(h[2])<<8 + int(h[3]),
+		return 4, 16448 + int(h[1]&0x3f)<<16 + int(h[2])<<8 + int(h[3]),
 			false, nil
 	} else { // 4-byte header of partial blob
-		return 16448 + int(h[1]&0x3f)<<16 + int(h[2])<<8 + int(h[3]),
+		return 4, 16448 + int(h[1]&0x3f)<<16 + int(h[2])<<8 + int(h[3]),
 			true, nil
 	}
 }
@@ -85,61 +223,288 @@ func (d *Decoder) header() (n int, part bool, err error) {
 // in chunks that may vary in size between MinChunkLen and MaxChunkLen,
 // depending on the encoder that wrote the blob.
 func (d *Decoder) WriteTo(w io.Writer) (n int64, err error) {
+	if d.sum == nil {
+		return d.writeTo(w)
+	}
+	if n, err = d.writeTo(io.MultiWriter(w, d.sum)); err != nil {
+		return 0, err
+	}
+	return n, d.verifySum()
+}
+
+func (d *Decoder) writeTo(w io.Writer) (n int64, err error) {
 	tot := int64(0)
-	for {
+	for first := true; ; first = false {
 		// Decode the next blob or part header
-		n, part, err := d.header()
+		n, part, err := d.chunk(tot)
 		if err != nil {
+			if !first {
+				err = d.truncated(err)
+			}
 			return 0, err
 		}
 
 		// Copy the data to the writer
-		wn, err := io.CopyN(w, d.r, int64(n))
+		if n > 0 {
+			wn, err := io.CopyN(w, d.r, int64(n))
+			if err != nil {
+				return 0, d.truncated(err)
+			}
+			if wn != int64(n) {
+				return 0, io.ErrShortWrite
+			}
+		}
+		tot += int64(n)
+		if d.progress != nil {
+			d.progress(tot)
+		}
+
+		if !part {
+			return tot, nil
+		}
+	}
+}
+
+// Return a Reader that yields the content of the next blob,
+// reading it progressively from the input across all its chunks
+// and returning io.EOF at the end of the blob.
+// If the input ends before or within the blob,
+// the Reader returns an error of kind coerr.Truncated.
+// With a checksum set, the Reader verifies the checksum
+// before returning io.EOF.
+// The Decoder must not be used for other purposes
+// until the Reader has returned an error.
+func (d *Decoder) Reader() io.Reader {
+	return &blobReader{d: d}
+}
+
+// blobReader reads the content of one blob from a Decoder.
+type blobReader struct {
+	d       *Decoder
+	started bool  // whether the blob's first header has been decoded
+	n       int   // content remaining in the current chunk
+	part    bool  // whether the current chunk is partial
+	tot     int64 // content read so far
+	err     error // sticky error, io.EOF at the end of the blob
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	for r.n == 0 && r.err == nil {
+		if r.started && !r.part { // end of the blob
+			r.err = io.EOF
+			if r.d.sum != nil {
+				if err := r.d.verifySum(); err != nil {
+					r.err = err
+				}
+			}
+			break
+		}
+		n, part, err := r.d.chunk(r.tot)
 		if err != nil {
+			r.err = r.d.truncated(err)
+			break
+		}
+		r.started, r.n, r.part = true, n, part
+	}
+	if r.n == 0 {
+		return 0, r.err
+	}
+
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.d.r.Read(p)
+	r.n -= n
+	r.tot += int64(n)
+	if r.n == 0 && n > 0 && r.d.progress != nil {
+		r.d.progress(r.tot)
+	}
+	if r.d.sum != nil {
+		r.d.sum.Write(p[:n])
+	}
+	if err == io.EOF && r.n == 0 {
+		err = nil // the blob may still be complete
+	}
+	if err != nil {
+		r.err = r.d.truncated(err)
+	}
+	return n, r.err
+}
+
+// Skip past the next complete blob without buffering its content,
+// returning the length of the content skipped.
+// Like WriteTo, supports blobs of any length.
+// If the Decoder's input is an io.Seeker, such as an os.File,
+// large content is skipped by seeking past it rather than reading it,
+// except that only its final byte is read to detect truncation.
+// With a checksum set, the content is still read in order to verify it.
+func (d *Decoder) Skip() (n int64, err error) {
+	if d.sum != nil {
+		return d.WriteTo(io.Discard)
+	}
+	tot := int64(0)
+	for first := true; ; first = false {
+		n, part, err := d.chunk(tot)
+		if err != nil {
+			if !first {
+				err = d.truncated(err)
+			}
 			return 0, err
 		}
-		if wn != int64(n) {
-			return 0, errors.New("short write")
+		if err := d.discard(n); err != nil {
+			return 0, d.truncated(err)
 		}
 		tot += int64(n)
-
 		if !part {
 			return tot, nil
 		}
 	}
 }
 
+// Content at least this long is skipped by seeking if the input allows.
+const seekSkipLen = 16 * 1024 // so every chunk of a chunked blob qualifies
+
+// Discard the next n bytes of input,
+// seeking past them if the input is seekable and n is large.
+func (d *Decoder) discard(n int) error {
+	if _, tee := d.r.(*teeReader); d.seeker != nil && !tee &&
+		n >= seekSkipLen {
+		if br, ok := d.r.(interface{ Buffered() int }); ok {
+			b := br.Buffered()
+			if _, err := d.discardRead(b); err != nil {
+				return err
+			}
+			n -= b
+		}
+
+		// Seek to the last byte and read it, to detect truncation
+		if _, err := d.seeker.Seek(int64(n-1), io.SeekCurrent); err == nil {
+			_, err := d.r.ReadByte()
+			return err
+		}
+		d.seeker = nil // not actually seekable, such as a pipe
+	}
+	_, err := d.discardRead(n)
+	return err
+}
+
+// Discard the next n bytes of input by reading them.
+func (d *Decoder) discardRead(n int) (int, error) {
+	if dr, ok := d.r.(interface{ Discard(int) (int, error) }); ok {
+		return dr.Discard(n)
+	}
+	k, err := io.CopyN(io.Discard, d.r, int64(n))
+	return int(k), err
+}
+
 // Decode a blob into a byte-slice.
 func (d *Decoder) Bytes() ([]byte, error) {
-	var buf bytes.Buffer
-	if _, err := d.WriteTo(&buf); err != nil {
+	if d.alloc != nil {
+		b, err := d.allocBytes()
+		if err == nil && d.sum != nil {
+			d.sum.Write(b)
+			err = d.verifySum()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	b, err := d.BytesAppend(nil)
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return b, nil
+}
+
+// Decode a blob and append its content to dst,
+// returning the extended slice,
+// or dst unchanged with an error if the blob could not be decoded.
+// Allocates only if dst lacks room for the content,
+// so that a caller can decode many blobs into one reused buffer.
+// Ignores any Allocator set on the Decoder.
+func (d *Decoder) BytesAppend(dst []byte) ([]byte, error) {
+	b, err := d.heapBytes(dst)
+	if err == nil && d.sum != nil {
+		d.sum.Write(b[len(dst):])
+		err = d.verifySum()
+	}
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
+}
+
+// Decode a blob and append its content to dst,
+// growing it on the heap as needed.
+// Trusts each chunk's declared length only up to MinChunkLen bytes
+// beyond the content actually read,
+// so that a header declaring a huge chunk at the end of a short input
+// cannot force a huge allocation.
+func (d *Decoder) heapBytes(dst []byte) ([]byte, error) {
+	buf := dst
+	for first := true; ; first = false {
+		n, part, err := d.chunk(int64(len(buf) - len(dst)))
+		if err != nil {
+			if !first {
+				err = d.truncated(err)
+			}
+			return nil, err
+		}
+		for n > 0 {
+			l := n
+			if l > MinChunkLen {
+				l = MinChunkLen
+			}
+			start := len(buf)
+			if cap(buf)-start >= l {
+				buf = buf[:start+l]
+			} else {
+				buf = append(buf, make([]byte, l)...)
+			}
+			if _, err := io.ReadFull(d.r, buf[start:]); err != nil {
+				return nil, d.truncated(err)
+			}
+			n -= l
+		}
+		if !part {
+			if buf == nil {
+				buf = []byte{}
+			}
+			return buf, nil
+		}
+	}
 }
 
 // Decode a blob into a UTF-8 string.
 func (d *Decoder) String() (string, error) {
-	var sb strings.Builder
-	if _, err := d.WriteTo(&sb); err != nil {
+	p := getDecodeBuf()
+	defer putDecodeBuf(p)
+	b, err := d.BytesAppend((*p)[:0])
+	*p = b
+	if err != nil {
 		return "", err
 	}
-	return sb.String(), nil
+	return string(b), nil
 }
 
 // Decode a blob as a big-endian unsigned integer.
 // Returns an error if the decoded value is too large for a uint64.
 func (d *Decoder) Uint64() (uint64, error) {
-	b, err := d.Bytes()
+	p := getDecodeBuf()
+	defer putDecodeBuf(p)
+	b, err := d.BytesAppend((*p)[:0])
+	*p = b
 	if err != nil {
 		return 0, err
 	}
 	if len(b) > 8 {
-		return 0, errors.New("integer value too large for uint64")
+		return 0, at(errUint64Range, d.pos)
+	}
+	if err := d.minimal(b); err != nil {
+		return 0, err
 	}
-	var b8 [8]byte
-	copy(b8[8-len(b):], b)
-	return binary.BigEndian.Uint64(b8[:]), nil
+	return uint64Value(b), nil
 }
 
 // Decode a blob as a big-endian zigzag-encoded signed integer.
@@ -150,40 +515,53 @@ func (d *Decoder) Int64() (int64, error) {
 		return 0, err
 	}
 
-	// zigzag-decode: 0 -> 0, 1 -> -1, 2 -> 1, 3 -> -2, etc.
-	if (v & 1) == 0 {
-		return int64(v >> 1), nil
-	} else {
-		return -1 - int64(v>>1), nil
-	}
+	return unzigzag(v), nil
 }
 
-// Decode a blob as a big-endian unsigned integer,
-// placing its value into the designated big.Int.
-func (d *Decoder) UnsignedInt(v *big.Int) error {
-	b, err := d.Bytes()
-	if err != nil {
-		return err
+// Check that integer content b has no leading zero byte,
+// returning an error if the Decoder is strict
+// or otherwise noting the non-canonical encoding.
+func (d *Decoder) minimal(b []byte) error {
+	if len(b) > 0 && b[0] == 0 {
+		if d.strict {
+			return at(errIntCanon, d.pos)
+		}
+		d.nonCanon = true
 	}
-	v.SetBytes(b)
 	return nil
 }
 
-// Decode a blob as a big-endian zigzag-encoded signed integer,
-// placing its value into the designated big.Int.
-func (d *Decoder) SignedInt(v *big.Int) error {
-	if err := d.UnsignedInt(v); err != nil {
-		return err
+// Returns a Truncated error if err indicates the input ended mid-blob.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errTruncated
 	}
+	return err
+}
 
-	// pull the sign out of the least-significant bit
-	sign := v.Bit(0)
+// Returns a Truncated error located at the chunk being decoded
+// if err indicates the input ended mid-blob.
+func (d *Decoder) truncated(err error) error {
+	return at(truncated(err), d.pos)
+}
 
-	// zigzag-decode: 0 -> 0, 1 -> -1, 2 -> 1, 3 -> -2, etc.
-	v.Rsh(v, 1)
-	if sign != 0 {
-		v.Sub(minusOne, v)
+// Returns err with its input offset set to off
+// if it is a codec error with no offset.
+func at(err error, off int64) error {
+	if ce, ok := err.(*coerr.Error); ok && ce.Offset < 0 {
+		return ce.At(off)
 	}
-
-	return nil
+	return err
 }
+
+var errBlobLen = coerr.New(coerr.TooLarge, "cbe", -1,
+	"blob exceeds the decoder's length limit")
+
+var errPartialLen = coerr.New(coerr.NonCanonical, "cbe", -1,
+	"partial chunk shorter than MaxChunkLen")
+var errEmptyFinal = coerr.New(coerr.NonCanonical, "cbe", -1,
+	"empty final chunk")
+var errIntCanon = coerr.New(coerr.NonCanonical, "cbe", -1,
+	"integer with leading zero byte")
+
+var errTruncated = coerr.Wrap(coerr.Truncated, "cbe", -1, io.ErrUnexpectedEOF)
@@ -0,0 +1,160 @@
+package cbe
+
+import (
+	"encoding/binary"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// Default number of dictionary entries used by NewDictEncoder
+// and NewDictDecoder when entries is zero.
+const DefaultDictEntries = 4096
+
+// Maximum length of a byte string that a dictionary may hold.
+// Longer strings are always encoded literally.
+const MaxDictEntryLen = 256
+
+// Tags marking the first content byte of each dictionary-layer blob.
+const (
+	dictLiteral = 0 // literal string, not added to the dictionary
+	dictDefine  = 1 // literal string, added as the next dictionary entry
+	dictRef     = 2 // big-endian index of an earlier dictionary entry
+)
+
+// DictEncoder encodes byte strings as blobs,
+// replacing strings it has seen before with small integer references
+// into a dictionary that it builds as the stream proceeds.
+//
+// Each string becomes one blob whose content starts with a tag byte.
+// A tag of 0 marks a literal string that is not added to the dictionary;
+// 1 marks a literal string that becomes the next dictionary entry;
+// and 2 marks a reference, followed by the big-endian entry index
+// with leading zero bytes removed.
+// Strings between 2 and MaxDictEntryLen bytes long are added
+// to the dictionary on first use until it holds the configured
+// number of entries, after which new strings are encoded literally.
+// A DictDecoder configured with the same number of entries
+// reconstructs the original strings.
+type DictEncoder struct {
+	e   *Encoder
+	ids map[string]uint64
+	max int
+	buf []byte // scratch buffer for tagged blob content
+}
+
+// Create a DictEncoder that writes to e,
+// holding at most entries strings in its dictionary,
+// or DefaultDictEntries if entries is zero.
+// Panics if entries is negative.
+func NewDictEncoder(e *Encoder, entries int) *DictEncoder {
+	if entries < 0 {
+		panic("negative dictionary size")
+	}
+	if entries == 0 {
+		entries = DefaultDictEntries
+	}
+	return &DictEncoder{e: e, ids: make(map[string]uint64), max: entries}
+}
+
+// Encode byte string b as a dictionary reference if b is already
+// in the dictionary, or otherwise as a literal blob.
+func (t *DictEncoder) Bytes(b []byte) error {
+	if id, ok := t.ids[string(b)]; ok {
+		var b8 [8]byte
+		binary.BigEndian.PutUint64(b8[:], id)
+		i := 0
+		for i < len(b8) && b8[i] == 0 { // trim leading 0 bytes
+			i++
+		}
+		return t.write(dictRef, b8[i:])
+	}
+	if len(b) < 2 || len(b) > MaxDictEntryLen || len(t.ids) >= t.max {
+		return t.write(dictLiteral, b)
+	}
+	t.ids[string(b)] = uint64(len(t.ids))
+	return t.write(dictDefine, b)
+}
+
+// Encode string s like Bytes.
+func (t *DictEncoder) String(s string) error {
+	return t.Bytes([]byte(s))
+}
+
+// Encode a blob containing tag followed by b.
+func (t *DictEncoder) write(tag byte, b []byte) error {
+	t.buf = append(append(t.buf[:0], tag), b...)
+	return t.e.Bytes(t.buf)
+}
+
+// DictDecoder decodes a stream of byte strings encoded by a DictEncoder,
+// resolving dictionary references to the strings they stand for.
+type DictDecoder struct {
+	d       *Decoder
+	entries [][]byte
+	max     int
+}
+
+// Create a DictDecoder that reads from d and accepts at most entries
+// dictionary entries, or DefaultDictEntries if entries is zero.
+// Panics if entries is negative.
+func NewDictDecoder(d *Decoder, entries int) *DictDecoder {
+	if entries < 0 {
+		panic("negative dictionary size")
+	}
+	if entries == 0 {
+		entries = DefaultDictEntries
+	}
+	return &DictDecoder{d: d, max: entries}
+}
+
+// Decode the next byte string, resolving it from the dictionary
+// if the stream encodes it as a reference.
+// Returns a Syntax error if the blob is not a valid dictionary-layer blob,
+// refers to a nonexistent entry, or defines more entries than allowed.
+func (t *DictDecoder) Bytes() ([]byte, error) {
+	b, err := t.d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	pos := t.d.pos
+	if len(b) == 0 {
+		return nil, errDictTag.At(pos)
+	}
+	tag, b := b[0], b[1:]
+	switch tag {
+	case dictLiteral:
+		return b, nil
+
+	case dictDefine:
+		if len(t.entries) >= t.max || len(b) < 2 ||
+			len(b) > MaxDictEntryLen {
+			return nil, errDictDefine.At(pos)
+		}
+		t.entries = append(t.entries, b)
+		return append([]byte(nil), b...), nil
+
+	case dictRef:
+		if len(b) > 8 || (len(b) > 0 && b[0] == 0) {
+			return nil, errDictRef.At(pos)
+		}
+		id := uint64Value(b)
+		if id >= uint64(len(t.entries)) {
+			return nil, errDictRef.At(pos)
+		}
+		return append([]byte(nil), t.entries[id]...), nil
+	}
+	return nil, errDictTag.At(pos)
+}
+
+// Decode the next string like Bytes.
+func (t *DictDecoder) String() (string, error) {
+	b, err := t.Bytes()
+	return string(b), err
+}
+
+var errDictTag = coerr.New(coerr.Syntax, "cbe", -1,
+	"invalid dictionary blob tag")
+var errDictDefine = coerr.New(coerr.Syntax, "cbe", -1,
+	"invalid or excess dictionary entry")
+var errDictRef = coerr.New(coerr.Syntax, "cbe", -1,
+	"invalid dictionary reference")
@@ -0,0 +1,160 @@
+//go:build !tinygo && !cbe_tiny
+
+package cbe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Write an annotated structural hexdump of the blob stream read from r
+// to w, for debugging.
+// Each line shows the offset of its first byte, the bytes in hex,
+// and either a description of a chunk header,
+// including its declared content length and position within the blob,
+// or the content bytes as text.
+// The content of single-chunk blobs that itself consists
+// of well-formed blobs is dumped as nested blobs, indented.
+// Truncated chunks and headers are reported as such.
+// Reads all of r into memory before dumping it.
+func Dump(w io.Writer, r io.Reader) error {
+	return dump(w, r, false)
+}
+
+// Write a hexdump of the blob stream read from r to w like Dump,
+// but without looking for blobs nested in content.
+func DumpFlat(w io.Writer, r io.Reader) error {
+	return dump(w, r, true)
+}
+
+func dump(w io.Writer, r io.Reader, flat bool) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	d := &dumper{w: bw, flat: flat}
+	d.blobs(b, 0, 0)
+	return bw.Flush()
+}
+
+// Bytes of content shown per hexdump line.
+const dumpWidth = 16
+
+// A dumper writes the annotated hexdump of a sequence of blobs,
+// as described for Dump.
+type dumper struct {
+	w    io.Writer
+	flat bool // don't dump content as nested blobs
+}
+
+// Dump the blobs in b, which starts at offset base, at nesting depth.
+func (d *dumper) blobs(b []byte, base, depth int) {
+	for off := 0; off < len(b); {
+		if b[off] < 0x80 {
+			d.line(base+off, depth, b[off:off+1],
+				"1-byte blob "+printable(b[off:off+1]))
+			off++
+			continue
+		}
+		for chunk := 1; ; chunk++ {
+			hlen, n, part, err := decodeHeader(b[off:])
+			if err != nil {
+				d.line(base+off, depth, b[off:],
+					"truncated chunk header")
+				return
+			}
+			if off+hlen+n > len(b) {
+				d.line(base+off, depth, b[off:off+hlen],
+					fmt.Sprintf("truncated chunk, %d of %d bytes",
+						len(b)-off-hlen, n))
+				d.content(b[off+hlen:], base+off+hlen, depth+1, false)
+				return
+			}
+			var note string
+			switch {
+			case part:
+				note = fmt.Sprintf("partial chunk %d, %d bytes",
+					chunk, n)
+			case chunk > 1:
+				note = fmt.Sprintf("final chunk %d, %d bytes",
+					chunk, n)
+			default:
+				note = fmt.Sprintf("blob, %d bytes", n)
+			}
+			d.line(base+off, depth, b[off:off+hlen], note)
+			off += hlen
+			d.content(b[off:off+n], base+off, depth+1,
+				chunk == 1 && !part)
+			off += n
+			if !part {
+				break
+			}
+		}
+	}
+}
+
+// Dump content b starting at offset base,
+// as nested blobs if nest is set and b appears to consist of blobs.
+func (d *dumper) content(b []byte, base, depth int, nest bool) {
+	if nest && !d.flat && isNested(b) {
+		d.blobs(b, base, depth)
+		return
+	}
+	for i := 0; i < len(b); i += dumpWidth {
+		line := b[i:]
+		if len(line) > dumpWidth {
+			line = line[:dumpWidth]
+		}
+		d.line(base+i, depth, line, printable(line))
+	}
+}
+
+// Write one line of the dump.
+func (d *dumper) line(off, depth int, b []byte, note string) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(d.w, "%08x  %s%-*s  %s%s\n", off, indent,
+		dumpWidth*3-1, hexBytes(b), indent, note)
+}
+
+// Reports whether b appears to be a sequence of nested blobs:
+// it consists entirely of well-formed blobs,
+// at least one of which has a chunk header.
+func isNested(b []byte) bool {
+	headers := false
+	for len(b) > 0 {
+		hlen, n, _, err := decodeHeader(b)
+		if err != nil || hlen+n > len(b) {
+			return false
+		}
+		headers = headers || hlen > 0
+		b = b[hlen+n:]
+	}
+	return headers
+}
+
+// Returns b as text between bars, with unprintable bytes as dots.
+func printable(b []byte) string {
+	s := []byte{'|'}
+	for _, c := range b {
+		if c < ' ' || c > '~' {
+			c = '.'
+		}
+		s = append(s, c)
+	}
+	return string(append(s, '|'))
+}
+
+// Returns b in hex with the bytes separated by spaces.
+func hexBytes(b []byte) string {
+	var sb strings.Builder
+	for i, c := range b {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%02x", c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,57 @@
+//go:build !tinygo && !cbe_tiny
+
+package cbe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	inner := Encode([]byte{5}, []byte("hi"))
+	var buf bytes.Buffer
+	buf.Write(Encode(nil, inner))
+	buf.WriteByte('z')
+	e := NewEncoder(&buf)
+	e.ReadFrom(bytes.NewReader(bytes.Repeat([]byte("x"), 2*MinChunkLen)))
+	buf.Write([]byte{0x85, 'a'}) // truncated
+	b := buf.Bytes()
+
+	var out bytes.Buffer
+	if err := Dump(&out, bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	pad := func(n int) string { return strings.Repeat(" ", n) }
+	want := []string{
+		"00000000  84" + pad(45) + "  blob, 4 bytes",
+		"00000001    05" + pad(45) + "    1-byte blob |.|",
+		"00000002    82" + pad(45) + "    blob, 2 bytes",
+		"00000003      68 69" + pad(42) + "      |hi|",
+		"00000005  7a" + pad(45) + "  1-byte blob |z|",
+		"00000006  81 40 00 00" + pad(36) + "  partial chunk 1, 16448 bytes",
+	}
+	for i, w := range want {
+		if i >= len(lines) || lines[i] != w {
+			t.Fatalf("line %d:\n%s\nwant\n%s\nin:\n%.1000s", i,
+				lines[i], w, out.String())
+		}
+	}
+	for _, w := range []string{
+		"  final chunk 3, 0 bytes\n",
+		"  truncated chunk, 1 of 5 bytes\n",
+		"    |a|\n",
+	} {
+		if !strings.Contains(out.String(), w) {
+			t.Errorf("missing %q", w)
+		}
+	}
+
+	// Flat dumps show nested blobs as plain content
+	out.Reset()
+	DumpFlat(&out, bytes.NewReader(Encode(nil, inner)))
+	if !strings.Contains(out.String(), "05 82 68 69") {
+		t.Errorf("flat dump:\n%s", out.String())
+	}
+}
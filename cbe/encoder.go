@@ -2,10 +2,9 @@ package cbe
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
+	"hash"
 	"io"
-	"math/big"
 	"strings"
 )
 
@@ -18,9 +17,22 @@ const MaxChunkLen int = 0x40403f // 4,210,751 bytes
 const defaultChunkLen = MinChunkLen // minimize buffering, latency
 
 // An Encoder encodes a series of blobs to an output stream.
+//
+// Once a write to the underlying writer fails,
+// or an operation fails after writing part of a blob,
+// the output is no longer a well-formed series of blobs.
+// The Encoder then records the error, which Err reports,
+// and every later operation returns it without writing anything.
 type Encoder struct {
-	w   io.Writer
-	buf []byte
+	w     io.Writer
+	buf   []byte
+	small [64]byte  // buffer for encoding small blobs
+	sum   hash.Hash // per-blob checksum, or nil
+	tee   hash.Hash // hash of all encoded output, or nil
+	stats *Stats    // framing statistics to update, or nil
+	err   error     // sticky error that ended the output, or nil
+
+	progress func(n int64) // called after each streamed chunk, or nil
 }
 
 // Create a new Encoder that writes encoded blobs to w.
@@ -31,11 +43,114 @@ func NewEncoder(w io.Writer) *Encoder {
 // Encode a blob by reading bytes from r until encountering EOF.
 // Supports streaming:
 // r can represent arbitrarily many bytes (even infinite).
-// This function will
+// This function will buffer r in chunks of the Encoder's ChunkLen,
+// writing each as a partial chunk once more content follows it.
+// To pass a file's content through to the underlying writer instead,
+// use ReadFromN with the file's length.
 func (e *Encoder) ReadFrom(r io.Reader) (n int64, err error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	if n, err = e.readFrom(r); err != nil {
+		return 0, err
+	}
+	return n, e.writeSum()
+}
+
+// Encode a blob of exactly n bytes read from r,
+// producing the canonical encoding that Encode would:
+// a single chunk if the content fits in one,
+// and otherwise the fewest chunks possible.
+// Copies the content directly to the underlying writer
+// without buffering it in chunks as ReadFrom must,
+// so io.Copy can use sendfile or similar
+// when r is a file and the writer a network connection.
+// Returns io.ErrUnexpectedEOF if r ends before supplying n bytes,
+// in which case the output ends within an incomplete blob.
+// Panics if n is negative.
+func (e *Encoder) ReadFromN(r io.Reader, n int64) (int64, error) {
+	if n < 0 {
+		panic("negative content length")
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	if err := e.copyN(r, n); err != nil {
+		return 0, err
+	}
+	return n, e.writeSum()
+}
+
+// Encode the n bytes of content read from r
+// as partial chunks of MaxChunkLen bytes followed by a non-empty final chunk,
+// or as a single chunk if the content fits in one,
+// copying the content directly to the underlying writer.
+func (e *Encoder) copyN(r io.Reader, n int64) error {
+	w := e.w
+	if e.tee != nil {
+		w = io.MultiWriter(w, e.tee)
+	}
+	if e.sum != nil {
+		w = io.MultiWriter(w, e.sum)
+	}
+	for rem := n; ; {
+		l, part := rem, false
+		if l > int64(MaxChunkLen) {
+			l, part = int64(MaxChunkLen), true
+		}
+
+		// Write the chunk's header, which for one byte depends on it
+		var err error
+		switch {
+		case part:
+			h := MaxChunkLen - 16448
+			err = e.write(append(e.small[:0], 0x81, 0x40+byte(h>>16),
+				byte(h>>8), byte(h)))
+			e.stats.count(4, int(l), true)
+		case l == 1:
+			if _, err := io.ReadFull(r, e.small[1:2]); err != nil {
+				if rem < n { // within a blob already begun
+					return e.fail(unexpected(err))
+				}
+				return unexpected(err)
+			}
+			h := 1 // no header for a byte below 0x80
+			if e.small[1] >= 0x80 {
+				e.small[0], h = 0x81, 0
+			}
+			err = e.write(e.small[h:2])
+			e.stats.count(1-h, 1, false)
+			if e.sum != nil {
+				e.sum.Write(e.small[1:2])
+			}
+			rem, l = 0, 0 // content already written
+		default:
+			hdr := AppendHeader(e.small[:0], int(l))
+			err = e.write(hdr)
+			e.stats.count(len(hdr), int(l), false)
+		}
+		if err != nil {
+			return err
+		}
 
-	// Get our chunk buffer, creating it if needed
-	buf := e.getBuf()
+		if _, err := io.CopyN(w, r, l); err != nil {
+			return e.fail(unexpected(err))
+		}
+		rem -= l
+		if e.progress != nil {
+			e.progress(n - rem)
+		}
+		if !part {
+			return nil
+		}
+	}
+}
+
+func (e *Encoder) readFrom(r io.Reader) (n int64, err error) {
+
+	// Get our chunk buffer, borrowing one if needed
+	buf, pooled := e.getBuf()
+	defer putChunkBuf(pooled)
 	chunkLen := len(buf) - 4
 
 	tot := int64(0)
@@ -44,119 +159,309 @@ func (e *Encoder) ReadFrom(r io.Reader) (n int64, err error) {
 		// Read a full chunk into the chunk buffer or until EOF
 		l, err := io.ReadFull(r, buf[4:])
 		if err != nil && err != EOF && err != io.ErrUnexpectedEOF {
+			if tot > 0 { // within a blob already begun
+				return 0, e.fail(err)
+			}
 			return 0, err
 		}
 
 		// Write either a complete or partial blob
-		more = false
-		h := 0
-		if l == chunkLen && err == nil { // chunk-size partial blob
-			n := l - 16448
-			buf[0] = 0x81
-			buf[1] = 0x40 + byte(n>>16)
-			buf[2] = byte(n >> 8)
-			buf[3] = byte(n)
-			more = true
-
-		} else if l == 1 && buf[4] < 128 { // 1-byte no-header blob
-			h = 4
-
-		} else if l < 64 { // small blob with 1-byte header
-			buf[3] = byte(0x80 + l)
-			h = 3
-
-		} else if l < 16448 { // small blob with 2-byte header
-			n := l - 64
-			buf[2] = 0xc0 + byte(n>>8)
-			buf[3] = byte(n)
-			h = 2
-
-		} else { // large blob with 4-byte header
-			n := l - 16448
-			buf[0] = 0x81
-			buf[1] = 0x00 + byte(n>>16)
-			buf[2] = byte(n >> 8)
-			buf[3] = byte(n)
-		}
-
-		// Write the blob header and data from the buffer
-		lw, err := e.w.Write(buf[h : 4+l])
-		if err != nil {
+		more = l == chunkLen && err == nil
+		if err := e.writeChunk(buf, l, more); err != nil {
 			return 0, err
-		} else if lw != 4-h+l {
-			return 0, errors.New("short write")
 		}
 
 		tot += int64(l)
+		if e.progress != nil {
+			e.progress(tot)
+		}
 	}
 	return tot, nil
 }
 
-// Encode a byte-slice as a blob.
+// Write the l bytes of content in buf[4:] as a final or partial chunk,
+// using the first four bytes of buf as space for the header.
+func (e *Encoder) writeChunk(buf []byte, l int, part bool) error {
+	h := 0
+	if part { // chunk-size partial blob
+		n := l - 16448
+		buf[0] = 0x81
+		buf[1] = 0x40 + byte(n>>16)
+		buf[2] = byte(n >> 8)
+		buf[3] = byte(n)
+
+	} else if l == 1 && buf[4] < 128 { // 1-byte no-header blob
+		h = 4
+
+	} else if l < 64 { // small blob with 1-byte header
+		buf[3] = byte(0x80 + l)
+		h = 3
+
+	} else if l < 16448 { // small blob with 2-byte header
+		n := l - 64
+		buf[2] = 0xc0 + byte(n>>8)
+		buf[3] = byte(n)
+		h = 2
+
+	} else { // large blob with 4-byte header
+		n := l - 16448
+		buf[0] = 0x81
+		buf[1] = 0x00 + byte(n>>16)
+		buf[2] = byte(n >> 8)
+		buf[3] = byte(n)
+	}
+
+	// Write the blob header and data from the buffer
+	e.stats.count(4-h, l, part)
+	if e.sum != nil {
+		e.sum.Write(buf[4 : 4+l])
+	}
+	return e.write(buf[h : 4+l])
+}
+
+// Write p as the next chunk of a blob,
+// giving the caller exact control over the blob's chunk boundaries.
+// If more is true, p is a partial chunk,
+// which must be between MinChunkLen and MaxChunkLen bytes long,
+// and further calls to Chunk continue the same blob.
+// Otherwise p is the blob's final chunk of up to MaxChunkLen bytes,
+// which is followed by the blob's checksum if one is set.
+// The blob is canonical, as defined for Decoder.SetStrict,
+// only if its partial chunks are all MaxChunkLen bytes long
+// and it has a non-empty final chunk after any partial chunks.
+// The Encoder must not be used for other purposes
+// until the final chunk has been written.
+// Panics if p is too long or a partial chunk is too short.
+func (e *Encoder) Chunk(p []byte, more bool) error {
+	n := len(p)
+	var hdr []byte
+	switch {
+	case more:
+		if n < MinChunkLen || n > MaxChunkLen {
+			panic("invalid partial chunk length")
+		}
+		h := n - 16448
+		hdr = append(e.small[:0], 0x81, 0x40+byte(h>>16),
+			byte(h>>8), byte(h))
+	case n == 1 && p[0] < 0x80:
+		hdr = e.small[:0] // the content byte is its own header
+	default:
+		hdr = AppendHeader(e.small[:0], n)
+	}
+	if err := e.write(hdr); err != nil {
+		return err
+	}
+	if err := e.write(p); err != nil {
+		return err
+	}
+	e.stats.count(len(hdr), n, more)
+	if e.sum != nil {
+		e.sum.Write(p)
+	}
+	if more {
+		return nil
+	}
+	return e.writeSum()
+}
+
+// Return a WriteCloser that encodes everything written to it
+// as the content of one blob of initially unknown length.
+// Writes are buffered and emitted in chunks of the Encoder's ChunkLen,
+// and Close emits the final chunk and any checksum.
+// Close does not close the Encoder's underlying writer.
+// The Encoder must not be used for other purposes
+// until the WriteCloser has been closed.
+func (e *Encoder) Writer() io.WriteCloser {
+	buf, pooled := e.getBuf()
+	return &blobWriter{e: e, buf: buf, pooled: pooled}
+}
+
+// blobWriter encodes the content written to it as one blob.
+type blobWriter struct {
+	e      *Encoder
+	buf    []byte  // chunk buffer with 4 bytes of header space
+	pooled *[]byte // pooled buffer to return on Close, if any
+	n      int     // content bytes buffered in buf[4:]
+	tot    int64   // content bytes written in earlier chunks
+	err    error   // sticky error, errClosed once closed
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	if w.err == nil && w.e.err != nil {
+		w.err = w.e.err
+	}
+	tot := 0
+	for len(p) > 0 && w.err == nil {
+		// A full chunk is partial once we know more content follows
+		if 4+w.n == len(w.buf) {
+			w.err = w.e.writeChunk(w.buf, w.n, true)
+			w.tot += int64(w.n)
+			w.n = 0
+			if w.err == nil && w.e.progress != nil {
+				w.e.progress(w.tot)
+			}
+			continue
+		}
+		l := copy(w.buf[4+w.n:], p)
+		w.n += l
+		tot += l
+		p = p[l:]
+	}
+	return tot, w.err
+}
+
+func (w *blobWriter) Close() error {
+	if w.err != nil {
+		if w.err == errClosed {
+			return nil
+		}
+		return w.err
+	}
+	w.err = errClosed
+	err := w.e.writeChunk(w.buf, w.n, false)
+	putChunkBuf(w.pooled)
+	w.buf, w.pooled = nil, nil
+	if err != nil {
+		return err
+	}
+	if w.e.progress != nil {
+		w.e.progress(w.tot + int64(w.n))
+	}
+	return w.e.writeSum()
+}
+
+// Encode a byte-slice as a blob,
+// in the canonical form that Encode produces regardless of ChunkLen:
+// a single chunk if the content fits in one,
+// and otherwise partial chunks of MaxChunkLen bytes
+// followed by a non-empty final chunk.
 func (e *Encoder) Bytes(b []byte) error {
-	_, err := e.ReadFrom(bytes.NewReader(b))
-	return err
+	n := len(b)
+	if n >= 16448 {
+		_, err := e.ReadFromN(bytes.NewReader(b), int64(n))
+		return err
+	}
+	if n < 64 { // tiny blob: header and content in one write
+		enc := Encode(e.small[:0], b)
+		if err := e.write(enc); err != nil {
+			return err
+		}
+		e.stats.count(len(enc)-n, n, false)
+	} else { // small blob: no need to copy through the chunk buffer
+		n -= 64
+		e.small[0] = 0xc0 + byte(n>>8)
+		e.small[1] = byte(n)
+		if err := e.write(e.small[:2]); err != nil {
+			return err
+		}
+		if err := e.write(b); err != nil {
+			return err
+		}
+		e.stats.count(2, len(b), false)
+	}
+	if e.sum != nil {
+		e.sum.Write(b)
+	}
+	return e.writeSum()
 }
 
-// Encode a UTF-8 string as a blob.
+// Encode a UTF-8 string as a blob, in canonical form like Bytes.
 func (e *Encoder) String(s string) error {
-	_, err := e.ReadFrom(strings.NewReader(s))
+	if len(s) < 16448 {
+		return e.Bytes([]byte(s))
+	}
+	_, err := e.ReadFromN(strings.NewReader(s), int64(len(s)))
 	return err
 }
 
 // Encode a uint64 as a big-endian unsigned integer blob.
 func (e *Encoder) Uint64(v uint64) error {
-	var b8 [8]byte
-	b := b8[:]
-	binary.BigEndian.PutUint64(b, v)
-	for len(b) > 0 && b[0] == 0 { // trim leading 0 bytes
-		b = b[1:]
+	b := AppendUint64(e.small[:0], v)
+	if err := e.write(b); err != nil {
+		return err
+	}
+	content, _, _ := Decode(b)
+	e.stats.count(len(b)-len(content), len(content), false)
+	if e.sum != nil {
+		e.sum.Write(content)
 	}
-	return e.Bytes(b)
+	return e.writeSum()
 }
 
 // Encode an int64 as a big-endian zigzag-encoded signed integer blob.
 func (e *Encoder) Int64(v int64) error {
-	var u uint64
-	if v >= 0 {
-		u = uint64(v) << 1
-	} else {
-		u = uint64(^v)<<1 + 1
+	return e.Uint64(zigzag(v))
+}
+
+// Write all of p to the underlying writer,
+// recording any error as the Encoder's sticky error.
+func (e *Encoder) write(p []byte) error {
+	if e.err != nil {
+		return e.err
+	}
+	n, err := e.w.Write(p)
+	if e.tee != nil {
+		e.tee.Write(p[:n])
+	}
+	if err == nil && n != len(p) {
+		err = io.ErrShortWrite
 	}
-	return e.Uint64(u)
+	if err != nil {
+		return e.fail(err)
+	}
+	return nil
+}
+
+// Record err as the Encoder's sticky error unless one is already recorded,
+// and return err.
+func (e *Encoder) fail(err error) error {
+	if e.err == nil {
+		e.err = err
+	}
+	return err
 }
 
-// Encode the absolute value of a big.Int
-// as a big-endian unsigned integer blob.
-func (e *Encoder) UnsignedInt(v *big.Int) error {
-	return e.Bytes(v.Bytes())
+// Return the first error that left the Encoder's output incomplete,
+// or nil if there has been none.
+func (e *Encoder) Err() error {
+	return e.err
 }
 
-// Encode a big.Int as a big-endian zigzag-encoded signed integer blob.
-func (e *Encoder) SignedInt(v *big.Int) error {
-	u := &big.Int{}
-	if v.Sign() >= 0 {
-		u.Lsh(v, 1)
-	} else {
-		u.Sub(minusOne, v)
-		u.Lsh(u, 1)
-		u.SetBit(u, 0, 1)
+// Flush any output buffered by the underlying writer
+// if it has a Flush method, as a bufio.Writer does,
+// and return the Encoder's sticky error, if any, or the error from Flush,
+// which becomes the sticky error.
+// Flush does not write the final chunk of a blob being written
+// with Writer or Chunk.
+func (e *Encoder) Flush() error {
+	if e.err != nil {
+		return e.err
 	}
-	return e.Bytes(u.Bytes())
+	if f, ok := e.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return e.fail(err)
+		}
+	}
+	return nil
 }
 
-// Get the current chunk buffer, creating one if necessary.
-func (e *Encoder) getBuf() []byte {
-	if e.buf == nil {
-		e.buf = make([]byte, 4+defaultChunkLen)
+// Get a chunk buffer: the Encoder's own if its chunk size has been set,
+// or else a buffer of the default size borrowed from a pool,
+// which the caller must return via putChunkBuf.
+func (e *Encoder) getBuf() (buf []byte, pooled *[]byte) {
+	if e.buf != nil {
+		return e.buf, nil
 	}
-	return e.buf
+	pooled = chunkPool.Get().(*[]byte)
+	return *pooled, pooled
 }
 
 // Returns the current chunk size used in streaming operation.
 func (e *Encoder) ChunkLen() int {
-	buf := e.getBuf()
-	return len(buf) - 4
+	if e.buf == nil {
+		return defaultChunkLen
+	}
+	return len(e.buf) - 4
 }
 
 // Set the chunk size used for streaming operation.
@@ -177,3 +482,13 @@ func (e *Encoder) SetChunkLen(chunkLen int) {
 	}
 	e.buf = e.buf[:bufLen]
 }
+
+// Returns io.ErrUnexpectedEOF if err is io.EOF, and otherwise err.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+var errClosed = errors.New("write to closed blob writer")
@@ -0,0 +1,84 @@
+package cbe
+
+// A tagged field consists of two consecutive blobs:
+// an unsigned integer tag identifying the field, as Uint64 encodes it,
+// followed by the field's content.
+// A series of tagged fields lets a protocol add optional fields
+// that older decoders can recognize by tag and skip,
+// rather than identifying fields by position.
+
+// Encode a tagged field with the given tag and content.
+func (e *Encoder) Field(tag uint64, content []byte) error {
+	if err := e.Uint64(tag); err != nil {
+		return err
+	}
+	return e.Bytes(content)
+}
+
+// Decode a tagged field, returning its tag and content.
+// Returns EOF if the input ends before the field.
+func (d *Decoder) Field() (tag uint64, content []byte, err error) {
+	if tag, err = d.Uint64(); err != nil {
+		return 0, nil, err
+	}
+	if content, err = d.Bytes(); err != nil {
+		return 0, nil, d.truncated(err)
+	}
+	return tag, content, nil
+}
+
+// Decode a tagged field, returning its tag and content.
+// Returns EOF if the input ends before the field.
+func (d *BytesDecoder) Field() (tag uint64, content []byte, err error) {
+	pos := d.pos
+	if tag, err = d.Uint64(); err != nil {
+		return 0, nil, err
+	}
+	if content, err = d.Bytes(); err != nil {
+		err = at(truncated(err), int64(d.pos))
+		d.pos = pos
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
+
+// Return an iterator over the tagged fields remaining in the input.
+func (d *Decoder) Fields() *FieldIter {
+	return &FieldIter{d: d}
+}
+
+// FieldIter iterates over a series of tagged fields.
+type FieldIter struct {
+	d       *Decoder
+	tag     uint64
+	content []byte
+	err     error
+}
+
+// Advance to the next field,
+// returning false at the end of the input or on error.
+func (it *FieldIter) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.tag, it.content, it.err = it.d.Field()
+	return it.err == nil
+}
+
+// Return the current field's tag.
+func (it *FieldIter) Tag() uint64 {
+	return it.tag
+}
+
+// Return the current field's content.
+func (it *FieldIter) Content() []byte {
+	return it.content
+}
+
+// Return the error that ended the iteration, or nil at the end of input.
+func (it *FieldIter) Err() error {
+	if it.err == EOF {
+		return nil
+	}
+	return it.err
+}
@@ -0,0 +1,212 @@
+package cbe
+
+import (
+	"errors"
+	"io"
+	"net/netip"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// Fixed-width binary values such as UUIDs, hash digests, and keys
+// are encoded as blobs containing their bytes.
+// Decoding them with the methods below rather than Bytes
+// checks that each blob has exactly the expected length,
+// catching mismatches that Bytes would pass through silently.
+// A blob that is too long yields an error of kind coerr.TooLarge,
+// which the Decoder detects as soon as the blob's chunks
+// exceed the expected length, without buffering the excess,
+// and a blob that is too short yields an error of kind coerr.Syntax.
+// In either case the blob has been consumed,
+// so that decoding can continue with the next blob.
+// IP addresses are encoded as blobs of 4 or 16 bytes,
+// as netip.Addr.AsSlice returns them, without any IPv6 zone.
+
+// Encode a UUID or other 16-byte value as a blob.
+func (e *Encoder) UUID(u [16]byte) error {
+	return e.Bytes(u[:])
+}
+
+// Encode an IP address as a blob of 4 bytes for an IPv4 address
+// or 16 bytes for an IPv6 address, omitting any zone.
+// Returns an error if a is the zero Addr.
+func (e *Encoder) Addr(a netip.Addr) error {
+	if !a.IsValid() {
+		return errInvalidAddr
+	}
+	return e.Bytes(a.AsSlice())
+}
+
+// Decode a UUID or other 16-byte value encoded by Encoder.UUID.
+func (d *Decoder) UUID() (u [16]byte, err error) {
+	err = d.fixed(u[:])
+	return u, err
+}
+
+// Decode a blob whose content must be exactly n bytes long,
+// such as a hash digest, returning its content.
+func (d *Decoder) FixedBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if err := d.fixed(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Decode a blob of exactly len(dst) bytes into dst,
+// tracking the content length across chunks
+// so as to refuse an over-long blob at its first excess chunk.
+func (d *Decoder) fixed(dst []byte) error {
+	if _, _, err := d.NextLen(); err != nil {
+		return err
+	}
+	pos := d.pos
+	tot := 0
+	for first := true; ; first = false {
+		n, part, err := d.chunk(int64(tot))
+		if err != nil {
+			if !first {
+				err = d.truncated(err)
+			}
+			return err
+		}
+		if n > len(dst)-tot {
+			if err := d.skipRest(dst[:tot], n, part); err != nil {
+				return err
+			}
+			return errFixedLong.At(pos)
+		}
+		if _, err := io.ReadFull(d.r, dst[tot:tot+n]); err != nil {
+			return d.truncated(err)
+		}
+		tot += n
+		if !part {
+			break
+		}
+	}
+	if d.sum != nil {
+		d.sum.Write(dst[:tot])
+		if err := d.verifySum(); err != nil {
+			return err
+		}
+	}
+	if tot != len(dst) {
+		return errFixedLen.At(pos)
+	}
+	return nil
+}
+
+// Consume the rest of a blob whose content so far was read into b
+// and whose current chunk has n unread bytes of content,
+// verifying any checksum without buffering the content.
+func (d *Decoder) skipRest(b []byte, n int, part bool) error {
+	tot := int64(len(b))
+	if d.sum != nil {
+		d.sum.Write(b)
+	}
+	for {
+		var err error
+		if d.sum != nil {
+			_, err = io.CopyN(d.sum, d.r, int64(n))
+		} else {
+			err = d.discard(n)
+		}
+		if err != nil {
+			return d.truncated(err)
+		}
+		tot += int64(n)
+		if !part {
+			break
+		}
+		if n, part, err = d.chunk(tot); err != nil {
+			return d.truncated(err)
+		}
+	}
+	if d.sum != nil {
+		return d.verifySum()
+	}
+	return nil
+}
+
+// Decode an IP address encoded by Encoder.Addr.
+func (d *Decoder) Addr() (netip.Addr, error) {
+	l, _, err := d.NextLen()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	var b [16]byte
+	if l == 4 {
+		err = d.fixed(b[:4])
+	} else {
+		err = d.fixed(b[:])
+	}
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if l == 4 {
+		return netip.AddrFrom4([4]byte(b[:4])), nil
+	}
+	return netip.AddrFrom16(b), nil
+}
+
+// Decode a UUID or other 16-byte value encoded by Encoder.UUID.
+func (d *BytesDecoder) UUID() (u [16]byte, err error) {
+	b, err := d.FixedBytes(16)
+	copy(u[:], b)
+	return u, err
+}
+
+// Decode a blob whose content must be exactly n bytes long,
+// returning a sub-slice of the input containing its content
+// unless the blob is chunked, as Bytes does.
+func (d *BytesDecoder) FixedBytes(n int) ([]byte, error) {
+	pos := d.pos
+	if err := d.refuseLong(n); err != nil {
+		return nil, err
+	}
+	b, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != n {
+		return nil, errFixedLen.At(int64(pos))
+	}
+	return b, nil
+}
+
+// Decode an IP address encoded by Encoder.Addr.
+func (d *BytesDecoder) Addr() (netip.Addr, error) {
+	pos := d.pos
+	if err := d.refuseLong(16); err != nil {
+		return netip.Addr{}, err
+	}
+	b, err := d.Bytes()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if len(b) != 4 && len(b) != 16 {
+		return netip.Addr{}, errFixedLen.At(int64(pos))
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a, nil
+}
+
+// Skip the next blob and return an error
+// if its content is longer than n bytes.
+func (d *BytesDecoder) refuseLong(n int) error {
+	pos := d.pos
+	l, _, err := d.NextLen()
+	if err != nil || l <= int64(n) {
+		return err
+	}
+	if _, err := d.Skip(); err != nil {
+		return err
+	}
+	return errFixedLong.At(int64(pos))
+}
+
+var errFixedLen = coerr.New(coerr.Syntax, "cbe", -1,
+	"blob has the wrong length for a fixed-width value")
+var errFixedLong = coerr.New(coerr.TooLarge, "cbe", -1,
+	"blob too long for a fixed-width value")
+var errInvalidAddr = errors.New("cannot encode an invalid IP address")
@@ -0,0 +1,81 @@
+package cbe
+
+import (
+	"encoding/binary"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// Append the integer blob encoding of unsigned integer v to dst,
+// exactly as Encoder.Uint64 would encode it.
+// Never allocates if dst has at least 9 bytes of spare capacity.
+func AppendUint64(dst []byte, v uint64) []byte {
+	var b8 [8]byte
+	b := b8[:]
+	binary.BigEndian.PutUint64(b, v)
+	for len(b) > 0 && b[0] == 0 { // trim leading 0 bytes
+		b = b[1:]
+	}
+	return Encode(dst, b)
+}
+
+// Append the zigzag-encoded integer blob encoding of signed integer v
+// to dst, exactly as Encoder.Int64 would encode it.
+// Never allocates if dst has at least 9 bytes of spare capacity.
+func AppendInt64(dst []byte, v int64) []byte {
+	return AppendUint64(dst, zigzag(v))
+}
+
+// Decode an unsigned integer blob from the start of buf,
+// returning its value and the remainder of buf following the blob.
+// Never allocates.
+func DecodeUint64(buf []byte) (v uint64, rest []byte, err error) {
+	ofs, n, part, err := decodeHeader(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	if part || n > 8 {
+		return 0, nil, errUint64Range
+	}
+	if len(buf) < ofs+n {
+		return 0, nil, EOF
+	}
+	return uint64Value(buf[ofs : ofs+n]), buf[ofs+n:], nil
+}
+
+// Decode a zigzag-encoded signed integer blob from the start of buf,
+// returning its value and the remainder of buf following the blob.
+// Never allocates.
+func DecodeInt64(buf []byte) (v int64, rest []byte, err error) {
+	u, rest, err := DecodeUint64(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	return unzigzag(u), rest, nil
+}
+
+// Returns the value of a big-endian unsigned integer of up to 8 bytes.
+func uint64Value(b []byte) uint64 {
+	var b8 [8]byte
+	copy(b8[8-len(b):], b)
+	return binary.BigEndian.Uint64(b8[:])
+}
+
+// zigzag-encode: 0 -> 0, -1 -> 1, 1 -> 2, -2 -> 3, etc.
+func zigzag(v int64) uint64 {
+	if v >= 0 {
+		return uint64(v) << 1
+	}
+	return uint64(^v)<<1 + 1
+}
+
+// zigzag-decode: 0 -> 0, 1 -> -1, 2 -> 1, 3 -> -2, etc.
+func unzigzag(v uint64) int64 {
+	if (v & 1) == 0 {
+		return int64(v >> 1)
+	}
+	return -1 - int64(v>>1)
+}
+
+var errUint64Range = coerr.New(coerr.TooLarge, "cbe", -1,
+	"integer value too large for uint64")
@@ -0,0 +1,50 @@
+package cbe
+
+import (
+	"encoding/binary"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// The LEB128 converters translate integers between CBE integer blobs
+// and the unsigned LEB128 varints used by protobuf, WebAssembly,
+// and encoding/binary's Uvarint.
+// Since CBE encodes signed integers with the same zigzag mapping
+// as protobuf's sint32 and sint64 types and encoding/binary's Varint,
+// the same functions convert between zigzag-encoded varints
+// and signed integer blobs.
+// They do not convert WebAssembly's signed LEB128 integers,
+// which are sign-extended rather than zigzag-encoded;
+// decode those and encode the value with AppendInt64 instead.
+
+// Convert the unsigned LEB128 varint at the start of src
+// to an integer blob appended to dst,
+// returning the extended slice and the remainder of src after the varint.
+// Accepts varints padded with redundant 0x80 bytes,
+// as protobuf decoders do, up to the 10 bytes of a 64-bit varint.
+// Returns EOF if src ends within the varint.
+func LEB128ToBlob(dst, src []byte) (out, rest []byte, err error) {
+	v, n := binary.Uvarint(src)
+	if n == 0 {
+		return dst, nil, EOF
+	} else if n < 0 {
+		return dst, nil, errVarintRange
+	}
+	return AppendUint64(dst, v), src[n:], nil
+}
+
+// Convert the integer blob at the start of src,
+// of up to 8 bytes of content,
+// to a minimal unsigned LEB128 varint appended to dst,
+// returning the extended slice and the remainder of src after the blob.
+// Returns EOF if src does not contain a complete blob.
+func BlobToLEB128(dst, src []byte) (out, rest []byte, err error) {
+	v, rest, err := DecodeUint64(src)
+	if err != nil {
+		return dst, nil, err
+	}
+	return binary.AppendUvarint(dst, v), rest, nil
+}
+
+var errVarintRange = coerr.New(coerr.TooLarge, "cbe", -1,
+	"varint too large for uint64")
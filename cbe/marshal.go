@@ -0,0 +1,140 @@
+package cbe
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// Marshaler is implemented by types that can encode themselves
+// as a sequence of one or more blobs.
+type Marshaler interface {
+	MarshalCBE(e *Encoder) error
+}
+
+// Unmarshaler is implemented by types that can decode themselves
+// from a sequence of blobs written by the corresponding MarshalCBE method.
+type Unmarshaler interface {
+	UnmarshalCBE(d *Decoder) error
+}
+
+// Marshal a value into a byte slice containing one or more blobs.
+//
+// Values implementing Marshaler encode themselves.
+// Byte slices and strings encode as a single blob containing their content,
+// as do values implementing encoding.BinaryMarshaler.
+// Fixed-size integers and big.Ints encode as integer blobs
+// as by the corresponding Encoder methods,
+// zigzag-encoded in the case of signed integer types,
+// and big.Rats and big.Floats as by Encoder.Rat and Encoder.Float.
+//
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	var err error
+	switch v := v.(type) {
+	case Marshaler:
+		err = v.MarshalCBE(e)
+	case []byte:
+		err = e.Bytes(v)
+	case string:
+		err = e.String(v)
+	case uint64:
+		err = e.Uint64(v)
+	case uint32:
+		err = e.Uint64(uint64(v))
+	case uint:
+		err = e.Uint64(uint64(v))
+	case int64:
+		err = e.Int64(v)
+	case int32:
+		err = e.Int64(int64(v))
+	case int:
+		err = e.Int64(int64(v))
+	case encoding.BinaryMarshaler:
+		var b []byte
+		if b, err = v.MarshalBinary(); err == nil {
+			err = e.Bytes(b)
+		}
+	default:
+		err = marshalBig(e, v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal a value of a type supported by Marshal from data,
+// which must be a pointer to the value to fill in.
+// Returns an error if data contains anything beyond the decoded value.
+func Unmarshal(data []byte, v interface{}) error {
+	d := NewDecoder(bytes.NewReader(data))
+	var err error
+	switch v := v.(type) {
+	case Unmarshaler:
+		err = v.UnmarshalCBE(d)
+	case *[]byte:
+		*v, err = d.Bytes()
+	case *string:
+		*v, err = d.String()
+	case *uint64:
+		*v, err = d.Uint64()
+	case *uint32:
+		var u uint64
+		if u, err = d.Uint64(); err == nil {
+			if uint64(uint32(u)) != u {
+				return errRange
+			}
+			*v = uint32(u)
+		}
+	case *uint:
+		var u uint64
+		if u, err = d.Uint64(); err == nil {
+			if uint64(uint(u)) != u {
+				return errRange
+			}
+			*v = uint(u)
+		}
+	case *int64:
+		*v, err = d.Int64()
+	case *int32:
+		var i int64
+		if i, err = d.Int64(); err == nil {
+			if int64(int32(i)) != i {
+				return errRange
+			}
+			*v = int32(i)
+		}
+	case *int:
+		var i int64
+		if i, err = d.Int64(); err == nil {
+			if int64(int(i)) != i {
+				return errRange
+			}
+			*v = int(i)
+		}
+	case encoding.BinaryUnmarshaler:
+		var b []byte
+		if b, err = d.Bytes(); err == nil {
+			err = v.UnmarshalBinary(b)
+		}
+	default:
+		err = unmarshalBig(d, v)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := d.r.ReadByte(); err == nil {
+		return errTrailing
+	}
+	return nil
+}
+
+var errUnsupported = errors.New("unsupported type for CBE marshaling")
+var errRange = coerr.New(coerr.TooLarge, "cbe", -1,
+	"integer value out of range")
+var errTrailing = coerr.New(coerr.Syntax, "cbe", -1,
+	"unexpected data after unmarshaled value")
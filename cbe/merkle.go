@@ -0,0 +1,289 @@
+package cbe
+
+import (
+	"bytes"
+	"hash"
+	"io"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// A Merkle tree lets a reader verify any range of a large blob's content
+// against a single trusted root hash,
+// reading only the chunks overlapping the range
+// rather than the whole blob.
+//
+// Encoder.MerkleReadFrom encodes a blob in chunks of the Encoder's ChunkLen,
+// all but the last exactly ChunkLen bytes long,
+// and hashes the content of each chunk as a leaf of the tree.
+// Leaf and interior node hashes follow RFC 6962:
+// a leaf hash is H(0x00 || content),
+// and an interior node hash is H(0x01 || left || right),
+// where the left subtree covers the largest power of two
+// of leaves less than the node's total.
+// A tree blob follows the content blob,
+// whose content is a series of three blobs:
+// the content length and the chunk length as unsigned integers,
+// and the concatenated leaf hashes.
+// The hash function is not encoded and must be agreed upon separately.
+
+// MerkleTree holds the leaf hashes of a blob's chunks.
+type MerkleTree struct {
+	newHash  func() hash.Hash
+	n        int64  // content length
+	chunkLen int    // content length of each chunk but the last
+	size     int    // hash size
+	leaves   []byte // concatenated leaf hashes
+}
+
+// Encode a blob with content read from r until EOF,
+// followed by a tree blob holding the Merkle tree of its chunks,
+// hashed with hash functions that newHash creates.
+// Returns the tree, whose Root the caller should record
+// to verify the blob later.
+func (e *Encoder) MerkleReadFrom(r io.Reader,
+	newHash func() hash.Hash) (*MerkleTree, error) {
+
+	t := &MerkleTree{newHash: newHash, chunkLen: e.ChunkLen()}
+	t.size = newHash().Size()
+	lw := &leafWriter{t: t, h: newHash()}
+	w := e.Writer()
+	if _, err := io.Copy(io.MultiWriter(w, lw), r); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if lw.l > 0 {
+		lw.leaf()
+	}
+
+	b := NewBuilder(nil)
+	b.Uint64(uint64(t.n))
+	b.Uint64(uint64(t.chunkLen))
+	b.Bytes(t.leaves)
+	if err := e.Bytes(b.Result()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// leafWriter hashes each chunk-length run of content written to it.
+type leafWriter struct {
+	t *MerkleTree
+	h hash.Hash
+	l int // content bytes hashed in the current leaf
+}
+
+func (w *leafWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if w.l == 0 {
+			w.h.Reset()
+			w.h.Write([]byte{0})
+		}
+		l := w.t.chunkLen - w.l
+		if l > len(p) {
+			l = len(p)
+		}
+		w.h.Write(p[:l])
+		w.l += l
+		p = p[l:]
+		if w.l == w.t.chunkLen {
+			w.leaf()
+		}
+	}
+	w.t.n += int64(n)
+	return n, nil
+}
+
+// Finish the current leaf and append its hash to the tree.
+func (w *leafWriter) leaf() {
+	w.t.leaves = w.h.Sum(w.t.leaves)
+	w.l = 0
+}
+
+// Decode a tree blob written by Encoder.MerkleReadFrom,
+// using hash functions that newHash creates.
+// The tree must be checked against a trusted root with Verify
+// before it is used to verify content.
+func (d *Decoder) MerkleTree(newHash func() hash.Hash) (*MerkleTree, error) {
+	b, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeMerkleTree(b, newHash)
+}
+
+// Decode the content of a tree blob written by Encoder.MerkleReadFrom,
+// as Decoder.MerkleTree does.
+func DecodeMerkleTree(b []byte, newHash func() hash.Hash) (*MerkleTree, error) {
+	d := NewBytesDecoder(b)
+	n, err := d.Uint64()
+	if err != nil {
+		return nil, truncated(err)
+	}
+	chunkLen, err := d.Uint64()
+	if err != nil {
+		return nil, truncated(err)
+	}
+	leaves, err := d.Bytes()
+	if err != nil {
+		return nil, truncated(err)
+	}
+	t := &MerkleTree{newHash: newHash, leaves: leaves}
+	t.size = newHash().Size()
+	if chunkLen < uint64(MinChunkLen) || chunkLen > uint64(MaxChunkLen) ||
+		n > 1<<62 || len(d.Remaining()) != 0 {
+		return nil, errMerkleTree
+	}
+	t.n, t.chunkLen = int64(n), int(chunkLen)
+	if int64(len(leaves)) != t.numLeaves()*int64(t.size) {
+		return nil, errMerkleTree
+	}
+	return t, nil
+}
+
+// Return the number of leaves, one per chunk of nonempty content.
+func (t *MerkleTree) numLeaves() int64 {
+	return (t.n + int64(t.chunkLen) - 1) / int64(t.chunkLen)
+}
+
+// Return the length of the blob content the tree covers.
+func (t *MerkleTree) Len() int64 {
+	return t.n
+}
+
+// Return the root hash of the tree.
+func (t *MerkleTree) Root() []byte {
+	if t.n == 0 {
+		return t.newHash().Sum(nil)
+	}
+	return t.root(t.newHash(), t.leaves)
+}
+
+// Return the root hash of the subtree over the given leaf hashes.
+func (t *MerkleTree) root(h hash.Hash, leaves []byte) []byte {
+	n := len(leaves) / t.size
+	if n == 1 {
+		return append([]byte(nil), leaves...)
+	}
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	left := t.root(h, leaves[:k*t.size])
+	right := t.root(h, leaves[k*t.size:])
+	h.Reset()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Check that the tree's root hash matches root,
+// returning ErrChecksum if not.
+func (t *MerkleTree) Verify(root []byte) error {
+	if !bytes.Equal(t.Root(), root) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// Return a MerkleReader that reads and verifies the content of the blob
+// whose encoding starts at offset off in r.
+func (t *MerkleTree) NewReader(r io.ReaderAt, off int64) *MerkleReader {
+	return &MerkleReader{t: t, r: r, off: off}
+}
+
+// MerkleReader provides verified random access to the content of a blob
+// encoded by Encoder.MerkleReadFrom.
+// It reads and hashes only the chunks overlapping each range requested,
+// and is safe for concurrent use by multiple goroutines.
+// Wrap it in an io.SectionReader for verified seeking.
+type MerkleReader struct {
+	t   *MerkleTree
+	r   io.ReaderAt
+	off int64 // offset of the blob's encoding in r
+}
+
+// Return the length of the blob's content.
+func (mr *MerkleReader) Size() int64 {
+	return mr.t.n
+}
+
+// Read len(p) bytes of content starting at content offset off,
+// verifying each chunk they lie in against the tree.
+// Returns ErrChecksum if a chunk's hash does not match.
+func (mr *MerkleReader) ReadAt(p []byte, off int64) (int, error) {
+	t := mr.t
+	if off < 0 {
+		return 0, errMerkleOffset
+	}
+	var buf []byte
+	tot := 0
+	for len(p) > 0 {
+		if off >= t.n {
+			return tot, io.EOF
+		}
+		if buf == nil {
+			buf = make([]byte, 4+t.chunkLen)
+		}
+		i := off / int64(t.chunkLen)
+		chunk, err := mr.chunk(i, buf)
+		if err != nil {
+			return tot, err
+		}
+		l := copy(p, chunk[off-i*int64(t.chunkLen):])
+		tot += l
+		off += int64(l)
+		p = p[l:]
+	}
+	return tot, nil
+}
+
+// Read and verify the content of the i-th chunk
+// into buf, which must hold the chunk's header and content.
+func (mr *MerkleReader) chunk(i int64, buf []byte) ([]byte, error) {
+	t := mr.t
+	pos := mr.off + i*int64(4+t.chunkLen) // offset of the chunk's header
+	l := t.chunkLen
+	if i == t.numLeaves()-1 {
+		l = int(t.n - i*int64(t.chunkLen))
+	}
+
+	var content []byte
+	if i < t.numLeaves()-1 { // partial chunk
+		content = buf[:l]
+		if k, err := mr.r.ReadAt(content, pos+4); k != l {
+			return nil, at(truncated(err), pos)
+		}
+	} else { // final chunk, whose header length depends on its length
+		enc := buf[:HeaderLen(l)+l]
+		k, err := mr.r.ReadAt(enc, pos)
+		if k < len(enc)-1 || (k < len(enc) && l != 1) {
+			return nil, at(truncated(err), pos)
+		}
+		content, _, err = Decode(enc[:k])
+		if err != nil || len(content) != l {
+			return nil, errMerkleChunk.At(pos)
+		}
+	}
+
+	h := t.newHash()
+	h.Write([]byte{0})
+	h.Write(content)
+	var sb [64]byte
+	if !bytes.Equal(h.Sum(sb[:0]), t.leaves[i*int64(t.size):][:t.size]) {
+		return nil, ErrChecksum
+	}
+	return content, nil
+}
+
+var errMerkleTree = coerr.New(coerr.Syntax, "cbe", -1,
+	"malformed Merkle tree blob")
+var errMerkleChunk = coerr.New(coerr.Syntax, "cbe", -1,
+	"chunk does not match Merkle tree layout")
+var errMerkleOffset = coerr.New(coerr.TooLarge, "cbe", -1,
+	"negative content offset")
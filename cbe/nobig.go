@@ -0,0 +1,14 @@
+//go:build tinygo || cbe_tiny
+
+package cbe
+
+// Marshal and Unmarshal support no big.Int, big.Rat, or big.Float values
+// in builds that avoid depending on math/big.
+
+func marshalBig(e *Encoder, v interface{}) error {
+	return errUnsupported
+}
+
+func unmarshalBig(d *Decoder, v interface{}) error {
+	return errUnsupported
+}
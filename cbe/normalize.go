@@ -0,0 +1,76 @@
+package cbe
+
+import (
+	"io"
+)
+
+// Rewrite the blob stream read from src into canonical form,
+// as defined for Decoder.SetStrict, writing it to dst.
+// Blobs encoded in a single chunk, which are always canonical,
+// are copied through unchanged,
+// while chunked blobs are streamed and rechunked
+// into partial chunks of MaxChunkLen bytes followed by a non-empty final chunk,
+// buffering at most one chunk of content at a time.
+// Input ending within a blob yields an error of kind coerr.Truncated.
+func Normalize(dst io.Writer, src io.Reader) error {
+	d := NewDecoder(src)
+	var buf, chunk []byte
+	for {
+		n, single, err := d.NextLen()
+		if err == EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if single {
+			if cap(buf) < 4+int(n) {
+				buf = make([]byte, 4+n)
+			}
+			content, err := d.BytesAppend(buf[4:4])
+			if err != nil {
+				return err
+			}
+			if _, err := dst.Write(Encode(buf[:0], content)); err != nil {
+				return err
+			}
+			continue
+		}
+		if chunk == nil {
+			chunk = make([]byte, 4+MaxChunkLen+1)
+		}
+		if err := normalizeChunked(dst, d.Reader(), chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// Rechunk the content read from r into a canonical chunked blob,
+// using chunk as a buffer for a 4-byte header and MaxChunkLen+1 bytes,
+// one more than a chunk so as to know whether more content follows.
+func normalizeChunked(dst io.Writer, r io.Reader, chunk []byte) error {
+	have := 0
+	for {
+		k, err := io.ReadFull(r, chunk[4+have:])
+		have += k
+		if err == EOF || err == io.ErrUnexpectedEOF { // final chunk
+			hdr := AppendHeader(chunk[:0], have)
+			if have == 1 && chunk[4] < 0x80 {
+				hdr = hdr[:0] // a 1-byte blob below 0x80 has no header
+			}
+			copy(chunk[4-len(hdr):], hdr)
+			_, err := dst.Write(chunk[4-len(hdr) : 4+have])
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		// Emit a full partial chunk and keep the byte after it
+		h := MaxChunkLen - 16448
+		chunk[0], chunk[1], chunk[2], chunk[3] =
+			0x81, 0x40+byte(h>>16), byte(h>>8), byte(h)
+		if _, err := dst.Write(chunk[:4+MaxChunkLen]); err != nil {
+			return err
+		}
+		chunk[4], have = chunk[4+MaxChunkLen], 1
+	}
+}
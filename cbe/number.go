@@ -0,0 +1,259 @@
+package cbe
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// NumberFormat selects an alternative layout for the content
+// of integer blobs, for interoperating with existing binary formats
+// that mandate a particular numeric layout.
+// The zero NumberFormat encodes integers exactly as
+// Encoder.Uint64 and Encoder.Int64 do:
+// big-endian, minimal-length, and zigzag-encoded if signed.
+type NumberFormat struct {
+	LittleEndian   bool // least significant byte first
+	TwosComplement bool // signed integers in two's complement, not zigzag
+	Width          int  // fixed content length from 1 to 8, or 0 for minimal
+	Scale          int  // decimal places of fixed-point decimals
+}
+
+// Append the content of an unsigned integer blob encoding v.
+// Returns an error if v does not fit in the format's fixed width.
+func (f *NumberFormat) appendContent(dst []byte, v uint64, signed bool) (
+	[]byte, error) {
+
+	if f.Width < 0 || f.Width > 8 {
+		return nil, errWidth
+	}
+	var b8 [8]byte
+	binary.BigEndian.PutUint64(b8[:], v)
+	b := b8[:]
+	if signed { // trim redundant sign bytes
+		for len(b) > 0 && ((b[0] == 0 && (len(b) == 1 || b[1] < 0x80)) ||
+			(b[0] == 0xff && len(b) > 1 && b[1] >= 0x80)) {
+			b = b[1:]
+		}
+	} else {
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+	}
+	if f.Width != 0 {
+		if len(b) > f.Width {
+			return nil, errRange
+		}
+		b = b8[8-f.Width:] // including any sign extension
+	}
+	if f.LittleEndian {
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+	}
+	return append(dst, b...), nil
+}
+
+// Returns the value of integer blob content b.
+func (f *NumberFormat) value(b []byte, signed bool) (uint64, error) {
+	if f.Width != 0 && len(b) != f.Width {
+		return 0, errWidthMismatch
+	}
+	if len(b) > 8 {
+		return 0, errUint64Range
+	}
+	var b8 [8]byte
+	lo := 8 - len(b)
+	if f.LittleEndian {
+		for i, c := range b {
+			b8[7-i] = c
+		}
+	} else {
+		copy(b8[lo:], b)
+	}
+	if signed && len(b) > 0 && b8[lo] >= 0x80 { // sign-extend
+		for i := 0; i < lo; i++ {
+			b8[i] = 0xff
+		}
+	}
+	return binary.BigEndian.Uint64(b8[:]), nil
+}
+
+// Append the integer blob encoding of unsigned integer v to dst.
+func (f *NumberFormat) AppendUint64(dst []byte, v uint64) ([]byte, error) {
+	var buf [8]byte
+	b, err := f.appendContent(buf[:0], v, false)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(dst, b), nil
+}
+
+// Append the integer blob encoding of signed integer v to dst.
+func (f *NumberFormat) AppendInt64(dst []byte, v int64) ([]byte, error) {
+	if !f.TwosComplement {
+		return f.AppendUint64(dst, zigzag(v))
+	}
+	var buf [8]byte
+	b, err := f.appendContent(buf[:0], uint64(v), true)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(dst, b), nil
+}
+
+// Decode an unsigned integer blob from the start of buf,
+// returning its value and the remainder of buf following the blob.
+func (f *NumberFormat) DecodeUint64(buf []byte) (v uint64, rest []byte,
+	err error) {
+
+	b, rest, err := Decode(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	if v, err = f.value(b, false); err != nil {
+		return 0, nil, err
+	}
+	return v, rest, nil
+}
+
+// Decode a signed integer blob from the start of buf,
+// returning its value and the remainder of buf following the blob.
+func (f *NumberFormat) DecodeInt64(buf []byte) (v int64, rest []byte,
+	err error) {
+
+	b, rest, err := Decode(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	if v, err = f.int64Value(b); err != nil {
+		return 0, nil, err
+	}
+	return v, rest, nil
+}
+
+func (f *NumberFormat) int64Value(b []byte) (int64, error) {
+	u, err := f.value(b, f.TwosComplement)
+	if err != nil || f.TwosComplement {
+		return int64(u), err
+	}
+	return unzigzag(u), nil
+}
+
+// Encode unsigned integer v to e as an integer blob in this format.
+func (f *NumberFormat) EncodeUint64(e *Encoder, v uint64) error {
+	var buf [8]byte
+	b, err := f.appendContent(buf[:0], v, false)
+	if err != nil {
+		return err
+	}
+	return e.Bytes(b)
+}
+
+// Encode signed integer v to e as an integer blob in this format.
+func (f *NumberFormat) EncodeInt64(e *Encoder, v int64) error {
+	if !f.TwosComplement {
+		return f.EncodeUint64(e, zigzag(v))
+	}
+	var buf [8]byte
+	b, err := f.appendContent(buf[:0], uint64(v), true)
+	if err != nil {
+		return err
+	}
+	return e.Bytes(b)
+}
+
+// Decode an unsigned integer blob in this format from d.
+func (f *NumberFormat) ReadUint64(d *Decoder) (uint64, error) {
+	b, err := d.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	return f.value(b, false)
+}
+
+// Decode a signed integer blob in this format from d.
+func (f *NumberFormat) ReadInt64(d *Decoder) (int64, error) {
+	b, err := d.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	return f.int64Value(b)
+}
+
+// Encode the decimal number s, such as "-12.5",
+// as a fixed-point signed integer blob counting units of 10^-Scale.
+// Returns an error if s has more than Scale decimal places
+// or its scaled value does not fit in an int64.
+func (f *NumberFormat) EncodeDecimal(e *Encoder, s string) error {
+	v, err := f.parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	return f.EncodeInt64(e, v)
+}
+
+// Decode a fixed-point decimal blob in this format from d,
+// returning it as a decimal string with exactly Scale decimal places.
+func (f *NumberFormat) ReadDecimal(d *Decoder) (string, error) {
+	v, err := f.ReadInt64(d)
+	if err != nil {
+		return "", err
+	}
+	return f.formatDecimal(v), nil
+}
+
+// Returns the value of decimal string s in units of 10^-Scale.
+func (f *NumberFormat) parseDecimal(s string) (int64, error) {
+	ip, fp := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		ip, fp = s[:i], s[i+1:]
+		if fp == "" || strings.IndexFunc(fp, notDigit) >= 0 {
+			return 0, errDecimal
+		}
+	}
+	digits := strings.TrimLeft(ip, "+-")
+	if len(ip)-len(digits) > 1 || digits == "" ||
+		strings.IndexFunc(digits, notDigit) >= 0 {
+		return 0, errDecimal
+	}
+	if len(fp) > f.Scale {
+		return 0, errScale
+	}
+	fp += strings.Repeat("0", f.Scale-len(fp))
+	v, err := strconv.ParseInt(ip+fp, 10, 64)
+	if err != nil {
+		return 0, errRange
+	}
+	return v, nil
+}
+
+func notDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// Format v, in units of 10^-Scale, as a decimal string.
+func (f *NumberFormat) formatDecimal(v int64) string {
+	s := strconv.FormatInt(v, 10)
+	if f.Scale <= 0 {
+		return s
+	}
+	sign := ""
+	if s[0] == '-' {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= f.Scale {
+		s = strings.Repeat("0", f.Scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-f.Scale] + "." + s[len(s)-f.Scale:]
+}
+
+var errWidth = coerr.New(coerr.TooLarge, "cbe", -1,
+	"integer width not between 0 and 8 bytes")
+var errWidthMismatch = coerr.New(coerr.Syntax, "cbe", -1,
+	"integer blob does not have the fixed width")
+var errDecimal = coerr.New(coerr.Syntax, "cbe", -1, "invalid decimal number")
+var errScale = coerr.New(coerr.TooLarge, "cbe", -1,
+	"decimal has too many decimal places")
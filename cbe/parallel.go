@@ -0,0 +1,158 @@
+package cbe
+
+import (
+	"io"
+)
+
+// Set the number of goroutines that transform chunks concurrently,
+// so that costly transforms such as compression of a large blob
+// can use multiple cores.
+// The transformed chunks are written in their original order,
+// and the output is identical to that of a single worker.
+// With more than one worker, the transforms must be safe
+// for concurrent use, as are those provided by this package,
+// and up to twice as many chunks as workers are buffered at once.
+// Panics if n is less than 1.
+func (t *TransformEncoder) SetWorkers(n int) {
+	if n < 1 {
+		panic("invalid number of transform workers")
+	}
+	t.workers = n
+}
+
+// Encode a blob with the n bytes of content starting at offset 0 in r,
+// such as a large file, as a transformed blob.
+// Each worker reads its chunks from r directly,
+// so that reading as well as transforming proceeds concurrently
+// while the Encoder writes the preceding chunks.
+// Use an io.SectionReader to encode content at another offset.
+// Returns io.ErrUnexpectedEOF if r holds fewer than n bytes.
+func (t *TransformEncoder) ReadFromAt(r io.ReaderAt, n int64) (int64, error) {
+	if n < 0 {
+		panic("negative content length")
+	}
+	return t.parallel(nil, r, n)
+}
+
+// transformJob is one chunk passing through the parallel pipeline.
+type transformJob struct {
+	in   []byte    // content chunk
+	off  int64     // offset of the chunk in a ReaderAt input
+	out  []byte    // transformed chunk
+	bufs [2][]byte // scratch buffers for the transforms
+	err  error
+	done chan struct{} // closed once the chunk has been transformed
+}
+
+// Transform the content of r, or else the n bytes of ra,
+// in worker goroutines, writing the transformed chunks in order.
+func (t *TransformEncoder) parallel(r io.Reader, ra io.ReaderAt,
+	n int64) (int64, error) {
+
+	window := 2 * t.workers
+	free := make(chan *transformJob, window)
+	for i := 0; i < window; i++ {
+		free <- &transformJob{in: make([]byte, t.chunkLen)}
+	}
+	work := make(chan *transformJob)
+	for i := 0; i < t.workers; i++ {
+		go t.worker(ra, work)
+	}
+	defer close(work)
+
+	// Write the jobs' results in order as they complete
+	order := make(chan *transformJob, window)
+	stop := make(chan struct{}) // closed on a write error
+	type result struct {
+		n   int64
+		err error
+	}
+	res := make(chan result)
+	go func() {
+		var rs result
+		for j := range order {
+			<-j.done
+			if rs.err != nil {
+				free <- j // drain the remaining jobs
+				continue
+			}
+			if rs.err = j.err; rs.err == nil {
+				rs.err = t.e.Bytes(j.out)
+				rs.n += int64(len(j.in))
+			}
+			if rs.err != nil {
+				close(stop)
+			}
+			free <- j
+		}
+		res <- rs
+	}()
+
+	// Dispatch the chunks to the workers in order
+	var rerr error
+	for off := int64(0); rerr == nil; off += int64(t.chunkLen) {
+		var j *transformJob
+		select {
+		case j = <-free:
+		case <-stop:
+		}
+		if j == nil {
+			break
+		}
+		j.in, j.off = j.in[:t.chunkLen], off
+		if ra != nil {
+			if off >= n {
+				free <- j
+				break
+			}
+			if l := n - off; l < int64(t.chunkLen) {
+				j.in = j.in[:l]
+			}
+		} else {
+			l, err := io.ReadFull(r, j.in)
+			if err == EOF || err == io.ErrUnexpectedEOF {
+				rerr = EOF // last chunk
+			} else if err != nil {
+				rerr = err
+				l = 0
+			}
+			if l == 0 {
+				free <- j
+				break
+			}
+			j.in = j.in[:l]
+		}
+		j.done = make(chan struct{})
+		work <- j
+		order <- j
+	}
+	close(order)
+	rs := <-res
+	if rerr == EOF {
+		rerr = nil
+	}
+	if rs.err != nil {
+		return rs.n, rs.err
+	}
+	if rerr != nil {
+		return rs.n, rerr
+	}
+	return rs.n, t.e.Bytes(nil)
+}
+
+// Read, if ra is non-nil, and transform the chunks of jobs from work.
+func (t *TransformEncoder) worker(ra io.ReaderAt, work chan *transformJob) {
+	for j := range work {
+		j.err = nil
+		if ra != nil {
+			k, err := ra.ReadAt(j.in, j.off)
+			if k < len(j.in) {
+				j.err = unexpected(err)
+			}
+		}
+		if j.err == nil {
+			j.out, j.err = t.transform(&j.bufs, j.in)
+		}
+		close(j.done)
+	}
+}
@@ -0,0 +1,39 @@
+package cbe
+
+import (
+	"io"
+)
+
+// Return a connected pair of a WriteCloser and a Reader,
+// such that everything written to the WriteCloser
+// can be read from the Reader encoded as a single blob,
+// for plugging CBE framing into code that composes Readers and Writers.
+// Content is encoded in chunks of the default chunk length
+// as with Encoder.Writer,
+// each chunk becoming readable once it is full or the writer is closed.
+// As with io.Pipe, writes block until the Reader consumes the output.
+// Closing the WriteCloser completes the blob,
+// after which the Reader returns io.EOF.
+func EncodePipe() (io.WriteCloser, io.Reader) {
+	pr, pw := io.Pipe()
+	return &pipeWriter{bw: NewEncoder(pw).Writer(), pw: pw}, pr
+}
+
+// pipeWriter encodes the content written to it into a pipe.
+type pipeWriter struct {
+	bw io.WriteCloser // blob writer encoding into pw
+	pw *io.PipeWriter
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	return w.bw.Write(p)
+}
+
+// Complete the blob and close the pipe,
+// so that the Reader returns any error in completing the blob
+// or else io.EOF.
+func (w *pipeWriter) Close() error {
+	err := w.bw.Close()
+	w.pw.CloseWithError(err)
+	return err
+}
@@ -0,0 +1,45 @@
+package cbe
+
+import (
+	"sync"
+)
+
+// Pools of buffers shared by all Encoders and Decoders,
+// so that servers encoding and decoding many blobs
+// need not allocate fresh buffers for each.
+
+// chunkPool holds chunk buffers of the default chunk size
+// plus room for a header, for Encoders whose chunk size is not set.
+var chunkPool = sync.Pool{New: func() interface{} {
+	b := make([]byte, 4+defaultChunkLen)
+	return &b
+}}
+
+// decodePool holds buffers for content decoded only transiently,
+// such as integers and the content of strings.
+var decodePool = sync.Pool{New: func() interface{} {
+	b := make([]byte, 0, 64)
+	return &b
+}}
+
+// Largest decode buffer worth returning to the pool,
+// so that one huge blob does not pin a huge buffer.
+const maxPooledLen = 64 << 10
+
+// Return a chunk buffer obtained via Encoder.getBuf, if pooled is not nil.
+func putChunkBuf(pooled *[]byte) {
+	if pooled != nil {
+		chunkPool.Put(pooled)
+	}
+}
+
+func getDecodeBuf() *[]byte {
+	return decodePool.Get().(*[]byte)
+}
+
+func putDecodeBuf(p *[]byte) {
+	if cap(*p) <= maxPooledLen {
+		*p = (*p)[:0]
+		decodePool.Put(p)
+	}
+}
@@ -0,0 +1,21 @@
+package cbe
+
+// Set a function that the Encoder calls after writing each chunk
+// of a blob it streams with ReadFrom, ReadFromN, or Writer,
+// passing the number of content bytes of the blob written so far,
+// so that a tool can report the progress of a long transfer.
+// The function is also called for Bytes and String
+// when their content is too long for a single chunk.
+// A nil f disables the calls.
+func (e *Encoder) SetProgress(f func(n int64)) {
+	e.progress = f
+}
+
+// Set a function that the Decoder calls after decoding each chunk
+// of a blob it streams with WriteTo, or with Skip when a checksum is set,
+// and after a Reader has read each chunk's content in full,
+// passing the number of content bytes of the blob decoded so far.
+// A nil f disables the calls.
+func (d *Decoder) SetProgress(f func(n int64)) {
+	d.progress = f
+}
@@ -0,0 +1,21 @@
+//go:build !tinygo && !cbe_tiny
+
+package cbe
+
+import (
+	"bufio"
+	"io"
+)
+
+// Wrap r in a bufio.Reader of at least size bytes,
+// or of the default size if size is 0 or less,
+// unless it already is one.
+func newByteReader(r io.Reader, size int) byteReader {
+	if br, ok := r.(*bufio.Reader); ok && br.Size() >= size {
+		return br
+	}
+	if size <= 0 {
+		return bufio.NewReader(r)
+	}
+	return bufio.NewReaderSize(r, size)
+}
@@ -0,0 +1,91 @@
+//go:build tinygo || cbe_tiny
+
+package cbe
+
+import (
+	"errors"
+	"io"
+)
+
+// tinyReader provides the byte-scanning operations the Decoder needs
+// without bufio's heap-allocated buffer,
+// at the cost of reading header bytes from r one at a time.
+type tinyReader struct {
+	r    io.Reader
+	b    [1]byte // last byte read
+	ok   bool    // b holds a byte that may be unread
+	back bool    // b has been unread and is to be read again
+
+	skip [64]byte // scratch space for Discard
+}
+
+func newByteReader(r io.Reader, size int) byteReader {
+	if br, ok := r.(byteReader); ok {
+		return br
+	}
+	return &tinyReader{r: r}
+}
+
+func (t *tinyReader) ReadByte() (byte, error) {
+	if t.back {
+		t.back = false
+		return t.b[0], nil
+	}
+	t.ok = false
+	if _, err := io.ReadFull(t.r, t.b[:]); err != nil {
+		return 0, err
+	}
+	t.ok = true
+	return t.b[0], nil
+}
+
+func (t *tinyReader) UnreadByte() error {
+	if !t.ok || t.back {
+		return errors.New("cbe: invalid use of UnreadByte")
+	}
+	t.back = true
+	return nil
+}
+
+func (t *tinyReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if t.back {
+		t.back = false
+		p[0] = t.b[0]
+		return 1, nil
+	}
+	t.ok = false
+	return t.r.Read(p)
+}
+
+// Discard the next n bytes, reading them through a small fixed buffer.
+func (t *tinyReader) Discard(n int) (int, error) {
+	d := 0
+	if n > 0 && t.back {
+		t.back = false
+		d++
+	}
+	t.ok = false
+	for d < n {
+		m := n - d
+		if m > len(t.skip) {
+			m = len(t.skip)
+		}
+		k, err := io.ReadFull(t.r, t.skip[:m])
+		d += k
+		if err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// Return the number of bytes read from r but not yet consumed.
+func (t *tinyReader) Buffered() int {
+	if t.back {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+package cbe
+
+import (
+	"io"
+)
+
+// ReaderAtDecoder provides random access to the blobs
+// in a series stored in an io.ReaderAt such as a file.
+// Scan indexes the offsets and lengths of the blobs once,
+// after which any blob may be decoded or streamed by its index
+// without decoding the blobs preceding it.
+//
+// A ReaderAtDecoder is safe for concurrent use by multiple goroutines
+// except while Scan is in progress.
+type ReaderAtDecoder struct {
+	r     io.ReaderAt
+	end   int64      // offset just past the last blob indexed
+	blobs []blobSpan // index of the blobs scanned so far
+}
+
+// blobSpan locates one blob in a ReaderAtDecoder's input.
+type blobSpan struct {
+	off, end int64 // offsets of the start and just past the end of the blob
+	n        int64 // content length
+	chunked  bool  // whether the blob comprises multiple chunks
+}
+
+// Create a ReaderAtDecoder for the blobs stored in r.
+// The decoder indexes no blobs until Scan is called.
+func NewReaderAtDecoder(r io.ReaderAt) *ReaderAtDecoder {
+	return &ReaderAtDecoder{r: r}
+}
+
+// Scan the input from the end of the last blob indexed,
+// adding each complete blob found to the index
+// until the input ends.
+// If the input ends within a blob, Scan indexes the blobs before it
+// and returns an error of kind coerr.Truncated;
+// a later Scan resumes from the incomplete blob,
+// so that a file being appended to may be rescanned as it grows.
+// Reads only the headers of the blobs, not their content.
+func (d *ReaderAtDecoder) Scan() error {
+	for {
+		s, err := d.scanBlob(d.end)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		d.blobs = append(d.blobs, s)
+		d.end = s.end
+	}
+}
+
+// Locate the blob starting at offset off,
+// returning EOF if the input ends at off.
+func (d *ReaderAtDecoder) scanBlob(off int64) (blobSpan, error) {
+	s := blobSpan{off: off}
+	var h [4]byte
+	for first := true; ; first = false {
+		k, err := d.r.ReadAt(h[:], off)
+		if k == 0 && err == io.EOF && first {
+			return s, EOF
+		}
+		hlen, l, part, herr := decodeHeader(h[:k])
+		if herr != nil {
+			if err == nil || err == io.EOF {
+				err = errTruncated.At(off)
+			}
+			return s, err
+		}
+
+		// Check that the chunk's content is all present
+		if l > 0 {
+			last := off + int64(hlen+l) - 1
+			if k, err := d.r.ReadAt(h[:1], last); k != 1 {
+				return s, at(truncated(err), off)
+			}
+		}
+		off += int64(hlen + l)
+		s.n += int64(l)
+		if !part {
+			s.end, s.chunked = off, !first
+			return s, nil
+		}
+	}
+}
+
+// Return the number of blobs indexed so far.
+func (d *ReaderAtDecoder) Len() int {
+	return len(d.blobs)
+}
+
+// Return the offset of the i-th blob's encoding in the input
+// and the length of its content.
+func (d *ReaderAtDecoder) Blob(i int) (offset, contentLen int64) {
+	s := d.blobs[i]
+	return s.off, s.n
+}
+
+// Decode the content of the i-th blob.
+func (d *ReaderAtDecoder) Bytes(i int) ([]byte, error) {
+	s := d.blobs[i]
+	if s.chunked {
+		return NewDecoder(d.section(s)).Bytes()
+	}
+	b := make([]byte, s.n)
+	if k, err := d.r.ReadAt(b, s.end-s.n); k != len(b) {
+		return nil, at(truncated(err), s.off)
+	}
+	return b, nil
+}
+
+// Return a Reader that streams the content of the i-th blob,
+// returning io.EOF at the end of the blob.
+func (d *ReaderAtDecoder) Reader(i int) io.Reader {
+	s := d.blobs[i]
+	if s.chunked {
+		return NewDecoder(d.section(s)).Reader()
+	}
+	return io.NewSectionReader(d.r, s.end-s.n, s.n)
+}
+
+// Return the portion of the input containing blob s's encoding.
+func (d *ReaderAtDecoder) section(s blobSpan) io.Reader {
+	return io.NewSectionReader(d.r, s.off, s.end-s.off)
+}
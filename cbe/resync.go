@@ -0,0 +1,150 @@
+package cbe
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// Resume decoding after the Decoder has reported corrupt input,
+// such as when salvaging data from a damaged blob log,
+// by scanning forward from the current input position
+// and returning the number of input bytes skipped.
+//
+// Since every byte begins a valid CBE header,
+// Resync needs more than a header to recognize a plausible blob.
+// If marker is non-nil, Resync scans for the encoding of marker as a blob,
+// which the writer of the input emits periodically with Encoder.Bytes,
+// and consumes it, so that decoding resumes with the blob after it.
+// The marker should be long and random enough, such as 16 random bytes,
+// that its encoding is unlikely to occur elsewhere in the input.
+// The count of bytes skipped excludes the marker blob itself.
+//
+// If marker is nil, the Decoder must have a checksum set,
+// and Resync scans for the next unchunked blob
+// whose checksum blob follows it and verifies,
+// leaving that blob to be decoded next.
+// Only blobs that fit in the Decoder's input buffer
+// together with their checksums are found,
+// so use NewDecoderSize to recover larger blobs.
+// This mode is unavailable in tiny builds or with a tee set,
+// whose input is not buffered.
+//
+// If the input ends before a plausible blob, Resync returns EOF
+// along with the count of bytes skipped.
+// Panics if marker is nil and no checksum is set.
+func (d *Decoder) Resync(marker []byte) (skipped int64, err error) {
+	d.peeked = false
+	if marker != nil {
+		enc := Encode(nil, marker)
+		if skipped, err = d.resyncMarker(enc); err == nil {
+			d.off += int64(len(enc))
+		}
+	} else {
+		if d.sum == nil {
+			panic("Resync needs a sync marker or checksum")
+		}
+		skipped, err = d.resyncSum()
+	}
+	d.off += skipped
+	if d.sum != nil {
+		d.sum.Reset()
+	}
+	return skipped, err
+}
+
+// Consume input through the next occurrence of enc,
+// returning the number of bytes preceding it.
+func (d *Decoder) resyncMarker(enc []byte) (int64, error) {
+
+	// fail[k] is the length of the longest proper prefix of enc
+	// that is also a suffix of enc[:k+1], as in Knuth-Morris-Pratt.
+	fail := make([]int, len(enc))
+	for i, k := 1, 0; i < len(enc); i++ {
+		for k > 0 && enc[i] != enc[k] {
+			k = fail[k-1]
+		}
+		if enc[i] == enc[k] {
+			k++
+		}
+		fail[i] = k
+	}
+
+	read := int64(0)
+	for k := 0; k < len(enc); {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return read, err
+		}
+		read++
+		for k > 0 && c != enc[k] {
+			k = fail[k-1]
+		}
+		if c == enc[k] {
+			k++
+		}
+	}
+	return read - int64(len(enc)), nil
+}
+
+// Discard input up to the next blob whose checksum verifies,
+// returning the number of bytes discarded.
+func (d *Decoder) resyncSum() (int64, error) {
+	pr, ok := d.r.(interface {
+		Peek(int) ([]byte, error)
+		Size() int
+	})
+	if !ok {
+		return 0, errResyncPeek
+	}
+	skipped := int64(0)
+	for {
+		buf, err := pr.Peek(pr.Size())
+		if len(buf) == 0 {
+			return skipped, err
+		}
+		i, found := 0, false
+		for ; i < len(buf) && !found; i++ {
+			ok, fits := plausibleSum(buf[i:], d.sum, d.max)
+			if !fits && i > 0 {
+				break // reexamine it at the start of the buffer
+			}
+			found = ok
+		}
+		if found {
+			i-- // leave the blob found in the input
+		}
+		if _, err := d.discardRead(i); err != nil {
+			return skipped, err
+		}
+		skipped += int64(i)
+		if found {
+			return skipped, nil
+		}
+	}
+}
+
+// Report whether buf starts with an unchunked blob of at most max bytes,
+// if max is positive, followed by a checksum blob under h that verifies,
+// and whether buf is long enough to tell.
+func plausibleSum(buf []byte, h hash.Hash, max int64) (ok, fits bool) {
+	ofs, n, part, err := decodeHeader(buf)
+	if err != nil || len(buf) < ofs+n {
+		return false, false
+	}
+	if part || max > 0 && int64(n) > max {
+		return false, true
+	}
+	h.Reset()
+	h.Write(buf[ofs : ofs+n])
+	var sb [64]byte
+	sum := h.Sum(sb[:0])
+	h.Reset()
+	got, _, err := Decode(buf[ofs+n:])
+	if err == EOF {
+		return false, false
+	}
+	return err == nil && bytes.Equal(got, sum), true
+}
+
+var errResyncPeek = errors.New("cbe: Resync by checksum needs buffered input")
@@ -0,0 +1,28 @@
+package cbe
+
+// Split function for a bufio.Scanner that yields each blob in the input
+// as a token containing its complete encoding, headers included,
+// whether the blob is encoded in one chunk or many.
+// Decode the content of a token with Decode or a BytesDecoder.
+// A Scanner fails with bufio.ErrTooLong on a blob whose encoding
+// exceeds its maximum token size, 64KiB by default,
+// so set a larger maximum with Scanner.Buffer to scan large blobs.
+// Input ending within a blob yields an error of kind coerr.Truncated.
+func ScanBlobs(data []byte, atEOF bool) (advance int, token []byte,
+	err error) {
+
+	end := 0
+	for {
+		ofs, n, part, err := decodeHeader(data[end:])
+		if err != nil || len(data)-end < ofs+n { // incomplete blob
+			if atEOF && len(data) > 0 {
+				return 0, nil, errTruncated
+			}
+			return 0, nil, nil
+		}
+		end += ofs + n
+		if !part {
+			return end, data[:end], nil
+		}
+	}
+}
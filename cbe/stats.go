@@ -0,0 +1,54 @@
+package cbe
+
+// Stats counts the framing an Encoder or Decoder has processed,
+// so that long-running pipelines can monitor
+// their throughput and the overhead of the encoding.
+// Checksum blobs are counted like any other blob.
+// A Stats must not be read while its Encoder or Decoder is in use
+// in another goroutine.
+type Stats struct {
+	Blobs   int64 // complete blobs, counted at their final chunk
+	Chunks  int64 // chunks, counting an unchunked blob as one
+	Header  int64 // bytes of chunk headers
+	Content int64 // bytes of blob content
+}
+
+// Set the Stats that the Encoder updates as it writes each chunk.
+// The Encoder adds to the existing counts, so that several Encoders
+// can share one Stats if they are not used concurrently.
+// A nil s disables counting.
+func (e *Encoder) SetStats(s *Stats) {
+	e.stats = s
+}
+
+// Set the Stats that the Decoder updates as it decodes each chunk header,
+// counting the chunk's content as decoded even if it is skipped.
+// The Decoder adds to the existing counts like Encoder.SetStats.
+// A nil s disables counting.
+func (d *Decoder) SetStats(s *Stats) {
+	d.stats = s
+}
+
+// Return the fraction of the bytes counted that are header overhead,
+// or 0 if none have been counted.
+func (s *Stats) Overhead() float64 {
+	tot := s.Header + s.Content
+	if tot == 0 {
+		return 0
+	}
+	return float64(s.Header) / float64(tot)
+}
+
+// Count a chunk with an hlen-byte header and n bytes of content,
+// which is the final chunk of a blob unless part is set.
+func (s *Stats) count(hlen, n int, part bool) {
+	if s == nil {
+		return
+	}
+	s.Chunks++
+	s.Header += int64(hlen)
+	s.Content += int64(n)
+	if !part {
+		s.Blobs++
+	}
+}
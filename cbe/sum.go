@@ -0,0 +1,119 @@
+package cbe
+
+import (
+	"bytes"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Returns a new CRC-32C hash, a suitable per-blob checksum
+// for SetChecksum on both Encoder and Decoder.
+func NewCRC32C() hash.Hash {
+	return crc32.New(castagnoli)
+}
+
+// Set a checksum with which the Encoder follows every blob it encodes.
+// After each blob, the Encoder writes a checksum blob
+// containing the hash of the preceding blob's content,
+// which a Decoder configured with the same hash verifies.
+// The Encoder resets h before each blob.
+// A nil h disables checksums.
+func (e *Encoder) SetChecksum(h hash.Hash) {
+	if h != nil {
+		h.Reset()
+	}
+	e.sum = h
+}
+
+// Write the checksum blob for the blob just encoded, if enabled.
+func (e *Encoder) writeSum() error {
+	if e.sum == nil {
+		return nil
+	}
+	var sb, eb [64]byte
+	s := e.sum.Sum(sb[:0])
+	e.sum.Reset()
+	enc := Encode(eb[:0], s)
+	if err := e.write(enc); err != nil {
+		return err
+	}
+	e.stats.count(len(enc)-len(s), len(s), false)
+	return nil
+}
+
+// Set a checksum with which the Decoder verifies every blob it decodes,
+// each of which must be followed by a checksum blob
+// as written by an Encoder configured with the same hash.
+// Decoding a blob whose checksum does not match returns ErrChecksum.
+// A nil h disables verification.
+func (d *Decoder) SetChecksum(h hash.Hash) {
+	if h != nil {
+		h.Reset()
+	}
+	d.sum = h
+}
+
+// Read and verify the checksum blob following a blob just decoded.
+func (d *Decoder) verifySum() error {
+	var gb, sb [64]byte
+	got := d.sum.Sum(gb[:0])
+	d.sum.Reset()
+
+	n, part, err := d.header()
+	if err != nil {
+		return d.truncated(err)
+	}
+	if part || n != len(got) {
+		return ErrChecksum
+	}
+	sum := sb[:0]
+	if n > len(sb) {
+		sum = make([]byte, n)
+	}
+	sum = sum[:n]
+	if _, err := io.ReadFull(d.r, sum); err != nil {
+		return d.truncated(err)
+	}
+	if !bytes.Equal(sum, got) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// Set a checksum with which the BytesDecoder verifies every blob,
+// as Decoder.SetChecksum does.
+func (d *BytesDecoder) SetChecksum(h hash.Hash) {
+	if h != nil {
+		h.Reset()
+	}
+	d.sum = h
+}
+
+// Advance past the next blob, which ends at offset end,
+// after verifying the checksum blob following it if enabled.
+// Hashes the content of each chunk in place.
+func (d *BytesDecoder) consume(end int) error {
+	if d.sum == nil {
+		d.pos = end
+		return nil
+	}
+	for p := d.pos; p < end; {
+		ofs, l, _, _ := decodeHeader(d.buf[p:end])
+		d.sum.Write(d.buf[p+ofs : p+ofs+l])
+		p += ofs + l
+	}
+	var gb [64]byte
+	got := d.sum.Sum(gb[:0])
+	d.sum.Reset()
+
+	sum, rest, err := Decode(d.buf[end:])
+	if err != nil {
+		return errTruncated.At(int64(end))
+	}
+	if !bytes.Equal(sum, got) {
+		return ErrChecksum
+	}
+	d.pos = len(d.buf) - len(rest)
+	return nil
+}
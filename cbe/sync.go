@@ -0,0 +1,86 @@
+package cbe
+
+import (
+	"io"
+	"sync"
+)
+
+// SyncEncoder wraps an Encoder so that multiple goroutines
+// can safely encode blobs to one shared output stream,
+// such as a network connection.
+//
+// Each method encodes its whole blob while holding a lock,
+// so the chunks of concurrently encoded blobs never interleave
+// and every blob, together with its checksum if the Encoder has one,
+// appears contiguously in the output.
+// Blobs encoded by different goroutines appear in an unspecified order.
+// To keep a multi-blob record together, encode it within a single call to Do.
+// The underlying Encoder must not be used directly while wrapped.
+type SyncEncoder struct {
+	mu sync.Mutex
+	e  *Encoder
+}
+
+// Create a SyncEncoder serializing access to e.
+func NewSyncEncoder(e *Encoder) *SyncEncoder {
+	return &SyncEncoder{e: e}
+}
+
+// Encode a byte-slice as a blob, atomically.
+func (se *SyncEncoder) Bytes(b []byte) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.e.Bytes(b)
+}
+
+// Encode a UTF-8 string as a blob, atomically.
+func (se *SyncEncoder) String(s string) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.e.String(s)
+}
+
+// Encode a uint64 as an unsigned integer blob, atomically.
+func (se *SyncEncoder) Uint64(v uint64) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.e.Uint64(v)
+}
+
+// Encode an int64 as a signed integer blob, atomically.
+func (se *SyncEncoder) Int64(v int64) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.e.Int64(v)
+}
+
+// Encode a blob by reading bytes from r until EOF, atomically.
+// Other goroutines' blobs wait until the whole stream has been encoded.
+func (se *SyncEncoder) ReadFrom(r io.Reader) (int64, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.e.ReadFrom(r)
+}
+
+// Flush the underlying Encoder, atomically.
+func (se *SyncEncoder) Flush() error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.e.Flush()
+}
+
+// Return the underlying Encoder's sticky error, if any.
+func (se *SyncEncoder) Err() error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.e.Err()
+}
+
+// Call f with exclusive use of the underlying Encoder,
+// so that all the blobs f encodes appear contiguously in the output.
+// The Encoder must not be retained after f returns.
+func (se *SyncEncoder) Do(f func(e *Encoder) error) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return f(se.e)
+}
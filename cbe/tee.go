@@ -0,0 +1,76 @@
+package cbe
+
+import (
+	"hash"
+)
+
+// Set a hash into which the Encoder feeds every encoded byte it writes,
+// headers and checksum blobs included,
+// so that a content-addressed store can obtain the digest
+// of the encoded blobs without a second pass over them.
+// Unlike a checksum, the Encoder never resets h,
+// so the caller resets it between blobs to hash each one separately.
+// A nil h disables the tee.
+func (e *Encoder) SetTee(h hash.Hash) {
+	e.tee = h
+}
+
+// Set a hash into which the Decoder feeds every encoded byte it consumes,
+// headers and checksum blobs included,
+// exactly as a tee set on the encoding Encoder received them.
+// The header of a blob is consumed when NextLen decodes it.
+// As with Encoder.SetTee, the Decoder never resets h.
+// A nil h disables the tee.
+func (d *Decoder) SetTee(h hash.Hash) {
+	if t, ok := d.r.(*teeReader); ok {
+		d.r = t.r
+	}
+	if h != nil {
+		d.r = &teeReader{r: d.r, h: h}
+	}
+}
+
+// teeReader hashes the bytes consumed from a byteReader,
+// hashing each byte only once even if it is unread and read again.
+// It deliberately lacks a Discard method,
+// so that the Decoder reads skipped content through it.
+type teeReader struct {
+	r    byteReader
+	h    hash.Hash
+	b    [1]byte
+	back bool // the last byte read was unread and has already been hashed
+}
+
+func (t *teeReader) ReadByte() (byte, error) {
+	c, err := t.r.ReadByte()
+	if err == nil {
+		if t.back {
+			t.back = false
+		} else {
+			t.b[0] = c
+			t.h.Write(t.b[:])
+		}
+	}
+	return c, err
+}
+
+func (t *teeReader) UnreadByte() error {
+	err := t.r.UnreadByte()
+	if err == nil {
+		t.back = true
+	}
+	return err
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		q := p[:n]
+		if t.back {
+			t.back = false
+			q = q[1:]
+		}
+		t.h.Write(q)
+	}
+	return n, err
+}
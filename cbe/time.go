@@ -0,0 +1,65 @@
+package cbe
+
+import (
+	"time"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// Times and durations are encoded as signed integer blobs,
+// as Int64 encodes them, counting nanoseconds:
+// since the Unix epoch, January 1, 1970 UTC, for a time.Time,
+// and of elapsed time for a time.Duration.
+// A time's location is not encoded, so decoded times are in UTC;
+// protocols needing the original zone must encode it separately.
+// The encodable times range from about the years 1678 to 2262.
+
+var minTime = time.Unix(0, -1<<63)
+var maxTime = time.Unix(0, 1<<63-1)
+
+// Encode a time as signed nanoseconds since the Unix epoch.
+// Returns an error if t is outside the range of such nanoseconds.
+func (e *Encoder) Time(t time.Time) error {
+	if t.Before(minTime) || t.After(maxTime) {
+		return errTimeRange
+	}
+	return e.Int64(t.UnixNano())
+}
+
+// Encode a duration as signed nanoseconds.
+func (e *Encoder) Duration(d time.Duration) error {
+	return e.Int64(int64(d))
+}
+
+// Decode a time encoded by Encoder.Time, returning it in UTC.
+func (d *Decoder) Time() (time.Time, error) {
+	ns, err := d.Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns).UTC(), nil
+}
+
+// Decode a duration encoded by Encoder.Duration.
+func (d *Decoder) Duration() (time.Duration, error) {
+	ns, err := d.Int64()
+	return time.Duration(ns), err
+}
+
+// Decode a time encoded by Encoder.Time, returning it in UTC.
+func (d *BytesDecoder) Time() (time.Time, error) {
+	ns, err := d.Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns).UTC(), nil
+}
+
+// Decode a duration encoded by Encoder.Duration.
+func (d *BytesDecoder) Duration() (time.Duration, error) {
+	ns, err := d.Int64()
+	return time.Duration(ns), err
+}
+
+var errTimeRange = coerr.New(coerr.TooLarge, "cbe", -1,
+	"time outside the range of int64 nanoseconds since 1970")
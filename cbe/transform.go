@@ -0,0 +1,287 @@
+package cbe
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// ChunkTransform is a reversible transformation,
+// such as compression, encryption, or checksumming,
+// that a TransformEncoder applies to blob content chunk by chunk.
+type ChunkTransform interface {
+
+	// Encode transforms a non-empty chunk and appends the result to dst.
+	// The result must also be non-empty.
+	Encode(dst, chunk []byte) ([]byte, error)
+
+	// Decode reverses Encode, appending the original chunk to dst.
+	Decode(dst, chunk []byte) ([]byte, error)
+}
+
+// Default chunk length used by TransformEncoders.
+const DefaultTransformChunkLen = 64 * 1024
+
+// TransformEncoder encodes blobs whose content passes through
+// a pipeline of ChunkTransforms.
+//
+// The content of each blob is split into chunks of ChunkLen bytes,
+// each chunk is passed through the transforms in order,
+// and each transformed chunk is written as a separate blob.
+// An empty blob terminates the sequence of transformed chunks.
+// A TransformDecoder configured with the same transforms
+// reverses the process.
+type TransformEncoder struct {
+	e        *Encoder
+	ts       []ChunkTransform
+	chunkLen int
+	workers  int       // goroutines transforming chunks concurrently
+	in       []byte    // input chunk buffer
+	bufs     [2][]byte // scratch buffers for transform outputs
+}
+
+// Create a TransformEncoder that writes to e
+// after applying the transforms ts in order to each chunk.
+func NewTransformEncoder(e *Encoder, ts ...ChunkTransform) *TransformEncoder {
+	return &TransformEncoder{e: e, ts: ts, chunkLen: DefaultTransformChunkLen,
+		workers: 1}
+}
+
+// Set the length of the content chunks passed to the transforms.
+// Panics if chunkLen is not between 1 and MaxChunkLen.
+func (t *TransformEncoder) SetChunkLen(chunkLen int) {
+	if chunkLen < 1 || chunkLen > MaxChunkLen {
+		panic("invalid transform chunk length")
+	}
+	t.chunkLen = chunkLen
+}
+
+// Encode a blob with content read from r until EOF.
+// With more than one worker, reads chunks from r sequentially
+// but transforms them concurrently.
+func (t *TransformEncoder) ReadFrom(r io.Reader) (n int64, err error) {
+	if t.workers > 1 {
+		return t.parallel(r, nil, 0)
+	}
+	if cap(t.in) < t.chunkLen {
+		t.in = make([]byte, t.chunkLen)
+	}
+	in := t.in[:t.chunkLen]
+	tot := int64(0)
+	for {
+		l, err := io.ReadFull(r, in)
+		if err != nil && err != EOF && err != io.ErrUnexpectedEOF {
+			return tot, err
+		}
+		if l > 0 {
+			if err := t.chunk(in[:l]); err != nil {
+				return tot, err
+			}
+			tot += int64(l)
+		}
+		if err != nil { // EOF or short chunk: we're done
+			return tot, t.e.Bytes(nil)
+		}
+	}
+}
+
+// Encode a byte-slice as a transformed blob.
+func (t *TransformEncoder) Bytes(b []byte) error {
+	_, err := t.ReadFrom(bytes.NewReader(b))
+	return err
+}
+
+// Transform and write one chunk.
+func (t *TransformEncoder) chunk(c []byte) (err error) {
+	if c, err = t.transform(&t.bufs, c); err != nil {
+		return err
+	}
+	return t.e.Bytes(c)
+}
+
+// Transform one chunk using the scratch buffers bufs.
+func (t *TransformEncoder) transform(bufs *[2][]byte, c []byte) (
+	_ []byte, err error) {
+
+	for i, tr := range t.ts {
+		buf := &bufs[i%2] // alternate scratch buffers
+		*buf, err = tr.Encode((*buf)[:0], c)
+		if err != nil {
+			return nil, err
+		}
+		if len(*buf) == 0 {
+			return nil, errEmptyTransform
+		}
+		c = *buf
+	}
+	return c, nil
+}
+
+// TransformDecoder decodes blobs encoded by a TransformEncoder.
+type TransformDecoder struct {
+	d    *Decoder
+	ts   []ChunkTransform
+	bufs [2][]byte // scratch buffers for transform outputs
+}
+
+// Create a TransformDecoder that reads from d
+// and reverses the transforms ts, which are listed in the same order
+// as for the TransformEncoder that produced the input.
+func NewTransformDecoder(d *Decoder, ts ...ChunkTransform) *TransformDecoder {
+	return &TransformDecoder{d: d, ts: ts}
+}
+
+// Decode the next transformed blob and write its content to w.
+func (t *TransformDecoder) WriteTo(w io.Writer) (n int64, err error) {
+	tot := int64(0)
+	for {
+		c, err := t.d.Bytes()
+		if err != nil {
+			return tot, err
+		}
+		if len(c) == 0 { // terminator
+			return tot, nil
+		}
+		for i := len(t.ts) - 1; i >= 0; i-- {
+			buf := &t.bufs[i%2] // alternate scratch buffers
+			*buf, err = t.ts[i].Decode((*buf)[:0], c)
+			if err != nil {
+				return tot, err
+			}
+			c = *buf
+		}
+		l, err := w.Write(c)
+		tot += int64(l)
+		if err != nil {
+			return tot, err
+		}
+	}
+}
+
+// Decode the next transformed blob into a byte slice.
+func (t *TransformDecoder) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := t.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ChecksumTransform appends a CRC-32C checksum to each chunk,
+// and verifies and strips it on decoding.
+type ChecksumTransform struct{}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func (ChecksumTransform) Encode(dst, chunk []byte) ([]byte, error) {
+	dst = append(dst, chunk...)
+	return binary.BigEndian.AppendUint32(dst,
+		crc32.Checksum(chunk, castagnoli)), nil
+}
+
+func (ChecksumTransform) Decode(dst, chunk []byte) ([]byte, error) {
+	n := len(chunk) - 4
+	if n < 0 || binary.BigEndian.Uint32(chunk[n:]) !=
+		crc32.Checksum(chunk[:n], castagnoli) {
+		return nil, ErrChecksum
+	}
+	return append(dst, chunk[:n]...), nil
+}
+
+// FlateTransform compresses each chunk independently with DEFLATE.
+type FlateTransform struct {
+	Level int // compression level as defined by package compress/flate
+}
+
+func (f FlateTransform) Encode(dst, chunk []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	fw, err := flate.NewWriter(buf, f.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(chunk); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f FlateTransform) Decode(dst, chunk []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	fr := flate.NewReader(bytes.NewReader(chunk))
+	defer fr.Close()
+
+	// Refuse to inflate beyond the maximum chunk length
+	n, err := io.Copy(buf, io.LimitReader(fr, int64(MaxChunkLen)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(MaxChunkLen) {
+		return nil, errors.New("decompressed chunk too large")
+	}
+	return buf.Bytes(), nil
+}
+
+// PadTransform pads each chunk to a fixed Size,
+// so that storage backends see chunks of uniform size
+// for alignment or to hide the lengths of the content.
+// Each padded chunk consists of the chunk encoded as a CBE blob,
+// which records its real length, followed by zero bytes up to Size.
+// Set the TransformEncoder's chunk length to ChunkLen or less
+// so that every chunk fits.
+// To hide lengths from an encrypting transform's storage as well,
+// list PadTransform before the encrypting transform,
+// so that the padding is encrypted and every encrypted chunk,
+// including the last, is the same size.
+type PadTransform struct {
+	Size int // length of every padded chunk
+}
+
+// Return the length of the largest chunk that fits when padded to p.Size.
+func (p PadTransform) ChunkLen() int {
+	n := p.Size - 1
+	for n > 0 && EncodedLen(n) > p.Size {
+		n--
+	}
+	return n
+}
+
+func (p PadTransform) Encode(dst, chunk []byte) ([]byte, error) {
+	if EncodedLen(len(chunk)) > p.Size {
+		return nil, errPadSize
+	}
+	start := len(dst)
+	dst = Encode(dst, chunk)
+	for len(dst)-start < p.Size {
+		dst = append(dst, 0)
+	}
+	return dst, nil
+}
+
+func (p PadTransform) Decode(dst, chunk []byte) ([]byte, error) {
+	content, rest, err := Decode(chunk)
+	if err != nil || len(chunk) != p.Size {
+		return nil, errPadding
+	}
+	for _, b := range rest {
+		if b != 0 {
+			return nil, errPadding
+		}
+	}
+	return append(dst, content...), nil
+}
+
+// ErrChecksum is returned when decoding a chunk or blob
+// with an invalid checksum.
+var ErrChecksum = errors.New("chunk checksum mismatch")
+
+var errEmptyTransform = errors.New("chunk transform produced empty output")
+var errPadSize = errors.New("chunk too long for padded size")
+var errPadding = coerr.New(coerr.Syntax, "cbe", -1, "invalid chunk padding")
@@ -0,0 +1,199 @@
+package cbe
+
+import (
+	"bytes"
+)
+
+// The typed codec functions encode and decode values of static types
+// without reflection, for protocols whose message types are known
+// at compile time.
+// Scalar values encode as single blobs, as the Encoder methods encode them,
+// with booleans as the unsigned integers 0 and 1.
+// Lists encode as a single blob containing the encodings of the elements,
+// each of which is encoded by a function passed to EncodeList,
+// such as EncodeTo for scalars or EncodeMarshaler for Marshalers,
+// so that lists of lists and of structured values compose.
+// For example:
+//
+//	err := cbe.EncodeList(e, []uint32{1, 2, 3}, cbe.EncodeTo[uint32])
+//	...
+//	l, err := cbe.DecodeList(d, cbe.DecodeFrom[uint32])
+
+// Scalar is the constraint satisfied by the types
+// that EncodeTo and DecodeFrom encode as a single blob.
+type Scalar interface {
+	bool | int | int8 | int16 | int32 | int64 |
+		uint | uint8 | uint16 | uint32 | uint64 | string | []byte
+}
+
+// UnmarshalerPtr is the constraint satisfied by a pointer type *T
+// that implements Unmarshaler.
+type UnmarshalerPtr[T any] interface {
+	*T
+	Unmarshaler
+}
+
+// Encode a scalar value as a blob.
+func EncodeTo[T Scalar](e *Encoder, v T) error {
+	switch v := any(v).(type) {
+	case bool:
+		if v {
+			return e.Uint64(1)
+		}
+		return e.Uint64(0)
+	case int:
+		return e.Int64(int64(v))
+	case int8:
+		return e.Int64(int64(v))
+	case int16:
+		return e.Int64(int64(v))
+	case int32:
+		return e.Int64(int64(v))
+	case int64:
+		return e.Int64(v)
+	case uint:
+		return e.Uint64(uint64(v))
+	case uint8:
+		return e.Uint64(uint64(v))
+	case uint16:
+		return e.Uint64(uint64(v))
+	case uint32:
+		return e.Uint64(uint64(v))
+	case uint64:
+		return e.Uint64(v)
+	case string:
+		return e.String(v)
+	default:
+		return e.Bytes(any(v).([]byte))
+	}
+}
+
+// Decode a scalar value encoded by EncodeTo.
+// Returns an error if an integer is out of range for type T.
+func DecodeFrom[T Scalar](d *Decoder) (T, error) {
+	var v T
+	var err error
+	switch p := any(&v).(type) {
+	case *bool:
+		var u uint64
+		if u, err = d.Uint64(); err == nil && u > 1 {
+			err = errRange
+		}
+		*p = u == 1
+	case *int:
+		err = decodeInt(d, p)
+	case *int8:
+		err = decodeInt(d, p)
+	case *int16:
+		err = decodeInt(d, p)
+	case *int32:
+		err = decodeInt(d, p)
+	case *int64:
+		*p, err = d.Int64()
+	case *uint:
+		err = decodeUint(d, p)
+	case *uint8:
+		err = decodeUint(d, p)
+	case *uint16:
+		err = decodeUint(d, p)
+	case *uint32:
+		err = decodeUint(d, p)
+	case *uint64:
+		*p, err = d.Uint64()
+	case *string:
+		*p, err = d.String()
+	case *[]byte:
+		*p, err = d.Bytes()
+	}
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// Decode a signed integer into *p, checking that it fits.
+func decodeInt[T int | int8 | int16 | int32](d *Decoder, p *T) error {
+	i, err := d.Int64()
+	if err != nil {
+		return err
+	}
+	if int64(T(i)) != i {
+		return errRange
+	}
+	*p = T(i)
+	return nil
+}
+
+// Decode an unsigned integer into *p, checking that it fits.
+func decodeUint[T uint | uint8 | uint16 | uint32](d *Decoder, p *T) error {
+	u, err := d.Uint64()
+	if err != nil {
+		return err
+	}
+	if uint64(T(u)) != u {
+		return errRange
+	}
+	*p = T(u)
+	return nil
+}
+
+// Encode a value that implements Marshaler,
+// for use as the element encoder of EncodeList.
+func EncodeMarshaler[T Marshaler](e *Encoder, v T) error {
+	return v.MarshalCBE(e)
+}
+
+// Decode a value of type T whose pointer implements Unmarshaler,
+// for use as the element decoder of DecodeList.
+func DecodeUnmarshaler[T any, PT UnmarshalerPtr[T]](d *Decoder) (T, error) {
+	var v T
+	if err := PT(&v).UnmarshalCBE(d); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// Encode a list as a blob containing the encodings of its elements,
+// each encoded by enc.
+func EncodeList[T any](e *Encoder, l []T,
+	enc func(*Encoder, T) error) error {
+
+	var buf bytes.Buffer
+	le := NewEncoder(&buf)
+	for _, v := range l {
+		if err := enc(le, v); err != nil {
+			return err
+		}
+	}
+	return e.Bytes(buf.Bytes())
+}
+
+// Decode a list encoded by EncodeList, decoding each element with dec,
+// which must consume exactly the blobs of one element.
+// The elements are decoded with the same length limit and strictness
+// as d itself.
+func DecodeList[T any](d *Decoder, dec func(*Decoder) (T, error)) ([]T,
+	error) {
+
+	b, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	ld := NewDecoder(bytes.NewReader(b))
+	ld.max, ld.strict = d.max, d.strict
+	l := []T{}
+	for {
+		if _, _, err := ld.NextLen(); err == EOF {
+			return l, nil
+		} else if err != nil {
+			return nil, err
+		}
+		v, err := dec(ld)
+		if err != nil {
+			return nil, truncated(err)
+		}
+		l = append(l, v)
+	}
+}
@@ -0,0 +1,122 @@
+// Package cbechan adapts between Go channels and CBE blob streams,
+// for building concurrent pipelines that read or write encoded data.
+//
+// Each adapter runs a goroutine that stops when its input is exhausted,
+// an error occurs, or its context is canceled,
+// and then delivers its final result on an error channel,
+// which receives exactly one value (nil on success) and is then closed.
+// A goroutine blocked in a Read or Write call on the underlying stream
+// cannot notice cancellation until that call returns;
+// closing the stream unblocks it in that case.
+//
+// Pipe connects an Encoder and a Decoder in different goroutines
+// through an in-memory buffer of bounded size,
+// for pipelines that would otherwise use an unbounded bytes.Buffer.
+//
+// Early unstable prototype code.
+//
+package cbechan
+
+import (
+	"context"
+	"io"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Start a goroutine encoding each value received from in to w
+// as cbe.Marshal would, until in is closed or ctx is canceled.
+func Encode(ctx context.Context, w io.Writer, in <-chan interface{}) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		for {
+			select {
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			case v, ok := <-in:
+				if !ok {
+					errc <- nil
+					return
+				}
+				b, err := cbe.Marshal(v)
+				if err == nil {
+					_, err = w.Write(b)
+				}
+				if err != nil {
+					errc <- err
+					return
+				}
+			}
+		}
+	}()
+	return errc
+}
+
+// Start a goroutine decoding blobs from r and sending them on the returned
+// channel, which buffers up to buffer blobs not yet received.
+// The blob channel is closed when the input ends, an error occurs,
+// or ctx is canceled.
+// The error channel then receives nil if the input ended cleanly,
+// or the error otherwise.
+func Decode(ctx context.Context, r io.Reader, buffer int) (<-chan []byte, <-chan error) {
+	out := make(chan []byte, buffer)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		d := cbe.NewDecoder(r)
+		for {
+			b, err := d.Bytes()
+			if err == io.EOF {
+				errc <- nil
+				return
+			} else if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case out <- b:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// Start a goroutine decoding values from r using decode
+// and sending them on the returned channel,
+// as Decode does for blobs.
+// The decode function must return io.EOF at the clean end of the input,
+// as value.Decode does, for example.
+func DecodeFunc(ctx context.Context, r io.Reader, buffer int,
+	decode func(*cbe.Decoder) (interface{}, error)) (<-chan interface{}, <-chan error) {
+
+	out := make(chan interface{}, buffer)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		d := cbe.NewDecoder(r)
+		for {
+			v, err := decode(d)
+			if err == io.EOF {
+				errc <- nil
+				return
+			} else if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,158 @@
+package cbechan
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	pr, pw := io.Pipe()
+
+	in := make(chan interface{})
+	errc := Encode(ctx, pw, in)
+	go func() {
+		for _, v := range []interface{}{[]byte("a"), "bc", uint64(300)} {
+			in <- v
+		}
+		close(in)
+		pw.CloseWithError(<-errc)
+	}()
+
+	out, derrc := Decode(ctx, pr, 1)
+	var got [][]byte
+	for b := range out {
+		got = append(got, b)
+	}
+	if err := <-derrc; err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{[]byte("a"), []byte("bc"), {1, 44}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v blobs", len(got))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("blob %v: got %x, want %x", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDecodeFunc(t *testing.T) {
+	var buf bytes.Buffer
+	e := cbe.NewEncoder(&buf)
+	for _, v := range []value.Value{"x", int64(-2), nil} {
+		value.Encode(e, v)
+	}
+	out, errc := DecodeFunc(context.Background(), &buf, 0,
+		func(d *cbe.Decoder) (interface{}, error) { return value.Decode(d) })
+	n := 0
+	for range out {
+		n++
+	}
+	if err := <-errc; err != nil || n != 3 {
+		t.Errorf("decoded %v values, error %v", n, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// A decoder whose consumer stops reading must exit when canceled
+	data := bytes.Repeat([]byte{0x01}, 100)
+	out, errc := Decode(ctx, bytes.NewReader(data), 2)
+	<-out
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("canceled Decode gave %v", err)
+	}
+
+	// An encoder with no input must exit when canceled
+	if err := <-Encode(ctx, io.Discard, make(chan interface{})); err !=
+		context.Canceled {
+		t.Errorf("canceled Encode gave %v", err)
+	}
+
+	// Decoding errors are reported after the blob channel closes
+	out, errc = Decode(context.Background(), bytes.NewReader([]byte{0x85}), 0)
+	for range out {
+	}
+	if err := <-errc; err == nil {
+		t.Error("truncated input decoded without error")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	const credits, chunkLen = 4, 1000
+	pr, pw := Pipe(credits, chunkLen)
+
+	var blobs [][]byte
+	for i := 0; i < 50; i++ {
+		blobs = append(blobs, bytes.Repeat([]byte{byte(i)}, i*i*10))
+	}
+	go func() {
+		e := cbe.NewEncoder(pw)
+		for _, b := range blobs {
+			if err := e.Bytes(b); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	// Check the memory bound while slowly draining the pipe
+	var buf bytes.Buffer
+	p := make([]byte, 100)
+	for {
+		n, err := pr.Read(p)
+		buf.Write(p[:n])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		pr.p.mu.Lock()
+		held, nchunks := 0, len(pr.p.chunks)
+		for _, c := range pr.p.chunks {
+			held += len(c)
+		}
+		pr.p.mu.Unlock()
+		if nchunks > credits || held > credits*chunkLen {
+			t.Fatalf("pipe holds %v bytes", held)
+		}
+	}
+	d := cbe.NewDecoder(&buf)
+	for i, want := range blobs {
+		if got, err := d.Bytes(); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("blob %v: %v", i, err)
+		}
+	}
+
+	// Closing the reader unblocks a stalled writer
+	pr, pw = Pipe(1, 10)
+	done := make(chan error)
+	go func() {
+		_, err := pw.Write(make([]byte, 100))
+		done <- err
+	}()
+	pr.Read(make([]byte, 5))
+	pr.Close()
+	if err := <-done; err != io.ErrClosedPipe {
+		t.Errorf("stalled write gave %v", err)
+	}
+
+	// The writer's close error reaches the reader after buffered data
+	pr, pw = Pipe(0, 0)
+	pw.Write([]byte("x"))
+	pw.CloseWithError(io.ErrUnexpectedEOF)
+	if b, err := io.ReadAll(pr); string(b) != "x" ||
+		err != io.ErrUnexpectedEOF {
+		t.Errorf("read %q, %v", b, err)
+	}
+}
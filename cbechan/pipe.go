@@ -0,0 +1,175 @@
+package cbechan
+
+import (
+	"io"
+	"sync"
+)
+
+// Default number of chunk credits and chunk length of a Pipe.
+const (
+	DefaultPipeCredits  = 16
+	DefaultPipeChunkLen = 16 * 1024
+)
+
+// pipe is the state shared by the two ends of a Pipe.
+type pipe struct {
+	mu       sync.Mutex
+	cond     sync.Cond
+	credits  int      // maximum number of chunks buffered
+	chunkLen int      // maximum length of each chunk
+	chunks   [][]byte // buffered chunks, oldest first
+	roff     int      // read offset within chunks[0]
+	free     [][]byte // recycled chunk buffers
+	werr     error    // error for reads once the writer has closed
+	rerr     error    // error for writes once the reader has closed
+}
+
+// PipeReader is the reading end of a Pipe.
+type PipeReader struct{ p *pipe }
+
+// PipeWriter is the writing end of a Pipe.
+type PipeWriter struct{ p *pipe }
+
+// Create an in-memory pipe connecting a writer goroutine to a reader,
+// typically a cbe.Encoder writing to the PipeWriter
+// and a cbe.Decoder reading from the PipeReader.
+//
+// Unlike a bytes.Buffer, the pipe bounds the memory it uses:
+// written data occupies chunks of at most chunkLen bytes,
+// each consuming one of credits chunk credits,
+// which return to the writer as the reader consumes the chunks.
+// A Write blocks while all credits are in use,
+// applying backpressure to the writer,
+// so the pipe never buffers more than credits*chunkLen bytes.
+// Non-positive arguments select DefaultPipeCredits and DefaultPipeChunkLen.
+func Pipe(credits, chunkLen int) (*PipeReader, *PipeWriter) {
+	if credits <= 0 {
+		credits = DefaultPipeCredits
+	}
+	if chunkLen <= 0 {
+		chunkLen = DefaultPipeChunkLen
+	}
+	p := &pipe{credits: credits, chunkLen: chunkLen}
+	p.cond.L = &p.mu
+	return &PipeReader{p}, &PipeWriter{p}
+}
+
+// Write b into the pipe, blocking as needed until the reader frees credits.
+// Returns io.ErrClosedPipe or the reader's close error
+// if the reader has closed the pipe.
+func (w *PipeWriter) Write(b []byte) (n int, err error) {
+	p := w.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(b) > 0 {
+		if p.rerr != nil {
+			return n, p.rerr
+		}
+		if p.werr != nil {
+			return n, io.ErrClosedPipe
+		}
+
+		// Fill the newest chunk if it has room, else start a new one
+		last := len(p.chunks) - 1
+		if last < 0 || len(p.chunks[last]) == p.chunkLen {
+			if len(p.chunks) == p.credits {
+				p.cond.Wait()
+				continue
+			}
+			p.chunks = append(p.chunks, p.buffer())
+			last++
+		}
+		c := p.chunks[last]
+		l := copy(c[len(c):p.chunkLen], b)
+		p.chunks[last] = c[:len(c)+l]
+		b = b[l:]
+		n += l
+		p.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// Returns an empty chunk buffer, recycling one if possible.
+func (p *pipe) buffer() []byte {
+	if n := len(p.free); n > 0 {
+		c := p.free[n-1]
+		p.free = p.free[:n-1]
+		return c
+	}
+	return make([]byte, 0, p.chunkLen)
+}
+
+// Close the writing end of the pipe.
+// Once the reader has consumed all buffered data, its reads return io.EOF.
+func (w *PipeWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// Close the writing end of the pipe, so that once the reader
+// has consumed all buffered data, its reads return err,
+// or io.EOF if err is nil.
+func (w *PipeWriter) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+	p := w.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.werr == nil {
+		p.werr = err
+	}
+	p.cond.Broadcast()
+	return nil
+}
+
+// Read data from the pipe, blocking until data is available
+// or the writer closes the pipe.
+func (r *PipeReader) Read(b []byte) (n int, err error) {
+	p := r.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.chunks) == 0 {
+		if p.rerr != nil {
+			return 0, io.ErrClosedPipe
+		}
+		if p.werr != nil {
+			return 0, p.werr
+		}
+		p.cond.Wait()
+	}
+	c := p.chunks[0]
+	n = copy(b, c[p.roff:])
+	p.roff += n
+	if p.roff == len(c) { // chunk consumed: return its credit
+		p.free = append(p.free, c[:0])
+		p.chunks = p.chunks[1:]
+		p.roff = 0
+		p.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// Close the reading end of the pipe,
+// discarding buffered data and unblocking the writer,
+// whose writes then return io.ErrClosedPipe.
+func (r *PipeReader) Close() error {
+	return r.CloseWithError(nil)
+}
+
+// Close the reading end of the pipe,
+// so that the writer's writes return err,
+// or io.ErrClosedPipe if err is nil.
+func (r *PipeReader) CloseWithError(err error) error {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+	p := r.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rerr == nil {
+		p.rerr = err
+	}
+	p.chunks, p.free = nil, nil
+	p.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,146 @@
+// Package cbehttp helps HTTP clients and servers
+// exchange CBE-encoded request and response bodies.
+//
+// Early unstable prototype code.
+//
+package cbehttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/mediatype"
+)
+
+// Media type of CBE-encoded HTTP bodies.
+const ContentType = mediatype.CBE
+
+// Default maximum body length accepted by the decoding functions
+// when called with a maxLen of zero.
+const DefaultMaxLen = 1 << 20
+
+// Marshal v via cbe.Marshal and write it as an HTTP response body
+// with the given status code.
+func EncodeResponse(w http.ResponseWriter, status int, v interface{}) error {
+	b, err := cbe.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	h.Set("Content-Type", ContentType)
+	h.Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+// Write the response header with the given status code
+// and return an Encoder for streaming the response body.
+func NewResponseEncoder(w http.ResponseWriter, status int) *cbe.Encoder {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	return cbe.NewEncoder(w)
+}
+
+// Create a new HTTP request whose body contains v marshaled via cbe.Marshal.
+func NewRequest(method, url string, v interface{}) (*http.Request, error) {
+	b, err := cbe.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	return req, nil
+}
+
+// Decode a CBE request body of at most maxLen bytes into v
+// via cbe.Unmarshal.
+// A negative maxLen imposes no limit.
+// Returns ErrContentType if the request is not labeled as CBE,
+// and ErrTooLarge if the body is longer than maxLen.
+func DecodeRequest(r *http.Request, v interface{}, maxLen int64) error {
+	return decodeBody(r.Header, r.Body, v, maxLen)
+}
+
+// Decode a CBE response body of at most maxLen bytes into v
+// via cbe.Unmarshal, as for DecodeRequest.
+func DecodeResponse(resp *http.Response, v interface{}, maxLen int64) error {
+	return decodeBody(resp.Header, resp.Body, v, maxLen)
+}
+
+// Return a Decoder for streaming a CBE request body
+// that yields ErrTooLarge after reading more than maxLen bytes.
+// A negative maxLen imposes no limit.
+func NewRequestDecoder(r *http.Request, maxLen int64) (*cbe.Decoder, error) {
+	if err := checkType(r.Header); err != nil {
+		return nil, err
+	}
+	return cbe.NewDecoder(limitBody(r.Body, maxLen)), nil
+}
+
+func decodeBody(h http.Header, body io.Reader, v interface{},
+	maxLen int64) error {
+
+	if err := checkType(h); err != nil {
+		return err
+	}
+	b, err := io.ReadAll(limitBody(body, maxLen))
+	if err != nil {
+		return err
+	}
+	return cbe.Unmarshal(b, v)
+}
+
+// Check that an HTTP body is labeled with the CBE media type.
+func checkType(h http.Header) error {
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || mt != ContentType {
+		return ErrContentType
+	}
+	return nil
+}
+
+// Limit body to maxLen bytes, DefaultMaxLen if maxLen is zero,
+// or no limit if maxLen is negative.
+func limitBody(body io.Reader, maxLen int64) io.Reader {
+	switch {
+	case maxLen < 0:
+		return body
+	case maxLen == 0:
+		maxLen = DefaultMaxLen
+	}
+	return &limitReader{r: body, n: maxLen}
+}
+
+// limitReader reads from r but fails with ErrTooLarge
+// on attempting to read more than n bytes.
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		return 0, ErrTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// ErrContentType is returned when decoding a body not labeled as CBE.
+var ErrContentType = errors.New("body content type is not " + ContentType)
+
+// ErrTooLarge is returned when a body exceeds the maximum length allowed.
+var ErrTooLarge = errors.New("body too large")
@@ -0,0 +1,102 @@
+package cbehttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		var s string
+		if err := DecodeRequest(r, &s, 100); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		EncodeResponse(w, http.StatusOK, strings.ToUpper(s))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(h))
+	defer srv.Close()
+
+	// A small request should be echoed in upper case
+	req, err := NewRequest("POST", srv.URL, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := DecodeResponse(resp, &s, 0); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if s != "HELLO" {
+		t.Errorf("got response %q", s)
+	}
+
+	// An oversize request should be refused
+	req, _ = NewRequest("POST", srv.URL, strings.Repeat("x", 200))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("oversize request got status %v", resp.StatusCode)
+	}
+
+	// So should a request with the wrong content type
+	resp, err = http.Post(srv.URL, "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("mislabeled request got status %v", resp.StatusCode)
+	}
+}
+
+func TestMaxLen(t *testing.T) {
+	long := strings.Repeat("x", 2*DefaultMaxLen)
+	for _, c := range []struct {
+		maxLen int64
+		s      string
+		ok     bool
+	}{
+		{-1, long, true}, // negative means no limit
+		{-5, "hello", true},
+		{0, "hello", true},
+		{0, long, false}, // zero means DefaultMaxLen
+		{5, "hello", false},
+		{7, "hello", true},
+	} {
+		req, err := NewRequest("POST", "/", c.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s string
+		err = DecodeRequest(req, &s, c.maxLen)
+		if c.ok && (err != nil || s != c.s) {
+			t.Errorf("maxLen %v: got %v", c.maxLen, err)
+		} else if !c.ok && err != ErrTooLarge {
+			t.Errorf("maxLen %v: got %v, want ErrTooLarge",
+				c.maxLen, err)
+		}
+
+		req, _ = NewRequest("POST", "/", c.s)
+		dec, err := NewRequestDecoder(req, c.maxLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err = dec.String()
+		if c.ok && (err != nil || s != c.s) {
+			t.Errorf("maxLen %v: decoder got %v", c.maxLen, err)
+		} else if !c.ok && !errors.Is(err, ErrTooLarge) {
+			t.Errorf("maxLen %v: decoder got %v", c.maxLen, err)
+		}
+	}
+}
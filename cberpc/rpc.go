@@ -0,0 +1,324 @@
+// Package cberpc implements a minimal request/response RPC convention
+// over the blob-framed message connections of package wire,
+// so that small services can use CBE end to end without gRPC.
+//
+// A request consists of three consecutive messages:
+// a method-name blob, a request-ID blob containing an unsigned integer,
+// and a payload blob.
+// The server answers each request with three messages:
+// the request's ID, an error blob that is empty on success
+// or contains an error message on failure, and a result payload blob.
+// A client may have many requests outstanding on one connection,
+// and a server may answer them in any order.
+//
+// Early unstable prototype code.
+//
+package cberpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bford/cofo/wire"
+)
+
+// Default maximum number of calls a Server handles at once
+// on each connection.
+const DefaultMaxCalls = 64
+
+// Handler handles one request, returning a result payload or an error.
+// The context is canceled when the call times out
+// or the connection it arrived on closes.
+type Handler func(ctx context.Context, req []byte) ([]byte, error)
+
+// Server dispatches requests to handlers registered by method name.
+type Server struct {
+	Config  wire.Config   // configuration for connections accepted by Serve
+	Timeout time.Duration // maximum duration of each call, or 0 for none
+
+	// Maximum number of calls handled at once on each connection,
+	// or DefaultMaxCalls if not positive
+	MaxCalls int
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// Register h as the handler for method, replacing any existing handler.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[string]Handler)
+	}
+	s.handlers[method] = h
+}
+
+// Accept connections from l and serve requests on each
+// until l fails or is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(s.Config.NewConn(nc))
+	}
+}
+
+// Serve requests arriving on c until it fails or is closed,
+// handling each request in its own goroutine.
+// Stops reading requests while MaxCalls calls are in progress,
+// so a client cannot queue unbounded work.
+// Closes c before returning.
+func (s *Server) ServeConn(c *wire.Conn) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wmu sync.Mutex // serializes responses
+	defer cancel()
+	defer closeConn(c, &wmu)
+
+	limit := s.MaxCalls
+	if limit <= 0 {
+		limit = DefaultMaxCalls
+	}
+	sem := make(chan struct{}, limit) // one token per call in progress
+	for {
+		sem <- struct{}{}
+		method, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		id, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		req, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer func() { <-sem }()
+			res, err := s.call(ctx, string(method), req)
+			wmu.Lock()
+			defer wmu.Unlock()
+			if err := writeResponse(c, id, res, err); err != nil {
+				c.Close() // the client cannot get a response
+			}
+		}()
+	}
+}
+
+// Invoke the handler for method.
+func (s *Server) call(ctx context.Context, method string, req []byte) ([]byte,
+	error) {
+
+	s.mu.RLock()
+	h := s.handlers[method]
+	s.mu.RUnlock()
+	if h == nil {
+		return nil, errors.New("unknown method " + method)
+	}
+	if s.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return h(ctx, req)
+}
+
+func writeResponse(c *wire.Conn, id, res []byte, err error) error {
+	var msg []byte
+	if err != nil {
+		res = nil
+		if msg = []byte(err.Error()); len(msg) == 0 {
+			msg = []byte("error")
+		}
+	}
+	for _, m := range [][]byte{id, msg, res} {
+		if err := c.WriteMessage(m); err != nil {
+			return err
+		}
+	}
+	return c.Flush()
+}
+
+// Close c once no writer holds wmu,
+// first closing the underlying connection to unblock any writer.
+func closeConn(c *wire.Conn, wmu *sync.Mutex) error {
+	err := c.NetConn().Close()
+	wmu.Lock()
+	defer wmu.Unlock()
+	c.Close() // discards anything left buffered
+	return err
+}
+
+// Client issues requests over one connection to a server.
+// A Client is safe for concurrent use.
+type Client struct {
+	c   *wire.Conn
+	wmu sync.Mutex // serializes requests
+
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan response
+	err     error // sticky error once the connection fails
+}
+
+type response struct {
+	res []byte
+	err error
+}
+
+// Create a Client issuing requests over c,
+// starting a goroutine that receives responses until c fails or is closed.
+func NewClient(c *wire.Conn) *Client {
+	cl := &Client{c: c, pending: make(map[uint64]chan response)}
+	go cl.readLoop()
+	return cl
+}
+
+// Connect to the server at address addr on the named network
+// and return a Client for it.
+// A nil cfg selects the default configuration, as for Server.
+func Dial(ctx context.Context, network, addr string, cfg *wire.Config) (
+	*Client, error) {
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &wire.Config{}
+	}
+	return NewClient(cfg.NewConn(nc)), nil
+}
+
+// Call method with payload req and wait for the result,
+// or until ctx is done.
+// Errors reported by the server are of type *RemoteError.
+func (cl *Client) Call(ctx context.Context, method string, req []byte) (
+	[]byte, error) {
+
+	ch := make(chan response, 1)
+	cl.mu.Lock()
+	if cl.err != nil {
+		cl.mu.Unlock()
+		return nil, cl.err
+	}
+	cl.next++
+	id := cl.next
+	cl.pending[id] = ch
+	cl.mu.Unlock()
+
+	if err := cl.send(method, id, req); err != nil {
+		cl.fail(err)
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return r.res, r.err
+	case <-ctx.Done():
+		cl.mu.Lock()
+		delete(cl.pending, id) // discard any late response
+		cl.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (cl *Client) send(method string, id uint64, req []byte) error {
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	for _, m := range [][]byte{[]byte(method), idBytes(id), req} {
+		if err := cl.c.WriteMessage(m); err != nil {
+			return err
+		}
+	}
+	return cl.c.Flush()
+}
+
+// Receive responses and deliver them to the waiting calls.
+func (cl *Client) readLoop() {
+	for {
+		var msgs [3][]byte
+		for i := range msgs {
+			var err error
+			if msgs[i], err = cl.c.ReadMessage(); err != nil {
+				cl.fail(err)
+				return
+			}
+		}
+		id, ok := idValue(msgs[0])
+		if !ok {
+			cl.fail(errID)
+			return
+		}
+		r := response{res: msgs[2]}
+		if len(msgs[1]) > 0 {
+			r.res, r.err = nil, &RemoteError{string(msgs[1])}
+		}
+		cl.mu.Lock()
+		ch := cl.pending[id]
+		delete(cl.pending, id)
+		cl.mu.Unlock()
+		if ch != nil {
+			ch <- r
+		}
+	}
+}
+
+// Fail all pending and future calls with err.
+func (cl *Client) fail(err error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.err == nil {
+		cl.err = err
+	}
+	for id, ch := range cl.pending {
+		ch <- response{err: cl.err}
+		delete(cl.pending, id)
+	}
+}
+
+// Close the client's connection, failing any outstanding calls.
+func (cl *Client) Close() error {
+	cl.fail(ErrClosed)
+	return closeConn(cl.c, &cl.wmu)
+}
+
+// Returns the minimal big-endian encoding of request ID id.
+func idBytes(id uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, id)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+func idValue(b []byte) (uint64, bool) {
+	if len(b) > 8 {
+		return 0, false
+	}
+	var b8 [8]byte
+	copy(b8[8-len(b):], b)
+	return binary.BigEndian.Uint64(b8[:]), true
+}
+
+// RemoteError is an error reported by the server handling a call.
+type RemoteError struct {
+	Msg string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Msg
+}
+
+// ErrClosed is returned by calls on a closed Client.
+var ErrClosed = errors.New("rpc client closed")
+
+var errID = errors.New("invalid request ID in response")
@@ -0,0 +1,168 @@
+package cberpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bford/cofo/wire"
+)
+
+func newPair(t *testing.T, s *Server) *Client {
+	a, b := net.Pipe()
+	var cfg wire.Config
+	go s.ServeConn(cfg.NewConn(b))
+	cl := NewClient(cfg.NewConn(a))
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func TestCall(t *testing.T) {
+	s := &Server{}
+	s.Handle("echo", func(ctx context.Context, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	s.Handle("fail", func(ctx context.Context, req []byte) ([]byte, error) {
+		return nil, errors.New("failed: " + string(req))
+	})
+	s.Handle("slow", func(ctx context.Context, req []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cl := newPair(t, s)
+	ctx := context.Background()
+
+	// Many concurrent calls, completing in any order
+	var wg sync.WaitGroup
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := []byte(strconv.Itoa(i))
+			res, err := cl.Call(ctx, "echo", req)
+			if err != nil || !bytes.Equal(res, req) {
+				t.Errorf("echo %d: got %q, %v", i, res, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var re *RemoteError
+	if _, err := cl.Call(ctx, "fail", []byte("x")); !errors.As(err, &re) ||
+		re.Msg != "failed: x" {
+		t.Errorf("fail: got %v", err)
+	}
+	if _, err := cl.Call(ctx, "nosuch", nil); !errors.As(err, &re) {
+		t.Errorf("unknown method: got %v", err)
+	}
+
+	// A client deadline abandons the call but not the connection
+	dctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := cl.Call(dctx, "slow", nil); err != context.DeadlineExceeded {
+		t.Errorf("slow: got %v", err)
+	}
+	if res, err := cl.Call(ctx, "echo", []byte{}); err != nil || len(res) != 0 {
+		t.Errorf("echo after timeout: got %q, %v", res, err)
+	}
+
+	cl.Close()
+	if _, err := cl.Call(ctx, "echo", nil); err != ErrClosed {
+		t.Errorf("call after close: got %v", err)
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	s := &Server{Timeout: 10 * time.Millisecond}
+	s.Handle("slow", func(ctx context.Context, req []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cl := newPair(t, s)
+	_, err := cl.Call(context.Background(), "slow", nil)
+	var re *RemoteError
+	if !errors.As(err, &re) || re.Msg != context.DeadlineExceeded.Error() {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	s := &Server{}
+	s.Handle("len", func(ctx context.Context, req []byte) ([]byte, error) {
+		return []byte(strconv.Itoa(len(req))), nil
+	})
+	go s.Serve(l)
+
+	ctx := context.Background()
+	for _, cfg := range []*wire.Config{{}, nil} {
+		cl, err := Dial(ctx, "tcp", l.Addr().String(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := cl.Call(ctx, "len", make([]byte, 100000))
+		if err != nil || string(res) != "100000" {
+			t.Errorf("config %v: got %q, %v", cfg, res, err)
+		}
+		cl.Close()
+	}
+}
+
+func TestID(t *testing.T) {
+	for _, id := range []uint64{0, 1, 255, 256, 1 << 63} {
+		b := idBytes(id)
+		if got, ok := idValue(b); !ok || got != id {
+			t.Errorf("%d: got %d via %x", id, got, b)
+		}
+	}
+	if _, ok := idValue(make([]byte, 9)); ok {
+		t.Error("accepted a 9-byte ID")
+	}
+}
+
+func TestMaxCalls(t *testing.T) {
+	s := &Server{MaxCalls: 2}
+	var mu sync.Mutex
+	active, peak := 0, 0
+	release := make(chan struct{})
+	s.Handle("wait", func(ctx context.Context, req []byte) ([]byte, error) {
+		mu.Lock()
+		if active++; active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return req, nil
+	})
+	cl := newPair(t, s)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cl.Call(context.Background(), "wait",
+				nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // let the server read what it will
+	close(release)
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("%d calls ran at once, want 2", peak)
+	}
+}
@@ -0,0 +1,279 @@
+// Package cbeslog implements a log/slog Handler
+// that writes structured log records as canonical CBE-encoded values,
+// a compact binary alternative to JSON logs,
+// together with a Decoder for reading such logs back.
+//
+// Each log record is encoded as one value of package value:
+// a map with the following keys.
+//
+//	"time"   the record's time as int64 nanoseconds since the Unix epoch,
+//	         omitted if the time is zero
+//	"level"  the record's level as an int64
+//	"msg"    the message string
+//	"source" the "file:line" of the log call, only if AddSource is set
+//	"attrs"  a map of the record's attributes, omitted if empty
+//
+// Attribute groups become nested maps,
+// and empty groups are omitted.
+// Attribute values are mapped to values as follows:
+// strings, booleans, and numbers map directly,
+// durations to int64 nanoseconds, times to int64 nanoseconds since the epoch,
+// byte slices to byte strings, errors to their message strings,
+// and anything else to its fmt representation.
+// When an attribute key is repeated, the last value wins.
+//
+// Early unstable prototype code.
+//
+package cbeslog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Handler is a slog.Handler writing records as CBE-encoded values.
+// It is safe for concurrent use.
+type Handler struct {
+	opts   slog.HandlerOptions
+	attrs  value.Map // attributes from WithAttrs
+	groups []string  // groups opened by WithGroup
+
+	mu *sync.Mutex // shared by handlers derived from the same root
+	w  io.Writer
+}
+
+// Create a Handler writing to w with options opts,
+// which may be nil for the defaults.
+// The ReplaceAttr option is applied to attributes,
+// but not to the fixed record fields.
+func NewHandler(w io.Writer, opts *slog.HandlerOptions) *Handler {
+	h := &Handler{mu: new(sync.Mutex), w: w}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.opts.Level != nil {
+		min = h.opts.Level.Level()
+	}
+	return level >= min
+}
+
+func (h *Handler) WithAttrs(as []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = h.insert(h.attrs, h.groups, 0, as)
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &h2
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	m := value.Map{
+		{Key: "level", Value: int64(r.Level)},
+		{Key: "msg", Value: r.Message},
+	}
+	if !r.Time.IsZero() {
+		m = append(m, value.Pair{Key: "time", Value: r.Time.UnixNano()})
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		m = append(m, value.Pair{Key: "source",
+			Value: fmt.Sprintf("%s:%d", f.File, f.Line)})
+	}
+	var as []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		as = append(as, a)
+		return true
+	})
+	if attrs := h.insert(h.attrs, h.groups, 0, as); len(attrs) > 0 {
+		m = append(m, value.Pair{Key: "attrs", Value: attrs})
+	}
+
+	b, err := value.Marshal(m)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.w.Write(b)
+	return err
+}
+
+// Returns a copy of m with the attributes as added
+// within the nested group path groups[depth:],
+// leaving m unmodified.
+// ReplaceAttr sees the full group path.
+func (h *Handler) insert(m value.Map, groups []string, depth int,
+	as []slog.Attr) value.Map {
+
+	if depth < len(groups) {
+		sub, _ := get(m, groups[depth])
+		subm, _ := sub.(value.Map)
+		subm = h.insert(subm, groups, depth+1, as)
+		if len(subm) == 0 {
+			return m
+		}
+		return set(m, groups[depth], subm)
+	}
+	for _, a := range as {
+		m = h.add(m, groups, a)
+	}
+	return m
+}
+
+// Returns a copy of m with attribute a added.
+func (h *Handler) add(m value.Map, groups []string, a slog.Attr) value.Map {
+	if a.Value.Kind() != slog.KindGroup && h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+	}
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return m
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		gs := a.Value.Group()
+		if a.Key == "" { // inline the group's attributes
+			for _, ga := range gs {
+				m = h.add(m, groups, ga)
+			}
+			return m
+		}
+		sub, _ := get(m, a.Key)
+		subm, _ := sub.(value.Map)
+		for _, ga := range gs {
+			subm = h.add(subm, append(groups[:len(groups):len(groups)],
+				a.Key), ga)
+		}
+		if len(subm) == 0 {
+			return m
+		}
+		return set(m, a.Key, subm)
+	}
+	return set(m, a.Key, attrValue(a.Value))
+}
+
+// Convert a resolved slog value to a value.
+func attrValue(v slog.Value) value.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindInt64:
+		return v.Int64()
+	case slog.KindUint64:
+		return v.Uint64()
+	case slog.KindFloat64:
+		return v.Float64()
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindDuration:
+		return int64(v.Duration())
+	case slog.KindTime:
+		return v.Time().UnixNano()
+	}
+	switch a := v.Any().(type) {
+	case []byte:
+		return a
+	case error:
+		return a.Error()
+	}
+	return fmt.Sprint(v.Any())
+}
+
+// Look up string key k in m.
+func get(m value.Map, k string) (value.Value, bool) {
+	for _, p := range m {
+		if p.Key == k {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Returns a copy of m with key k set to v.
+func set(m value.Map, k string, v value.Value) value.Map {
+	n := make(value.Map, 0, len(m)+1)
+	for _, p := range m {
+		if p.Key != k {
+			n = append(n, p)
+		}
+	}
+	return append(n, value.Pair{Key: k, Value: v})
+}
+
+// Record is one decoded log record.
+type Record struct {
+	Time    time.Time // zero if the record had no time
+	Level   slog.Level
+	Message string
+	Source  string    // "file:line", or empty
+	Attrs   value.Map // attributes, with groups as nested maps
+}
+
+// Decoder reads log records written by a Handler.
+type Decoder struct {
+	d *cbe.Decoder
+}
+
+// Create a Decoder reading log records from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{cbe.NewDecoder(r)}
+}
+
+// Decode the next log record, returning io.EOF at the end of the log.
+func (d *Decoder) Decode() (Record, error) {
+	v, err := value.Decode(d.d)
+	if err != nil {
+		return Record{}, err
+	}
+	m, ok := v.(value.Map)
+	if !ok {
+		return Record{}, errRecord
+	}
+	var r Record
+	for _, p := range m {
+		ok := false
+		switch p.Key {
+		case "time":
+			var ns int64
+			ns, ok = p.Value.(int64)
+			r.Time = time.Unix(0, ns)
+		case "level":
+			var l int64
+			l, ok = p.Value.(int64)
+			r.Level = slog.Level(l)
+		case "msg":
+			r.Message, ok = p.Value.(string)
+		case "source":
+			r.Source, ok = p.Value.(string)
+		case "attrs":
+			r.Attrs, ok = p.Value.(value.Map)
+		default:
+			ok = true // ignore unknown fields for future extension
+		}
+		if !ok {
+			return Record{}, errRecord
+		}
+	}
+	return r, nil
+}
+
+var errRecord = errors.New("malformed log record")
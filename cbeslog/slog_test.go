@@ -0,0 +1,123 @@
+package cbeslog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"testing"
+	"testing/slogtest"
+	"time"
+
+	"github.com/bford/cofo/value"
+)
+
+func TestSlogtest(t *testing.T) {
+	var buf bytes.Buffer
+	slogtest.Run(t, func(*testing.T) slog.Handler {
+		buf.Reset()
+		return NewHandler(&buf, nil)
+	}, func(t *testing.T) map[string]any {
+		r, err := NewDecoder(&buf).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := toMap(r.Attrs)
+		if !r.Time.IsZero() {
+			m[slog.TimeKey] = r.Time
+		}
+		m[slog.LevelKey] = r.Level
+		m[slog.MessageKey] = r.Message
+		return m
+	})
+}
+
+// Convert a decoded attribute map to the form slogtest expects.
+func toMap(attrs value.Map) map[string]any {
+	m := map[string]any{}
+	for _, p := range attrs {
+		if sub, ok := p.Value.(value.Map); ok {
+			m[p.Key.(string)] = toMap(sub)
+		} else {
+			m[p.Key.(string)] = p.Value
+		}
+	}
+	return m
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(NewHandler(&buf, &slog.HandlerOptions{
+		Level:     slog.LevelDebug,
+		AddSource: true,
+	}))
+	now := time.Unix(1700000000, 123)
+	l.Debug("first", "n", 1, "n", 2, "d", time.Second, "at", now,
+		"u", uint64(1)<<63, "err", errors.New("oops"), "b", []byte{1})
+	l.With("k", "v").WithGroup("g").Warn("second", "x", 1.5)
+
+	d := NewDecoder(&buf)
+	r, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Level != slog.LevelDebug || r.Message != "first" ||
+		r.Time.IsZero() || !bytes.Contains([]byte(r.Source), []byte(".go:")) {
+		t.Errorf("bad first record %+v", r)
+	}
+	want := value.Map{
+		{Key: "n", Value: int64(2)},
+		{Key: "d", Value: int64(time.Second)},
+		{Key: "at", Value: now.UnixNano()},
+		{Key: "u", Value: new(big.Int).Lsh(big.NewInt(1), 63)},
+		{Key: "err", Value: "oops"},
+		{Key: "b", Value: []byte{1}},
+	}
+	if !value.Equal(r.Attrs, want) {
+		t.Errorf("first record attrs %v", r.Attrs)
+	}
+
+	r, err = d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = value.Map{
+		{Key: "k", Value: "v"},
+		{Key: "g", Value: value.Map{{Key: "x", Value: 1.5}}},
+	}
+	if r.Level != slog.LevelWarn || !value.Equal(r.Attrs, want) {
+		t.Errorf("bad second record %+v", r)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReplaceAttrGroups(t *testing.T) {
+	var seen [][]string
+	h := NewHandler(io.Discard, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			seen = append(seen, append([]string{}, groups...))
+			return a
+		},
+	})
+	l := slog.New(h).WithGroup("g")
+	l.Info("msg", "a", 1)
+	l.WithGroup("h").Info("msg", slog.Group("i", "b", 2))
+
+	want := [][]string{{"g"}, {"g", "h", "i"}}
+	if len(seen) != len(want) {
+		t.Fatalf("ReplaceAttr saw groups %q", seen)
+	}
+	for i := range want {
+		if len(seen[i]) != len(want[i]) {
+			t.Fatalf("ReplaceAttr saw groups %q", seen)
+		}
+		for j := range want[i] {
+			if seen[i][j] != want[i][j] {
+				t.Errorf("ReplaceAttr saw groups %q", seen)
+			}
+		}
+	}
+}
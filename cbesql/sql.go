@@ -0,0 +1,58 @@
+// Package cbesql stores Go values as CBE blobs in database BLOB columns,
+// via wrapper types implementing database/sql's Scanner
+// and database/sql/driver's Valuer interfaces.
+//
+// Values are marshaled and unmarshaled via cbe.Marshal and cbe.Unmarshal,
+// so stored types should implement cbe.Marshaler and cbe.Unmarshaler,
+// or be among the basic types those functions support.
+// For example:
+//
+//	db.Exec("INSERT INTO t (id, data) VALUES (?, ?)", id, cbesql.Blob{V: v})
+//	...
+//	err := row.Scan(&cbesql.Blob{V: &v})
+//
+// Early unstable prototype code.
+//
+package cbesql
+
+import (
+	"database/sql/driver"
+	"errors"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Blob wraps a Go value to be stored in or loaded from a BLOB column.
+type Blob struct {
+	V    interface{} // value to store, or pointer to value to load
+	Null bool        // set by Scan when the column is NULL
+}
+
+// Returns the CBE encoding of b.V for storage in the database,
+// or NULL if b.V is nil.
+func (b Blob) Value() (driver.Value, error) {
+	if b.V == nil {
+		return nil, nil
+	}
+	return cbe.Marshal(b.V)
+}
+
+// Decode a CBE blob read from the database into the value b.V points to.
+// If the column is NULL, sets b.Null and leaves b.V unmodified.
+func (b *Blob) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		b.Null = true
+		return nil
+	case []byte:
+		b.Null = false
+		return cbe.Unmarshal(src, b.V)
+	case string:
+		b.Null = false
+		return cbe.Unmarshal([]byte(src), b.V)
+	default:
+		return errType
+	}
+}
+
+var errType = errors.New("cannot scan non-blob column as CBE")
@@ -0,0 +1,40 @@
+package cbesql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+var _ driver.Valuer = Blob{}
+var _ sql.Scanner = &Blob{}
+
+func TestBlob(t *testing.T) {
+	dv, err := Blob{V: "hello"}.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !driver.IsValue(dv) {
+		t.Fatalf("invalid driver value %v", dv)
+	}
+
+	var s string
+	b := Blob{V: &s}
+	if err := b.Scan(dv); err != nil {
+		t.Fatal(err)
+	}
+	if s != "hello" || b.Null {
+		t.Errorf("scanned %q null %v", s, b.Null)
+	}
+
+	// NULL values
+	if dv, err := (Blob{}).Value(); dv != nil || err != nil {
+		t.Errorf("nil value stored as %v, %v", dv, err)
+	}
+	if err := b.Scan(nil); err != nil || !b.Null || s != "hello" {
+		t.Errorf("scanning NULL gave %q null %v err %v", s, b.Null, err)
+	}
+	if err := b.Scan(int64(1)); err == nil {
+		t.Error("scanned integer column as blob")
+	}
+}
@@ -0,0 +1,132 @@
+//go:build js && wasm
+
+// Command cofo-wasm exposes the composable formats to JavaScript
+// when compiled for WebAssembly:
+//
+//	GOOS=js GOARCH=wasm go build -o cofo.wasm ./cmd/cofo-wasm
+//
+// Once loaded via Go's wasm_exec.js support script,
+// it defines a global object "cofo" with the following functions:
+//
+//	cbeEncode(content: Uint8Array) -> Uint8Array
+//	cbeDecode(blob: Uint8Array) -> {content: Uint8Array, rest: Uint8Array}
+//	ctsDecode(input: string, brackets?: string)
+//		-> {head, open, tail, close, rest: string}
+//	criFrom(form: "uri"|"iri"|"cri", ri: string, lazy?: boolean) -> string
+//
+// On failure, each function instead returns an object
+// whose "error" property holds a message describing the error.
+//
+package main
+
+import (
+	"io"
+	"strings"
+
+	"syscall/js"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/cri"
+	"github.com/bford/cofo/cts"
+)
+
+func main() {
+	js.Global().Set("cofo", js.ValueOf(map[string]interface{}{
+		"cbeEncode": js.FuncOf(cbeEncode),
+		"cbeDecode": js.FuncOf(cbeDecode),
+		"ctsDecode": js.FuncOf(ctsDecode),
+		"criFrom":   js.FuncOf(criFrom),
+	}))
+	select {} // keep the functions available until the page goes away
+}
+
+// Returns a JavaScript object describing an error.
+func jsError(msg string) interface{} {
+	return map[string]interface{}{"error": msg}
+}
+
+// Copy a JavaScript Uint8Array into a Go byte slice.
+func bytesFromJS(v js.Value) []byte {
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b
+}
+
+// Copy a Go byte slice into a new JavaScript Uint8Array.
+func bytesToJS(b []byte) js.Value {
+	v := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(v, b)
+	return v
+}
+
+func cbeEncode(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return jsError("cbeEncode: missing content argument")
+	}
+	return bytesToJS(cbe.Encode(nil, bytesFromJS(args[0])))
+}
+
+func cbeDecode(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return jsError("cbeDecode: missing blob argument")
+	}
+	content, rest, err := cbe.Decode(bytesFromJS(args[0]))
+	if err != nil {
+		return jsError(err.Error())
+	}
+	return map[string]interface{}{
+		"content": bytesToJS(content),
+		"rest":    bytesToJS(rest),
+	}
+}
+
+func ctsDecode(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return jsError("ctsDecode: missing input argument")
+	}
+	c := cts.Config{}
+	if len(args) > 1 && args[1].Type() == js.TypeString {
+		c.Brackets = cts.Brackets(args[1].String())
+	}
+	d := c.NewDecoder(strings.NewReader(args[0].String()))
+	head, open, tail, close, err := d.Decode()
+	if err != nil {
+		return jsError(err.Error())
+	}
+	var rest strings.Builder
+	if _, err := io.Copy(&rest, d.Buffered()); err != nil {
+		return jsError(err.Error())
+	}
+	return map[string]interface{}{
+		"head":  head,
+		"open":  string(open),
+		"tail":  tail,
+		"close": string(close),
+		"rest":  rest.String(),
+	}
+}
+
+func criFrom(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return jsError("criFrom: missing arguments")
+	}
+	var f cri.Form
+	switch args[0].String() {
+	case "uri":
+		f = *cri.URI
+	case "iri":
+		f = *cri.IRI
+	case "cri":
+		f = *cri.CRI
+	default:
+		return jsError("criFrom: unknown form " + args[0].String())
+	}
+	if len(args) > 2 {
+		f.Lazy = args[2].Truthy()
+	}
+	ri, err := f.From(args[1].String())
+	if err != nil {
+		return jsError(err.Error())
+	}
+	return ri
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Compare two encoded streams record by record.
+func runDiff(args []string) error {
+	fs := newFlagSet("diff", "[-raw] old new")
+	raw := fs.Bool("raw", false,
+		"compare plain blobs instead of encoded values")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	// Use the value model unless either stream does not conform to it
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if !*raw {
+		cs, err := value.DiffStreams(
+			cbe.NewDecoder(bytes.NewReader(a)),
+			cbe.NewDecoder(bytes.NewReader(b)))
+		if err == nil {
+			w.WriteString(value.FormatChanges(cs))
+			return exitIfChanged(w, len(cs))
+		}
+	}
+	n, err := diffBlobs(w, a, b)
+	if err != nil {
+		return err
+	}
+	return exitIfChanged(w, n)
+}
+
+// Compare two streams of plain blobs, reporting each differing blob.
+func diffBlobs(w io.Writer, a, b []byte) (n int, err error) {
+	da := cbe.NewDecoder(bytes.NewReader(a))
+	db := cbe.NewDecoder(bytes.NewReader(b))
+	for rec := 0; ; rec++ {
+		ba, erra := da.Bytes()
+		bb, errb := db.Bytes()
+		if erra != nil && erra != io.EOF {
+			return n, erra
+		}
+		if errb != nil && errb != io.EOF {
+			return n, errb
+		}
+		switch {
+		case erra == io.EOF && errb == io.EOF:
+			return n, nil
+		case errb == io.EOF:
+			fmt.Fprintf(w, "blob %d: removed: %d bytes\n",
+				rec, len(ba))
+		case erra == io.EOF:
+			fmt.Fprintf(w, "blob %d: added: %d bytes\n",
+				rec, len(bb))
+		case !bytes.Equal(ba, bb):
+			i := 0
+			for i < len(ba) && i < len(bb) && ba[i] == bb[i] {
+				i++
+			}
+			fmt.Fprintf(w, "blob %d: changed: %d bytes -> %d bytes, "+
+				"first difference at offset %d\n",
+				rec, len(ba), len(bb), i)
+		default:
+			continue
+		}
+		n++
+	}
+}
+
+// Exit with status 1 if there were any differences, like diff(1).
+func exitIfChanged(w *bufio.Writer, n int) error {
+	if n == 0 {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	os.Exit(1)
+	return errors.New("unreachable")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Print an annotated structural hexdump of encoded files.
+func runDump(args []string) error {
+	fs := newFlagSet("dump", "[-flat] [file ...]")
+	flat := fs.Bool("flat", false, "do not look for blobs nested in content")
+	fs.Parse(args)
+
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	dump := cbe.Dump
+	if *flat {
+		dump = cbe.DumpFlat
+	}
+	for _, name := range names {
+		var r io.Reader = os.Stdin
+		if name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		if len(names) > 1 {
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+		if err := dump(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Search for a pattern within the contents of the blobs in encoded streams.
+func runGrep(args []string) error {
+	fs := newFlagSet("grep", "[-x | -e] [-c] pattern [file ...]")
+	isHex := fs.Bool("x", false, "pattern is a hexadecimal byte string")
+	isRegexp := fs.Bool("e", false,
+		"pattern is a regular expression matched within each whole blob")
+	count := fs.Bool("c", false, "print only a count of matching blobs")
+	fs.Parse(args)
+	if fs.NArg() < 1 || (*isHex && *isRegexp) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// Build a function searching one blob for the pattern
+	pat := []byte(fs.Arg(0))
+	var search func(d *cbe.Decoder) ([]int64, error)
+	switch {
+	case *isRegexp:
+		re, err := regexp.Compile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		search = func(d *cbe.Decoder) ([]int64, error) {
+			b, err := d.Bytes()
+			var offs []int64
+			for _, loc := range re.FindAllIndex(b, -1) {
+				offs = append(offs, int64(loc[0]))
+			}
+			return offs, err
+		}
+	case *isHex:
+		var err error
+		if pat, err = hex.DecodeString(fs.Arg(0)); err != nil {
+			return err
+		}
+		fallthrough
+	default:
+		if len(pat) == 0 {
+			return fmt.Errorf("empty pattern")
+		}
+		search = func(d *cbe.Decoder) ([]int64, error) {
+			m := &matcher{pat: pat}
+			_, err := d.WriteTo(m)
+			return m.offs, err
+		}
+	}
+
+	names := fs.Args()[1:]
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	found := false
+	for _, name := range names {
+		f := os.Stdin
+		if name != "-" {
+			var err error
+			if f, err = os.Open(name); err != nil {
+				return err
+			}
+		}
+		n, err := grepStream(w, name, cbe.NewDecoder(f), search, *count)
+		if f != os.Stdin {
+			f.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		found = found || n > 0
+	}
+	if !found {
+		w.Flush()
+		os.Exit(1)
+	}
+	return nil
+}
+
+// Search each blob in a stream, printing the matches,
+// and return the number of blobs that matched.
+func grepStream(w io.Writer, name string, d *cbe.Decoder,
+	search func(*cbe.Decoder) ([]int64, error), count bool) (int, error) {
+
+	n := 0
+	for blob := 0; ; blob++ {
+		offs, err := search(d)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+		if len(offs) > 0 {
+			n++
+		}
+		for _, off := range offs {
+			if !count {
+				fmt.Fprintf(w, "%s: blob %d: offset %d\n",
+					name, blob, off)
+			}
+		}
+	}
+	if count {
+		fmt.Fprintf(w, "%s: %d\n", name, n)
+	}
+	return n, nil
+}
+
+// A matcher finds all occurrences of a pattern
+// in content written to it in arbitrary pieces,
+// including occurrences spanning the boundaries between pieces,
+// such as between the chunks of a large blob.
+type matcher struct {
+	pat  []byte
+	tail []byte  // last len(pat)-1 bytes written
+	pos  int64   // total bytes written
+	offs []int64 // offsets of matches found
+}
+
+func (m *matcher) Write(p []byte) (int, error) {
+	buf := append(m.tail, p...)
+	base := m.pos - int64(len(m.tail)) // offset of buf[0]
+	for i := 0; ; {
+		j := bytes.Index(buf[i:], m.pat)
+		if j < 0 {
+			break
+		}
+		m.offs = append(m.offs, base+int64(i+j))
+		i += j + 1
+	}
+
+	// Keep just enough of the end to complete a spanning match
+	keep := len(m.pat) - 1
+	if keep > len(buf) {
+		keep = len(buf)
+	}
+	m.tail = append(m.tail[:0], buf[len(buf)-keep:]...)
+	m.pos += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+func TestGrepSpansChunks(t *testing.T) {
+	// Place matches straddling each chunk boundary of a large blob
+	data := bytes.Repeat([]byte{'.'}, 3*cbe.MinChunkLen)
+	var want []int64
+	for i := 1; i <= 2; i++ {
+		off := i*cbe.MinChunkLen - 2
+		copy(data[off:], "needle")
+		want = append(want, int64(off))
+	}
+	copy(data[100:], "needleedle") // overlapping candidates
+	want = append([]int64{100}, want...)
+
+	var buf bytes.Buffer
+	e := cbe.NewEncoder(&buf)
+	e.Bytes([]byte("no match"))
+	e.Bytes(data)
+
+	var out bytes.Buffer
+	m := func(d *cbe.Decoder) ([]int64, error) {
+		mt := &matcher{pat: []byte("needle")}
+		_, err := d.WriteTo(mt)
+		return mt.offs, err
+	}
+	n, err := grepStream(&out, "f", cbe.NewDecoder(&buf), m, false)
+	if err != nil || n != 1 {
+		t.Fatalf("grep gave %v, %v", n, err)
+	}
+	var exp bytes.Buffer
+	for _, off := range want {
+		fmt.Fprintf(&exp, "f: blob 1: offset %d\n", off)
+	}
+	if out.String() != exp.String() {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), exp.String())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Convert between CBE-encoded values and JSON.
+func runJSON(args []string) error {
+	fs := newFlagSet("json", "[-r] [-o file] [file]")
+	reverse := fs.Bool("r", false, "convert JSON to CBE instead of CBE to JSON")
+	indent := fs.Bool("i", false, "indent JSON output")
+	out := fs.String("o", "", "write output to `file` instead of stdout")
+	fs.Parse(args)
+
+	in := io.Reader(os.Stdin)
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	f, err := createOutput(*out)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	if *reverse {
+		err = jsonToCBE(w, in)
+	} else {
+		err = cbeToJSON(w, in, *indent)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil && f != os.Stdout {
+		err = f.Close()
+	}
+	return err
+}
+
+// Convert a stream of JSON values to a stream of CBE-encoded values.
+func jsonToCBE(w io.Writer, r io.Reader) error {
+	dec := value.NewJSONDecoder(r)
+	enc := cbe.NewEncoder(w)
+	for {
+		v, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := value.Encode(enc, v); err != nil {
+			return err
+		}
+	}
+}
+
+// Convert a stream of CBE-encoded values to JSON values, one per line.
+func cbeToJSON(w io.Writer, r io.Reader, indent bool) error {
+	dec := cbe.NewDecoder(r)
+	for {
+		v, err := value.Decode(dec)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		j, err := value.MarshalJSON(v)
+		if err != nil {
+			return err
+		}
+		if indent {
+			var buf bytes.Buffer
+			json.Indent(&buf, j, "", "\t")
+			j = buf.Bytes()
+		}
+		if _, err := w.Write(append(j, '\n')); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/conv"
+)
+
+// Convert between CBE streams and JSON Lines, one line per top-level item.
+func runJSONL(args []string) error {
+	fs := newFlagSet("jsonl", "[-r] [-raw] [-o file] [file]")
+	reverse := fs.Bool("r", false,
+		"convert JSON Lines to CBE instead of CBE to JSON Lines")
+	raw := fs.Bool("raw", false,
+		"map each blob to a base64 string instead of decoding values")
+	out := fs.String("o", "", "write output to `file` instead of stdout")
+	fs.Parse(args)
+
+	in := io.Reader(os.Stdin)
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	f, err := createOutput(*out)
+	if err != nil {
+		return err
+	}
+
+	if *reverse {
+		e := cbe.NewEncoder(f)
+		err = conv.JSONLinesToCBE(e, in, *raw)
+	} else {
+		err = conv.CBEToJSONLines(f, cbe.NewDecoder(in), *raw)
+	}
+	if err == nil && f != os.Stdout {
+		err = f.Close()
+	}
+	return err
+}
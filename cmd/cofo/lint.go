@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Scan encoded files for non-canonical constructs.
+func runLint(args []string) error {
+	fs := newFlagSet("lint", "[-value] [file ...]")
+	values := fs.Bool("value", false,
+		"also check that records are canonically encoded values")
+	fs.Parse(args)
+
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	problems := 0
+	for _, name := range names {
+		var b []byte
+		var err error
+		if name == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		problems += lintBlobs(w, name, b)
+		if *values {
+			problems += lintValues(w, name, b)
+		}
+	}
+	if problems > 0 {
+		w.Flush()
+		os.Exit(1)
+	}
+	return nil
+}
+
+// Check the chunking of every blob in b,
+// reporting each problem and returning the number found.
+// A blob is canonically chunked if it consists of a single chunk
+// when its content fits in one, and otherwise
+// of maximum-size partial chunks followed by a non-empty final chunk.
+func lintBlobs(w io.Writer, name string, b []byte) (problems int) {
+	report := func(off int, format string, args ...interface{}) {
+		fmt.Fprintf(w, "%s: offset %d: %s\n", name, off,
+			fmt.Sprintf(format, args...))
+		problems++
+	}
+
+	for off := 0; off < len(b); {
+		start := off
+		chunks, total := 0, 0
+		for {
+			hlen, n, part := chunkHeader(b[off:])
+			if hlen < 0 || off+hlen+n > len(b) {
+				report(off, "truncated blob")
+				return problems
+			}
+			if part && n != cbe.MaxChunkLen {
+				report(off, "undersized partial chunk of %d bytes", n)
+			}
+			if !part && n == 0 && chunks > 0 {
+				report(off, "empty final chunk")
+			}
+			chunks++
+			total += n
+			off += hlen + n
+			if !part {
+				break
+			}
+		}
+		if chunks > 1 && total <= cbe.MaxChunkLen {
+			report(start, "%d-byte blob split into %d chunks "+
+				"but fits in one", total, chunks)
+		}
+	}
+	return problems
+}
+
+// Decode the chunk header at the start of b,
+// returning the header and content lengths and whether it is partial,
+// or a negative header length if b is too short.
+func chunkHeader(b []byte) (hlen, n int, part bool) {
+	switch {
+	case len(b) < 1:
+		return -1, 0, false
+	case b[0] < 0x80:
+		return 0, 1, false
+	case b[0] != 0x81 && b[0] < 0xc0:
+		return 1, int(b[0] - 0x80), false
+	case len(b) < 2:
+		return -1, 0, false
+	case b[0] == 0x81 && b[1] >= 0x80:
+		return 1, 1, false
+	case b[0] >= 0xc0:
+		return 2, 64 + int(b[0]&0x3f)<<8 + int(b[1]), false
+	case len(b) < 4:
+		return -1, 0, false
+	}
+	return 4, 16448 + int(b[1]&0x3f)<<16 + int(b[2])<<8 + int(b[3]),
+		b[1] >= 0x40
+}
+
+// Check that b is a sequence of canonically encoded values,
+// reporting the first problem and returning the number found.
+func lintValues(w io.Writer, name string, b []byte) int {
+	for rest := b; len(rest) > 0; {
+		off := len(b) - len(rest)
+		_, r, err := value.Parse(rest)
+		if err != nil {
+			fmt.Fprintf(w, "%s: offset %d: %v\n", name, off, err)
+			return 1
+		}
+		rest = r
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+func TestLint(t *testing.T) {
+	var buf bytes.Buffer
+	e := cbe.NewEncoder(&buf)
+	e.Bytes([]byte("fine"))
+	e.ReadFrom(bytes.NewReader(bytes.Repeat([]byte("x"), 2*cbe.MinChunkLen)))
+	buf.Write([]byte{0x85, 'a'}) // truncated
+
+	var out bytes.Buffer
+	if n := lintBlobs(&out, "f", buf.Bytes()); n != 5 {
+		t.Errorf("found %v problems:\n%s", n, out.String())
+	}
+	want := []string{
+		"f: offset 5: undersized partial chunk of 16448 bytes",
+		"f: offset 16457: undersized partial chunk of 16448 bytes",
+		"f: offset 32909: empty final chunk",
+		"f: offset 5: 32896-byte blob split into 3 chunks but fits in one",
+		"f: offset 32910: truncated blob",
+	}
+	for _, w := range want {
+		if !strings.Contains(out.String(), w+"\n") {
+			t.Errorf("missing %q in:\n%s", w, out.String())
+		}
+	}
+
+	// Canonical encodings produce no complaints
+	out.Reset()
+	canon := cbe.Encode(nil, bytes.Repeat([]byte("y"), cbe.MaxChunkLen+10))
+	canon = cbe.Encode(canon, []byte("small"))
+	if n := lintBlobs(&out, "f", canon); n != 0 {
+		t.Errorf("canonical input gave:\n%s", out.String())
+	}
+
+	// Non-minimal integers in values are flagged
+	out.Reset()
+	if n := lintValues(&out, "f", []byte{'i', 0x82, 0, 1}); n != 1 ||
+		!strings.Contains(out.String(), "non-canonical") {
+		t.Errorf("lintValues gave %v:\n%s", n, out.String())
+	}
+}
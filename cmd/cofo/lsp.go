@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/bford/cofo/coerr"
+	"github.com/bford/cofo/cri"
+	"github.com/bford/cofo/cts"
+)
+
+// Serve the Language Server Protocol over standard input and output,
+// for editing CTS text and CRI lists.
+func runLSP(args []string) error {
+	fs := newFlagSet("lsp", "[-brackets pairs]")
+	brackets := fs.String("brackets", "",
+		"sensitive CTS bracket pairs (default \"[]\")")
+	fs.Parse(args)
+	if len([]rune(*brackets))%2 != 0 {
+		return errBrackets
+	}
+	s := newLSPServer(os.Stdout, *brackets)
+	return s.serve(os.Stdin)
+}
+
+// An lspServer holds the open documents of one LSP session.
+//
+// Documents whose language is "cri", or whose names end in ".cri",
+// are lists of CRIs, one per line;
+// all others are CTS text.
+// The server publishes diagnostics from CTS and CRI validation,
+// formats documents by reparsing CTS or converting CRIs to canonical form,
+// highlights the bracket matching the one at the cursor,
+// and describes the nesting of bracketed elements on hover.
+type lspServer struct {
+	w      io.Writer
+	config *cts.Config
+	docs   map[string]*lspDoc
+}
+
+func newLSPServer(w io.Writer, brackets string) *lspServer {
+	if brackets == "" {
+		brackets = string(cts.SquareBrackets)
+	}
+	return &lspServer{w: w,
+		config: &cts.Config{Brackets: cts.Brackets(brackets)},
+		docs:   make(map[string]*lspDoc)}
+}
+
+// A JSON-RPC message, which may be a request, notification, or response.
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // in UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// Parameters common to requests about a position in a document.
+type lspDocParams struct {
+	TextDocument struct {
+		URI        string `json:"uri"`
+		LanguageID string `json:"languageId"`
+		Text       string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+}
+
+// Serve LSP requests read from r until an exit notification or EOF.
+// Fails on a framing error,
+// but answers a message that is not valid JSON with a parse error
+// and continues.
+func (s *lspServer) serve(r io.Reader) error {
+	tr := textproto.NewReader(bufio.NewReader(r))
+	for {
+		body, err := readLSPBody(tr)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg := &lspMessage{}
+		if err := json.Unmarshal(body, msg); err != nil {
+			null := json.RawMessage("null")
+			err = s.send(&lspMessage{JSONRPC: "2.0", ID: &null,
+				Error: &lspError{-32700, err.Error()}})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			continue // notifications get no response
+		}
+		res := &lspMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+		if err == errMethod {
+			res.Error = &lspError{-32601, err.Error()}
+		} else if err != nil {
+			res.Error = &lspError{-32603, err.Error()}
+		} else if result == nil {
+			res.Result = json.RawMessage("null")
+		}
+		if err := s.send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// Maximum length of a message body, matching the serve command's default.
+const maxLSPMessage = 64 << 20
+
+// Read the body of one message with its Content-Length header,
+// refusing bodies longer than maxLSPMessage before allocating them.
+func readLSPBody(tr *textproto.Reader) ([]byte, error) {
+	h, err := tr.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(h) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, errContentLength
+	} else if n > maxLSPMessage {
+		return nil, errMessageLong
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(tr.R, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Write one message with its Content-Length header.
+func (s *lspServer) send(msg *lspMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+// Handle a request or notification, returning the result for a request.
+func (s *lspServer) handle(msg *lspMessage) (interface{}, error) {
+	var p lspDocParams
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+	}
+	uri := p.TextDocument.URI
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // full content
+				"documentFormattingProvider": true,
+				"documentHighlightProvider":  true,
+				"hoverProvider":              true,
+			},
+			"serverInfo": map[string]string{"name": "cofo"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		lang := p.TextDocument.LanguageID
+		if lang != "cri" && strings.HasSuffix(uri, ".cri") {
+			lang = "cri"
+		}
+		s.docs[uri] = &lspDoc{cri: lang == "cri"}
+		return nil, s.update(uri, p.TextDocument.Text)
+	case "textDocument/didChange":
+		if s.docs[uri] == nil || len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(uri, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return nil, s.publish(uri, []lspDiagnostic{})
+	case "textDocument/formatting":
+		if d := s.docs[uri]; d != nil {
+			return s.format(d)
+		}
+		return nil, nil
+	case "textDocument/documentHighlight":
+		if d := s.docs[uri]; d != nil {
+			return s.highlight(d, d.offset(p.Position)), nil
+		}
+		return nil, nil
+	case "textDocument/hover":
+		if d := s.docs[uri]; d != nil {
+			return s.hover(d, d.offset(p.Position)), nil
+		}
+		return nil, nil
+	}
+	if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+		return nil, errMethod
+	}
+	return nil, nil // ignore other notifications
+}
+
+// Replace the text of document uri and publish its diagnostics.
+func (s *lspServer) update(uri, text string) error {
+	d := s.docs[uri]
+	d.setText(text)
+	return s.publish(uri, s.diagnose(d))
+}
+
+func (s *lspServer) publish(uri string, diags []lspDiagnostic) error {
+	params, err := json.Marshal(map[string]interface{}{
+		"uri": uri, "diagnostics": diags})
+	if err != nil {
+		return err
+	}
+	return s.send(&lspMessage{JSONRPC: "2.0",
+		Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// Returns diagnostics for the problems in document d.
+func (s *lspServer) diagnose(d *lspDoc) []lspDiagnostic {
+	diags := []lspDiagnostic{}
+	report := func(off int, err error) {
+		msg := err.Error()
+		var ce *coerr.Error
+		if errors.As(err, &ce) && ce.Detail != "" {
+			msg = ce.Detail
+		}
+		diags = append(diags, lspDiagnostic{Range: d.runeRange(off),
+			Severity: 1, Source: "cofo", Message: msg})
+	}
+
+	if !d.cri {
+		s.diagnoseCTS(d.text, 0, s.config, report)
+		return diags
+	}
+	sq := &cts.Config{Brackets: cts.SquareBrackets}
+	for i, line := range strings.Split(d.text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		base := d.lines[i]
+		if err := cri.CRI.Check(line); err != nil {
+			report(base+int(errOffset(err)), err)
+		}
+		s.diagnoseCTS(line, base, sq, report)
+	}
+	return diags
+}
+
+// Report the CTS syntax errors in text, which starts at offset base,
+// continuing past each to find the rest.
+func (s *lspServer) diagnoseCTS(text string, base int, c *cts.Config,
+	report func(int, error)) {
+
+	tc := *c
+	tc.HandleError = func(err error) error {
+		report(base+int(errOffset(err)), err)
+		return nil
+	}
+	_, err := tc.Parse(strings.NewReader(text))
+	if errors.Is(err, coerr.Truncated) {
+		// Point at the innermost unclosed opener rather than the end
+		m := s.match(text, c.Brackets)
+		off := len(text)
+		if len(m.unclosed) > 0 {
+			off = m.unclosed[len(m.unclosed)-1]
+		}
+		report(base+off, errUnclosed)
+	} else if err != nil {
+		report(base+int(errOffset(err)), err)
+	}
+}
+
+// Returns the input offset recorded in err, or 0 if none.
+func errOffset(err error) int64 {
+	var ce *coerr.Error
+	if errors.As(err, &ce) && ce.Offset >= 0 {
+		return ce.Offset
+	}
+	return 0
+}
+
+// Format document d, returning edits replacing its whole text,
+// or no edits if it is already formatted.
+// CTS text is rechecked and copied without building a tree,
+// which changes nothing in valid text
+// because all whitespace is significant, but fails on invalid text.
+// CRIs are converted to canonical CRI form.
+func (s *lspServer) format(d *lspDoc) (interface{}, error) {
+	var sb strings.Builder
+	if d.cri {
+		lines := strings.SplitAfter(d.text, "\n")
+		for _, line := range lines {
+			ri := strings.TrimRight(line, "\r\n")
+			if ri != "" {
+				var err error
+				if ri, err = cri.CRI.From(ri); err != nil {
+					return nil, err
+				}
+			}
+			sb.WriteString(ri + line[len(strings.TrimRight(line, "\r\n")):])
+		}
+	} else {
+		err := s.config.Copy(&sb, strings.NewReader(d.text))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if sb.String() == d.text {
+		return []interface{}{}, nil
+	}
+	all := lspRange{End: d.position(len(d.text))}
+	return []interface{}{map[string]interface{}{
+		"range": all, "newText": sb.String()}}, nil
+}
+
+// Returns highlights for the bracket at or just before offset off
+// and its match, or nil if there is none.
+func (s *lspServer) highlight(d *lspDoc, off int) interface{} {
+	m := s.match(d.text, s.brackets(d))
+	for _, o := range []int{off, off - 1} {
+		if other, ok := m.pairs[o]; ok {
+			return []interface{}{
+				map[string]interface{}{"range": d.runeRange(o)},
+				map[string]interface{}{"range": d.runeRange(other)},
+			}
+		}
+	}
+	return nil
+}
+
+// Returns hover information describing the bracketed elements
+// enclosing offset off, or nil if there are none.
+// Each element is named by the identifier immediately preceding
+// its open bracket, as in the "name" of "name[...]".
+func (s *lspServer) hover(d *lspDoc, off int) interface{} {
+	m := s.match(d.text, s.brackets(d))
+	var path []string
+	for _, o := range m.opens {
+		c, ok := m.pairs[o]
+		if o < off && (!ok || off <= c) {
+			name := elementName(d.text[:o])
+			if name == "" {
+				name = "(anonymous)"
+			}
+			path = append(path, "`"+name+"`")
+		}
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"contents": map[string]string{
+		"kind": "markdown",
+		"value": strings.Join(path, " › ") +
+			fmt.Sprintf("\n\nnesting depth %d", len(path)),
+	}}
+}
+
+// Returns the identifier at the end of s.
+func elementName(s string) string {
+	i := strings.LastIndexFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) &&
+			!strings.ContainsRune("_-.", r)
+	})
+	return s[i+1:]
+}
+
+// Returns the sensitive brackets of document d.
+func (s *lspServer) brackets(d *lspDoc) cts.Brackets {
+	if d.cri {
+		return cts.SquareBrackets
+	}
+	return s.config.Brackets
+}
+
+// The result of matching the brackets in a text.
+type bracketMatch struct {
+	pairs    map[int]int // offsets of matched brackets to their partners
+	opens    []int       // offsets of all open brackets, in order
+	unclosed []int       // offsets of open brackets never closed
+}
+
+// Match the sensitive brackets in text,
+// ignoring unexpected or mismatched closers as the tolerant parser does.
+func (s *lspServer) match(text string, b cts.Brackets) *bracketMatch {
+	closer := make(map[rune]rune)
+	isClose := make(map[rune]bool)
+	r := []rune(b)
+	for i := 0; i+1 < len(r); i += 2 {
+		closer[r[i]] = r[i+1]
+		isClose[r[i+1]] = true
+	}
+	m := &bracketMatch{pairs: make(map[int]int)}
+	var stack []int
+	for off, c := range text {
+		if _, ok := closer[c]; ok {
+			stack = append(stack, off)
+			m.opens = append(m.opens, off)
+		} else if isClose[c] && len(stack) > 0 {
+			top := stack[len(stack)-1]
+			open, _ := utf8.DecodeRuneInString(text[top:])
+			if closer[open] == c {
+				m.pairs[top], m.pairs[off] = off, top
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	m.unclosed = stack
+	return m
+}
+
+// An lspDoc is one open document.
+type lspDoc struct {
+	cri   bool   // a CRI list rather than CTS text
+	text  string // current content
+	lines []int  // byte offset of the start of each line
+}
+
+func (d *lspDoc) setText(text string) {
+	d.text = text
+	d.lines = []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+}
+
+// Convert byte offset off to an LSP position.
+func (d *lspDoc) position(off int) lspPosition {
+	line := 0
+	for line+1 < len(d.lines) && d.lines[line+1] <= off {
+		line++
+	}
+	ch := 0
+	for _, r := range d.text[d.lines[line]:off] {
+		ch += len(utf16.Encode([]rune{r}))
+	}
+	return lspPosition{Line: line, Character: ch}
+}
+
+// Convert an LSP position to a byte offset, clamped to the document.
+func (d *lspDoc) offset(p lspPosition) int {
+	if p.Line < 0 {
+		return 0
+	} else if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	start, ch := d.lines[p.Line], 0
+	for i, r := range d.text[start:] {
+		if ch >= p.Character || r == '\n' {
+			return start + i
+		}
+		ch += len(utf16.Encode([]rune{r}))
+	}
+	return len(d.text)
+}
+
+// Returns the range of the character at byte offset off.
+func (d *lspDoc) runeRange(off int) lspRange {
+	_, size := utf8.DecodeRuneInString(d.text[off:])
+	return lspRange{d.position(off), d.position(off + size)}
+}
+
+var errMethod = errors.New("method not supported")
+var errContentLength = errors.New("missing or invalid Content-Length")
+var errMessageLong = errors.New("message exceeds maximum length")
+var errUnclosed = errors.New("unclosed bracket")
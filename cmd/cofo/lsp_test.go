@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestLSP(t *testing.T) {
+	var in bytes.Buffer
+	id := 0
+	send := func(method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method,
+			"params": params}
+		if !strings.HasPrefix(method, "textDocument/did") &&
+			method != "initialized" && method != "exit" {
+			id++
+			msg["id"] = id
+		}
+		b, _ := json.Marshal(msg)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	}
+	doc := func(uri string) map[string]interface{} {
+		return map[string]interface{}{"uri": uri}
+	}
+	at := func(uri string, line, ch int) map[string]interface{} {
+		return map[string]interface{}{"textDocument": doc(uri),
+			"position": map[string]int{"line": line, "character": ch}}
+	}
+
+	send("initialize", map[string]interface{}{}) // 1
+	send("initialized", map[string]interface{}{})
+	open := func(uri, lang, text string) {
+		send("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]string{
+				"uri": uri, "languageId": lang, "text": text}})
+	}
+	open("a.cts", "cts", "héllo]\ngreet[name[x]]\n")
+	send("textDocument/hover", at("a.cts", 1, 12))            // 2
+	send("textDocument/documentHighlight", at("a.cts", 1, 5)) // 3
+	send("textDocument/formatting", map[string]interface{}{
+		"textDocument": doc("a.cts")}) // 4: fails on the stray closer
+	send("textDocument/didChange", map[string]interface{}{
+		"textDocument":   doc("a.cts"),
+		"contentChanges": []map[string]string{{"text": "a[b"}}})
+	open("l.cri", "", "http://example.com/x\n")
+	send("textDocument/formatting", map[string]interface{}{
+		"textDocument": doc("l.cri")}) // 5
+	send("nosuch", nil)   // 6
+	send("shutdown", nil) // 7
+	send("exit", nil)
+
+	var out bytes.Buffer
+	if err := newLSPServer(&out, "").serve(&in); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	tr := textproto.NewReader(bufio.NewReader(&out))
+	for {
+		h, err := tr.ReadMIMEHeader()
+		if err != nil {
+			break
+		}
+		var n int
+		fmt.Sscan(h.Get("Content-Length"), &n)
+		body := make([]byte, n)
+		io.ReadFull(tr.R, body)
+		msgs = append(msgs, string(body))
+	}
+	got := func(i int) string { return msgs[i] }
+	rng := func(line, start, end int) string {
+		return fmt.Sprintf(`"range":{"start":{"line":%d,"character":%d},`+
+			`"end":{"line":%d,"character":%d}}`, line, start, line, end)
+	}
+	if len(msgs) != 10 {
+		t.Fatalf("got %d messages:\n%s", len(msgs), strings.Join(msgs, "\n"))
+	}
+	for i, want := range []string{
+		`"hoverProvider":true`,
+		// the stray closer is the sixth UTF-16 unit of the line
+		rng(0, 5, 6) + `,"severity":1,"source":"cofo",` +
+			`"message":"unexpected closer"`,
+		"`greet` › `name`",
+		`[{` + rng(1, 5, 6) + `},{` + rng(1, 13, 14) + `}]`,
+		`"error":{"code":-32603`,
+		rng(0, 1, 2) + `,"severity":1,"source":"cofo",` +
+			`"message":"unclosed bracket"`,
+		`"diagnostics":[]`,
+		`"newText":"http[//example.com/x]\n"`,
+		`"error":{"code":-32601`,
+		`"result":null`,
+	} {
+		if !strings.Contains(got(i), want) {
+			t.Errorf("message %d: want %s in\n%s", i, want, got(i))
+		}
+	}
+}
+
+func TestLSPHostile(t *testing.T) {
+	// An over-long Content-Length is refused before allocating the body
+	in := strings.NewReader("Content-Length: 1000000000000\r\n\r\n{}")
+	var out bytes.Buffer
+	if err := newLSPServer(&out, "").serve(in); err != errMessageLong {
+		t.Errorf("over-long message gave %v", err)
+	}
+
+	// A body that is not JSON gets a parse error without ending the session
+	req := `{"jsonrpc":"2.0","id":1,"method":"nosuch"}`
+	in = strings.NewReader(fmt.Sprintf(
+		"Content-Length: 5\r\n\r\n{bad}Content-Length: %d\r\n\r\n%s",
+		len(req), req))
+	out.Reset()
+	if err := newLSPServer(&out, "").serve(in); err != nil {
+		t.Errorf("malformed message gave %v", err)
+	}
+	for _, want := range []string{`"id":null,"error":{"code":-32700`,
+		`"id":1,"error":{"code":-32601`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want %s in\n%s", want, out.String())
+		}
+	}
+
+	// Formatting deeply nested text neither overflows the stack
+	// nor changes the text
+	text := strings.Repeat("[", 1<<20) + strings.Repeat("]", 1<<20)
+	s := newLSPServer(&out, "")
+	res, err := s.format(&lspDoc{text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edits, ok := res.([]interface{}); !ok || len(edits) != 0 {
+		t.Errorf("deep text formatted to %v", res)
+	}
+}
@@ -0,0 +1,94 @@
+// Command cofo provides command-line tools
+// for working with the composable formats in this repository.
+//
+// Usage:
+//
+//	cofo <command> [arguments]
+//
+// The commands are:
+//
+//	vectors    generate boundary-case test vectors in JSON
+//	json       convert between CBE-encoded values and JSON
+//	jsonl      convert between CBE streams and JSON Lines
+//	diff       compare two encoded streams record by record
+//	grep       search for patterns within blob contents
+//	lint       flag non-canonical encodings
+//	dump       print an annotated structural hexdump
+//	serve      serve format conversions over HTTP
+//	lsp        serve the Language Server Protocol for CTS and CRI
+//
+// Run "cofo <command> -h" for help on a particular command.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// A command is one cofo subcommand.
+type command struct {
+	name  string
+	short string // one-line description
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"vectors", "generate boundary-case test vectors in JSON", runVectors},
+	{"json", "convert between CBE-encoded values and JSON", runJSON},
+	{"jsonl", "convert between CBE streams and JSON Lines", runJSONL},
+	{"diff", "compare two encoded streams record by record", runDiff},
+	{"grep", "search for patterns within blob contents", runGrep},
+	{"lint", "flag non-canonical encodings", runLint},
+	{"dump", "print an annotated structural hexdump", runDump},
+	{"serve", "serve format conversions over HTTP", runServe},
+	{"lsp", "serve the Language Server Protocol for CTS and CRI", runLSP},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: cofo <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "The commands are:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-10s %s\n", c.name, c.short)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(args); err != nil {
+				fmt.Fprintf(os.Stderr, "cofo %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "cofo: unknown command %q\n", name)
+	usage()
+}
+
+// Create a FlagSet for a subcommand with a usage message.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: cofo %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Create the named output file, or return standard output if name is "".
+func createOutput(name string) (*os.File, error) {
+	if name == "" {
+		return os.Stdout, nil
+	}
+	return os.Create(name)
+}
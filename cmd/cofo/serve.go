@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bford/cofo/cri"
+	"github.com/bford/cofo/cts"
+	"github.com/bford/cofo/mediatype"
+)
+
+// Serve format conversions over HTTP.
+//
+// Each endpoint accepts a POST request and streams the converted body
+// back in the response:
+//
+//	/cbe/json     CBE-encoded values to JSON, one value per line
+//	/json/cbe     a stream of JSON values to CBE-encoded values
+//	/uri/cri      URIs, one per line, to CRIs
+//	/cri/uri      CRIs, one per line, to URIs
+//	/cts/validate check CTS text, responding with an error if malformed
+//	/cts/format   check CTS text and copy it back if well-formed
+//
+// The CTS endpoints accept a brackets query parameter
+// listing the sensitive bracket pairs,
+// and stream the text rather than building its element tree,
+// so that deep nesting costs only a small stack per open element.
+// Errors detected before any output is written produce an error status;
+// errors detected later abort the response.
+func runServe(args []string) error {
+	fs := newFlagSet("serve", "[-addr host:port] [-max bytes]")
+	addr := fs.String("addr", "localhost:8080", "listen on `address`")
+	maxLen := fs.Int64("max", 64<<20, "maximum request body length in bytes")
+	fs.Parse(args)
+
+	fmt.Fprintf(os.Stderr, "cofo serve: listening on %s\n", *addr)
+	return http.ListenAndServe(*addr, newServeMux(*maxLen))
+}
+
+// Create the handler for the conversion endpoints.
+func newServeMux(maxLen int64) *http.ServeMux {
+	mux := http.NewServeMux()
+	handle := func(path, typ string, conv func(io.Writer, *http.Request) error) {
+		mux.Handle(path, convHandler(maxLen, typ, conv))
+	}
+	handle("/cbe/json", "application/jsonl",
+		func(w io.Writer, r *http.Request) error {
+			return cbeToJSON(w, r.Body, false)
+		})
+	handle("/json/cbe", mediatype.Value,
+		func(w io.Writer, r *http.Request) error {
+			return jsonToCBE(w, r.Body)
+		})
+	handle("/uri/cri", mediatype.CRI,
+		func(w io.Writer, r *http.Request) error {
+			return convertLines(w, r.Body, cri.CRI)
+		})
+	handle("/cri/uri", "text/uri-list",
+		func(w io.Writer, r *http.Request) error {
+			return convertLines(w, r.Body, cri.URI)
+		})
+	handle("/cts/validate", mediatype.Text,
+		func(w io.Writer, r *http.Request) error {
+			c, err := ctsConfig(r)
+			if err != nil {
+				return err
+			}
+			if err := c.Copy(io.Discard, r.Body); err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, "ok\n")
+			return err
+		})
+	handle("/cts/format", mediatype.CTS,
+		func(w io.Writer, r *http.Request) error {
+			c, err := ctsConfig(r)
+			if err != nil {
+				return err
+			}
+			return c.Copy(w, r.Body)
+		})
+	return mux
+}
+
+// Returns the CTS configuration requested by r's query parameters.
+func ctsConfig(r *http.Request) (*cts.Config, error) {
+	b := r.URL.Query().Get("brackets") // not FormValue, which eats the body
+	if len([]rune(b))%2 != 0 {
+		return nil, errBrackets
+	}
+	return &cts.Config{Brackets: cts.Brackets(b)}, nil
+}
+
+// Convert resource identifiers read one per line from r to form f.
+func convertLines(w io.Writer, r io.Reader, f *cri.Form) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			ri, err := f.From(line)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, ri+"\n"); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Returns a handler that streams the request body through conv,
+// labeling the response with content type typ.
+func convHandler(maxLen int64, typ string,
+	conv func(io.Writer, *http.Request) error) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxLen)
+		sw := &startWriter{w: w, typ: typ}
+		err := conv(sw, r)
+		switch {
+		case err == nil:
+			sw.start()
+		case !sw.started:
+			status := http.StatusBadRequest
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+		default:
+			panic(http.ErrAbortHandler) // too late to report the error
+		}
+	})
+}
+
+// startWriter writes the response header just before the first output.
+type startWriter struct {
+	w       http.ResponseWriter
+	typ     string
+	started bool
+}
+
+func (sw *startWriter) start() {
+	if !sw.started {
+		sw.w.Header().Set("Content-Type", sw.typ)
+		sw.w.WriteHeader(http.StatusOK)
+		sw.started = true
+	}
+}
+
+func (sw *startWriter) Write(p []byte) (int, error) {
+	sw.start()
+	return sw.w.Write(p)
+}
+
+var errBrackets = errors.New("brackets parameter must have an even length")
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bford/cofo/value"
+)
+
+func TestServe(t *testing.T) {
+	srv := httptest.NewServer(newServeMux(1 << 10))
+	defer srv.Close()
+
+	post := func(path, ctype string, body []byte) (int, string) {
+		resp, err := http.Post(srv.URL+path, ctype,
+			bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	deep := strings.Repeat("[", 500) + strings.Repeat("]", 500)
+	enc, _ := value.Marshal(value.Map{{Key: "a", Value: int64(1)}})
+	for _, c := range []struct {
+		path, body string
+		status     int
+		want       string
+	}{
+		{"/cbe/json", string(enc), 200, "{\"a\":1}\n"},
+		{"/json/cbe", "{\"a\":1}", 200, string(enc)},
+		{"/json/cbe", "{\"a\":", 400, ""},
+		{"/cri/uri", "http[//example.com/x]\n", 200, "http://example.com/x\n"},
+		{"/uri/cri", "http://example.com/x\r\n", 200, "http[//example.com/x]\n"},
+		{"/cts/validate", "a[b]c", 200, "ok\n"},
+		{"/cts/validate", "a[b", 400, ""},
+		{"/cts/format?brackets=()[]", "a(b[c])", 200, "a(b[c])"},
+		{"/cts/format?brackets=(", "x", 400, ""},
+		{"/cts/format", "a]b", 400, ""},
+		{"/cts/format", deep, 200, deep},
+		{"/cts/validate", strings.Repeat("[", 1000), 400, ""},
+		{"/json/cbe", strings.Repeat(" ", 2<<10), 413, ""},
+	} {
+		status, got := post(c.path, "application/octet-stream",
+			[]byte(c.body))
+		if status != c.status || (c.status == 200 && got != c.want) {
+			t.Errorf("%s %q gave %v %q", c.path, c.body, status, got)
+		}
+	}
+
+	// CTS bodies labeled as form data, as curl -d sends them,
+	// are still read as CTS text
+	form := "application/x-www-form-urlencoded"
+	if status, got := post("/cts/format?brackets=()", form,
+		[]byte("a=(b)")); status != 200 || got != "a=(b)" {
+		t.Errorf("form-labeled format gave %v %q", status, got)
+	}
+	status, _ := post("/cts/validate", form, []byte("a=[b"))
+	if status != 400 {
+		t.Errorf("form-labeled validate gave %v", status)
+	}
+
+	resp, err := http.Get(srv.URL + "/cbe/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET gave %v", resp.StatusCode)
+	}
+}
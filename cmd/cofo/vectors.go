@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+
+	"github.com/bford/cofo/testvectors"
+)
+
+// Generate boundary-case test vectors for non-Go implementations.
+func runVectors(args []string) error {
+	fs := newFlagSet("vectors", "[-o file]")
+	out := fs.String("o", "", "write vectors to `file` instead of stdout")
+	fs.Parse(args)
+
+	f, err := createOutput(*out)
+	if err != nil {
+		return err
+	}
+	if err := testvectors.Generate().Write(f); err != nil {
+		return err
+	}
+	if f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
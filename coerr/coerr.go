@@ -0,0 +1,105 @@
+// Package coerr defines the error kinds shared by the composable format codecs,
+// so that callers can handle errors from any of them uniformly.
+//
+// Errors produced by the codecs are of type *Error,
+// which records the kind of error, the component that detected it,
+// and where known, the byte offset in the input at which it occurred.
+// Test for a particular kind of error with errors.Is, as in:
+//
+//	if errors.Is(err, coerr.Truncated) { ... }
+//
+// or obtain the details with errors.As:
+//
+//	var ce *coerr.Error
+//	if errors.As(err, &ce) { ... ce.Offset ... }
+//
+// Early unstable prototype code.
+//
+package coerr
+
+import (
+	"strconv"
+)
+
+// Kind classifies an error.
+// Each Kind is itself an error, for use as the target of errors.Is.
+type Kind int
+
+const (
+	Truncated    Kind = iota + 1 // input ended in the middle of an item
+	TooLarge                     // item exceeds a size or range limit
+	NonCanonical                 // item is validly but non-canonically encoded
+	Syntax                       // input is malformed
+)
+
+var kindNames = []string{
+	Truncated:    "truncated input",
+	TooLarge:     "item too large",
+	NonCanonical: "non-canonical encoding",
+	Syntax:       "syntax error",
+}
+
+// Returns a short description of the error kind.
+func (k Kind) Error() string {
+	if k > 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "error kind " + strconv.Itoa(int(k))
+}
+
+// Error is an error detected by one of the codecs.
+type Error struct {
+	Kind      Kind   // the kind of error
+	Component string // the package detecting the error, such as "cbe"
+	Offset    int64  // byte offset in the input, or -1 if unknown
+	Detail    string // further description of the error, if any
+	Err       error  // underlying error, if any
+}
+
+// Create an Error of the given kind detected by component
+// at input offset off, or -1 if not known, with the given detail message.
+func New(kind Kind, component string, off int64, detail string) *Error {
+	return &Error{Kind: kind, Component: component, Offset: off,
+		Detail: detail}
+}
+
+// Create an Error as New does, wrapping the underlying error err.
+func Wrap(kind Kind, component string, off int64, err error) *Error {
+	return &Error{Kind: kind, Component: component, Offset: off, Err: err}
+}
+
+// Returns a copy of the error with its offset set to off.
+func (e *Error) At(off int64) *Error {
+	c := *e
+	c.Offset = off
+	return &c
+}
+
+// Formats the error as "component: kind at offset N: detail".
+func (e *Error) Error() string {
+	s := e.Kind.Error()
+	if e.Component != "" {
+		s = e.Component + ": " + s
+	}
+	if e.Offset >= 0 {
+		s += " at offset " + strconv.FormatInt(e.Offset, 10)
+	}
+	if e.Detail != "" {
+		s += ": " + e.Detail
+	} else if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Returns the underlying error, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reports whether target is this error's Kind,
+// so that errors.Is(err, kind) matches any Error of that kind.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(Kind)
+	return ok && k == e.Kind
+}
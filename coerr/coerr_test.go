@@ -0,0 +1,39 @@
+package coerr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	err := fmt.Errorf("reading: %w",
+		Wrap(Truncated, "cbe", 12, io.ErrUnexpectedEOF))
+	if !errors.Is(err, Truncated) || errors.Is(err, Syntax) {
+		t.Error("errors.Is does not match kind")
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("errors.Is does not match underlying error")
+	}
+	var ce *Error
+	if !errors.As(err, &ce) || ce.Offset != 12 || ce.Component != "cbe" {
+		t.Errorf("errors.As gave %+v", ce)
+	}
+
+	for _, c := range []struct {
+		err *Error
+		s   string
+	}{
+		{New(Syntax, "cts", 3, "unexpected closer"),
+			"cts: syntax error at offset 3: unexpected closer"},
+		{New(TooLarge, "", -1, ""), "item too large"},
+		{New(NonCanonical, "value", -1, "").At(5),
+			"value: non-canonical encoding at offset 5"},
+		{New(Kind(99), "x", -1, ""), "x: error kind 99"},
+	} {
+		if c.err.Error() != c.s {
+			t.Errorf("got %q, want %q", c.err.Error(), c.s)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package conv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/coerr"
+	"github.com/bford/cofo/value"
+)
+
+// Convert a stream of bencoded items read from r into CBE.
+// Bencode byte strings become []byte values,
+// integers become int64 or *big.Int values,
+// and dictionaries become Maps with []byte keys.
+func BencodeToCBE(e *cbe.Encoder, r io.Reader, raw bool) error {
+	return toCBE(e, r, raw, func(br *bufio.Reader) (value.Value, error) {
+		return readBencode(br, 0)
+	})
+}
+
+// Convert a stream of CBE-encoded items read from d into bencode.
+// Both []byte and string values become bencode byte strings.
+// Returns an error on values bencode cannot represent,
+// such as nil, booleans, floats, and maps with non-string keys.
+func CBEToBencode(w io.Writer, d *cbe.Decoder, raw bool) error {
+	return fromCBE(w, d, raw, writeBencode)
+}
+
+// Read one bencoded item.
+func readBencode(r *bufio.Reader, depth int) (value.Value, error) {
+	if depth > value.MaxDepth {
+		return nil, errDepth
+	}
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == 'i':
+		s, err := readBencodeDigits(r, 'e', true)
+		if err != nil {
+			return nil, err
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		i, _ := new(big.Int).SetString(s, 10)
+		return i, nil
+
+	case c >= '0' && c <= '9':
+		r.UnreadByte()
+		s, err := readBencodeDigits(r, ':', false)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, errBencodeSyntax
+		}
+		return readN(r, n)
+
+	case c == 'l':
+		l := []value.Value{}
+		for {
+			if b, err := r.Peek(1); err != nil {
+				return nil, err
+			} else if b[0] == 'e' {
+				r.ReadByte()
+				return l, nil
+			}
+			v, err := readBencode(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+
+	case c == 'd':
+		m := value.Map{}
+		var prev []byte
+		for {
+			if b, err := r.Peek(1); err != nil {
+				return nil, err
+			} else if b[0] == 'e' {
+				r.ReadByte()
+				return m, nil
+			}
+			k, err := readBencode(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			kb, ok := k.([]byte)
+			if !ok {
+				return nil, errBencodeKey
+			}
+			if len(m) > 0 && bytes.Compare(prev, kb) >= 0 {
+				return nil, errBencodeKeyOrder
+			}
+			prev = kb
+			v, err := readBencode(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, value.Pair{Key: kb, Value: v})
+		}
+	}
+	return nil, errBencodeSyntax
+}
+
+// Read a canonical decimal number terminated by end.
+func readBencodeDigits(r *bufio.Reader, end byte, signed bool) (string, error) {
+	var s []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == end {
+			break
+		}
+		if (c < '0' || c > '9') && !(signed && c == '-' && len(s) == 0) {
+			return "", errBencodeSyntax
+		}
+		if len(s) > value.MaxDigits {
+			return "", errDigits
+		}
+		s = append(s, c)
+	}
+
+	// Reject empty numbers, leading zeros, and negative zero
+	d := s
+	if len(d) > 0 && d[0] == '-' {
+		d = d[1:]
+		if len(d) > 0 && d[0] == '0' {
+			return "", errBencodeSyntax
+		}
+	}
+	if len(d) == 0 || (d[0] == '0' && len(d) > 1) {
+		return "", errBencodeSyntax
+	}
+	return string(s), nil
+}
+
+// Write one value as a bencoded item.
+func writeBencode(w *bufio.Writer, v value.Value) error {
+	switch v := v.(type) {
+	case int:
+		return writeBencode(w, int64(v))
+	case int64:
+		w.WriteByte('i')
+		w.WriteString(strconv.FormatInt(v, 10))
+		w.WriteByte('e')
+	case *big.Int:
+		w.WriteByte('i')
+		w.WriteString(v.String())
+		w.WriteByte('e')
+	case []byte:
+		w.WriteString(strconv.Itoa(len(v)))
+		w.WriteByte(':')
+		w.Write(v)
+	case string:
+		w.WriteString(strconv.Itoa(len(v)))
+		w.WriteByte(':')
+		w.WriteString(v)
+	case []value.Value:
+		w.WriteByte('l')
+		for _, elt := range v {
+			if err := writeBencode(w, elt); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('e')
+	case value.Map:
+		// Bencode requires dictionary keys sorted as raw byte strings
+		type entry struct {
+			key []byte
+			val value.Value
+		}
+		ents := make([]entry, len(v))
+		for i, p := range v {
+			switch k := p.Key.(type) {
+			case []byte:
+				ents[i] = entry{k, p.Value}
+			case string:
+				ents[i] = entry{[]byte(k), p.Value}
+			default:
+				return errBencodeKey
+			}
+		}
+		sort.Slice(ents, func(i, j int) bool {
+			return bytes.Compare(ents[i].key, ents[j].key) < 0
+		})
+		w.WriteByte('d')
+		for i, ent := range ents {
+			if i > 0 && bytes.Equal(ents[i-1].key, ent.key) {
+				return errBencodeKeyOrder
+			}
+			writeBencode(w, ent.key)
+			if err := writeBencode(w, ent.val); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('e')
+	default:
+		return errors.New("value type not representable in bencode")
+	}
+	return nil
+}
+
+var errBencodeSyntax = errors.New("bencode syntax error")
+var errBencodeKey = errors.New("bencode dictionary key is not a byte string")
+var errBencodeKeyOrder = errors.New("bencode dictionary keys unsorted or duplicated")
+var errDigits = coerr.New(coerr.TooLarge, "conv", -1,
+	"bencode integer has too many digits")
@@ -0,0 +1,525 @@
+package conv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"unicode/utf8"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Convert a stream of CBOR items read from r into CBE.
+// In raw mode, each item must be a byte or text string,
+// which is streamed into a blob without being held in memory,
+// so the strings may be arbitrarily long.
+// Otherwise, byte strings become []byte values and text strings strings,
+// and bignums (tags 2 and 3) become integers;
+// other tags, undefined, and simple values are not supported.
+func CBORToCBE(e *cbe.Encoder, r io.Reader, raw bool) error {
+	if raw {
+		return cborRawToCBE(e, r)
+	}
+	return toCBE(e, r, raw, func(br *bufio.Reader) (value.Value, error) {
+		return readCBOR(br, 0)
+	})
+}
+
+// Convert a stream of CBE-encoded items read from d into CBOR.
+// In raw mode, each blob becomes a byte string:
+// of definite length if the blob has only one chunk,
+// and otherwise of indefinite length,
+// with one definite-length chunk per chunk of the blob's first chunk length,
+// streamed without holding the blob in memory.
+// Otherwise, floating-point numbers are always written
+// in double precision.
+func CBEToCBOR(w io.Writer, d *cbe.Decoder, raw bool) error {
+	if raw {
+		return cbeRawToCBOR(w, d)
+	}
+	return fromCBE(w, d, raw, writeCBOR)
+}
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+
+	cborIndefinite = 31   // additional information for indefinite length
+	cborBreak      = 0xff // terminator of indefinite-length items
+)
+
+// Read the argument of an item head with additional information info.
+func readCBORArg(r *bufio.Reader, info byte) (uint64, error) {
+	if info < 24 {
+		return uint64(info), nil
+	}
+	if info > 27 {
+		return 0, errCBORType
+	}
+	var b8 [8]byte
+	n := 1 << (info - 24)
+	if _, err := io.ReadFull(r, b8[8-n:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b8[:]), nil
+}
+
+// Read one CBOR item.
+func readCBOR(r *bufio.Reader, depth int) (value.Value, error) {
+	if depth > value.MaxDepth {
+		return nil, errDepth
+	}
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	major, info := c>>5, c&31
+
+	if info == cborIndefinite {
+		switch major {
+		case cborBytes, cborText:
+			return readCBORChunks(r, major)
+		case cborArray:
+			return readCBORArray(r, -1, depth)
+		case cborMap:
+			return readCBORMap(r, -1, depth)
+		}
+		return nil, errCBORType
+	}
+	if major == cborSimple {
+		return readCBORSimple(r, info)
+	}
+	u, err := readCBORArg(r, info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if u > math.MaxInt64 {
+			return new(big.Int).SetUint64(u), nil
+		}
+		return int64(u), nil
+	case cborNegint:
+		if u > math.MaxInt64 {
+			v := new(big.Int).SetUint64(u)
+			return v.Neg(v.Add(v, big.NewInt(1))), nil
+		}
+		return -1 - int64(u), nil
+	case cborBytes:
+		return readN(r, u)
+	case cborText:
+		return readCBORText(r, u)
+	case cborArray, cborMap:
+		if u > math.MaxInt64 {
+			return nil, errCBORLength
+		}
+		if major == cborArray {
+			return readCBORArray(r, int64(u), depth)
+		}
+		return readCBORMap(r, int64(u), depth)
+	default: // cborTag
+		return readCBORBignum(r, u, depth)
+	}
+}
+
+// Read a simple value or floating-point number.
+func readCBORSimple(r *bufio.Reader, info byte) (value.Value, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22:
+		return nil, nil
+	case 25, 26, 27:
+		u, err := readCBORArg(r, info)
+		if err != nil {
+			return nil, err
+		}
+		switch info {
+		case 25:
+			return halfToFloat(uint16(u)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(u))), nil
+		}
+		return math.Float64frombits(u), nil
+	}
+	return nil, errCBORType
+}
+
+// Convert an IEEE 754 half-precision number to a float64.
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0: // zero or subnormal
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		f = math.Inf(1)
+		if mant != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+func readCBORText(r *bufio.Reader, n uint64) (value.Value, error) {
+	b, err := readN(r, n)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		return nil, errors.New("CBOR text string is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+// Read the chunks of an indefinite-length byte or text string.
+func readCBORChunks(r *bufio.Reader, major byte) (value.Value, error) {
+	b := []byte{}
+	for {
+		n, err := readCBORChunkLen(r, major)
+		if err == io.EOF { // break
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		c, err := readN(r, n)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, c...)
+	}
+	if major == cborText {
+		if !utf8.Valid(b) {
+			return nil, errors.New("CBOR text string is not valid UTF-8")
+		}
+		return string(b), nil
+	}
+	return b, nil
+}
+
+// Read the head of the next chunk of an indefinite-length string
+// of the given major type, returning its length,
+// or io.EOF on reaching the terminating break.
+func readCBORChunkLen(r *bufio.Reader, major byte) (uint64, error) {
+	c, err := r.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	if c == cborBreak {
+		return 0, io.EOF
+	}
+	if c>>5 != major || c&31 == cborIndefinite {
+		return 0, errors.New("invalid chunk in CBOR indefinite-length string")
+	}
+	return readCBORArg(r, c&31)
+}
+
+// Check for the break ending an indefinite-length array or map,
+// consuming it if present.
+func readCBORBreak(r *bufio.Reader) (bool, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	if b[0] != cborBreak {
+		return false, nil
+	}
+	r.ReadByte()
+	return true, nil
+}
+
+// Read an array of n items, or of indefinite length if n is negative.
+func readCBORArray(r *bufio.Reader, n int64, depth int) (value.Value, error) {
+	l := []value.Value{}
+	for i := int64(0); n < 0 || i < n; i++ {
+		if n < 0 {
+			if brk, err := readCBORBreak(r); err != nil {
+				return nil, err
+			} else if brk {
+				break
+			}
+		}
+		v, err := readCBOR(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	return l, nil
+}
+
+// Read a map of n pairs, or of indefinite length if n is negative.
+func readCBORMap(r *bufio.Reader, n int64, depth int) (value.Value, error) {
+	m := value.Map{}
+	for i := int64(0); n < 0 || i < n; i++ {
+		if n < 0 {
+			if brk, err := readCBORBreak(r); err != nil {
+				return nil, err
+			} else if brk {
+				break
+			}
+		}
+		k, err := readCBOR(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readCBOR(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, value.Pair{Key: k, Value: v})
+	}
+	return m, nil
+}
+
+// Read the content of a bignum with the given tag,
+// which must be a byte string.
+func readCBORBignum(r *bufio.Reader, tag uint64, depth int) (
+	value.Value, error) {
+
+	if tag != 2 && tag != 3 {
+		return nil, errCBORType
+	}
+	if c, err := r.Peek(1); err != nil {
+		return nil, unexpected(err)
+	} else if c[0]>>5 != cborBytes {
+		return nil, errBignum
+	}
+	c, err := readCBOR(r, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.([]byte)
+	if !ok {
+		return nil, errBignum
+	}
+	v := new(big.Int).SetBytes(b)
+	if tag == 3 {
+		v.Neg(v.Add(v, big.NewInt(1)))
+	}
+	if v.IsInt64() {
+		return v.Int64(), nil
+	}
+	return v, nil
+}
+
+// Write a CBOR item head with the given major type and argument,
+// using the shortest form.
+func writeCBORHead(w *bufio.Writer, major byte, u uint64) {
+	var b9 [9]byte
+	b9[0] = major << 5
+	switch {
+	case u < 24:
+		b9[0] |= byte(u)
+		w.Write(b9[:1])
+	case u <= math.MaxUint8:
+		b9[0] |= 24
+		b9[1] = byte(u)
+		w.Write(b9[:2])
+	case u <= math.MaxUint16:
+		b9[0] |= 25
+		binary.BigEndian.PutUint16(b9[1:], uint16(u))
+		w.Write(b9[:3])
+	case u <= math.MaxUint32:
+		b9[0] |= 26
+		binary.BigEndian.PutUint32(b9[1:], uint32(u))
+		w.Write(b9[:5])
+	default:
+		b9[0] |= 27
+		binary.BigEndian.PutUint64(b9[1:], u)
+		w.Write(b9[:9])
+	}
+}
+
+// Write one value as a CBOR item.
+func writeCBOR(w *bufio.Writer, v value.Value) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteByte(cborSimple<<5 | 22)
+	case bool:
+		if v {
+			return w.WriteByte(cborSimple<<5 | 21)
+		}
+		return w.WriteByte(cborSimple<<5 | 20)
+	case int:
+		return writeCBOR(w, int64(v))
+	case int64:
+		if v >= 0 {
+			writeCBORHead(w, cborUint, uint64(v))
+		} else {
+			writeCBORHead(w, cborNegint, uint64(-1-v))
+		}
+	case *big.Int:
+		if v.IsInt64() {
+			return writeCBOR(w, v.Int64())
+		}
+		major, tag, m := byte(cborUint), uint64(2), v
+		if v.Sign() < 0 { // encode -1-v
+			major, tag = cborNegint, 3
+			m = new(big.Int).Neg(v)
+			m.Sub(m, big.NewInt(1))
+		}
+		if m.IsUint64() {
+			writeCBORHead(w, major, m.Uint64())
+		} else {
+			writeCBORHead(w, cborTag, tag)
+			b := m.Bytes()
+			writeCBORHead(w, cborBytes, uint64(len(b)))
+			w.Write(b)
+		}
+	case float64:
+		var b9 [9]byte
+		b9[0] = cborSimple<<5 | 27
+		binary.BigEndian.PutUint64(b9[1:], math.Float64bits(v))
+		w.Write(b9[:])
+	case []byte:
+		writeCBORHead(w, cborBytes, uint64(len(v)))
+		w.Write(v)
+	case string:
+		writeCBORHead(w, cborText, uint64(len(v)))
+		w.WriteString(v)
+	case []value.Value:
+		writeCBORHead(w, cborArray, uint64(len(v)))
+		for _, elt := range v {
+			if err := writeCBOR(w, elt); err != nil {
+				return err
+			}
+		}
+	case value.Map:
+		writeCBORHead(w, cborMap, uint64(len(v)))
+		for _, p := range v {
+			if err := writeCBOR(w, p.Key); err != nil {
+				return err
+			}
+			if err := writeCBOR(w, p.Value); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("value type not representable in CBOR")
+	}
+	return nil
+}
+
+// Stream raw CBOR byte and text strings into blobs.
+func cborRawToCBE(e *cbe.Encoder, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		major, info := c>>5, c&31
+		if major != cborBytes && major != cborText {
+			return errNotBytes
+		}
+
+		if info != cborIndefinite {
+			n, err := readCBORArg(br, info)
+			if err != nil {
+				return unexpected(err)
+			}
+			if n > math.MaxInt64 {
+				return errCBORLength
+			}
+			if _, err := e.ReadFromN(br, int64(n)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Stream the chunks of an indefinite-length string into one blob
+		w := e.Writer()
+		for {
+			n, err := readCBORChunkLen(br, major)
+			if err == io.EOF { // break
+				break
+			} else if err != nil {
+				w.Close()
+				return unexpected(err)
+			}
+			if n > math.MaxInt64 {
+				w.Close()
+				return errCBORLength
+			}
+			if _, err := io.CopyN(w, br, int64(n)); err != nil {
+				w.Close()
+				return unexpected(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// Stream blobs into raw CBOR byte strings.
+func cbeRawToCBOR(w io.Writer, d *cbe.Decoder) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for {
+		n, single, err := d.NextLen()
+		if err == io.EOF {
+			return bw.Flush()
+		} else if err != nil {
+			return err
+		}
+		if single {
+			b, err := d.Bytes()
+			if err != nil {
+				return err
+			}
+			writeCBORHead(bw, cborBytes, uint64(len(b)))
+			bw.Write(b)
+			continue
+		}
+
+		// Write a chunked blob as an indefinite-length byte string
+		bw.WriteByte(cborBytes<<5 | cborIndefinite)
+		if int64(cap(buf)) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		r := d.Reader()
+		for {
+			k, err := io.ReadFull(r, buf)
+			if k > 0 {
+				writeCBORHead(bw, cborBytes, uint64(k))
+				bw.Write(buf[:k])
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		bw.WriteByte(cborBreak)
+	}
+}
+
+var errCBORType = errors.New("unsupported CBOR type")
+var errCBORLength = errors.New("CBOR item too long")
+var errBignum = errors.New("CBOR bignum content is not a byte string")
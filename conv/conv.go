@@ -0,0 +1,116 @@
+// Package conv converts between streams of CBE blobs
+// and other serialization formats,
+// currently MessagePack, CBOR, bencode, and JSON Lines.
+//
+// Each converter operates in one of two modes.
+// In raw mode, every top-level item in the foreign stream
+// must be a byte string, which maps directly to a CBE blob
+// with the same content, and vice versa.
+// Otherwise, each top-level item maps to one value
+// in the dynamic value model of package value, encoded as CBE.
+//
+// Early unstable prototype code.
+//
+package conv
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Convert a stream of foreign items, each read by read, to CBE.
+func toCBE(e *cbe.Encoder, r io.Reader, raw bool,
+	read func(*bufio.Reader) (value.Value, error)) error {
+
+	br := bufio.NewReader(r)
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return nil
+		}
+		v, err := read(br)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if raw {
+			switch b := v.(type) {
+			case []byte:
+				err = e.Bytes(b)
+			case string:
+				err = e.String(b)
+			default:
+				err = errNotBytes
+			}
+		} else {
+			err = value.Encode(e, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Convert a CBE stream to foreign items, each written by write.
+func fromCBE(w io.Writer, d *cbe.Decoder, raw bool,
+	write func(*bufio.Writer, value.Value) error) error {
+
+	bw := bufio.NewWriter(w)
+	for {
+		var v value.Value
+		var err error
+		if raw {
+			v, err = d.Bytes()
+		} else {
+			v, err = value.Decode(d)
+		}
+		if err == io.EOF {
+			return bw.Flush()
+		} else if err != nil {
+			return err
+		}
+		if err := write(bw, v); err != nil {
+			return err
+		}
+	}
+}
+
+// Read exactly n bytes from r without trusting n for preallocation.
+func readN(r io.Reader, n uint64) ([]byte, error) {
+	var buf []byte
+	for n > 0 {
+		l := n
+		if l > 64*1024 {
+			l = 64 * 1024
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, l)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		n -= l
+	}
+	if buf == nil {
+		buf = []byte{}
+	}
+	return buf, nil
+}
+
+// Returns io.ErrUnexpectedEOF if err is io.EOF, and otherwise err.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+var errNotBytes = errors.New("raw mode item is not a byte string")
+var errDepth = errors.New("items nested too deeply")
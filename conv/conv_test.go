@@ -0,0 +1,296 @@
+package conv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+func TestMsgpack(t *testing.T) {
+	for i, h := range []string{
+		"c0", "c2", "c3", "00", "7f", "ff", "e0", "cc80", "cd0100",
+		"ce00010000", "cf0000000100000000", "cfffffffffffffffff",
+		"d080", "d1ff00", "d2ffff0000", "d3ffffffff00000000",
+		"cb3ff8000000000000", "c400", "c403010203",
+		"a0", "a568656c6c6f", "90", "9301a162c0", "80", "82a16101a16202",
+	} {
+		in, _ := hex.DecodeString(h)
+		var enc bytes.Buffer
+		if err := MsgpackToCBE(cbe.NewEncoder(&enc), bytes.NewReader(in),
+			false); err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		var out bytes.Buffer
+		if err := CBEToMsgpack(&out, cbe.NewDecoder(&enc), false); err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("case %v: %s round-tripped as %x", i, h, out.Bytes())
+		}
+	}
+
+	// Unsupported or truncated input must fail
+	for _, h := range []string{"c1", "d40100", "c405", "92c0", "a2c3"} {
+		in, _ := hex.DecodeString(h)
+		var enc bytes.Buffer
+		if MsgpackToCBE(cbe.NewEncoder(&enc), bytes.NewReader(in),
+			false) == nil {
+			t.Errorf("accepted invalid MessagePack %s", h)
+		}
+	}
+}
+
+func TestMsgpackBytes(t *testing.T) {
+	// Convert bin and str items embedded in a larger stream
+	in, _ := hex.DecodeString("01c403616263a26869d90178c50000c0")
+	r := bytes.NewReader(in[1:])
+	var enc bytes.Buffer
+	e := cbe.NewEncoder(&enc)
+	for i := 0; i < 4; i++ {
+		if err := MsgpackBytesToCBE(e, r); err != nil {
+			t.Fatalf("item %v: %v", i, err)
+		}
+	}
+	if r.Len() != 1 {
+		t.Errorf("MsgpackBytesToCBE consumed %v extra bytes", 1-r.Len())
+	}
+	want := []byte{0x83, 'a', 'b', 'c', 0x82, 'h', 'i', 'x', 0x80}
+	if !bytes.Equal(enc.Bytes(), want) {
+		t.Errorf("MsgpackBytesToCBE gave %x", enc.Bytes())
+	}
+	for _, h := range []string{"01", "c0", "c403ab", "c5", "da00"} {
+		in, _ := hex.DecodeString(h)
+		if MsgpackBytesToCBE(cbe.NewEncoder(io.Discard),
+			bytes.NewReader(in)) == nil {
+			t.Errorf("MsgpackBytesToCBE accepted %s", h)
+		}
+	}
+
+	// Convert single-chunk and chunked blobs back
+	big := bytes.Repeat([]byte("m"), 2*cbe.MinChunkLen)
+	enc.Reset()
+	e.Bytes([]byte("abc"))
+	e.ReadFrom(bytes.NewReader(big))
+	d := cbe.NewDecoder(&enc)
+	var out bytes.Buffer
+	if err := CBEToMsgpackBytes(&out, d, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := CBEToMsgpackBytes(&out, d, false); err != nil {
+		t.Fatal(err)
+	}
+	if h := hex.EncodeToString(out.Bytes()[:7]); h != "a3616263c58080" {
+		t.Errorf("CBEToMsgpackBytes gave %s", h)
+	}
+	if !bytes.Equal(out.Bytes()[7:], big) {
+		t.Errorf("CBEToMsgpackBytes gave wrong chunked content")
+	}
+	if err := CBEToMsgpackBytes(&out, d, false); err != io.EOF {
+		t.Errorf("CBEToMsgpackBytes at end gave %v", err)
+	}
+}
+
+func TestCBOR(t *testing.T) {
+	for i, h := range []string{
+		"f6", "f4", "f5", "00", "17", "1818", "190100", "1a00010000",
+		"1b0000000100000000", "1bffffffffffffffff", "20", "3818",
+		"3bffffffffffffffff", "c249010000000000000000",
+		"c349010000000000000000", "fb3ff8000000000000",
+		"40", "43010203", "60", "6568656c6c6f",
+		"80", "8301816162f6", "a0", "a2616101616202",
+	} {
+		in, _ := hex.DecodeString(h)
+		var enc bytes.Buffer
+		if err := CBORToCBE(cbe.NewEncoder(&enc), bytes.NewReader(in),
+			false); err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		var out bytes.Buffer
+		if err := CBEToCBOR(&out, cbe.NewDecoder(&enc), false); err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Errorf("case %v: %s round-tripped as %x", i, h, out.Bytes())
+		}
+	}
+
+	// Other encodings of the same values convert to the shortest form
+	for _, c := range [][2]string{
+		{"f93e00", "fb3ff8000000000000"},     // half-precision 1.5
+		{"fa3fc00000", "fb3ff8000000000000"}, // single-precision 1.5
+		{"5f41014102ff", "420102"},           // indefinite byte string
+		{"7f61616162ff", "626162"},           // indefinite text string
+		{"9f0102ff", "820102"},               // indefinite array
+		{"bf0102ff", "a10102"},               // indefinite map
+		{"c24101", "01"},                     // small bignum
+	} {
+		in, _ := hex.DecodeString(c[0])
+		var enc, out bytes.Buffer
+		if err := CBORToCBE(cbe.NewEncoder(&enc), bytes.NewReader(in),
+			false); err != nil {
+			t.Fatalf("%s: %v", c[0], err)
+		}
+		CBEToCBOR(&out, cbe.NewDecoder(&enc), false)
+		if h := hex.EncodeToString(out.Bytes()); h != c[1] {
+			t.Errorf("%s converted to %s, want %s", c[0], h, c[1])
+		}
+	}
+
+	// Unsupported or truncated input must fail
+	for _, h := range []string{"f7", "c101", "1c", "ff", "4201", "5f4101",
+		"5f01ff", "9f01", "a1", "6180", "c2c24101", "c201", "c2"} {
+		in, _ := hex.DecodeString(h)
+		var enc bytes.Buffer
+		if CBORToCBE(cbe.NewEncoder(&enc), bytes.NewReader(in),
+			false) == nil {
+			t.Errorf("accepted invalid CBOR %s", h)
+		}
+	}
+
+	// Raw mode streams strings of any length, chunked or not
+	big := bytes.Repeat([]byte("z"), 2*cbe.MinChunkLen+1)
+	var enc bytes.Buffer
+	e := cbe.NewEncoder(&enc)
+	e.Bytes([]byte("abc"))
+	e.ReadFrom(bytes.NewReader(big))
+	var out bytes.Buffer
+	if err := CBEToCBOR(&out, cbe.NewDecoder(&enc), true); err != nil {
+		t.Fatal(err)
+	}
+	cb := out.Bytes()
+	if h := hex.EncodeToString(cb[:6]); h != "436162635f59" {
+		t.Errorf("raw CBOR begins %s", h)
+	}
+	if cb[len(cb)-1] != 0xff {
+		t.Errorf("raw CBOR chunked string not terminated")
+	}
+	enc.Reset()
+	in := append(cb, 0x62, 'h', 'i')
+	if err := CBORToCBE(cbe.NewEncoder(&enc), bytes.NewReader(in),
+		true); err != nil {
+		t.Fatal(err)
+	}
+	d := cbe.NewDecoder(&enc)
+	for _, want := range [][]byte{[]byte("abc"), big, []byte("hi")} {
+		if b, err := d.Bytes(); err != nil || !bytes.Equal(b, want) {
+			t.Errorf("raw CBOR round trip gave %v bytes, %v", len(b), err)
+		}
+	}
+	for _, h := range []string{"01", "5f01ff", "5f41", "43ab"} {
+		in, _ := hex.DecodeString(h)
+		if CBORToCBE(cbe.NewEncoder(&enc), bytes.NewReader(in),
+			true) == nil {
+			t.Errorf("raw mode accepted %s", h)
+		}
+	}
+}
+
+func TestBencode(t *testing.T) {
+	for i, s := range []string{
+		"i0e", "i-42e", "i123456789012345678901234567890e",
+		"0:", "4:spam", "le", "l4:spami42ee",
+		"de", "d3:bar4:spam3:fooi42ee",
+		"d4:infod6:lengthi1e4:name1:xee",
+		"i1e4:spamle", // several top-level items
+	} {
+		var enc bytes.Buffer
+		if err := BencodeToCBE(cbe.NewEncoder(&enc),
+			bytes.NewReader([]byte(s)), false); err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		var out bytes.Buffer
+		if err := CBEToBencode(&out, cbe.NewDecoder(&enc), false); err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if out.String() != s {
+			t.Errorf("case %v: %q round-tripped as %q", i, s, out.String())
+		}
+	}
+
+	for _, s := range []string{
+		"i-0e", "i03e", "ie", "01:a", "5:abc", "d3:fooi1e3:bari2ee",
+		"di1ei2ee", "l", "x",
+	} {
+		var enc bytes.Buffer
+		if BencodeToCBE(cbe.NewEncoder(&enc),
+			bytes.NewReader([]byte(s)), false) == nil {
+			t.Errorf("accepted invalid bencode %q", s)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	// Raw mode maps byte strings directly to plain blobs
+	var enc bytes.Buffer
+	err := BencodeToCBE(cbe.NewEncoder(&enc),
+		bytes.NewReader([]byte("3:abc0:1:x")), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x83, 'a', 'b', 'c', 0x80, 'x'}
+	if !bytes.Equal(enc.Bytes(), want) {
+		t.Errorf("raw bencode gave %x", enc.Bytes())
+	}
+
+	var out bytes.Buffer
+	if err := CBEToMsgpack(&out, cbe.NewDecoder(&enc), true); err != nil {
+		t.Fatal(err)
+	}
+	if h := hex.EncodeToString(out.Bytes()); h != "c403616263c400c40178" {
+		t.Errorf("raw MessagePack gave %s", h)
+	}
+
+	if BencodeToCBE(cbe.NewEncoder(&enc),
+		bytes.NewReader([]byte("i1e")), true) == nil {
+		t.Error("raw mode accepted an integer")
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	in := "{\"a\":[1,2.5,null]}\n\n{\"$bytes\":\"AQI=\"}\n\"x\"\n"
+	var enc bytes.Buffer
+	if err := JSONLinesToCBE(cbe.NewEncoder(&enc),
+		bytes.NewReader([]byte(in)), false); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := CBEToJSONLines(&out, cbe.NewDecoder(&enc), false); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"a\":[1,2.5,null]}\n{\"$bytes\":\"AQI=\"}\n\"x\"\n"; out.String() != want {
+		t.Errorf("JSON Lines round-tripped as %q", out.String())
+	}
+
+	// Raw mode maps each blob to a base64 string, without a final newline
+	raw := "\"YWJj\"\n\"\"\n\"eA==\""
+	enc.Reset()
+	if err := JSONLinesToCBE(cbe.NewEncoder(&enc),
+		bytes.NewReader([]byte(raw)), true); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x83, 'a', 'b', 'c', 0x80, 'x'}
+	if !bytes.Equal(enc.Bytes(), want) {
+		t.Errorf("raw JSON Lines gave %x", enc.Bytes())
+	}
+	out.Reset()
+	if err := CBEToJSONLines(&out, cbe.NewDecoder(&enc), true); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != raw+"\n" {
+		t.Errorf("raw JSON Lines round-tripped as %q", out.String())
+	}
+
+	for _, s := range []string{"1", "\"!!\"", "[\"YQ==\"]"} {
+		if JSONLinesToCBE(cbe.NewEncoder(&enc),
+			bytes.NewReader([]byte(s)), true) == nil {
+			t.Errorf("raw mode accepted %q", s)
+		}
+	}
+	if JSONLinesToCBE(cbe.NewEncoder(&enc),
+		bytes.NewReader([]byte("{\"a\":1,\"a\":2}")), false) == nil {
+		t.Error("accepted duplicate keys")
+	}
+}
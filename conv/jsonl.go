@@ -0,0 +1,76 @@
+package conv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Convert a JSON Lines stream read from r into CBE,
+// one top-level item per non-blank line.
+// In raw mode, each line must be a JSON string
+// containing the standard base64 encoding of a blob's content.
+// Otherwise, each line is a value in the JSON mapping of package value.
+func JSONLinesToCBE(e *cbe.Encoder, r io.Reader, raw bool) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if l := bytes.TrimSpace(line); len(l) > 0 {
+			if err := jsonLineToCBE(e, l, raw); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func jsonLineToCBE(e *cbe.Encoder, line []byte, raw bool) error {
+	if !raw {
+		v, err := value.UnmarshalJSON(line)
+		if err != nil {
+			return err
+		}
+		return value.Encode(e, v)
+	}
+	var s string
+	if err := json.Unmarshal(line, &s); err != nil {
+		return errNotBytes
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return e.Bytes(b)
+}
+
+// Convert a stream of CBE-encoded items read from d into JSON Lines,
+// writing one line per top-level item.
+// In raw mode, each blob becomes a JSON string
+// containing the base64 encoding of its content.
+func CBEToJSONLines(w io.Writer, d *cbe.Decoder, raw bool) error {
+	return fromCBE(w, d, raw, func(bw *bufio.Writer, v value.Value) error {
+		var j []byte
+		var err error
+		if raw {
+			j, err = json.Marshal(base64.StdEncoding.EncodeToString(
+				v.([]byte)))
+		} else {
+			j, err = value.MarshalJSON(v)
+		}
+		if err != nil {
+			return err
+		}
+		bw.Write(j)
+		return bw.WriteByte('\n')
+	})
+}
@@ -0,0 +1,352 @@
+package conv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"unicode/utf8"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Convert a stream of MessagePack items read from r into CBE.
+// MessagePack bin items become []byte values and str items strings;
+// extension types are not supported.
+// In raw mode, each item must be a bin or str item,
+// whose content is streamed into a blob as MsgpackBytesToCBE does.
+func MsgpackToCBE(e *cbe.Encoder, r io.Reader, raw bool) error {
+	if raw {
+		br := bufio.NewReader(r)
+		for {
+			if _, err := br.Peek(1); err == io.EOF {
+				return nil
+			}
+			if err := MsgpackBytesToCBE(e, br); err != nil {
+				return err
+			}
+		}
+	}
+	return toCBE(e, r, raw, func(br *bufio.Reader) (value.Value, error) {
+		return readMsgpack(br, 0)
+	})
+}
+
+// Convert a stream of CBE-encoded items read from d into MessagePack.
+// In raw mode, each blob becomes a bin item.
+func CBEToMsgpack(w io.Writer, d *cbe.Decoder, raw bool) error {
+	return fromCBE(w, d, raw, writeMsgpack)
+}
+
+// Convert one MessagePack bin or str item read from r into a blob
+// with the same content, streaming the content without buffering it.
+// Reads exactly the item's bytes from r, and no further,
+// so that a protocol migrating from MessagePack to CBE
+// can convert individual items embedded in a larger stream.
+// The content of a str item is not checked to be valid UTF-8.
+func MsgpackBytesToCBE(e *cbe.Encoder, r io.Reader) error {
+	var b1 [1]byte
+	var b8 [8]byte
+	if _, err := io.ReadFull(r, b1[:1]); err != nil {
+		return err
+	}
+	c := b1[0]
+	var n int
+	switch {
+	case c >= 0xa0 && c < 0xc0: // fixstr
+		_, err := e.ReadFromN(r, int64(c&0x1f))
+		return err
+	case c >= 0xc4 && c <= 0xc6: // bin 8/16/32
+		n = 1 << (c - 0xc4)
+	case c >= 0xd9 && c <= 0xdb: // str 8/16/32
+		n = 1 << (c - 0xd9)
+	default:
+		return errNotBytes
+	}
+	if _, err := io.ReadFull(r, b8[8-n:]); err != nil {
+		return unexpected(err)
+	}
+	_, err := e.ReadFromN(r, int64(binary.BigEndian.Uint64(b8[:])))
+	return err
+}
+
+// Convert the next blob read from d into a MessagePack item
+// with the same content, a str item if str is true and otherwise bin,
+// without checking that the content of a str item is valid UTF-8.
+// Since MessagePack requires an item's length before its content,
+// only a blob encoded in a single chunk is streamed
+// while a chunked blob is read into memory.
+func CBEToMsgpackBytes(w io.Writer, d *cbe.Decoder, str bool) error {
+	n, single, err := d.NextLen()
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if !single {
+		if b, err = d.Bytes(); err != nil {
+			return err
+		}
+		n = int64(len(b))
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeMsgpackBytesHead(bw, str, int(n)); err != nil {
+		return err
+	}
+	if single {
+		_, err = d.WriteTo(bw)
+	} else {
+		_, err = bw.Write(b)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Read one MessagePack item.
+func readMsgpack(r *bufio.Reader, depth int) (value.Value, error) {
+	if depth > value.MaxDepth {
+		return nil, errDepth
+	}
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read a big-endian unsigned integer of n bytes
+	uint := func(n int) (uint64, error) {
+		var b8 [8]byte
+		if _, err := io.ReadFull(r, b8[8-n:]); err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b8[:]), nil
+	}
+
+	switch {
+	case c < 0x80: // positive fixint
+		return int64(c), nil
+	case c >= 0xe0: // negative fixint
+		return int64(int8(c)), nil
+	case c < 0x90: // fixmap
+		return readMsgpackMap(r, uint64(c&0x0f), depth)
+	case c < 0xa0: // fixarray
+		return readMsgpackArray(r, uint64(c&0x0f), depth)
+	case c < 0xc0: // fixstr
+		return readMsgpackStr(r, uint64(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8/16/32
+		n, err := uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readN(r, n)
+	case 0xca: // float 32
+		u, err := uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb: // float 64
+		u, err := uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8/16/32/64
+		u, err := uint(1 << (c - 0xcc))
+		if u > math.MaxInt64 {
+			return new(big.Int).SetUint64(u), err
+		}
+		return int64(u), err
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8/16/32/64
+		n := 1 << (c - 0xd0)
+		u, err := uint(n)
+		shift := uint64(64 - 8*n) // sign-extend
+		return int64(u<<shift) >> shift, err
+	case 0xd9, 0xda, 0xdb: // str 8/16/32
+		n, err := uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackStr(r, n)
+	case 0xdc, 0xdd: // array 16/32
+		n, err := uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, n, depth)
+	case 0xde, 0xdf: // map 16/32
+		n, err := uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, n, depth)
+	default:
+		return nil, errMsgpackType
+	}
+}
+
+func readMsgpackStr(r *bufio.Reader, n uint64) (value.Value, error) {
+	b, err := readN(r, n)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		return nil, errors.New("MessagePack str is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+func readMsgpackArray(r *bufio.Reader, n uint64, depth int) (value.Value, error) {
+	l := []value.Value{}
+	for i := uint64(0); i < n; i++ {
+		v, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	return l, nil
+}
+
+func readMsgpackMap(r *bufio.Reader, n uint64, depth int) (value.Value, error) {
+	m := value.Map{}
+	for i := uint64(0); i < n; i++ {
+		k, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, value.Pair{Key: k, Value: v})
+	}
+	return m, nil
+}
+
+// Write a type byte followed by a big-endian n-byte integer.
+func writeMsgpackHead(w *bufio.Writer, c byte, n int, u uint64) {
+	w.WriteByte(c)
+	var b8 [8]byte
+	binary.BigEndian.PutUint64(b8[:], u)
+	w.Write(b8[8-n:])
+}
+
+// Write the head of a length-prefixed item using the smallest length field.
+func writeMsgpackSized(w *bufio.Writer, fix, fixmax, c8, c16, c32 int,
+	n int) error {
+
+	switch {
+	case fix >= 0 && n <= fixmax:
+		w.WriteByte(byte(fix + n))
+	case c8 >= 0 && n <= math.MaxUint8:
+		writeMsgpackHead(w, byte(c8), 1, uint64(n))
+	case n <= math.MaxUint16:
+		writeMsgpackHead(w, byte(c16), 2, uint64(n))
+	case int64(n) <= math.MaxUint32:
+		writeMsgpackHead(w, byte(c32), 4, uint64(n))
+	default:
+		return errors.New("item too long for MessagePack")
+	}
+	return nil
+}
+
+// Write the head of a bin item, or of a str item if str is true,
+// with content of length n.
+func writeMsgpackBytesHead(w *bufio.Writer, str bool, n int) error {
+	if str {
+		return writeMsgpackSized(w, 0xa0, 31, 0xd9, 0xda, 0xdb, n)
+	}
+	return writeMsgpackSized(w, -1, 0, 0xc4, 0xc5, 0xc6, n)
+}
+
+// Write one value as a MessagePack item.
+func writeMsgpack(w *bufio.Writer, v value.Value) error {
+
+	switch v := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if v {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case int:
+		return writeMsgpack(w, int64(v))
+	case int64:
+		switch {
+		case v >= 0 && v < 0x80, v < 0 && v >= -32:
+			return w.WriteByte(byte(v))
+		case v >= 0 && v <= math.MaxUint8:
+			writeMsgpackHead(w, 0xcc, 1, uint64(v))
+		case v >= 0 && v <= math.MaxUint16:
+			writeMsgpackHead(w, 0xcd, 2, uint64(v))
+		case v >= 0 && v <= math.MaxUint32:
+			writeMsgpackHead(w, 0xce, 4, uint64(v))
+		case v >= 0:
+			writeMsgpackHead(w, 0xcf, 8, uint64(v))
+		case v >= math.MinInt8:
+			writeMsgpackHead(w, 0xd0, 1, uint64(v))
+		case v >= math.MinInt16:
+			writeMsgpackHead(w, 0xd1, 2, uint64(v))
+		case v >= math.MinInt32:
+			writeMsgpackHead(w, 0xd2, 4, uint64(v))
+		default:
+			writeMsgpackHead(w, 0xd3, 8, uint64(v))
+		}
+	case *big.Int:
+		if v.IsInt64() {
+			return writeMsgpack(w, v.Int64())
+		}
+		if !v.IsUint64() {
+			return errors.New("integer too large for MessagePack")
+		}
+		writeMsgpackHead(w, 0xcf, 8, v.Uint64())
+	case float64:
+		writeMsgpackHead(w, 0xcb, 8, math.Float64bits(v))
+	case []byte:
+		if err := writeMsgpackBytesHead(w, false, len(v)); err != nil {
+			return err
+		}
+		w.Write(v)
+	case string:
+		if err := writeMsgpackBytesHead(w, true, len(v)); err != nil {
+			return err
+		}
+		w.WriteString(v)
+	case []value.Value:
+		err := writeMsgpackSized(w, 0x90, 15, -1, 0xdc, 0xdd, len(v))
+		if err != nil {
+			return err
+		}
+		for _, elt := range v {
+			if err := writeMsgpack(w, elt); err != nil {
+				return err
+			}
+		}
+	case value.Map:
+		err := writeMsgpackSized(w, 0x80, 15, -1, 0xde, 0xdf, len(v))
+		if err != nil {
+			return err
+		}
+		for _, p := range v {
+			if err := writeMsgpack(w, p.Key); err != nil {
+				return err
+			}
+			if err := writeMsgpack(w, p.Value); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("value type not representable in MessagePack")
+	}
+	return nil
+}
+
+var errMsgpackType = errors.New("unsupported MessagePack type")
@@ -10,13 +10,17 @@
 // is to convert it to a URI then parse it using net.url.Parse
 // (see https://golang.org/pkg/net/url/).
 //
+// URNs can be parsed in either colon-delimited or bracketed form
+// with ParseURN, and URNs in the uuid, isbn, and ietf namespaces
+// further parsed into typed values.
+//
 // This is early, incomplete, experimental code with many limitations,
 // including not yet dealing with internationalized IRIs.
 //
 package cri
 
 import (
-	"errors"
+	"github.com/bford/cofo/coerr"
 )
 
 // Check
@@ -50,9 +54,9 @@ var CRI = &Form{Unicode: true, Brackets: true, NestedIP: true}
 func (f *Form) Check(ri string) error {
 
 	// Check characters allowed
-	for _, r := range ri {
+	for i, r := range ri {
 		if r >= 128 && !f.Unicode {
-			return errNoUnicode
+			return errNoUnicode.At(int64(i))
 		}
 	}
 
@@ -157,4 +161,5 @@ func decodeUnreserved(s string) string {
 	return s
 }
 
-var errNoUnicode = errors.New("Unicode characters not allowed")
+var errNoUnicode = coerr.New(coerr.Syntax, "cri", -1,
+	"Unicode characters not allowed")
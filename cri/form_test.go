@@ -1,7 +1,11 @@
 package cri
 
 import (
+	"errors"
+	"strings"
 	"testing"
+
+	"github.com/bford/cofo/coerr"
 )
 
 var lazyIRI = &Form{Unicode: true, Lazy: true}
@@ -57,3 +61,122 @@ func TestFrom(t *testing.T) {
 		}
 	}
 }
+
+// Checking and converting identifiers that need no changes
+// should not allocate.
+func TestAllocs(t *testing.T) {
+	cases := []struct {
+		name string
+		f    func()
+	}{
+		{"Check", func() { CRI.Check("https://foo.bar/baz") }},
+		{"From", func() { URI.From("https://foo.bar/baz") }},
+		{"From lazy", func() { lazyCRI.From("https://12.34.56.78/") }},
+	}
+	for _, c := range cases {
+		if n := testing.AllocsPerRun(100, c.f); n != 0 {
+			t.Errorf("%s allocates %v times per call", c.name, n)
+		}
+	}
+}
+
+func BenchmarkFrom(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CRI.From("https://user@12.34.56.78:80/a/b/c?q#f")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var ce *coerr.Error
+	err := URI.Check("http://ex.org/ü")
+	if !errors.As(err, &ce) || ce.Kind != coerr.Syntax || ce.Offset != 14 {
+		t.Errorf("Check gave %v", err)
+	}
+	if err := IRI.Check("http://ex.org/ü"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestURN(t *testing.T) {
+	const id = "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"
+	for _, s := range []string{"urn:uuid:" + id, "URN[UUID:" + id + "]",
+		"urn:uuid:F81D4FAE-7DEC-11D0-A765-00A0C91E6BF6"} {
+		u, err := ParseURN(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		uuid, err := u.UUID()
+		if err != nil || uuid.String() != id {
+			t.Errorf("%s: UUID %v, %v", s, uuid, err)
+		}
+		if got := uuid.URN().String(); got != "urn:uuid:"+id {
+			t.Errorf("%s: String %s", s, got)
+		}
+		if got := uuid.URN().CRI(); got != "urn[uuid:"+id+"]" {
+			t.Errorf("%s: CRI %s", s, got)
+		}
+	}
+
+	u, err := ParseURN("urn:isbn:0-306-40615-2?=x#frag")
+	if err != nil || u.NSS != "0-306-40615-2" || u.Components != "?=x#frag" {
+		t.Fatalf("got %+v, %v", u, err)
+	}
+	isbn, err := u.ISBN()
+	if err != nil || isbn != "0306406152" || isbn.ISBN13() != "9780306406157" {
+		t.Errorf("ISBN %v, %v", isbn, err)
+	}
+	if _, err := ParseISBN("978-0-306-40615-7"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseISBN("080442957X"); err != nil {
+		t.Error(err)
+	}
+
+	u, _ = ParseURN("urn[ietf:rfc:2648]")
+	if n, err := u.IETF(); err != nil || *n != (IETFName{"rfc", "2648"}) {
+		t.Errorf("IETF %v, %v", n, err)
+	}
+	n := &IETFName{"params", "xml:ns:yang"}
+	if s := n.URN().String(); s != "urn:ietf:params:xml:ns:yang" {
+		t.Errorf("IETF URN %s", s)
+	}
+
+	for _, s := range []string{
+		"http://x/", "urn:x:y", "urn:-ab:y", "urn:uuid:", "urn:uuid",
+		"urn[uuid:y", "urn:" + strings.Repeat("a", 33) + ":y",
+	} {
+		if _, err := ParseURN(s); !errors.Is(err, coerr.Syntax) {
+			t.Errorf("%s: got %v", s, err)
+		}
+	}
+	for _, c := range []struct {
+		urn string
+		get func(*URN) error
+	}{
+		{"urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf", func(u *URN) error {
+			_, err := u.UUID()
+			return err
+		}},
+		{"urn:isbn:0-306-40615-3", func(u *URN) error {
+			_, err := u.ISBN()
+			return err
+		}},
+		{"urn:ietf:rfc:12a", func(u *URN) error {
+			_, err := u.IETF()
+			return err
+		}},
+		{"urn:isbn:0306406152", func(u *URN) error {
+			_, err := u.UUID()
+			return err
+		}},
+	} {
+		u, err := ParseURN(c.urn)
+		if err != nil {
+			t.Fatalf("%s: %v", c.urn, err)
+		}
+		if err := c.get(u); !errors.Is(err, coerr.Syntax) {
+			t.Errorf("%s: got %v", c.urn, err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cri
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// URN is a uniform resource name (RFC 8141),
+// parsed from either the conventional colon-delimited form
+// "urn:nid:nss" or the bracketed CRI form "urn[nid:nss]".
+type URN struct {
+	NID        string // namespace identifier, in lower case
+	NSS        string // namespace-specific string
+	Components string // any "?+", "?=", or "#" components, verbatim
+}
+
+// Parse a URN in either colon-delimited or bracketed form.
+// Validates the namespace identifier's syntax but not the NSS;
+// use the namespace-specific methods such as UUID to validate it.
+func ParseURN(s string) (*URN, error) {
+	start, end, delim := scanScheme(s)
+	if delim == 0 || !strings.EqualFold(s[:start-1], "urn") {
+		return nil, errURN
+	}
+	body := s[start:end]
+	i := strings.IndexByte(body, ':')
+	if i < 0 {
+		return nil, errURN.At(int64(start))
+	}
+	u := &URN{NID: strings.ToLower(body[:i]), NSS: body[i+1:]}
+	if !validNID(u.NID) {
+		return nil, errNID.At(int64(start))
+	}
+	if j := strings.IndexAny(u.NSS, "?#"); j >= 0 {
+		u.NSS, u.Components = u.NSS[:j], u.NSS[j:]
+	}
+	if u.NSS == "" {
+		return nil, errURN.At(int64(start + i + 1))
+	}
+	return u, nil
+}
+
+// Reports whether nid is a syntactically valid namespace identifier:
+// 2 to 32 letters, digits, and hyphens, not beginning or ending in a hyphen.
+func validNID(nid string) bool {
+	if len(nid) < 2 || len(nid) > 32 ||
+		nid[0] == '-' || nid[len(nid)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(nid); i++ {
+		if c := nid[i]; !isAlpha(c) && !isDigit(c) && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the URN in conventional colon-delimited form.
+func (u *URN) String() string {
+	return "urn:" + u.NID + ":" + u.NSS + u.Components
+}
+
+// Returns the URN in bracketed CRI form.
+func (u *URN) CRI() string {
+	return "urn[" + u.NID + ":" + u.NSS + u.Components + "]"
+}
+
+// UUID is a universally unique identifier (RFC 9562).
+type UUID [16]byte
+
+// Parse a UUID in its standard hyphenated hexadecimal form,
+// such as "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", ignoring case.
+func ParseUUID(s string) (UUID, error) {
+	var id UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' ||
+		s[23] != '-' {
+		return id, errUUID
+	}
+	h := s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(id[:], []byte(h)); err != nil {
+		return id, errUUID
+	}
+	return id, nil
+}
+
+// Returns the UUID in standard lower-case hyphenated form.
+func (id UUID) String() string {
+	h := hex.EncodeToString(id[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" +
+		h[20:]
+}
+
+// Returns the URN for the UUID in the "uuid" namespace (RFC 4122).
+func (id UUID) URN() *URN {
+	return &URN{NID: "uuid", NSS: id.String()}
+}
+
+// Returns the UUID named by a URN in the "uuid" namespace.
+func (u *URN) UUID() (UUID, error) {
+	if u.NID != "uuid" {
+		return UUID{}, errNamespace
+	}
+	return ParseUUID(u.NSS)
+}
+
+// ISBN is an International Standard Book Number
+// of 10 or 13 characters, without hyphens or spaces.
+type ISBN string
+
+// Parse an ISBN-10 or ISBN-13, ignoring hyphens and spaces,
+// and verify its check digit.
+func ParseISBN(s string) (ISBN, error) {
+	b := make([]byte, 0, 13)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '-' || c == ' ':
+		case isDigit(c):
+			b = append(b, c)
+		case (c == 'X' || c == 'x') && len(b) == 9 && i == len(s)-1:
+			b = append(b, 'X')
+		default:
+			return "", errISBN
+		}
+	}
+	switch len(b) {
+	case 10:
+		sum := 0
+		for i, c := range b {
+			d := int(c - '0')
+			if c == 'X' {
+				d = 10
+			}
+			sum += (10 - i) * d
+		}
+		if sum%11 != 0 {
+			return "", errISBN
+		}
+	case 13:
+		if isbn13Check(b[:12]) != b[12] {
+			return "", errISBN
+		}
+	default:
+		return "", errISBN
+	}
+	return ISBN(b), nil
+}
+
+// Returns the check digit of the 12-digit ISBN-13 prefix b.
+func isbn13Check(b []byte) byte {
+	sum := 0
+	for i, c := range b {
+		sum += int(c-'0') * (1 + 2*(i%2))
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Returns the ISBN in 13-digit form,
+// converting an ISBN-10 by adding the "978" prefix.
+func (i ISBN) ISBN13() ISBN {
+	if len(i) != 10 {
+		return i
+	}
+	b := []byte("978" + string(i[:9]))
+	return ISBN(append(b, isbn13Check(b)))
+}
+
+// Returns the URN for the ISBN in the "isbn" namespace (RFC 8254).
+func (i ISBN) URN() *URN {
+	return &URN{NID: "isbn", NSS: string(i)}
+}
+
+// Returns the ISBN named by a URN in the "isbn" namespace.
+func (u *URN) ISBN() (ISBN, error) {
+	if u.NID != "isbn" {
+		return "", errNamespace
+	}
+	return ParseISBN(u.NSS)
+}
+
+// IETFName is a name in the "ietf" URN namespace (RFC 2648),
+// such as urn:ietf:rfc:2648 or urn:ietf:params:xml:ns:yang.
+type IETFName struct {
+	Kind  string // "rfc", "fyi", "std", "bcp", "id", "mtg", or "params"
+	Value string // the document number, draft name, or parameter path
+}
+
+// Returns the URN for the IETF name.
+func (n *IETFName) URN() *URN {
+	return &URN{NID: "ietf", NSS: n.Kind + ":" + n.Value}
+}
+
+// Returns the IETF name named by a URN in the "ietf" namespace,
+// checking that document series numbers are decimal numbers.
+func (u *URN) IETF() (*IETFName, error) {
+	if u.NID != "ietf" {
+		return nil, errNamespace
+	}
+	i := strings.IndexByte(u.NSS, ':')
+	if i < 0 || i == len(u.NSS)-1 {
+		return nil, errIETF
+	}
+	n := &IETFName{Kind: strings.ToLower(u.NSS[:i]), Value: u.NSS[i+1:]}
+	switch n.Kind {
+	case "rfc", "fyi", "std", "bcp":
+		for j := 0; j < len(n.Value); j++ {
+			if !isDigit(n.Value[j]) {
+				return nil, errIETF
+			}
+		}
+	case "id", "mtg", "params":
+	default:
+		return nil, errIETF
+	}
+	return n, nil
+}
+
+var errURN = coerr.New(coerr.Syntax, "cri", -1, "not a URN")
+var errNID = coerr.New(coerr.Syntax, "cri", -1,
+	"invalid URN namespace identifier")
+var errNamespace = coerr.New(coerr.Syntax, "cri", -1,
+	"URN in a different namespace")
+var errUUID = coerr.New(coerr.Syntax, "cri", -1, "invalid UUID")
+var errISBN = coerr.New(coerr.Syntax, "cri", -1, "invalid ISBN")
+var errIETF = coerr.New(coerr.Syntax, "cri", -1, "invalid IETF URN")
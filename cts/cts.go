@@ -9,9 +9,10 @@ package cts
 
 import (
 	"bufio"
-	"errors"
 	"io"
 	"strings"
+
+	"github.com/bford/cofo/coerr"
 )
 
 // Brackets defines a punctuation configuration for a CTS encoder/decoder
@@ -103,6 +104,7 @@ type Decoder struct {
 	h func(error) error
 	b strings.Builder
 	e error
+	o int64 // byte offset of the next input rune
 }
 
 // Create a new Decoder that reads UTF-8 encoded input text from r.
@@ -118,28 +120,50 @@ func (c *Config) NewDecoder(r io.Reader) *Decoder {
 		h: h}
 }
 
+// Scan to the closer close, or to the next open bracket if close is 0,
+// copying nested bracketed substrings along the way.
+// Keeps a stack of the closers of the nested substrings
+// rather than recursing, so that deep nesting cannot exhaust the stack.
 func (dec *Decoder) toBracket(close rune) (rune, rune, error) {
+	var nest []rune // closers awaited for nested substrings
 	for {
-		rune, _, err := dec.r.ReadRune()
+		rune, size, err := dec.r.ReadRune()
+		if err == io.EOF && close != 0 {
+			err = coerr.Wrap(coerr.Truncated, "cts", dec.o,
+				io.ErrUnexpectedEOF)
+		}
 		if err != nil {
 			return 0, 0, err // we have to stop at EOF or I/O error
 		}
-		if close != 0 && rune == close { // found closer we wanted
-			return 0, 0, nil
+		off := dec.o
+		dec.o += int64(size)
+		want := close
+		if len(nest) > 0 {
+			want = nest[len(nest)-1]
+		}
+		if want != 0 && rune == want { // found closer we wanted
+			if len(nest) == 0 {
+				return 0, 0, nil
+			}
+			nest = nest[:len(nest)-1]
+			dec.b.WriteRune(rune) // copy close bracket
+			continue
 		}
 		if br, ok := dec.p[rune]; ok { // found a bracket?
-			if close == 0 && !br.close { // found open bracket
+			if want == 0 && !br.close { // found open bracket
 				return rune, br.other, nil
 
-			} else if close == 0 { // found close looking for open
-				err = errors.New("unexpected closer")
+			} else if want == 0 { // found close looking for open
+				err = coerr.New(coerr.Syntax, "cts", off,
+					"unexpected closer")
 				if e := dec.h(err); e != nil {
 					return 0, 0, e
 				}
 				dec.b.WriteRune(rune) // just copy and ignore
 
 			} else if br.close { // found wrong close bracket
-				err = errors.New("mismatched closer")
+				err = coerr.New(coerr.Syntax, "cts", off,
+					"mismatched closer")
 				if e := dec.h(err); e != nil {
 					return 0, 0, e
 				}
@@ -147,14 +171,7 @@ func (dec *Decoder) toBracket(close rune) (rune, rune, error) {
 
 			} else { // start of nested bracketed string
 				dec.b.WriteRune(rune) // copy the open bracket
-
-				// recursively scan to the matching closer
-				_, _, err = dec.toBracket(br.other)
-				if err != nil {
-					return 0, 0, err
-				}
-
-				dec.b.WriteRune(br.other) // copy close bracket
+				nest = append(nest, br.other)
 			}
 
 		} else { // this rune isn't a bracket
@@ -2,9 +2,12 @@ package cts
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"strings"
 	"testing"
+
+	"github.com/bford/cofo/coerr"
 )
 
 type decodeTest struct {
@@ -61,3 +64,140 @@ func TestDecode(t *testing.T) {
 		// XXX check remainder
 	}
 }
+
+func TestXML(t *testing.T) {
+	c := Config{Brackets: AsciiBrackets}
+	for _, x := range []struct{ cts, xml string }{
+		{"", "<cts></cts>"},
+		{"plain text", "<cts>plain text</cts>"},
+		{"a[b(c)]d", `<cts>a<b class="[">b<b class="(">c</b></b>d</cts>`},
+		{"<&>{}", `<cts>&lt;&amp;&gt;<b class="{"></b></cts>`},
+	} {
+		nodes, err := c.Parse(strings.NewReader(x.cts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteXML(&buf, nodes); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != x.xml {
+			t.Errorf("%q converted to %q, want %q", x.cts, buf.String(), x.xml)
+		}
+
+		nodes, err = c.ReadXML(strings.NewReader(x.xml))
+		if err != nil {
+			t.Fatalf("ReadXML %q: %v", x.xml, err)
+		}
+		buf.Reset()
+		if err := c.Format(&buf, nodes); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != x.cts {
+			t.Errorf("%q converted back to %q", x.xml, buf.String())
+		}
+	}
+
+	// Comments and declarations are ignored
+	nodes, err := c.ReadXML(strings.NewReader(
+		"<?xml version=\"1.0\"?>\n<cts>a<!-- c -->b</cts>\n"))
+	if err != nil || len(nodes) != 1 || nodes[0].Text != "ab" {
+		t.Errorf("ReadXML gave %v, %v", nodes, err)
+	}
+
+	for _, s := range []string{"a]", "[a)", "[a"} {
+		if _, err := c.Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("parsed invalid CTS %q", s)
+		}
+	}
+	for _, s := range []string{
+		"<cts>a]</cts>", `<cts><b class="]"></b></cts>`,
+		`<cts><b class="[[">x</b></cts>`, `<cts><i>x</i></cts>`,
+		`<cts><b class="[" id="x"></b></cts>`, `<doc></doc>`,
+		"<cts></cts><cts></cts>", "<cts>",
+	} {
+		if _, err := c.ReadXML(strings.NewReader(s)); err == nil {
+			t.Errorf("read invalid XML %q", s)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := Config{Brackets: AsciiBrackets}
+	for _, e := range []struct {
+		in   string
+		kind coerr.Kind
+		off  int64
+	}{
+		{"ab]", coerr.Syntax, 2},
+		{"é[a)", coerr.Syntax, 4},
+		{"[ab", coerr.Truncated, 3},
+	} {
+		_, _, _, _, err := c.NewDecoder(strings.NewReader(e.in)).Decode()
+		var ce *coerr.Error
+		if !errors.As(err, &ce) || ce.Kind != e.kind || ce.Offset != e.off {
+			t.Errorf("Decode %q gave %v", e.in, err)
+		}
+		_, err = c.Parse(strings.NewReader(e.in))
+		if !errors.As(err, &ce) || ce.Kind != e.kind || ce.Offset != e.off {
+			t.Errorf("Parse %q gave %v", e.in, err)
+		}
+		err = c.Copy(io.Discard, strings.NewReader(e.in))
+		if !errors.As(err, &ce) || ce.Kind != e.kind || ce.Offset != e.off {
+			t.Errorf("Copy %q gave %v", e.in, err)
+		}
+	}
+
+	// Copy reproduces well-formed text and text with tolerated closers
+	in := "a(b[c]{d})e" + strings.Repeat("[", 1e5) + strings.Repeat("]", 1e5)
+	var out strings.Builder
+	if err := c.Copy(&out, strings.NewReader(in)); err != nil ||
+		out.String() != in {
+		t.Errorf("Copy gave %v", err)
+	}
+	c.HandleError = func(error) error { return nil }
+	out.Reset()
+	if err := c.Copy(&out, strings.NewReader("a]b(c]d)")); err != nil ||
+		out.String() != "a]b(c]d)" {
+		t.Errorf("tolerant Copy gave %q, %v", out.String(), err)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	c := &Config{Brackets: AsciiBrackets + "〈〉"}
+	for _, s := range []string{
+		"", "plain text", "a[b(c){d}]〈e〉",
+		`<b>bold</b> & "quotes" 'too'`,
+		"*emph* _u_ `code` # h \\ | ~x~ ![img](u) 1. 2) +-",
+		"line one\nline two\n",
+	} {
+		h := c.EscapeHTML(s)
+		if strings.ContainsAny(h, "<>\"'[](){}〈〉") {
+			t.Errorf("EscapeHTML(%q) = %q", s, h)
+		}
+		if u := UnescapeHTML(h); u != s {
+			t.Errorf("HTML round trip of %q gave %q", s, u)
+		}
+
+		m := c.EscapeMarkdown(s)
+		if u := UnescapeMarkdown(m); u != s {
+			t.Errorf("Markdown round trip of %q gave %q via %q", s, u, m)
+		}
+
+		f := FenceMarkdown(s)
+		if u, ok := UnfenceMarkdown(f); !ok || u != s {
+			t.Errorf("fence round trip of %q gave %q, %v via %q", s, u,
+				ok, f)
+		}
+	}
+
+	if m := c.EscapeMarkdown("a[b]〈c〉"); m != `a\[b\]〈c〉` {
+		t.Errorf("EscapeMarkdown gave %q", m)
+	}
+	if f := FenceMarkdown("x````y"); !strings.HasPrefix(f, "`````cts\n") {
+		t.Errorf("fence too short: %q", f)
+	}
+	if _, ok := UnfenceMarkdown("```cts\nunterminated"); ok {
+		t.Error("unfenced an unterminated block")
+	}
+}
@@ -0,0 +1,123 @@
+package cts
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Escape text s for embedding in HTML,
+// replacing the markup-significant characters <, >, &, ', and "
+// with character references as html.EscapeString does,
+// and likewise the Config's sensitive brackets,
+// so that wiki and template engines interpreting brackets
+// leave the CTS structure intact.
+// UnescapeHTML recovers s.
+func (c *Config) EscapeHTML(s string) string {
+	p := newPairs(c.Brackets)
+	var b strings.Builder
+	for _, r := range s {
+		if _, ok := p[r]; ok {
+			b.WriteString("&#" + strconv.Itoa(int(r)) + ";")
+			continue
+		}
+		switch r {
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '&':
+			b.WriteString("&amp;")
+		case '\'':
+			b.WriteString("&#39;")
+		case '"':
+			b.WriteString("&#34;")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Unescape text embedded in HTML, replacing all character references.
+func UnescapeHTML(s string) string {
+	return html.UnescapeString(s)
+}
+
+// ASCII punctuation with inline meaning in Markdown or embedded HTML,
+// all of which CommonMark allows to be escaped with a backslash.
+const markdownSpecial = "\\`*_{}[]()<>#+-.!|~&"
+
+// Escape text s for embedding as inline Markdown text,
+// preceding each character with Markdown meaning
+// and each ASCII sensitive bracket with a backslash.
+// UnescapeMarkdown recovers s.
+// Markdown renderers may still reflow whitespace and line breaks;
+// use FenceMarkdown to embed multi-line text verbatim.
+func (c *Config) EscapeMarkdown(s string) string {
+	p := newPairs(c.Brackets)
+	var b strings.Builder
+	for _, r := range s {
+		if _, ok := p[r]; (ok && r < 0x80) ||
+			strings.ContainsRune(markdownSpecial, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Unescape inline Markdown text,
+// removing the backslash from each backslash-escaped ASCII punctuation
+// character as CommonMark does.
+func UnescapeMarkdown(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isASCIIPunct(c byte) bool {
+	return (c >= '!' && c <= '/') || (c >= ':' && c <= '@') ||
+		(c >= '[' && c <= '`') || (c >= '{' && c <= '~')
+}
+
+// Returns text s as a fenced Markdown code block with info string "cts",
+// which renders s verbatim.
+// The fence is a run of backticks longer than any within s.
+// UnfenceMarkdown recovers s.
+func FenceMarkdown(s string) string {
+	n, run := 3, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '`' {
+			if run++; run >= n {
+				n = run + 1
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", n)
+	return fence + "cts\n" + s + "\n" + fence + "\n"
+}
+
+// Returns the text within a fenced Markdown code block
+// as produced by FenceMarkdown, or false if block is not one.
+func UnfenceMarkdown(block string) (string, bool) {
+	n := len(block) - len(strings.TrimLeft(block, "`"))
+	if n < 3 {
+		return "", false
+	}
+	fence := block[:n]
+	start := strings.IndexByte(block, '\n') + 1
+	end := len(strings.TrimSuffix(block, "\n")) - len(fence) - 1
+	if start == 0 || end < start ||
+		!strings.HasSuffix(block[:end+1+len(fence)], "\n"+fence) {
+		return "", false
+	}
+	return block[start:end], true
+}
@@ -0,0 +1,372 @@
+package cts
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// Node is one node in a CTS element tree:
+// either a run of text containing no sensitive brackets,
+// or a bracketed element containing a sequence of child nodes.
+type Node struct {
+	Text     string // text content, for text nodes
+	Open     rune   // open bracket of an element, or 0 for a text node
+	Children []Node // element content, for element nodes
+}
+
+// Parse an entire UTF-8 input stream into a CTS element tree.
+// Unexpected and mismatched closers are errors,
+// unless the Config's HandleError function tolerates them,
+// in which case they are treated as text.
+func (c *Config) Parse(r io.Reader) ([]Node, error) {
+	h := c.HandleError
+	if h == nil {
+		h = func(e error) error { return e }
+	}
+	p := &parser{r: bufio.NewReader(r), p: newPairs(c.Brackets), h: h}
+	return p.parse()
+}
+
+type parser struct {
+	r *bufio.Reader
+	p pairs
+	h func(error) error
+	b strings.Builder
+	o int64 // byte offset of the next input rune
+}
+
+// Parse nodes to EOF.
+// Keeps an explicit stack of the elements being parsed
+// rather than recursing, so that deep nesting cannot exhaust the stack.
+func (p *parser) parse() ([]Node, error) {
+	type frame struct {
+		open, close rune // brackets of the element, or 0 at top level
+		nodes       []Node
+	}
+	stack := []frame{{nodes: []Node{}}}
+	text := func() {
+		if p.b.Len() > 0 {
+			top := &stack[len(stack)-1]
+			top.nodes = append(top.nodes, Node{Text: p.b.String()})
+			p.b.Reset()
+		}
+	}
+	for {
+		r, size, err := p.r.ReadRune()
+		if err == io.EOF && len(stack) == 1 {
+			text()
+			return stack[0].nodes, nil
+		} else if err == io.EOF {
+			return nil, coerr.Wrap(coerr.Truncated, "cts", p.o,
+				io.ErrUnexpectedEOF)
+		} else if err != nil {
+			return nil, err
+		}
+		off := p.o
+		p.o += int64(size)
+
+		top := &stack[len(stack)-1]
+		br, ok := p.p[r]
+		switch {
+		case !ok:
+			p.b.WriteRune(r)
+
+		case r == top.close && top.close != 0: // end of element
+			text()
+			n := Node{Open: top.open, Children: top.nodes}
+			stack = stack[:len(stack)-1]
+			parent := &stack[len(stack)-1]
+			parent.nodes = append(parent.nodes, n)
+
+		case !br.close: // nested element
+			text()
+			stack = append(stack, frame{open: r, close: br.other,
+				nodes: []Node{}})
+
+		default: // unexpected or mismatched closer
+			err = coerr.New(coerr.Syntax, "cts", off, "unexpected closer")
+			if top.close != 0 {
+				err = coerr.New(coerr.Syntax, "cts", off,
+					"mismatched closer")
+			}
+			if e := p.h(err); e != nil {
+				return nil, e
+			}
+			p.b.WriteRune(r) // just copy and ignore
+		}
+	}
+}
+
+// Write a CTS element tree to w as UTF-8 text.
+// Returns an error if an element's open bracket is not sensitive
+// or a text node contains a sensitive bracket,
+// either of which would change the tree's structure.
+func (c *Config) Format(w io.Writer, nodes []Node) error {
+	bw := bufio.NewWriter(w)
+	if err := format(bw, newPairs(c.Brackets), nodes); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Copy UTF-8 text from r to w, checking its brackets as Parse does
+// but without building an element tree,
+// so that it uses memory only for a stack of the open elements' closers.
+// Closers that the Config's HandleError function tolerates
+// are copied as text.
+// Output is buffered, and w receives none of it if Copy fails
+// before the first buffer fills.
+func (c *Config) Copy(w io.Writer, r io.Reader) error {
+	h := c.HandleError
+	if h == nil {
+		h = func(e error) error { return e }
+	}
+	p := newPairs(c.Brackets)
+	br, bw := bufio.NewReader(r), bufio.NewWriter(w)
+	var nest []rune // closers awaited for the open elements
+	o := int64(0)   // byte offset of the next input rune
+	for {
+		r, size, err := br.ReadRune()
+		if err == io.EOF && len(nest) == 0 {
+			return bw.Flush()
+		} else if err == io.EOF {
+			return coerr.Wrap(coerr.Truncated, "cts", o,
+				io.ErrUnexpectedEOF)
+		} else if err != nil {
+			return err
+		}
+		off := o
+		o += int64(size)
+
+		if b, ok := p[r]; ok {
+			switch {
+			case len(nest) > 0 && r == nest[len(nest)-1]:
+				nest = nest[:len(nest)-1]
+			case !b.close:
+				nest = append(nest, b.other)
+			default: // unexpected or mismatched closer
+				err = coerr.New(coerr.Syntax, "cts", off,
+					"unexpected closer")
+				if len(nest) > 0 {
+					err = coerr.New(coerr.Syntax, "cts", off,
+						"mismatched closer")
+				}
+				if e := h(err); e != nil {
+					return e
+				}
+			}
+		}
+		if _, err := bw.WriteRune(r); err != nil {
+			return err
+		}
+	}
+}
+
+// Format nodes, keeping an explicit stack of the elements being written
+// like parse, so that deep nesting cannot exhaust the stack.
+func format(w *bufio.Writer, p pairs, nodes []Node) error {
+	type frame struct {
+		nodes []Node // nodes remaining to be written
+		close rune   // closer of the element, or 0 at top level
+	}
+	stack := []frame{{nodes: nodes}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if len(top.nodes) == 0 { // end of element
+			if top.close != 0 {
+				w.WriteRune(top.close)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		n := top.nodes[0]
+		top.nodes = top.nodes[1:]
+		if n.Open == 0 {
+			if strings.IndexFunc(n.Text, func(r rune) bool {
+				_, ok := p[r]
+				return ok
+			}) >= 0 {
+				return errTextBracket
+			}
+			w.WriteString(n.Text)
+			continue
+		}
+		br, ok := p[n.Open]
+		if !ok || br.close {
+			return errElementBracket
+		}
+		w.WriteRune(n.Open)
+		stack = append(stack, frame{nodes: n.Children, close: br.other})
+	}
+	return nil
+}
+
+// Write a CTS element tree as a constrained XML document.
+// The document's root element is named cts.
+// Each CTS element becomes an XML element named b
+// whose class attribute contains the element's open bracket,
+// and text nodes become XML character data.
+// For example, the CTS text "a[b(c)]" yields the XML document
+//
+//	<cts>a<b class="[">b<b class="(">c</b></b></cts>
+//
+func WriteXML(w io.Writer, nodes []Node) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("<cts>")
+	if err := writeXML(bw, nodes); err != nil {
+		return err
+	}
+	bw.WriteString("</cts>")
+	return bw.Flush()
+}
+
+// Write nodes as XML content, keeping an explicit stack like format.
+func writeXML(w *bufio.Writer, nodes []Node) error {
+	stack := [][]Node{nodes} // nodes remaining at each level
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if len(*top) == 0 { // end of element
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				w.WriteString("</b>")
+			}
+			continue
+		}
+		n := (*top)[0]
+		*top = (*top)[1:]
+		if n.Open == 0 {
+			if err := xml.EscapeText(w, []byte(n.Text)); err != nil {
+				return err
+			}
+			continue
+		}
+		w.WriteString(`<b class="`)
+		xml.EscapeText(w, []byte(string(n.Open)))
+		w.WriteString(`">`)
+		stack = append(stack, n.Children)
+	}
+	return nil
+}
+
+// Read a constrained XML document in the form WriteXML produces
+// and return the CTS element tree it represents.
+// Comments, processing instructions, and directives are ignored.
+// Returns an error on any other elements or attributes,
+// on class attributes that are not sensitive open brackets
+// in the Config's bracket configuration,
+// and on character data containing sensitive brackets.
+func (c *Config) ReadXML(r io.Reader) ([]Node, error) {
+	p := newPairs(c.Brackets)
+	d := xml.NewDecoder(r)
+
+	// Find the root element
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Local != "cts" || tok.Name.Space != "" ||
+				len(tok.Attr) != 0 {
+				return nil, errXMLForm
+			}
+			nodes, err := readXML(d, p)
+			if err != nil {
+				return nil, err
+			}
+
+			// Only miscellany may follow the root element
+			for {
+				tok, err := d.Token()
+				if err == io.EOF {
+					return nodes, nil
+				} else if err != nil {
+					return nil, err
+				}
+				switch tok.(type) {
+				case xml.StartElement, xml.CharData:
+					if cd, ok := tok.(xml.CharData); ok &&
+						len(strings.TrimSpace(string(cd))) == 0 {
+						continue
+					}
+					return nil, errXMLForm
+				}
+			}
+		case xml.CharData:
+			if len(strings.TrimSpace(string(tok))) != 0 {
+				return nil, errXMLForm
+			}
+		}
+	}
+}
+
+// Read XML content up to the end of the current element,
+// keeping an explicit stack of the elements being read like parse.
+func readXML(d *xml.Decoder, p pairs) ([]Node, error) {
+	type frame struct {
+		open  rune // open bracket of the element, or 0 at top level
+		nodes []Node
+	}
+	stack := []frame{{nodes: []Node{}}}
+	for {
+		top := &stack[len(stack)-1]
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return top.nodes, nil
+			}
+			n := Node{Open: top.open, Children: top.nodes}
+			stack = stack[:len(stack)-1]
+			parent := &stack[len(stack)-1]
+			parent.nodes = append(parent.nodes, n)
+
+		case xml.CharData:
+			s := string(tok)
+			if strings.IndexFunc(s, func(r rune) bool {
+				_, ok := p[r]
+				return ok
+			}) >= 0 {
+				return nil, errTextBracket
+			}
+			// Merge with adjacent text split by comments and the like
+			l := len(top.nodes)
+			if l > 0 && top.nodes[l-1].Open == 0 {
+				top.nodes[l-1].Text += s
+			} else {
+				top.nodes = append(top.nodes, Node{Text: s})
+			}
+
+		case xml.StartElement:
+			if tok.Name.Local != "b" || tok.Name.Space != "" ||
+				len(tok.Attr) != 1 ||
+				tok.Attr[0].Name.Local != "class" ||
+				tok.Attr[0].Name.Space != "" {
+				return nil, errXMLForm
+			}
+			class := tok.Attr[0].Value
+			open, size := utf8.DecodeRuneInString(class)
+			if br, ok := p[open]; !ok || br.close || size != len(class) {
+				return nil, errElementBracket
+			}
+			stack = append(stack, frame{open: open, nodes: []Node{}})
+		}
+	}
+}
+
+var errTextBracket = errors.New("text contains a sensitive bracket")
+var errElementBracket = errors.New("element bracket is not a sensitive open bracket")
+var errXMLForm = errors.New("XML document not in CTS element form")
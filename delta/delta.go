@@ -0,0 +1,191 @@
+// Package delta computes and applies compact patches
+// between two streams of CBE-encoded blobs.
+//
+// A patch describes a target stream in terms of an old stream
+// as a sequence of operations, each of which either copies
+// a run of consecutive blobs from the old stream,
+// or inserts a new blob.
+// A patch is itself a stream of CBE-encoded blobs:
+// each copy operation is an integer blob containing 1
+// followed by integer blobs for the index of the first old blob and the count,
+// and each insert operation is an integer blob containing 2
+// followed by the blob to insert.
+//
+// Blobs are compared by content as a whole,
+// so patches are compact when the two streams share many identical blobs,
+// as in successive versions of an archive or log.
+// Both Diff and Apply hold the old stream's blobs in memory.
+//
+// Early unstable prototype code.
+//
+package delta
+
+import (
+	"errors"
+	"io"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/coerr"
+)
+
+// Patch operation codes.
+const (
+	opCopy   = 1
+	opInsert = 2
+)
+
+// Read all blobs from a stream.
+func readAll(r io.Reader) ([][]byte, error) {
+	var blobs [][]byte
+	dec := cbe.NewDecoder(r)
+	for {
+		b, err := dec.Bytes()
+		if err == io.EOF {
+			return blobs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+}
+
+// Compare the blob streams old and new
+// and write a patch transforming old into new to patch.
+func Diff(patch io.Writer, old, new io.Reader) error {
+	oblobs, err := readAll(old)
+	if err != nil {
+		return err
+	}
+
+	// Index the first occurrence of each distinct old blob
+	first := make(map[string]int)
+	for i := len(oblobs) - 1; i >= 0; i-- {
+		first[string(oblobs[i])] = i
+	}
+
+	enc := cbe.NewEncoder(patch)
+	start, count := 0, 0 // pending copy run
+	flush := func() error {
+		if count == 0 {
+			return nil
+		}
+		enc.Uint64(opCopy)
+		enc.Uint64(uint64(start))
+		err := enc.Uint64(uint64(count))
+		count = 0
+		return err
+	}
+
+	dec := cbe.NewDecoder(new)
+	for {
+		b, err := dec.Bytes()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// Extend the pending copy run if possible
+		next := start + count
+		if count > 0 && next < len(oblobs) &&
+			string(oblobs[next]) == string(b) {
+			count++
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+
+		// Otherwise start a new copy run or insert the blob
+		if i, ok := first[string(b)]; ok {
+			start, count = i, 1
+			continue
+		}
+		if err := enc.Uint64(opInsert); err != nil {
+			return err
+		}
+		if err := enc.Bytes(b); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// Apply patch to the blob stream old,
+// writing the resulting blob stream to dst.
+// Returns a Truncated error if the patch ends in the middle of an operation.
+func Apply(dst io.Writer, old, patch io.Reader) error {
+	oblobs, err := readAll(old)
+	if err != nil {
+		return err
+	}
+
+	enc := cbe.NewEncoder(dst)
+	cr := &countReader{r: patch}
+	dec := cbe.NewDecoder(cr)
+
+	// An operand missing at the end of the patch truncates its operation
+	truncated := func(err error) error {
+		if err == io.EOF {
+			return coerr.Wrap(coerr.Truncated, "delta", cr.n,
+				io.ErrUnexpectedEOF)
+		}
+		return err
+	}
+	for {
+		op, err := dec.Uint64()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch op {
+		case opCopy:
+			start, err := dec.Uint64()
+			if err != nil {
+				return truncated(err)
+			}
+			count, err := dec.Uint64()
+			if err != nil {
+				return truncated(err)
+			}
+			if start > uint64(len(oblobs)) ||
+				count > uint64(len(oblobs))-start {
+				return errRange
+			}
+			for _, b := range oblobs[start : start+count] {
+				if err := enc.Bytes(b); err != nil {
+					return err
+				}
+			}
+
+		case opInsert:
+			b, err := dec.Bytes()
+			if err != nil {
+				return truncated(err)
+			}
+			if err := enc.Bytes(b); err != nil {
+				return err
+			}
+
+		default:
+			return errOp
+		}
+	}
+}
+
+// countReader counts the bytes read through it.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+var errRange = errors.New("patch copies blobs beyond end of old stream")
+var errOp = errors.New("unknown patch operation")
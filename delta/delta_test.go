@@ -0,0 +1,94 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/coerr"
+)
+
+// Encode strings as a blob stream.
+func stream(strs ...string) []byte {
+	var b []byte
+	for _, s := range strs {
+		b = cbe.Encode(b, []byte(s))
+	}
+	return b
+}
+
+func TestDiffApply(t *testing.T) {
+	cases := [][2][]string{
+		{{}, {}},
+		{{"a", "b", "c"}, {}},
+		{{}, {"a", "b", "c"}},
+		{{"a", "b", "c"}, {"a", "b", "c"}},
+		{{"a", "b", "c", "d"}, {"a", "x", "c", "d", "a", "b"}},
+		{{"a", "a", "b"}, {"b", "a", "a", "a", "new"}},
+	}
+	for i, c := range cases {
+		old, new := stream(c[0]...), stream(c[1]...)
+		var patch, out bytes.Buffer
+		if err := Diff(&patch, bytes.NewReader(old),
+			bytes.NewReader(new)); err != nil {
+			t.Fatal(err)
+		}
+		if err := Apply(&out, bytes.NewReader(old),
+			bytes.NewReader(patch.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), new) {
+			t.Errorf("case %v: patch did not reproduce target", i)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	var strs []string
+	for i := 0; i < 1000; i++ {
+		strs = append(strs, string(rune('A'+i%50))+"some blob content")
+	}
+	old := stream(strs...)
+	strs[500] = "changed"
+	new := stream(strs...)
+
+	var patch bytes.Buffer
+	if err := Diff(&patch, bytes.NewReader(old),
+		bytes.NewReader(new)); err != nil {
+		t.Fatal(err)
+	}
+	if patch.Len() > 30 {
+		t.Errorf("patch of one changed blob is %v bytes", patch.Len())
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	old := stream("a", "b")
+	var full bytes.Buffer
+	var ends []int // patch lengths ending between blobs mid-operation
+	enc := cbe.NewEncoder(&full)
+	enc.Uint64(opInsert)
+	ends = append(ends, full.Len())
+	enc.String("x")
+	enc.Uint64(opCopy)
+	ends = append(ends, full.Len())
+	enc.Uint64(0)
+	ends = append(ends, full.Len())
+	enc.Uint64(2)
+	for _, n := range ends {
+		patch := full.Bytes()[:n]
+		err := Apply(io.Discard, bytes.NewReader(old),
+			bytes.NewReader(patch))
+		var ce *coerr.Error
+		if !errors.Is(err, coerr.Truncated) || !errors.As(err, &ce) ||
+			ce.Offset != int64(n) {
+			t.Errorf("patch of %d bytes gave %v", n, err)
+		}
+	}
+	if err := Apply(io.Discard, bytes.NewReader(old),
+		bytes.NewReader(full.Bytes())); err != nil {
+		t.Errorf("full patch gave %v", err)
+	}
+}
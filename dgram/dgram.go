@@ -0,0 +1,295 @@
+// Package dgram implements a CBE encoding profile for datagram transports
+// such as UDP or QUIC datagrams,
+// for real-time protocols that cannot use a reliable byte stream.
+//
+// A datagram consists of one or more frames, each three CBE blobs:
+//
+//	id        unsigned integer identifying the blob being carried
+//	fragment  unsigned integer 2*index+last,
+//	          where index is the fragment's position within the blob
+//	          and last is 1 if this is the blob's final fragment
+//	data      the fragment's content
+//
+// A Packer packs several small blobs into each datagram,
+// and splits blobs too large for one datagram into fragments
+// bounded by the datagram size limit.
+// A Reassembler collects the frames of received datagrams,
+// which may arrive out of order, duplicated, or not at all,
+// and delivers each blob whose fragments have all arrived, exactly once.
+//
+// Early unstable prototype code.
+//
+package dgram
+
+import (
+	"errors"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Default maximum datagram size,
+// the minimum that QUIC requires every path to support.
+const DefaultMTU = 1200
+
+// Minimum datagram size a Packer supports.
+const MinMTU = 32
+
+// Default number of recent blob IDs a Reassembler tracks.
+const DefaultWindow = 256
+
+// Maximum number of fragments in a blob a Reassembler accepts.
+const maxFragments = 1 << 16
+
+// Maximum number of fragments and bytes of fragment content
+// a Reassembler holds for incomplete blobs.
+const (
+	maxPendingFragments = 1 << 16
+	maxPendingBytes     = 16 << 20
+)
+
+// Packer packs blobs into datagrams.
+type Packer struct {
+	mtu  int
+	send func(datagram []byte) error
+	id   uint64 // ID of the next blob
+	buf  []byte // datagram under construction
+}
+
+// Create a Packer that calls send with each datagram of at most mtu bytes,
+// or DefaultMTU if mtu is zero.
+// The datagram buffer passed to send is reused after send returns.
+// Panics if mtu is less than MinMTU.
+func NewPacker(mtu int, send func(datagram []byte) error) *Packer {
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	if mtu < MinMTU {
+		panic("datagram size too small")
+	}
+	return &Packer{mtu: mtu, send: send, buf: make([]byte, 0, mtu)}
+}
+
+// Add a blob, sending datagrams as they fill up.
+// The blob may not be sent until a later Add or Flush.
+func (p *Packer) Add(blob []byte) error {
+	id := p.id
+	p.id++
+	for index := uint64(0); ; {
+		// Space left in this datagram for the fragment blob
+		avail := p.mtu - len(p.buf) - uintLen(id) - uintLen(2*index+1)
+		n := len(blob)
+		if n+headerLen(n) > avail {
+			n = fragmentLen(avail)
+		}
+		if n <= 0 && len(blob) > 0 || avail < headerLen(0) {
+			if len(p.buf) == 0 {
+				return errMTU
+			}
+			if err := p.Flush(); err != nil {
+				return err
+			}
+			continue // retry this fragment in an empty datagram
+		}
+
+		last := uint64(0)
+		if n == len(blob) {
+			last = 1
+		}
+		p.buf = cbe.AppendUint64(p.buf, id)
+		p.buf = cbe.AppendUint64(p.buf, 2*index+last)
+		p.buf = cbe.Encode(p.buf, blob[:n])
+		blob = blob[n:]
+		if last != 0 {
+			return nil
+		}
+		index++
+	}
+}
+
+// Send the datagram under construction, if it is not empty.
+func (p *Packer) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.send(p.buf)
+	p.buf = p.buf[:0]
+	return err
+}
+
+// Returns the encoded length of the unsigned integer blob for v.
+func uintLen(v uint64) int {
+	var buf [9]byte
+	return len(cbe.AppendUint64(buf[:0], v))
+}
+
+// Returns an upper bound on the header length of an n-byte blob.
+func headerLen(n int) int {
+	switch {
+	case n < 64:
+		return 1
+	case n < 16448:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// Returns the largest fragment length whose blob fits in avail bytes.
+func fragmentLen(avail int) int {
+	for _, h := range []int{1, 2, 4} {
+		if n := avail - h; headerLen(n) <= h {
+			return n
+		}
+	}
+	return 0
+}
+
+// Reassembler reassembles blobs from received datagrams.
+type Reassembler struct {
+	window  uint64
+	top     uint64 // highest blob ID seen
+	pending map[uint64]*partial
+	done    map[uint64]bool // recently delivered blob IDs
+	frags   int             // fragments held in pending
+	bytes   int             // bytes of fragment content held in pending
+}
+
+type partial struct {
+	frags map[uint64][]byte // fragments received so far, by index
+	max   uint64            // highest fragment index received
+	last  int64             // index of the last fragment, or -1 if unknown
+	bytes int               // bytes of fragment content received
+}
+
+// Create a Reassembler tracking the window most recent blob IDs,
+// or DefaultWindow if window is zero.
+// Fragments of blobs older than this window are discarded,
+// as are fragments of blobs more than window IDs ahead
+// of the highest blob ID seen so far,
+// so that one forged ID cannot push every pending blob out of the window.
+// The Reassembler holds at most 65536 fragments and 16MiB of content
+// for incomplete blobs, discarding further fragments until blobs complete
+// or leave the window.
+func NewReassembler(window int) *Reassembler {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Reassembler{window: uint64(window),
+		pending: make(map[uint64]*partial),
+		done:    make(map[uint64]bool)}
+}
+
+// Process a received datagram,
+// returning any blobs it completes in the order they complete.
+// Returns an error if the datagram is malformed,
+// in which case the blobs it completed before the error are also returned.
+func (r *Reassembler) Receive(datagram []byte) (blobs [][]byte, err error) {
+	for b := datagram; len(b) > 0; {
+		var id, frag uint64
+		var data []byte
+		if id, b, err = cbe.DecodeUint64(b); err != nil {
+			return blobs, errFrame
+		}
+		if frag, b, err = cbe.DecodeUint64(b); err != nil {
+			return blobs, errFrame
+		}
+		if data, b, err = cbe.Decode(b); err != nil {
+			return blobs, errFrame
+		}
+		if blob := r.add(id, frag, data); blob != nil {
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs, nil
+}
+
+// Add one fragment, returning the blob it completes if any.
+func (r *Reassembler) add(id, frag uint64, data []byte) []byte {
+	if id > r.top {
+		if id-r.top > r.window {
+			return nil // too far ahead
+		}
+		r.advance(id)
+	}
+	if r.top-id >= r.window || r.done[id] {
+		return nil // too old or already delivered
+	}
+	index, last := frag>>1, frag&1 != 0
+	if index >= maxFragments {
+		return nil // unreasonably many fragments
+	}
+
+	p := r.pending[id]
+	if p == nil {
+		if index == 0 && last { // common case: unfragmented blob
+			r.done[id] = true
+			return append([]byte{}, data...)
+		}
+		p = &partial{frags: make(map[uint64][]byte), last: -1}
+		r.pending[id] = p
+	}
+	if last {
+		if p.last >= 0 || p.max > index {
+			return nil // conflicting last fragment
+		}
+		p.last = int64(index)
+	}
+	if p.last >= 0 && int64(index) > p.last {
+		return nil // beyond the last fragment
+	}
+	if _, dup := p.frags[index]; dup {
+		return nil // duplicate fragment
+	}
+	if r.frags >= maxPendingFragments ||
+		r.bytes+len(data) > maxPendingBytes {
+		if len(p.frags) == 0 {
+			delete(r.pending, id)
+		}
+		return nil // too much pending content
+	}
+	p.frags[index] = append([]byte{}, data...)
+	if index > p.max {
+		p.max = index
+	}
+	p.bytes += len(data)
+	r.frags++
+	r.bytes += len(data)
+	if int64(len(p.frags)) != p.last+1 {
+		return nil
+	}
+
+	// All fragments present: concatenate them
+	blob := make([]byte, 0, p.bytes)
+	for i := uint64(0); i < uint64(len(p.frags)); i++ {
+		blob = append(blob, p.frags[i]...)
+	}
+	r.drop(id, p)
+	r.done[id] = true
+	return blob
+}
+
+// Discard the pending fragments of blob id.
+func (r *Reassembler) drop(id uint64, p *partial) {
+	r.frags -= len(p.frags)
+	r.bytes -= p.bytes
+	delete(r.pending, id)
+}
+
+// Advance the window so that top is the highest ID seen,
+// discarding state for blob IDs that fall out of the window.
+func (r *Reassembler) advance(top uint64) {
+	r.top = top
+	for id, p := range r.pending {
+		if top-id >= r.window {
+			r.drop(id, p)
+		}
+	}
+	for id := range r.done {
+		if top-id >= r.window {
+			delete(r.done, id)
+		}
+	}
+}
+
+var errFrame = errors.New("malformed datagram frame")
+var errMTU = errors.New("datagram size too small for frame")
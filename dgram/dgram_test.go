@@ -0,0 +1,148 @@
+package dgram
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+func TestPackReassemble(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var blobs [][]byte
+	for _, n := range []int{0, 1, 5, 63, 64, 200, 1199, 1200, 5000, 40000} {
+		b := make([]byte, n)
+		rnd.Read(b)
+		blobs = append(blobs, b)
+	}
+
+	for _, mtu := range []int{MinMTU, 100, DefaultMTU, 20000} {
+		var dgrams [][]byte
+		p := NewPacker(mtu, func(d []byte) error {
+			if len(d) > mtu {
+				t.Errorf("datagram of %v bytes exceeds MTU %v", len(d), mtu)
+			}
+			dgrams = append(dgrams, append([]byte{}, d...))
+			return nil
+		})
+		for _, b := range blobs {
+			if err := p.Add(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Deliver datagrams shuffled and duplicated
+		all := append(append([][]byte{}, dgrams...), dgrams...)
+		rnd.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+		r := NewReassembler(0)
+		var got [][]byte
+		for _, d := range all {
+			bs, err := r.Receive(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, bs...)
+		}
+		if len(got) != len(blobs) {
+			t.Fatalf("MTU %v: got %v blobs, want %v", mtu, len(got), len(blobs))
+		}
+		for _, b := range blobs {
+			found := false
+			for _, g := range got {
+				found = found || bytes.Equal(g, b)
+			}
+			if !found {
+				t.Errorf("MTU %v: blob of length %v not delivered", mtu, len(b))
+			}
+		}
+	}
+}
+
+func TestSmallBlobsShareDatagram(t *testing.T) {
+	n := 0
+	p := NewPacker(0, func(d []byte) error { n++; return nil })
+	for i := 0; i < 100; i++ {
+		p.Add([]byte("hello"))
+	}
+	p.Flush()
+	if n != 1 {
+		t.Errorf("100 small blobs took %v datagrams", n)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	r := NewReassembler(4)
+	// Fragment 0 of blob 0 arrives, then blob 0 falls out of the window
+	r.Receive([]byte{0, 0, 0x81, 'a'})
+	for id := byte(1); id < 10; id++ {
+		r.Receive([]byte{id, 1, 0x81, 'x'})
+	}
+	if bs, _ := r.Receive([]byte{0, 3, 0x81, 'b'}); len(bs) != 0 {
+		t.Errorf("reassembled stale blob %q", bs)
+	}
+	if len(r.pending) != 0 || len(r.done) > 5 {
+		t.Errorf("window not enforced: %v pending, %v done",
+			len(r.pending), len(r.done))
+	}
+	if _, err := r.Receive([]byte{1, 1}); err == nil {
+		t.Error("accepted truncated frame")
+	}
+}
+
+// frame encodes one datagram frame.
+func frame(id, frag uint64, data []byte) []byte {
+	b := cbe.AppendUint64(nil, id)
+	b = cbe.AppendUint64(b, frag)
+	return cbe.Encode(b, data)
+}
+
+func TestHostileFrames(t *testing.T) {
+	r := NewReassembler(4)
+	r.Receive(frame(2, 0, []byte("a")))
+
+	// IDs far ahead, including near 2^64, neither advance the window
+	// nor evict the pending blob
+	for _, id := range []uint64{7, 1 << 40, math.MaxUint64} {
+		if bs, _ := r.Receive(frame(id, 1, []byte("x"))); len(bs) != 0 {
+			t.Errorf("delivered blob %v beyond the window", id)
+		}
+	}
+	if r.top != 2 || len(r.pending) != 1 {
+		t.Errorf("far-ahead IDs moved the window to %v", r.top)
+	}
+	if bs, _ := r.Receive(frame(2, 3, []byte("b"))); len(bs) != 1 ||
+		string(bs[0]) != "ab" {
+		t.Errorf("pending blob gave %q", bs)
+	}
+
+	// Fragment indexes beyond a known last fragment are rejected,
+	// and high indexes take no more space than low ones
+	r.Receive(frame(3, 2*2+1, nil))
+	r.Receive(frame(3, 2*9, []byte("x")))
+	r.Receive(frame(4, 2*(maxFragments-1), []byte("x")))
+	if r.frags != 2 || len(r.pending[3].frags) != 1 {
+		t.Errorf("holding %v fragments", r.frags)
+	}
+
+	// Pending content is bounded in total
+	r = NewReassembler(0)
+	big := make([]byte, 1000)
+	for id := uint64(0); id < DefaultWindow; id++ {
+		for i := uint64(0); i < 200; i++ {
+			r.Receive(frame(id, 2*i, big))
+		}
+	}
+	if r.frags > maxPendingFragments || r.bytes > maxPendingBytes {
+		t.Errorf("holding %v fragments, %v bytes", r.frags, r.bytes)
+	}
+	r.Receive(frame(2*DefaultWindow-1, 1, nil))
+	if r.frags != 0 || r.bytes != 0 || len(r.pending) != 0 {
+		t.Errorf("left %v fragments, %v bytes after the window moved",
+			r.frags, r.bytes)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/bford/cofo
+
+go 1.22
@@ -0,0 +1,44 @@
+// Package grpccodec provides a gRPC codec that marshals messages using CBE.
+//
+// The Codec type implements the encoding.Codec interface
+// of the google.golang.org/grpc/encoding package,
+// without this package depending on gRPC itself.
+// To make the codec available to a gRPC client or server, register it:
+//
+//	encoding.RegisterCodec(grpccodec.Codec{})
+//
+// then select it with the grpc.CallContentSubtype("cbe") call option
+// or the grpc.ForceServerCodec server option.
+//
+// Messages are marshaled via cbe.Marshal and cbe.Unmarshal,
+// so message types should implement cbe.Marshaler and cbe.Unmarshaler,
+// or encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+//
+// Early unstable prototype code.
+//
+package grpccodec
+
+import (
+	"github.com/bford/cofo/cbe"
+)
+
+// Name under which the codec registers, used as the gRPC content subtype.
+const Name = "cbe"
+
+// Codec is a gRPC codec that marshals messages using CBE.
+type Codec struct{}
+
+// Marshal a message into its CBE encoding.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return cbe.Marshal(v)
+}
+
+// Unmarshal a CBE-encoded message into v, which must be a pointer.
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return cbe.Unmarshal(data, v)
+}
+
+// Returns the codec's name, "cbe".
+func (Codec) Name() string {
+	return Name
+}
@@ -0,0 +1,54 @@
+package grpccodec
+
+import (
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// The method set gRPC's encoding.Codec interface requires.
+type grpcCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	Name() string
+}
+
+var _ grpcCodec = Codec{}
+
+// A test message with a custom CBE encoding.
+type point struct {
+	x, y int64
+}
+
+func (p *point) MarshalCBE(e *cbe.Encoder) error {
+	if err := e.Int64(p.x); err != nil {
+		return err
+	}
+	return e.Int64(p.y)
+}
+
+func (p *point) UnmarshalCBE(d *cbe.Decoder) (err error) {
+	if p.x, err = d.Int64(); err != nil {
+		return err
+	}
+	p.y, err = d.Int64()
+	return err
+}
+
+func TestCodec(t *testing.T) {
+	c := Codec{}
+	b, err := c.Marshal(&point{3, -4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p point
+	if err := c.Unmarshal(b, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{3, -4}) {
+		t.Errorf("got %v", p)
+	}
+	if c.Name() != "cbe" {
+		t.Errorf("wrong codec name %q", c.Name())
+	}
+}
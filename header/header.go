@@ -0,0 +1,138 @@
+// Package header defines a small convention for versioning
+// protocols and file formats built on CBE,
+// so that they can evolve without breaking old readers.
+//
+// A header is a single CBE blob at the start of a stream or file,
+// whose content is a sequence of integer blobs:
+//
+//	version   format version number
+//	required  bit mask of features the reader must understand
+//	optional  bit mask of features the reader may safely ignore
+//
+// Readers ignore any further blobs within the header's content,
+// which later versions of this convention may define.
+// A reader rejects a header whose version it does not support,
+// or that requires a feature the reader does not know;
+// it proceeds normally if only unknown optional features are present.
+//
+// Files on disk may additionally begin with a magic prefix
+// identifying their format, so that tools can refuse
+// wrong-format inputs before reading any further.
+//
+// Early unstable prototype code.
+//
+package header
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Header describes the version and features used by a stream or file.
+type Header struct {
+	Version  uint64 // format version
+	Required uint64 // features readers must understand
+	Optional uint64 // features readers may ignore
+}
+
+// Append the header's encoding to dst.
+func (h *Header) Append(dst []byte) []byte {
+	var buf [27]byte
+	return cbe.Encode(dst, h.content(buf[:0]))
+}
+
+// Encode the header to e.
+func (h *Header) Encode(e *cbe.Encoder) error {
+	var buf [27]byte
+	return e.Bytes(h.content(buf[:0]))
+}
+
+// Append the header blob's content to dst.
+func (h *Header) content(dst []byte) []byte {
+	dst = cbe.AppendUint64(dst, h.Version)
+	dst = cbe.AppendUint64(dst, h.Required)
+	return cbe.AppendUint64(dst, h.Optional)
+}
+
+// Parse a header from the start of b,
+// returning it and the remainder of b following the header.
+func Parse(b []byte) (h *Header, rest []byte, err error) {
+	content, rest, err := cbe.Decode(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err = parseContent(content)
+	return h, rest, err
+}
+
+// Decode a header from d.
+func Decode(d *cbe.Decoder) (*Header, error) {
+	content, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return parseContent(content)
+}
+
+func parseContent(b []byte) (*Header, error) {
+	h := &Header{}
+	var err error
+	for _, p := range []*uint64{&h.Version, &h.Required, &h.Optional} {
+		if *p, b, err = cbe.DecodeUint64(b); err != nil {
+			return nil, errHeader
+		}
+	}
+	return h, nil // ignore any extension blobs
+}
+
+// Support describes the versions and features a reader understands.
+type Support struct {
+	MinVersion, MaxVersion uint64 // range of versions supported
+	Features               uint64 // all features understood
+}
+
+// Check whether a reader with this Support can read
+// a stream or file with header h.
+// Returns an error wrapping ErrVersion or ErrFeature if not.
+func (s *Support) Check(h *Header) error {
+	if h.Version < s.MinVersion || h.Version > s.MaxVersion {
+		return fmt.Errorf("%w %v, want %v to %v", ErrVersion,
+			h.Version, s.MinVersion, s.MaxVersion)
+	}
+	if unknown := h.Required &^ s.Features; unknown != 0 {
+		return fmt.Errorf("%w %#x", ErrFeature, unknown)
+	}
+	return nil
+}
+
+// Negotiate the header for a session with a peer that sent offer,
+// listing the highest version it supports and the features it can use.
+// Returns a header with the highest version both ends support,
+// and only the features both ends understand.
+// Returns an error wrapping ErrVersion if there is no common version,
+// or ErrFeature if the peer requires a feature this end does not know.
+func (s *Support) Negotiate(offer *Header) (*Header, error) {
+	v := offer.Version
+	if v > s.MaxVersion {
+		v = s.MaxVersion
+	}
+	if v < s.MinVersion {
+		return nil, fmt.Errorf("%w %v, want %v to %v", ErrVersion,
+			offer.Version, s.MinVersion, s.MaxVersion)
+	}
+	if unknown := offer.Required &^ s.Features; unknown != 0 {
+		return nil, fmt.Errorf("%w %#x", ErrFeature, unknown)
+	}
+	return &Header{Version: v, Required: offer.Required,
+		Optional: offer.Optional & s.Features}, nil
+}
+
+// ErrVersion indicates an unsupported format version.
+var ErrVersion = errors.New("unsupported version")
+
+// ErrFeature indicates that an unknown feature is required.
+var ErrFeature = errors.New("unknown required feature")
+
+var errHeader = errors.New("malformed version header")
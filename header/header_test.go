@@ -0,0 +1,115 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+func TestHeader(t *testing.T) {
+	for _, h := range []Header{
+		{}, {1, 0, 0}, {2, 1, 6}, {1 << 40, ^uint64(0), 1 << 63},
+	} {
+		b := h.Append([]byte("x"))
+		g, rest, err := Parse(b[1:])
+		if err != nil || *g != h || len(rest) != 0 {
+			t.Errorf("Parse %v gave %v, %v, %v", h, g, rest, err)
+		}
+
+		var buf bytes.Buffer
+		if err := h.Encode(cbe.NewEncoder(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), b[1:]) {
+			t.Errorf("Encode and Append differ for %v", h)
+		}
+		g, err = Decode(cbe.NewDecoder(&buf))
+		if err != nil || *g != h {
+			t.Errorf("Decode %v gave %v, %v", h, g, err)
+		}
+	}
+
+	// Old readers must ignore extension blobs in the header
+	content := append((&Header{3, 0, 1}).Append(nil)[1:], 0x83, 'e', 'x', 't')
+	h, _, err := Parse(cbe.Encode(nil, content))
+	if err != nil || *h != (Header{3, 0, 1}) {
+		t.Errorf("extended header parsed as %v, %v", h, err)
+	}
+	if _, _, err := Parse([]byte{0x82, 1, 2}); err == nil {
+		t.Error("parsed header with missing fields")
+	}
+}
+
+func TestSupport(t *testing.T) {
+	s := &Support{MinVersion: 1, MaxVersion: 2, Features: 0x3}
+	for _, c := range []struct {
+		h   Header
+		err error
+	}{
+		{Header{1, 0, 0}, nil},
+		{Header{2, 0x3, 0xf0}, nil}, // unknown optional features are fine
+		{Header{0, 0, 0}, ErrVersion},
+		{Header{3, 0, 0}, ErrVersion},
+		{Header{2, 0x4, 0}, ErrFeature},
+	} {
+		if err := s.Check(&c.h); !errors.Is(err, c.err) ||
+			(c.err == nil && err != nil) {
+			t.Errorf("Check %v gave %v", c.h, err)
+		}
+	}
+
+	h, err := s.Negotiate(&Header{5, 0x1, 0x6})
+	if err != nil || *h != (Header{2, 0x1, 0x2}) {
+		t.Errorf("Negotiate gave %v, %v", h, err)
+	}
+	if _, err := s.Negotiate(&Header{0, 0, 0}); !errors.Is(err, ErrVersion) {
+		t.Errorf("Negotiate with old peer gave %v", err)
+	}
+}
+
+func TestMagic(t *testing.T) {
+	for _, m := range []Magic{MagicCBE, MagicCBS, MagicArchive} {
+		var buf bytes.Buffer
+		if err := m.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		if len(b) != MagicLen || !bytes.Equal(b, m.Append(nil)) {
+			t.Errorf("%v: Write and Append differ", m)
+		}
+		if g, ok := Sniff(b); !ok || g != m {
+			t.Errorf("%v: sniffed %v, %v", m, g, ok)
+		}
+
+		// The prefix is an ordinary CBE blob
+		if content, rest, err := cbe.Decode(b); err != nil ||
+			len(content) != MagicLen-1 || len(rest) != 0 {
+			t.Errorf("%v: not a single blob", m)
+		}
+	}
+
+	// An older file is acceptable, but a newer or different one is not
+	old := Magic{MagicCBE.Format, 0}
+	for _, c := range []struct {
+		in  []byte
+		err error
+	}{
+		{MagicCBE.Append(nil), nil},
+		{old.Append(nil), nil},
+		{Magic{MagicCBE.Format, 2}.Append(nil), ErrVersion},
+		{MagicCBS.Append(nil), ErrMagic},
+		{[]byte("\x87CBE\n\n\x1a\x01"), ErrMagic}, // mangled line ending
+		{MagicCBE.Append(nil)[:5], ErrMagic},
+		{[]byte("plain text"), ErrMagic},
+	} {
+		r := bytes.NewReader(append(c.in, "data"...))
+		if _, err := MagicCBE.Expect(r); !errors.Is(err, c.err) {
+			t.Errorf("Expect(%q) gave %v, want %v", c.in, err, c.err)
+		}
+	}
+	if s := MagicArchive.String(); s != "CBA/1" {
+		t.Errorf("String gave %q", s)
+	}
+}
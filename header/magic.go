@@ -0,0 +1,98 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A magic prefix optionally identifies the format of a file on disk,
+// ahead of any version header.
+// It is an 8-byte CBE blob whose 7 content bytes consist of
+// a 3-letter format tag, the bytes "\r\n\x1a", and a version byte:
+//
+//	0x87  'C' 'B' 'E'  '\r' '\n' 0x1a  version
+//
+// Because the prefix is itself a well-formed blob,
+// CBE readers unaware of the convention can skip it like any other blob.
+// The leading non-ASCII byte keeps files from being mistaken for text,
+// and the carriage return, line feed, and control-Z bytes
+// reveal files mangled by text-mode transfers, as in PNG signatures.
+
+// Number of bytes in a magic prefix.
+const MagicLen = 8
+
+// Magic identifies a file format and the version of its magic prefix.
+type Magic struct {
+	Format  [3]byte // format tag
+	Version byte    // format version
+}
+
+// Magic prefixes of the formats in this repository.
+var (
+	MagicCBE     = Magic{[3]byte{'C', 'B', 'E'}, 1} // stream of CBE blobs
+	MagicCBS     = Magic{[3]byte{'C', 'B', 'S'}, 1} // stream of CBS blobs
+	MagicArchive = Magic{[3]byte{'C', 'B', 'A'}, 1} // archive of CBE blobs
+)
+
+// Append the magic prefix m to dst.
+func (m Magic) Append(dst []byte) []byte {
+	dst = append(dst, 0x80+MagicLen-1)
+	dst = append(dst, m.Format[:]...)
+	return append(dst, '\r', '\n', 0x1a, m.Version)
+}
+
+// Write the magic prefix m to w.
+func (m Magic) Write(w io.Writer) error {
+	var buf [MagicLen]byte
+	_, err := w.Write(m.Append(buf[:0]))
+	return err
+}
+
+// Read a magic prefix from r and check that it identifies m's format
+// at a version no later than m's, returning the prefix read.
+// Returns an error wrapping ErrMagic if r does not begin with
+// a magic prefix for m's format, or ErrVersion if its version is later.
+func (m Magic) Expect(r io.Reader) (Magic, error) {
+	var buf [MagicLen]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return Magic{}, err
+		}
+		return Magic{}, fmt.Errorf("%w: file too short", ErrMagic)
+	}
+	got, ok := Sniff(buf[:])
+	if !ok {
+		return Magic{}, fmt.Errorf("%w: no %s prefix", ErrMagic, m.Format)
+	}
+	if got.Format != m.Format {
+		return got, fmt.Errorf("%w: %s file, want %s", ErrMagic,
+			got.Format, m.Format)
+	}
+	if got.Version > m.Version {
+		return got, fmt.Errorf("%w %v of %s, want at most %v", ErrVersion,
+			got.Version, m.Format, m.Version)
+	}
+	return got, nil
+}
+
+// Sniff the magic prefix at the start of b, if any.
+// The format tag and version need not be among those defined here.
+func Sniff(b []byte) (m Magic, ok bool) {
+	if len(b) < MagicLen || b[0] != 0x80+MagicLen-1 ||
+		!bytes.Equal(b[4:7], []byte("\r\n\x1a")) {
+		return Magic{}, false
+	}
+	copy(m.Format[:], b[1:4])
+	m.Version = b[7]
+	return m, true
+}
+
+// Returns the magic prefix's format tag and version, such as "CBE/1".
+func (m Magic) String() string {
+	return fmt.Sprintf("%s/%d", m.Format[:], m.Version)
+}
+
+// ErrMagic indicates a missing or unexpected magic prefix.
+var ErrMagic = errors.New("wrong file format")
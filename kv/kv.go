@@ -0,0 +1,299 @@
+// Package kv implements a simple immutable key-value file format
+// built on Composable Binary Encoding (CBE).
+//
+// A kv file consists of a sequence of records,
+// each a CBE-encoded key blob followed by a CBE-encoded value blob,
+// in strictly increasing key order.
+// The records are followed by a trailing index blob,
+// which contains the CBE-encoded unsigned integer byte offset of each record,
+// and finally a fixed 8-byte big-endian footer
+// holding the byte offset of the index blob itself.
+//
+// Keys are ordered bytewise, as by bytes.Compare.
+// Applications wanting keys composed of typed values
+// should encode them so that bytewise order matches the desired order.
+//
+// Early unstable prototype code.
+//
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/bford/cofo/cbe"
+)
+
+const footerLen = 8
+
+// A Writer writes a kv file to an underlying output stream.
+// Records must be added in strictly increasing key order.
+type Writer struct {
+	w    countWriter
+	enc  *cbe.Encoder
+	offs []int64
+	last []byte
+	done bool
+}
+
+// Create a new Writer that writes a kv file to w.
+func NewWriter(w io.Writer) *Writer {
+	kw := &Writer{w: countWriter{w: w}}
+	kw.enc = cbe.NewEncoder(&kw.w)
+	return kw
+}
+
+// Add a record to the file.
+// Returns an error if key is not strictly greater than the previous key.
+func (w *Writer) Put(key, value []byte) error {
+	if w.done {
+		return errClosed
+	}
+	if len(w.offs) > 0 && bytes.Compare(key, w.last) <= 0 {
+		return errOrder
+	}
+	w.offs = append(w.offs, w.w.n)
+	w.last = append(w.last[:0], key...)
+
+	if err := w.enc.Bytes(key); err != nil {
+		return err
+	}
+	return w.enc.Bytes(value)
+}
+
+// Finish the file by writing its index and footer.
+// Does not close the underlying output stream.
+func (w *Writer) Close() error {
+	if w.done {
+		return errClosed
+	}
+	w.done = true
+
+	// Build and write the index blob
+	var idx bytes.Buffer
+	ienc := cbe.NewEncoder(&idx)
+	for _, ofs := range w.offs {
+		if err := ienc.Uint64(uint64(ofs)); err != nil {
+			return err
+		}
+	}
+	idxOfs := w.w.n
+	if err := w.enc.Bytes(idx.Bytes()); err != nil {
+		return err
+	}
+
+	// Write the footer locating the index blob
+	var foot [footerLen]byte
+	binary.BigEndian.PutUint64(foot[:], uint64(idxOfs))
+	_, err := w.w.Write(foot[:])
+	return err
+}
+
+// A Reader provides random access to the records in a kv file.
+type Reader struct {
+	r      io.ReaderAt
+	offs   []int64
+	idxOfs int64
+}
+
+// Open a kv file of the given total size for reading from r.
+// Reads the file's index into memory but no records.
+func Open(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < footerLen {
+		return nil, errFormat
+	}
+
+	// Read the footer to locate the index blob
+	var foot [footerLen]byte
+	if _, err := r.ReadAt(foot[:], size-footerLen); err != nil {
+		return nil, err
+	}
+	idxOfs := int64(binary.BigEndian.Uint64(foot[:]))
+	if idxOfs < 0 || idxOfs >= size-footerLen {
+		return nil, errFormat
+	}
+
+	// Read and decode the index blob
+	sr := io.NewSectionReader(r, idxOfs, size-footerLen-idxOfs)
+	idx, err := cbe.NewDecoder(sr).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var offs []int64
+	dec := cbe.NewDecoder(bytes.NewReader(idx))
+	for {
+		ofs, err := dec.Uint64()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if int64(ofs) >= idxOfs ||
+			(len(offs) > 0 && int64(ofs) <= offs[len(offs)-1]) {
+			return nil, errFormat
+		}
+		offs = append(offs, int64(ofs))
+	}
+
+	return &Reader{r: r, offs: offs, idxOfs: idxOfs}, nil
+}
+
+// Returns the number of records in the file.
+func (r *Reader) Len() int {
+	return len(r.offs)
+}
+
+// Returns a decoder positioned at the start of record i.
+func (r *Reader) record(i int) *cbe.Decoder {
+	end := r.idxOfs
+	if i+1 < len(r.offs) {
+		end = r.offs[i+1]
+	}
+	sr := io.NewSectionReader(r.r, r.offs[i], end-r.offs[i])
+	return cbe.NewDecoder(sr)
+}
+
+// Returns the key of record i.
+func (r *Reader) Key(i int) ([]byte, error) {
+	return r.record(i).Bytes()
+}
+
+// Returns the key and value of record i.
+func (r *Reader) At(i int) (key, value []byte, err error) {
+	dec := r.record(i)
+	if key, err = dec.Bytes(); err != nil {
+		return nil, nil, err
+	}
+	if value, err = dec.Bytes(); err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+// Search for the smallest record index whose key is not less than key,
+// using binary search via the file's index.
+// Returns Len() if all keys are less than key.
+func (r *Reader) Search(key []byte) (int, error) {
+	lo, hi := 0, len(r.offs)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		k, err := r.Key(mid)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Compare(k, key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// Look up the value associated with key.
+// Returns ErrNotFound if the file contains no record with that key.
+func (r *Reader) Get(key []byte) ([]byte, error) {
+	i, err := r.Search(key)
+	if err != nil {
+		return nil, err
+	}
+	if i == len(r.offs) {
+		return nil, ErrNotFound
+	}
+	k, v, err := r.At(i)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(k, key) {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+// Merge the records of several kv files into w in key order.
+// When several files contain the same key,
+// the record from the file latest in rs takes precedence.
+// Does not close w.
+func Merge(w *Writer, rs ...*Reader) error {
+	return Compact(w, nil, rs...)
+}
+
+// Merge the records of several kv files into w as in Merge,
+// but omitting any winning record for which keep returns false.
+// This may be used to drop deleted or expired records, for example.
+// If keep is nil, all winning records are kept.
+func Compact(w *Writer, keep func(key, value []byte) bool, rs ...*Reader) error {
+	pos := make([]int, len(rs))
+	keys := make([][]byte, len(rs))
+
+	// Load the current key at each input position
+	load := func(j int) (err error) {
+		keys[j] = nil
+		if pos[j] < rs[j].Len() {
+			keys[j], err = rs[j].Key(pos[j])
+		}
+		return err
+	}
+	for j := range rs {
+		if err := load(j); err != nil {
+			return err
+		}
+	}
+
+	for {
+		// Find the smallest current key, preferring later inputs
+		win := -1
+		for j := range rs {
+			if pos[j] >= rs[j].Len() {
+				continue
+			}
+			if win < 0 || bytes.Compare(keys[j], keys[win]) <= 0 {
+				win = j
+			}
+		}
+		if win < 0 {
+			return nil // all inputs exhausted
+		}
+
+		key, value, err := rs[win].At(pos[win])
+		if err != nil {
+			return err
+		}
+		if keep == nil || keep(key, value) {
+			if err := w.Put(key, value); err != nil {
+				return err
+			}
+		}
+
+		// Advance past this key in every input containing it
+		for j := range rs {
+			if pos[j] < rs[j].Len() && bytes.Equal(keys[j], key) {
+				pos[j]++
+				if err := load(j); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// ErrNotFound is returned by Get when a key is not present in a file.
+var ErrNotFound = errors.New("key not found")
+
+var errOrder = errors.New("keys not in strictly increasing order")
+var errClosed = errors.New("writer already closed")
+var errFormat = errors.New("invalid kv file format")
@@ -0,0 +1,103 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// Write a kv file containing the given key-value pairs and open it.
+func testFile(t *testing.T, kvs ...string) *Reader {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i := 0; i < len(kvs); i += 2 {
+		if err := w.Put([]byte(kvs[i]), []byte(kvs[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestWriteRead(t *testing.T) {
+	var kvs []string
+	for i := 0; i < 300; i++ {
+		kvs = append(kvs, fmt.Sprintf("key%04d", i*2),
+			fmt.Sprintf("value %v", i))
+	}
+	r := testFile(t, kvs...)
+	if r.Len() != 300 {
+		t.Fatalf("wrong record count %v", r.Len())
+	}
+
+	for i := 0; i < 300; i++ {
+		v, err := r.Get([]byte(fmt.Sprintf("key%04d", i*2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v) != fmt.Sprintf("value %v", i) {
+			t.Errorf("wrong value for key %v: %q", i*2, v)
+		}
+		absent := []byte(fmt.Sprintf("key%04d", i*2+1))
+		if _, err := r.Get(absent); err != ErrNotFound {
+			t.Errorf("found absent key %v", i*2+1)
+		}
+	}
+
+	// An empty file should work too
+	if r := testFile(t); r.Len() != 0 {
+		t.Errorf("empty file has %v records", r.Len())
+	}
+}
+
+func TestOrder(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	if err := w.Put([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Put([]byte("a"), nil); err == nil {
+		t.Error("accepted out-of-order key")
+	}
+	if err := w.Put([]byte("b"), nil); err == nil {
+		t.Error("accepted duplicate key")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r1 := testFile(t, "a", "1", "c", "1", "e", "1")
+	r2 := testFile(t, "b", "2", "c", "2", "f", "2")
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	keep := func(key, value []byte) bool { return string(key) != "e" }
+	if err := Compact(w, keep, r1, r2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a", "1", "b", "2", "c", "2", "f", "2"}
+	if r.Len() != len(want)/2 {
+		t.Fatalf("wrong merged record count %v", r.Len())
+	}
+	for i := 0; i < r.Len(); i++ {
+		k, v, err := r.At(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(k) != want[2*i] || string(v) != want[2*i+1] {
+			t.Errorf("record %v is %s=%s", i, k, v)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package mediatype defines proposed media types for the composable formats,
+// sniffs the format of data from its first few bytes,
+// and helps HTTP servers negotiate and route among the formats.
+//
+// Early unstable prototype code.
+//
+package mediatype
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bford/cofo/header"
+)
+
+// Proposed media types for the composable formats.
+const (
+	CBE   = "application/cbe"       // stream of CBE blobs
+	CBS   = "application/cbs"       // stream of CBS blobs
+	Value = "application/cbe-value" // CBE-encoded values of package value
+	CTS   = "text/cts"              // composable text syntax
+	CRI   = "text/cri"              // composable resource identifier
+
+	Text   = "text/plain"               // text with no apparent structure
+	Binary = "application/octet-stream" // unrecognized binary data
+)
+
+// Number of leading bytes Detect examines.
+const SniffLen = 512
+
+// Detect the media type of data beginning with prefix,
+// examining at most SniffLen bytes.
+// Returns the type a magic prefix of package header identifies, if any,
+// CTS for UTF-8 text containing balanced square brackets,
+// Text for other UTF-8 text,
+// CBE for binary data consisting of well-formed blobs,
+// and Binary otherwise.
+// A prefix shorter than SniffLen is taken to be the complete data,
+// so it must end at a blob boundary to be detected as CBE.
+// Every byte string is a valid CBS stream,
+// so Detect returns CBS only for data with a CBS magic prefix.
+func Detect(prefix []byte) string {
+	if len(prefix) > SniffLen {
+		prefix = prefix[:SniffLen]
+	}
+	if m, ok := header.Sniff(prefix); ok {
+		switch m.Format {
+		case header.MagicCBS.Format:
+			return CBS
+		case header.MagicCBE.Format, header.MagicArchive.Format:
+			return CBE
+		}
+	}
+	if isText(prefix) {
+		if hasBrackets(prefix) {
+			return CTS
+		}
+		return Text
+	}
+	if len(prefix) > 0 && isCBE(prefix, len(prefix) == SniffLen) {
+		return CBE
+	}
+	return Binary
+}
+
+// Reports whether b looks like UTF-8 text,
+// allowing for a final character truncated by the end of the prefix.
+func isText(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size <= 1 {
+			return !utf8.FullRune(b) && len(b) < utf8.UTFMax
+		}
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+		b = b[size:]
+	}
+	return true
+}
+
+// Reports whether text b contains square brackets
+// with no unmatched closer.
+func hasBrackets(b []byte) bool {
+	depth, seen := 0, false
+	for _, c := range b {
+		switch c {
+		case '[':
+			depth++
+			seen = true
+		case ']':
+			if depth--; depth < 0 {
+				return false
+			}
+		}
+	}
+	return seen
+}
+
+// Reports whether b is a sequence of well-formed CBE blobs,
+// the last of which may extend beyond b if more data follows.
+func isCBE(b []byte, more bool) bool {
+	for len(b) > 0 {
+		hlen, n := 0, 0
+		switch {
+		case b[0] < 0x80:
+			hlen, n = 0, 1
+		case b[0] != 0x81 && b[0] < 0xc0:
+			hlen, n = 1, int(b[0]-0x80)
+		case len(b) < 2:
+			return more
+		case b[0] == 0x81 && b[1] >= 0x80:
+			hlen, n = 1, 1
+		case b[0] >= 0xc0:
+			hlen, n = 2, 64+int(b[0]&0x3f)<<8+int(b[1])
+		default:
+			return more // large chunk extending beyond the prefix
+		}
+		if hlen+n > len(b) {
+			return more
+		}
+		b = b[hlen+n:]
+	}
+	return true
+}
+
+// Negotiate the media type of a response to r
+// from the offered types in order of the server's preference,
+// using the request's Accept header.
+// Returns the first offer with the highest quality the client accepts,
+// the first offer if the request has no Accept header,
+// or "" if the client accepts none of the offers.
+func Negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// Returns the quality with which an Accept header accepts a media type,
+// using the most specific matching range.
+func quality(accept, mt string) float64 {
+	q, spec := 0.0, -1
+	for _, rng := range strings.Split(accept, ",") {
+		rt, params, err := mime.ParseMediaType(strings.TrimSpace(rng))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch {
+		case rt == mt:
+			s = 2
+		case strings.HasSuffix(rt, "/*") &&
+			strings.HasPrefix(mt, rt[:len(rt)-1]):
+			s = 1
+		case rt == "*/*":
+			s = 0
+		}
+		if s > spec {
+			spec, q = s, 1.0
+			if v, ok := params["q"]; ok {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+	}
+	return q
+}
+
+// Router returns an http.Handler that routes each request
+// to the handler registered for the media type of its body.
+// It uses the request's Content-Type if present and not Binary,
+// and otherwise detects the type from the first bytes of the body,
+// which remain available to the handler.
+// Requests for which no handler is registered
+// go to the handler for "" if present,
+// and otherwise fail with status 415 (Unsupported Media Type).
+func Router(handlers map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mt == "" || mt == Binary {
+			br := bufio.NewReaderSize(r.Body, SniffLen)
+			prefix, _ := br.Peek(SniffLen)
+			mt = Detect(prefix)
+			r.Body = readCloser{br, r.Body}
+		}
+		h := handlers[mt]
+		if h == nil {
+			h = handlers[""]
+		}
+		if h == nil {
+			types := make([]string, 0, len(handlers))
+			for t := range handlers {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+			w.Header().Set("Accept", strings.Join(types, ", "))
+			http.Error(w, "unsupported media type "+mt,
+				http.StatusUnsupportedMediaType)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
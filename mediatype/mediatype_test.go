@@ -0,0 +1,86 @@
+package mediatype
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for _, c := range []struct {
+		in, mt string
+	}{
+		{"hello world\n", Text},
+		{"greeting[hello]", CTS},
+		{"closer] first[", Text},
+		{"héllo", Text},
+		{"h\xc3", Text}, // truncated final character
+		{"\x83abc\x00\x81\xff", CBE},
+		{"\xc0\x05" + strings.Repeat("x", 100), CBE},
+		{"\x85ab", Binary},                                // truncated blob
+		{"\xc3\x00" + strings.Repeat("x", SniffLen), CBE}, // continues
+		{"\x81\x00\x00", Binary},
+		{"\x81\x00\x00" + strings.Repeat("x", SniffLen), CBE},
+		{"\xff\xfe\x00\x00\x10", Binary},
+		{"", Binary},
+		{"\x87CBS\r\n\x1a\x01\xff\xff", CBS},
+		{"\x87CBE\r\n\x1a\x01\x85ab", CBE}, // trusted despite truncation
+	} {
+		if mt := Detect([]byte(c.in)); mt != c.mt {
+			t.Errorf("Detect(%q) = %v, want %v", c.in, mt, c.mt)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, c := range []struct {
+		accept string
+		want   string
+	}{
+		{"", CBE},
+		{"application/json", ""},
+		{"*/*", CBE},
+		{"text/*", CTS},
+		{"application/cbe;q=0.5, text/cts", CTS},
+		{"application/*;q=0.2, application/cbe;q=0", ""},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		if got := Negotiate(r, CBE, CTS); got != c.want {
+			t.Errorf("Accept %q: got %q, want %q", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	echo := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			io.WriteString(w, name+":"+string(b))
+		})
+	}
+	h := Router(map[string]http.Handler{CBE: echo("cbe"), CTS: echo("cts")})
+	for _, c := range []struct {
+		ctype, body, want string
+		status            int
+	}{
+		{"", "a[b]", "cts:a[b]", 200},
+		{Binary, "\x82\xff\x00", "cbe:\x82\xff\x00", 200},
+		{CBE + "; charset=binary", "x", "cbe:x", 200},
+		{"", "plain", "", 415},
+	} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+		if c.ctype != "" {
+			r.Header.Set("Content-Type", c.ctype)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status || (c.status == 200 && w.Body.String() != c.want) {
+			t.Errorf("%q %q: got %v %q", c.ctype, c.body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package mqcodec provides message serializers for message-queue clients
+// that marshal messages using CBE.
+//
+// The Encoder type implements the Encoder interface
+// of the NATS Go client's EncodedConn,
+// and the Serializer and Deserializer types provide the
+// Serialize, Deserialize, DeserializeInto, and Close methods
+// of Kafka serde-style serializers such as those of confluent-kafka-go,
+// all without this package depending on any broker client.
+// To use CBE with a NATS EncodedConn, for example, register the encoder:
+//
+//	nats.RegisterEncoder(mqcodec.Name, mqcodec.Encoder{})
+//
+// then pass mqcodec.Name to nats.NewEncodedConn.
+//
+// Messages are marshaled via cbe.Marshal and cbe.Unmarshal,
+// so message types should implement cbe.Marshaler and cbe.Unmarshaler,
+// or encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+// The subject or topic a message is sent on does not affect its encoding.
+//
+// Early unstable prototype code.
+//
+package mqcodec
+
+import (
+	"errors"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Name under which to register the NATS encoder.
+const Name = "cbe"
+
+// Encoder is a NATS-style encoder that marshals messages using CBE.
+type Encoder struct{}
+
+// Marshal message v, published on subject, into its CBE encoding.
+func (Encoder) Encode(subject string, v interface{}) ([]byte, error) {
+	return cbe.Marshal(v)
+}
+
+// Unmarshal a CBE-encoded message received on subject into vPtr,
+// which must be a pointer.
+func (Encoder) Decode(subject string, data []byte, vPtr interface{}) error {
+	return cbe.Unmarshal(data, vPtr)
+}
+
+// Serializer is a Kafka serde-style serializer
+// that marshals messages using CBE.
+type Serializer struct{}
+
+// Marshal message msg, produced to topic, into its CBE encoding.
+func (Serializer) Serialize(topic string, msg interface{}) ([]byte, error) {
+	return cbe.Marshal(msg)
+}
+
+// Release the serializer's resources, of which there are none.
+func (Serializer) Close() {}
+
+// Deserializer is a Kafka serde-style deserializer
+// that unmarshals CBE-encoded messages.
+type Deserializer struct {
+
+	// New returns a pointer to a new message
+	// into which to unmarshal a payload received on topic.
+	// Deserialize requires it; DeserializeInto does not use it.
+	New func(topic string) interface{}
+}
+
+// Unmarshal a CBE-encoded payload received on topic
+// into a new message obtained from d.New, returning the message.
+func (d Deserializer) Deserialize(topic string, payload []byte) (
+	interface{}, error) {
+
+	if d.New == nil {
+		return nil, errNoNew
+	}
+	msg := d.New(topic)
+	if err := cbe.Unmarshal(payload, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Unmarshal a CBE-encoded payload received on topic into msg,
+// which must be a pointer.
+func (Deserializer) DeserializeInto(topic string, payload []byte,
+	msg interface{}) error {
+
+	return cbe.Unmarshal(payload, msg)
+}
+
+// Release the deserializer's resources, of which there are none.
+func (Deserializer) Close() {}
+
+var errNoNew = errors.New("deserializer has no New function")
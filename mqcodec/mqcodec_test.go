@@ -0,0 +1,92 @@
+package mqcodec
+
+import (
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// The method set of the NATS client's Encoder interface.
+type natsEncoder interface {
+	Encode(subject string, v interface{}) ([]byte, error)
+	Decode(subject string, data []byte, vPtr interface{}) error
+}
+
+// The methods Kafka serde-style serializers and deserializers share.
+type kafkaSerializer interface {
+	Serialize(topic string, msg interface{}) ([]byte, error)
+	Close()
+}
+
+type kafkaDeserializer interface {
+	Deserialize(topic string, payload []byte) (interface{}, error)
+	DeserializeInto(topic string, payload []byte, msg interface{}) error
+	Close()
+}
+
+var _ natsEncoder = Encoder{}
+var _ kafkaSerializer = Serializer{}
+var _ kafkaDeserializer = Deserializer{}
+
+// A test message with a custom CBE encoding.
+type point struct {
+	x, y int64
+}
+
+func (p *point) MarshalCBE(e *cbe.Encoder) error {
+	if err := e.Int64(p.x); err != nil {
+		return err
+	}
+	return e.Int64(p.y)
+}
+
+func (p *point) UnmarshalCBE(d *cbe.Decoder) (err error) {
+	if p.x, err = d.Int64(); err != nil {
+		return err
+	}
+	p.y, err = d.Int64()
+	return err
+}
+
+func TestEncoder(t *testing.T) {
+	b, err := Encoder{}.Encode("points", &point{3, -4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p point
+	if err := (Encoder{}).Decode("points", b, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{3, -4}) {
+		t.Errorf("got %v", p)
+	}
+	var s string
+	if err := (Encoder{}).Decode("points", b, &s); err == nil {
+		t.Error("decoded two blobs into a string")
+	}
+}
+
+func TestSerde(t *testing.T) {
+	b, err := Serializer{}.Serialize("points", &point{5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Deserializer{}).Deserialize("points", b); err != errNoNew {
+		t.Errorf("expected errNoNew, got %v", err)
+	}
+	d := Deserializer{New: func(topic string) interface{} {
+		return new(point)
+	}}
+	msg, err := d.Deserialize("points", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := msg.(*point); !ok || *p != (point{5, 6}) {
+		t.Errorf("got %v", msg)
+	}
+	var p point
+	if err := d.DeserializeInto("points", b, &p); err != nil ||
+		p != (point{5, 6}) {
+		t.Errorf("got %v, %v", p, err)
+	}
+}
@@ -0,0 +1,128 @@
+package mux
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Mux interleaves several logical blob streams over one Encoder,
+// writing each blob as a frame like a Session's:
+// a stream-ID blob followed by the blob itself.
+// Unlike a Session, a Mux has no control frames or flow control,
+// and its stream IDs may be any unsigned integer, including 0.
+// A Mux is safe for concurrent use.
+type Mux struct {
+	mu sync.Mutex // serializes frames
+	e  *cbe.Encoder
+}
+
+// Create a Mux writing frames to e.
+func NewMux(e *cbe.Encoder) *Mux {
+	return &Mux{e: e}
+}
+
+// Write b as a blob on stream id.
+func (m *Mux) Bytes(id uint64, b []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.e.Uint64(id); err != nil {
+		return err
+	}
+	return m.e.Bytes(b)
+}
+
+// Write a blob on stream id with content read from r until EOF,
+// as Encoder.ReadFrom does.
+// Frames of other streams wait until the blob is complete.
+func (m *Mux) ReadFrom(id uint64, r io.Reader) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.e.Uint64(id); err != nil {
+		return 0, err
+	}
+	return m.e.ReadFrom(r)
+}
+
+// Demux routes the blobs read from a Decoder by a Mux
+// to per-stream Decoders.
+// Run reads the frames and delivers each blob to its stream's Decoder,
+// waiting until that stream's reader consumes it,
+// so every stream must be read concurrently
+// for the others to make progress.
+type Demux struct {
+	d       *cbe.Decoder
+	mu      sync.Mutex
+	streams map[uint64]*demuxStream
+}
+
+// demuxStream delivers one stream's blobs through a pipe.
+type demuxStream struct {
+	pw  *io.PipeWriter
+	e   *cbe.Encoder // re-encodes blobs into pw
+	dec *cbe.Decoder // decodes blobs from the pipe's reader
+}
+
+// Create a Demux reading frames from d.
+func NewDemux(d *cbe.Decoder) *Demux {
+	return &Demux{d: d, streams: make(map[uint64]*demuxStream)}
+}
+
+// Return the Decoder yielding the blobs of stream id,
+// which returns io.EOF once Run has reached the end of the input.
+// Obtain the Decoders of all expected streams before calling Run,
+// which fails on a frame for a stream with no Decoder.
+// Stream may also be called concurrently with Run.
+func (dm *Demux) Stream(id uint64) *cbe.Decoder {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	st := dm.streams[id]
+	if st == nil {
+		pr, pw := io.Pipe()
+		st = &demuxStream{pw: pw, e: cbe.NewEncoder(pw),
+			dec: cbe.NewDecoder(pr)}
+		dm.streams[id] = st
+	}
+	return st.dec
+}
+
+// Read frames and deliver their blobs until the end of the input,
+// then close every stream so that its Decoder returns io.EOF.
+// If an error occurs, Run returns it and closes every stream with it.
+// Returns ErrUnknownStream on a frame for a stream
+// whose Decoder has not been obtained with Stream.
+func (dm *Demux) Run() error {
+	err := dm.run()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	for _, st := range dm.streams {
+		st.pw.CloseWithError(err)
+	}
+	return err
+}
+
+func (dm *Demux) run() error {
+	for {
+		id, err := dm.d.Uint64()
+		if err == cbe.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		dm.mu.Lock()
+		st := dm.streams[id]
+		dm.mu.Unlock()
+		if st == nil {
+			return ErrUnknownStream
+		}
+		if _, err := st.e.ReadFrom(dm.d.Reader()); err != nil {
+			return err
+		}
+	}
+}
+
+// ErrUnknownStream is returned by Demux.Run
+// on a frame for a stream with no Decoder.
+var ErrUnknownStream = errors.New("blob for unknown stream")
@@ -0,0 +1,378 @@
+// Package mux multiplexes several logical byte streams
+// over one underlying stream using CBE framing.
+//
+// Each frame on the underlying stream consists of two CBE blobs:
+// a stream-ID blob containing an unsigned integer,
+// followed by a payload blob.
+// Stream ID 0 is reserved for control frames,
+// whose payload in turn consists of three blobs:
+// an operation code, the ID of the stream it applies to,
+// and an unsigned integer argument.
+//
+// Each stream is subject to credit-based flow control:
+// a sender may have at most the session's window size of data
+// outstanding on a stream that the receiving application has not yet read.
+// As the receiver reads data, it returns credit to the sender
+// via window-update control frames.
+// Both ends of a session must be configured with the same window size.
+// A session holds at most MaxStreams streams at once,
+// and forgets each stream once both ends have closed it.
+//
+// For carrying several streams of whole blobs, such as
+// control and data channels, over one connection,
+// a Mux writes each blob as a frame in the same format,
+// prefixed by its stream's ID but without flow control,
+// and a Demux routes the blobs it reads to a Decoder per stream.
+//
+// Early unstable prototype code.
+//
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Default per-stream flow control window.
+const DefaultWindow = 256 * 1024
+
+// Maximum number of streams open at once in a session,
+// counting streams that one end has closed but the other has not.
+const MaxStreams = 1024
+
+// Maximum length of a control frame's payload of three integer blobs.
+const maxControlLen = 3 * 9
+
+// Control frame operation codes.
+const (
+	opWindow = 1 // grant the sender more credit on a stream
+	opClose  = 2 // sender will send no more data on a stream
+)
+
+// Session multiplexes streams over an underlying byte stream.
+type Session struct {
+	rw     io.ReadWriter
+	window int
+
+	wmu sync.Mutex // serializes frame writes
+	enc *cbe.Encoder
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	streams map[uint64]*Stream
+	accept  []*Stream // remotely-opened streams not yet accepted
+	err     error     // sticky session error
+}
+
+// Stream is one logical bidirectional byte stream within a Session.
+type Stream struct {
+	s       *Session
+	id      uint64
+	rbuf    bytes.Buffer // received data not yet read
+	rclosed bool         // remote end closed its sending side
+	credit  int          // bytes we may still send
+	wclosed bool         // we closed our sending side
+}
+
+// Create a new Session multiplexing streams over rw,
+// with a per-stream flow control window of window bytes,
+// or DefaultWindow if window is not positive.
+// Starts a goroutine that reads and dispatches incoming frames
+// until the underlying stream fails or the Session is closed.
+func NewSession(rw io.ReadWriter, window int) *Session {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	s := &Session{rw: rw, window: window,
+		enc:     cbe.NewEncoder(rw),
+		streams: make(map[uint64]*Stream)}
+	s.cond.L = &s.mu
+
+	// No valid frame's payload exceeds the window,
+	// so refuse to buffer a longer one before checking it
+	maxLen := window
+	if maxLen < maxControlLen {
+		maxLen = maxControlLen
+	}
+	dec := cbe.NewDecoder(rw)
+	dec.SetMaxBlobLen(int64(maxLen))
+	go s.readLoop(dec)
+	return s
+}
+
+// Open a new stream with the given nonzero ID.
+// The two ends of a session must avoid opening the same stream ID,
+// for example by one end using only odd IDs and the other only even IDs.
+func (s *Session) Open(id uint64) (*Stream, error) {
+	if id == 0 {
+		return nil, errStreamID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.streams[id] != nil {
+		return nil, errStreamID
+	}
+	if len(s.streams) >= MaxStreams {
+		return nil, errStreams
+	}
+	return s.newStream(id), nil
+}
+
+// Wait for and return the next stream opened by the remote end.
+func (s *Session) Accept() (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.accept) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.accept) == 0 {
+		return nil, s.err
+	}
+	st := s.accept[0]
+	s.accept = s.accept[1:]
+	return st, nil
+}
+
+// Close the session and, if it is an io.Closer, the underlying stream.
+// Pending and future operations on the session's streams fail.
+func (s *Session) Close() error {
+	s.fail(ErrClosed)
+	if c, ok := s.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Record a sticky session error and wake up all waiters.
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Create and register a new stream. Caller must hold s.mu.
+func (s *Session) newStream(id uint64) *Stream {
+	st := &Stream{s: s, id: id, credit: s.window}
+	s.streams[id] = st
+	return st
+}
+
+// Forget a stream once both ends have closed it. Caller must hold s.mu.
+func (s *Session) release(st *Stream) {
+	if st.rclosed && st.wclosed && s.streams[st.id] == st {
+		delete(s.streams, st.id)
+	}
+}
+
+// Accept a stream newly opened by the remote end,
+// unless the session already holds MaxStreams streams.
+// Caller must hold s.mu.
+func (s *Session) remoteStream(id uint64) (*Stream, error) {
+	if len(s.streams) >= MaxStreams {
+		return nil, errProtocol
+	}
+	st := s.newStream(id)
+	s.accept = append(s.accept, st)
+	return st, nil
+}
+
+// Write one frame to the underlying stream.
+func (s *Session) writeFrame(id uint64, payload []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err := s.enc.Uint64(id); err != nil {
+		return err
+	}
+	return s.enc.Bytes(payload)
+}
+
+// Write a control frame to the underlying stream.
+func (s *Session) writeControl(op int, id uint64, arg uint64) error {
+	var buf bytes.Buffer
+	enc := cbe.NewEncoder(&buf)
+	enc.Uint64(uint64(op))
+	enc.Uint64(id)
+	enc.Uint64(arg)
+	return s.writeFrame(0, buf.Bytes())
+}
+
+// Read and dispatch incoming frames until an error occurs.
+func (s *Session) readLoop(dec *cbe.Decoder) {
+	for {
+		id, err := dec.Uint64()
+		if err == nil {
+			var payload []byte
+			if payload, err = dec.Bytes(); err == nil {
+				err = s.dispatch(id, payload)
+			}
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+// Dispatch one incoming frame.
+func (s *Session) dispatch(id uint64, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.cond.Broadcast()
+
+	// Data frame
+	if id != 0 {
+		st := s.streams[id]
+		if st == nil { // newly opened by remote end
+			var err error
+			if st, err = s.remoteStream(id); err != nil {
+				return err
+			}
+		}
+		if st.rclosed || st.rbuf.Len()+len(payload) > s.window {
+			return errProtocol
+		}
+		st.rbuf.Write(payload)
+		return nil
+	}
+
+	// Control frame
+	dec := cbe.NewDecoder(bytes.NewReader(payload))
+	op, err := dec.Uint64()
+	if err != nil {
+		return err
+	}
+	sid, err := dec.Uint64()
+	if err != nil {
+		return err
+	}
+	arg, err := dec.Uint64()
+	if err != nil {
+		return err
+	}
+	st := s.streams[sid]
+	switch op {
+	case opWindow:
+		if st == nil {
+			return nil // credit for a stream already released
+		}
+		if arg > uint64(s.window-st.credit) {
+			return errProtocol // more credit than the window allows
+		}
+		st.credit += int(arg)
+	case opClose:
+		if st == nil && sid != 0 { // closed before any data
+			if st, err = s.remoteStream(sid); err != nil {
+				return err
+			}
+		}
+		if st == nil || st.rclosed {
+			return errProtocol
+		}
+		st.rclosed = true
+		s.release(st)
+	default:
+		return errProtocol
+	}
+	return nil
+}
+
+// Returns the stream's ID.
+func (st *Stream) ID() uint64 {
+	return st.id
+}
+
+// Read data received on the stream.
+// Returns io.EOF after the remote end has closed the stream
+// and all data it sent has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	s := st.s
+	s.mu.Lock()
+	for st.rbuf.Len() == 0 && !st.rclosed && s.err == nil {
+		s.cond.Wait()
+	}
+	if st.rbuf.Len() == 0 {
+		err := s.err
+		if st.rclosed {
+			err = io.EOF
+		}
+		s.mu.Unlock()
+		return 0, err
+	}
+	n, _ := st.rbuf.Read(p)
+	s.mu.Unlock()
+
+	// Return the consumed credit to the sender
+	if err := s.writeControl(opWindow, st.id, uint64(n)); err != nil {
+		s.fail(err)
+	}
+	return n, nil
+}
+
+// Write data to the stream,
+// blocking as necessary until the remote end grants enough credit.
+func (st *Stream) Write(p []byte) (int, error) {
+	s := st.s
+	tot := 0
+	for len(p) > 0 {
+		s.mu.Lock()
+		for st.credit == 0 && !st.wclosed && s.err == nil {
+			s.cond.Wait()
+		}
+		if st.wclosed {
+			s.mu.Unlock()
+			return tot, ErrClosed
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return tot, err
+		}
+		n := st.credit
+		if n > len(p) {
+			n = len(p)
+		}
+		st.credit -= n
+		s.mu.Unlock()
+
+		if err := s.writeFrame(st.id, p[:n]); err != nil {
+			s.fail(err)
+			return tot, err
+		}
+		p = p[n:]
+		tot += n
+	}
+	return tot, nil
+}
+
+// Close the sending side of the stream.
+// The remote end reads io.EOF after consuming all data already sent.
+func (st *Stream) Close() error {
+	s := st.s
+	s.mu.Lock()
+	if st.wclosed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	st.wclosed = true
+	s.release(st)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return s.writeControl(opClose, st.id, 0)
+}
+
+// ErrClosed is returned when using a closed Session or Stream.
+var ErrClosed = errors.New("closed")
+
+var errStreamID = errors.New("invalid or duplicate stream ID")
+var errStreams = errors.New("too many open streams")
+var errProtocol = errors.New("multiplexing protocol violation")
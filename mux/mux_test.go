@@ -0,0 +1,236 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+func TestStreams(t *testing.T) {
+	a, b := net.Pipe()
+	sa := NewSession(a, 1000) // small window to exercise flow control
+	sb := NewSession(b, 1000)
+	defer sa.Close()
+	defer sb.Close()
+
+	// Send different data concurrently on several streams
+	data := make(map[uint64][]byte)
+	for id := uint64(1); id <= 3; id++ {
+		data[id] = bytes.Repeat([]byte{byte(id)}, 10000*int(id))
+		st, err := sa.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(st *Stream, d []byte) {
+			if _, err := st.Write(d); err != nil {
+				t.Error(err)
+			}
+			st.Close()
+		}(st, data[id])
+	}
+
+	// Receive and check each stream concurrently
+	done := make(chan error)
+	for i := 0; i < 3; i++ {
+		st, err := sb.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(st *Stream) {
+			got, err := io.ReadAll(st)
+			if err == nil && !bytes.Equal(got, data[st.ID()]) {
+				t.Errorf("wrong data on stream %v", st.ID())
+			}
+			done <- err
+		}(st)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestEmptyStream(t *testing.T) {
+	a, b := net.Pipe()
+	sa := NewSession(a, 0)
+	sb := NewSession(b, 0)
+	defer sa.Close()
+	defer sb.Close()
+
+	st, err := sa.Open(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.Open(7); err == nil {
+		t.Error("opened duplicate stream ID")
+	}
+	go st.Close()
+
+	rs, err := sb.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.ID() != 7 {
+		t.Errorf("accepted wrong stream ID %v", rs.ID())
+	}
+	if n, err := rs.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF but got %v, %v", n, err)
+	}
+}
+
+func TestBlobMux(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewMux(cbe.NewEncoder(&buf))
+	big := bytes.Repeat([]byte("d"), 3*cbe.MinChunkLen)
+	m.Bytes(0, []byte("start"))
+	m.ReadFrom(1, bytes.NewReader(big))
+	m.Bytes(0, []byte("stop"))
+	m.Bytes(1, nil)
+
+	dm := NewDemux(cbe.NewDecoder(bytes.NewReader(buf.Bytes())))
+	want := map[uint64][]string{
+		0: {"start", "stop"},
+		1: {string(big), ""},
+	}
+	done := make(chan error)
+	for id, blobs := range want {
+		go func(d *cbe.Decoder, blobs []string) {
+			for _, w := range blobs {
+				if s, err := d.String(); err != nil || s != w {
+					t.Errorf("demuxed %.10q, %v, want %.10q",
+						s, err, w)
+				}
+			}
+			_, err := d.String()
+			if err == io.EOF {
+				err = nil
+			}
+			done <- err
+		}(dm.Stream(id), blobs)
+	}
+	if err := dm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for range want {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Frames for streams with no Decoder are rejected
+	dm = NewDemux(cbe.NewDecoder(bytes.NewReader(buf.Bytes())))
+	if err := dm.Run(); err != ErrUnknownStream {
+		t.Errorf("Run with no streams gave %v", err)
+	}
+}
+
+func TestHostilePeer(t *testing.T) {
+	// A frame longer than the window fails the session
+	// before its payload is read
+	a, b := net.Pipe()
+	s := NewSession(a, 1000)
+	frame := cbe.AppendUint64(nil, 1)
+	frame = cbe.AppendHeader(frame, 1<<20)
+	go b.Write(frame)
+	if _, err := s.Accept(); !errors.Is(err, cbe.ErrTooLong) {
+		t.Errorf("over-long frame gave %v", err)
+	}
+	s.Close()
+	b.Close()
+
+	// A window grant beyond the window size fails the session
+	a, b = net.Pipe()
+	s = NewSession(a, 1000)
+	defer s.Close()
+	defer b.Close()
+	if _, err := s.Open(1); err != nil {
+		t.Fatal(err)
+	}
+	var ctl []byte
+	for _, v := range []uint64{opWindow, 1, 1} {
+		ctl = cbe.AppendUint64(ctl, v)
+	}
+	go b.Write(cbe.Encode(cbe.AppendUint64(nil, 0), ctl))
+	if _, err := s.Accept(); err != errProtocol {
+		t.Errorf("excess window grant gave %v", err)
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	// Streams closed at both ends are released,
+	// so a session can carry any number in turn
+	a, b := net.Pipe()
+	sa := NewSession(a, 0)
+	sb := NewSession(b, 0)
+	for id := uint64(1); id <= 2*MaxStreams; id++ {
+		st, err := sa.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			st.Write([]byte("x"))
+			st.Close()
+		}()
+		rs, err := sb.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(rs); err != nil {
+			t.Fatal(err)
+		}
+		rs.Close()
+		if _, err := io.ReadAll(st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []*Session{sa, sb} {
+		s.mu.Lock()
+		if n := len(s.streams); n != 0 {
+			t.Errorf("%d streams remain after closing", n)
+		}
+		s.mu.Unlock()
+	}
+	sa.Close()
+	sb.Close()
+
+	// A peer opening too many streams fails the session
+	a, b = net.Pipe()
+	s := NewSession(a, 0)
+	defer s.Close()
+	defer b.Close()
+	go func() {
+		for id := uint64(1); id <= MaxStreams+1; id++ {
+			f := cbe.Encode(cbe.AppendUint64(nil, id), []byte("x"))
+			if _, err := b.Write(f); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < MaxStreams; i++ {
+		if _, err := s.Accept(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Accept(); err != errProtocol {
+		t.Errorf("excess stream gave %v", err)
+	}
+
+	// and opening too many locally fails
+	a, b = net.Pipe()
+	s = NewSession(a, 0)
+	defer s.Close()
+	defer b.Close()
+	for id := uint64(1); id <= MaxStreams; id++ {
+		if _, err := s.Open(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Open(MaxStreams + 1); err != errStreams {
+		t.Errorf("excess Open gave %v", err)
+	}
+}
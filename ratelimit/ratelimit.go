@@ -0,0 +1,155 @@
+// Package ratelimit shapes the bandwidth of CBE blob streams,
+// so that bulk blob transfers can share a link
+// with latency-sensitive traffic.
+//
+// A Limiter implements a token bucket
+// that refills at a fixed number of bytes per second
+// up to a maximum burst size.
+// Encoders and decoders created by this package charge each
+// underlying write or read against a Limiter,
+// which several streams may share to enforce an aggregate budget.
+// Since a cbe.Encoder writes each streamed chunk in a single write,
+// encoding is shaped at chunk granularity.
+//
+// Early unstable prototype code.
+//
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Limiter is a token bucket limiting throughput to a number of bytes per second.
+// A Limiter is safe for concurrent use by multiple goroutines.
+type Limiter struct {
+	rate  float64 // tokens (bytes) added per second
+	burst float64 // maximum tokens accumulated
+
+	mu     sync.Mutex
+	tokens float64   // available tokens, negative if in debt
+	last   time.Time // time tokens was last updated
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Create a Limiter allowing bytesPerSec bytes per second on average
+// and bursts of up to burst bytes, or one second's worth if burst is zero.
+// The bucket starts full.
+func NewLimiter(bytesPerSec, burst int) *Limiter {
+	if bytesPerSec <= 0 {
+		panic("rate must be positive")
+	}
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+	return &Limiter{rate: float64(bytesPerSec), burst: float64(burst),
+		tokens: float64(burst), now: time.Now, sleep: sleep}
+}
+
+// Wait until n bytes may be transferred, then charge them to the bucket.
+// Requests larger than the burst size are permitted,
+// and leave the bucket in debt until it refills.
+// Returns early with the context's error if ctx is canceled.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	// Take the tokens, going into debt if necessary,
+	// and wait until the debt would be repaid.
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	if err := l.sleep(ctx, wait); err != nil {
+		l.mu.Lock()
+		l.tokens += float64(n) // refund the unused tokens
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type writer struct {
+	w   io.Writer
+	l   *Limiter
+	ctx context.Context
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.l.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+type reader struct {
+	r   io.Reader
+	l   *Limiter
+	ctx context.Context
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// Create a writer that charges each write to l before passing it to w.
+// Writes fail with the context's error if ctx is canceled while waiting.
+func NewWriter(ctx context.Context, w io.Writer, l *Limiter) io.Writer {
+	return &writer{w, l, ctx}
+}
+
+// Create a reader that charges each read from r to l,
+// waiting after each read until the bytes read are paid for.
+// Reads fail with the context's error if ctx is canceled while waiting.
+func NewReader(ctx context.Context, r io.Reader, l *Limiter) io.Reader {
+	return &reader{r, l, ctx}
+}
+
+// Create an Encoder writing to w with throughput limited by l.
+func NewEncoder(ctx context.Context, w io.Writer, l *Limiter) *cbe.Encoder {
+	return cbe.NewEncoder(NewWriter(ctx, w, l))
+}
+
+// Create a Decoder reading from r with throughput limited by l.
+// Since the Decoder buffers its input,
+// reads are charged in units of the buffer size
+// rather than of individual chunks.
+func NewDecoder(ctx context.Context, r io.Reader, l *Limiter) *cbe.Decoder {
+	return cbe.NewDecoder(NewReader(ctx, r, l))
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// Create a Limiter using a simulated clock advanced only by sleeping.
+func fakeLimiter(rate, burst int) (*Limiter, *time.Time) {
+	l := NewLimiter(rate, burst)
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		now = now.Add(d)
+		return nil
+	}
+	return l, &now
+}
+
+func TestLimiter(t *testing.T) {
+	l, now := fakeLimiter(1000, 500)
+	start := *now
+	for i := 0; i < 10; i++ {
+		l.WaitN(context.Background(), 250)
+	}
+
+	// 2500 bytes minus the 500-byte initial burst at 1000 bytes/sec
+	if d := now.Sub(start); d != 2*time.Second {
+		t.Errorf("took %v, want 2s", d)
+	}
+
+	// A request larger than the burst size goes into debt
+	l.WaitN(context.Background(), 3000)
+	if d := now.Sub(start); d != 5*time.Second {
+		t.Errorf("took %v, want 5s", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.WaitN(ctx, 1000); err != context.Canceled {
+		t.Errorf("canceled WaitN gave %v", err)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	l, now := fakeLimiter(100000, 20000)
+	start := *now
+
+	var buf bytes.Buffer
+	e := NewEncoder(context.Background(), &buf, l)
+	data := bytes.Repeat([]byte("x"), 220000)
+	if _, err := e.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(context.Background(), &buf, l)
+	got, err := d.Bytes()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	if _, err := d.Bytes(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	// About 440KB through a 100KB/sec limit, less the initial burst
+	if el := now.Sub(start); el < 4*time.Second || el > 5*time.Second {
+		t.Errorf("transfer took %v", el)
+	}
+}
@@ -0,0 +1,248 @@
+// Package remote reads CBE-encoded objects stored remotely,
+// such as in S3 or GCS buckets accessed over HTTP,
+// by fetching only the byte ranges needed.
+//
+// A ReaderAt presents a remote object as an io.ReaderAt,
+// fetching and caching fixed-size blocks of the object on demand
+// through a pluggable Fetch function.
+// Combined with an index such as that of package kv,
+// this allows individual blobs to be decoded
+// from a multi-gigabyte object without downloading it entirely:
+//
+//	size, err := remote.HTTPSize(ctx, client, url)
+//	ra := (&remote.Config{}).NewReaderAt(ctx, remote.HTTPFetch(client, url), size)
+//	r, err := kv.Open(ra, size)
+//	value, err := r.Get(key)
+//
+// Early unstable prototype code.
+//
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default size of the blocks a ReaderAt fetches.
+const DefaultBlockSize = 64 * 1024
+
+// Default number of blocks a ReaderAt caches.
+const DefaultCacheBlocks = 16
+
+// Fetch reads len(p) bytes of a remote object starting at offset off into p,
+// returning an error if it cannot read them all.
+type Fetch func(ctx context.Context, off int64, p []byte) error
+
+// Config holds options for a ReaderAt.
+type Config struct {
+	BlockSize   int // size of fetched blocks, or 0 for DefaultBlockSize
+	CacheBlocks int // blocks cached, or 0 for DefaultCacheBlocks
+}
+
+// ReaderAt reads a remote object in blocks fetched on demand.
+// It is safe for concurrent use.
+type ReaderAt struct {
+	ctx    context.Context
+	fetch  Fetch
+	size   int64
+	bsize  int64
+	ncache int
+
+	mu    sync.Mutex
+	cache map[int64][]byte // cached blocks by block number
+	lru   []int64          // cached block numbers, least recent first
+}
+
+// Create a ReaderAt for a remote object of the given size,
+// fetching its blocks using fetch with context ctx.
+// A nil Config selects the defaults.
+func (c *Config) NewReaderAt(ctx context.Context, fetch Fetch, size int64) *ReaderAt {
+	if c == nil {
+		c = &Config{}
+	}
+	bs, nc := c.BlockSize, c.CacheBlocks
+	if bs <= 0 {
+		bs = DefaultBlockSize
+	}
+	if nc <= 0 {
+		nc = DefaultCacheBlocks
+	}
+	return &ReaderAt{ctx: ctx, fetch: fetch, size: size,
+		bsize: int64(bs), ncache: nc, cache: make(map[int64][]byte)}
+}
+
+// Returns the size of the remote object.
+func (ra *ReaderAt) Size() int64 {
+	return ra.size
+}
+
+// Read len(p) bytes starting at offset off,
+// as specified by the io.ReaderAt interface.
+func (ra *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errOffset
+	}
+	n := 0
+	for n < len(p) {
+		if off >= ra.size {
+			return n, io.EOF
+		}
+		b, err := ra.block(off / ra.bsize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], b[off%ra.bsize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// Returns block number i, fetching it if it is not cached.
+func (ra *ReaderAt) block(i int64) ([]byte, error) {
+	ra.mu.Lock()
+	if b, ok := ra.cache[i]; ok {
+		ra.touch(i)
+		ra.mu.Unlock()
+		return b, nil
+	}
+	ra.mu.Unlock()
+
+	// Fetch the block without holding the lock
+	start := i * ra.bsize
+	end := start + ra.bsize
+	if end > ra.size {
+		end = ra.size
+	}
+	b := make([]byte, end-start)
+	if err := ra.fetch(ra.ctx, start, b); err != nil {
+		return nil, err
+	}
+
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if _, ok := ra.cache[i]; !ok { // not fetched concurrently meanwhile
+		if len(ra.lru) >= ra.ncache {
+			delete(ra.cache, ra.lru[0])
+			ra.lru = ra.lru[1:]
+		}
+		ra.cache[i] = b
+		ra.lru = append(ra.lru, i)
+	}
+	return b, nil
+}
+
+// Mark block i most recently used. Caller must hold ra.mu.
+func (ra *ReaderAt) touch(i int64) {
+	for j, k := range ra.lru {
+		if k == i {
+			copy(ra.lru[j:], ra.lru[j+1:])
+			ra.lru[len(ra.lru)-1] = i
+			return
+		}
+	}
+}
+
+// Returns a Fetch function that reads byte ranges of the object at url
+// using HTTP Range requests issued by client,
+// or http.DefaultClient if client is nil.
+// The server must support range requests,
+// and each response's Content-Range must match the range requested.
+func HTTPFetch(client *http.Client, url string) Fetch {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context, off int64, p []byte) error {
+		if len(p) == 0 {
+			return nil
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range",
+			fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusPartialContent {
+			return fmt.Errorf("%w: %s", errRange, resp.Status)
+		}
+		cr := resp.Header.Get("Content-Range")
+		if !matchRange(cr, off, int64(len(p))) {
+			return fmt.Errorf("%w: Content-Range %q", errRange, cr)
+		}
+		if _, err := io.ReadFull(resp.Body, p); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// Reports whether Content-Range value cr, of the form
+// "bytes first-last/length" or "bytes first-last/*",
+// describes exactly the n bytes starting at off.
+func matchRange(cr string, off, n int64) bool {
+	rng, ok := strings.CutPrefix(cr, "bytes ")
+	if !ok {
+		return false
+	}
+	rng, length, ok := strings.Cut(rng, "/")
+	if !ok {
+		return false
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return false
+	}
+	f, err1 := strconv.ParseInt(first, 10, 64)
+	l, err2 := strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || f != off || l != off+n-1 {
+		return false
+	}
+	if length != "*" {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || size <= l {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the size of the object at url using an HTTP HEAD request
+// issued by client, or http.DefaultClient if client is nil.
+func HTTPSize(ctx context.Context, client *http.Client, url string) (int64, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HEAD %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, errors.New("object size unknown")
+	}
+	return resp.ContentLength, nil
+}
+
+var errOffset = errors.New("negative offset")
+var errRange = errors.New("server did not honor range request")
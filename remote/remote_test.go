@@ -0,0 +1,153 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bford/cofo/kv"
+)
+
+func TestReaderAt(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	fetches := 0
+	fetch := func(ctx context.Context, off int64, p []byte) error {
+		fetches++
+		copy(p, data[off:])
+		return nil
+	}
+	ra := (&Config{BlockSize: 100, CacheBlocks: 2}).NewReaderAt(
+		context.Background(), fetch, int64(len(data)))
+
+	for _, c := range []struct{ off, n int }{
+		{0, 10}, {95, 10}, {990, 10}, {250, 300}, {0, 1000},
+	} {
+		p := make([]byte, c.n)
+		n, err := ra.ReadAt(p, int64(c.off))
+		if n != c.n || err != nil || !bytes.Equal(p, data[c.off:c.off+c.n]) {
+			t.Errorf("ReadAt(%v, %v) gave %v, %v", c.n, c.off, n, err)
+		}
+	}
+	n, err := ra.ReadAt(make([]byte, 20), 990)
+	if n != 10 || err != io.EOF {
+		t.Errorf("ReadAt past end gave %v, %v", n, err)
+	}
+
+	// Repeated reads of a cached block must not fetch
+	fetches = 0
+	ra.ReadAt(make([]byte, 5), 995)
+	ra.ReadAt(make([]byte, 5), 990)
+	if fetches != 0 {
+		t.Errorf("cached reads fetched %v blocks", fetches)
+	}
+}
+
+func TestHTTPKV(t *testing.T) {
+	// Build a kv file with records too large to fit in one block
+	var buf bytes.Buffer
+	w := kv.NewWriter(&buf)
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("key%03d", i)),
+			bytes.Repeat([]byte{byte(i)}, 5000))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	var served int64
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			cw := &countingWriter{ResponseWriter: w, n: &served}
+			http.ServeContent(cw, r, "", time.Time{},
+				bytes.NewReader(file))
+		}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	size, err := HTTPSize(ctx, nil, srv.URL)
+	if err != nil || size != int64(len(file)) {
+		t.Fatalf("HTTPSize gave %v, %v", size, err)
+	}
+	ra := (&Config{BlockSize: 4096}).NewReaderAt(ctx,
+		HTTPFetch(nil, srv.URL), size)
+	r, err := kv.Open(ra, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.Get([]byte("key042"))
+	if err != nil || !bytes.Equal(v, bytes.Repeat([]byte{42}, 5000)) {
+		t.Fatalf("Get gave %v", err)
+	}
+	if atomic.LoadInt64(&served) > int64(len(file))/4 {
+		t.Errorf("served %v of %v bytes", served, len(file))
+	}
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestHTTPRange(t *testing.T) {
+	data := []byte("0123456789")
+	var cr string // Content-Range to claim, or "" for the true one
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if cr == "" {
+				http.ServeContent(w, r, "", time.Time{},
+					bytes.NewReader(data))
+				return
+			}
+			w.Header().Set("Content-Range", cr)
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[:4])
+		}))
+	defer srv.Close()
+
+	fetch := HTTPFetch(nil, srv.URL)
+	ctx := context.Background()
+	for _, c := range []struct {
+		cr string
+		ok bool
+	}{
+		{"", true},
+		{"bytes 2-5/10", true},
+		{"bytes 2-5/*", true},
+		{"bytes 0-3/10", false}, // wrong start
+		{"bytes 2-6/10", false}, // wrong end
+		{"bytes 2-5/5", false},  // length inconsistent with the range
+		{"bytes 2-5", false},
+	} {
+		cr = c.cr
+		p := make([]byte, 4)
+		err := fetch(ctx, 2, p)
+		if c.ok && err != nil {
+			t.Errorf("Content-Range %q gave %v", c.cr, err)
+		} else if !c.ok && !errors.Is(err, errRange) {
+			t.Errorf("Content-Range %q was accepted", c.cr)
+		}
+	}
+
+	// A nil Config selects the defaults
+	ra := (*Config)(nil).NewReaderAt(ctx, fetch, int64(len(data)))
+	if ra.bsize != DefaultBlockSize || ra.ncache != DefaultCacheBlocks {
+		t.Errorf("nil Config gave block size %v, %v blocks",
+			ra.bsize, ra.ncache)
+	}
+}
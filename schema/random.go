@@ -0,0 +1,102 @@
+package schema
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+
+	"github.com/bford/cofo/value"
+)
+
+// Returns a random value of Go type t for property-based testing,
+// in the style of testing/quick,
+// such that encoding and decoding the value through a schema stream
+// reproduces it exactly.
+// The size bounds the lengths of strings, slices, and maps,
+// and roughly halves with each level of nesting,
+// so recursive types terminate with nil pointers.
+// Slices and maps are never nil, since they decode as empty,
+// unexported struct fields are left zero, since they are not encoded,
+// and empty interfaces hold random values as by value.Random,
+// except that interface map keys are strings.
+// Types implementing encoding.BinaryMarshaler are left zero,
+// since their valid encodings are specific to each type.
+// Returns an error if t involves a type an Encoder does not support.
+func Random(t reflect.Type, r *rand.Rand, size int) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	return v, randomData(v, r, size)
+}
+
+// Fill settable value v with random data.
+func randomData(v reflect.Value, r *rand.Rand, size int) error {
+	if size < 0 {
+		size = 0
+	}
+	t := v.Type()
+	if t.Implements(binaryMarshaler) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(r.Intn(2) == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		v.SetInt(int64(r.Uint64()) >> (64 - t.Bits()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		v.SetUint(r.Uint64() >> (64 - t.Bits()))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(r.NormFloat64() * 1e6)
+	case reflect.String:
+		v.SetString(value.RandomString(r, size))
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			if x := value.Random(r, size); x != nil {
+				v.Set(reflect.ValueOf(x))
+			}
+		}
+	case reflect.Slice:
+		n := r.Intn(size + 1)
+		v.Set(reflect.MakeSlice(t, n, n))
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := randomData(v.Index(i), r, size/2); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		for i := r.Intn(size + 1); i > 0; i-- {
+			k := reflect.New(t.Key()).Elem()
+			e := reflect.New(t.Elem()).Elem()
+			if t.Key().Kind() == reflect.Interface {
+				// Random values may be unhashable
+				k.Set(reflect.ValueOf(value.RandomString(r, size/2)))
+			} else if err := randomData(k, r, size/2); err != nil {
+				return err
+			}
+			if err := randomData(e, r, size/2); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Ptr:
+		if size > 0 && r.Intn(4) != 0 {
+			p := reflect.New(t.Elem())
+			if err := randomData(p.Elem(), r, size/2); err != nil {
+				return err
+			}
+			v.Set(p)
+		}
+	case reflect.Struct:
+		for _, i := range exportedFields(t) {
+			if err := randomData(v.Field(i), r, size); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %v", t)
+	}
+	return nil
+}
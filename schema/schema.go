@@ -0,0 +1,714 @@
+// Package schema implements a self-describing stream encoding,
+// in the spirit of encoding/gob,
+// in which a stream carries descriptions of the Go types it contains
+// so that a reader can decode the stream without the original types.
+//
+// A stream is a sequence of messages,
+// each consisting of an integer blob followed by values
+// of the dynamic value model of package value.
+// A negative integer -id introduces a type definition,
+// followed by one value describing the struct type numbered id.
+// A zero integer introduces a record,
+// followed by a type reference value and the record's data value.
+//
+// A type reference is one of the following:
+//
+//	"bool", "int", "uint", "float", "string", "bytes", "any"
+//	             a basic type, where "any" denotes an arbitrary value
+//	int64 id     the struct type numbered id
+//	["list", t]  a slice or array with elements of type t
+//	["map", k, v]  a map with keys of type k and values of type v
+//	["ptr", t]   a pointer to type t
+//
+// A struct type definition is a list ["struct", name, fields],
+// where fields is a list of [field-name, type-reference] pairs
+// for the struct's exported fields in order.
+// The Encoder defines each struct type before the first record using it,
+// assigning type numbers in order starting from 1.
+//
+// Data values follow the structure of their types:
+// struct data is a list of field values in order,
+// a nil pointer is nil and a non-nil pointer is the data it points to,
+// and types implementing encoding.BinaryMarshaler are "bytes".
+// Nil slices and maps are encoded, and thus decoded, as empty ones.
+//
+// A Decoder can decode records into Go values,
+// matching struct fields by name and ignoring fields missing on either side,
+// or into dynamic values, with structs decoded as maps from field names.
+//
+// Early unstable prototype code.
+//
+package schema
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/value"
+)
+
+// Names of basic types.
+const (
+	typeBool   = "bool"
+	typeInt    = "int"
+	typeUint   = "uint"
+	typeFloat  = "float"
+	typeString = "string"
+	typeBytes  = "bytes"
+	typeAny    = "any"
+)
+
+var (
+	binaryMarshaler   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshaler = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// Encoder writes a self-describing stream of records.
+type Encoder struct {
+	e     *cbe.Encoder
+	types map[reflect.Type]int64 // numbers of struct types defined so far
+	last  int64                  // last type number assigned
+}
+
+// Create an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{e: cbe.NewEncoder(w), types: make(map[reflect.Type]int64)}
+}
+
+// Encode v as a record,
+// preceded by definitions of any struct types not yet described.
+func (enc *Encoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return errNil
+	}
+	ref, err := enc.typeRef(rv.Type())
+	if err != nil {
+		return err
+	}
+	data, err := encodeData(rv, 0)
+	if err != nil {
+		return err
+	}
+	if err := enc.e.Int64(0); err != nil {
+		return err
+	}
+	if err := value.Encode(enc.e, ref); err != nil {
+		return err
+	}
+	return value.Encode(enc.e, data)
+}
+
+// Returns the type reference for t,
+// first writing definitions of any new struct types it involves.
+func (enc *Encoder) typeRef(t reflect.Type) (value.Value, error) {
+	if t.Implements(binaryMarshaler) {
+		return typeBytes, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return typeBool, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return typeInt, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return typeUint, nil
+	case reflect.Float32, reflect.Float64:
+		return typeFloat, nil
+	case reflect.String:
+		return typeString, nil
+	case reflect.Interface:
+		return typeAny, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return typeBytes, nil
+		}
+		elem, err := enc.typeRef(t.Elem())
+		return []value.Value{"list", elem}, err
+	case reflect.Map:
+		k, err := enc.typeRef(t.Key())
+		if err != nil {
+			return nil, err
+		}
+		v, err := enc.typeRef(t.Elem())
+		return []value.Value{"map", k, v}, err
+	case reflect.Ptr:
+		elem, err := enc.typeRef(t.Elem())
+		return []value.Value{"ptr", elem}, err
+	case reflect.Struct:
+		return enc.structRef(t)
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
+
+// Returns the number of struct type t, defining it if necessary.
+func (enc *Encoder) structRef(t reflect.Type) (value.Value, error) {
+	if id, ok := enc.types[t]; ok {
+		return id, nil
+	}
+	enc.last++
+	id := enc.last
+	enc.types[t] = id // before describing fields, for recursive types
+
+	fields := []value.Value{}
+	for _, i := range exportedFields(t) {
+		f := t.Field(i)
+		ref, err := enc.typeRef(f.Type)
+		if err != nil {
+			delete(enc.types, t)
+			return nil, err
+		}
+		fields = append(fields, []value.Value{f.Name, ref})
+	}
+	if err := enc.e.Int64(-id); err != nil {
+		return nil, err
+	}
+	def := []value.Value{"struct", t.Name(), fields}
+	return id, value.Encode(enc.e, def)
+}
+
+// Returns the indexes of the exported fields of struct type t.
+func exportedFields(t reflect.Type) []int {
+	var is []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			is = append(is, i)
+		}
+	}
+	return is
+}
+
+// Returns the data value representing rv.
+func encodeData(rv reflect.Value, depth int) (value.Value, error) {
+	if depth > value.MaxDepth {
+		return nil, errDepth
+	}
+	t := rv.Type()
+	if t.Implements(binaryMarshaler) {
+		if t.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, errNil
+		}
+		return rv.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return rv.Elem().Interface(), nil // must be a value.Value
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return b, nil
+		}
+		l := make([]value.Value, rv.Len())
+		for i := range l {
+			var err error
+			if l[i], err = encodeData(rv.Index(i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	case reflect.Map:
+		m := make(value.Map, 0, rv.Len())
+		for it := rv.MapRange(); it.Next(); {
+			k, err := encodeData(it.Key(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := encodeData(it.Value(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, value.Pair{Key: k, Value: v})
+		}
+		return m, nil
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return encodeData(rv.Elem(), depth+1)
+	case reflect.Struct:
+		is := exportedFields(t)
+		l := make([]value.Value, len(is))
+		for j, i := range is {
+			var err error
+			if l[j], err = encodeData(rv.Field(i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
+
+// Decoder reads a self-describing stream of records.
+type Decoder struct {
+	d     *cbe.Decoder
+	types map[int64]*structDef
+}
+
+// A struct type definition received from the stream.
+type structDef struct {
+	name   string
+	fields []fieldDef
+}
+
+type fieldDef struct {
+	name string
+	ref  value.Value
+}
+
+// Create a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{d: cbe.NewDecoder(r), types: make(map[int64]*structDef)}
+}
+
+// Read the next record, processing any type definitions preceding it,
+// and return its type reference and data.
+func (dec *Decoder) next() (ref, data value.Value, err error) {
+	for {
+		n, err := dec.d.Int64()
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > 0 {
+			return nil, nil, errMessage
+		}
+		v, err := dec.value()
+		if err != nil {
+			return nil, nil, err
+		}
+		if n == 0 {
+			data, err := dec.value()
+			return v, data, err
+		}
+		if err := dec.define(-n, v); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// Decode a value that must follow within the current message.
+func (dec *Decoder) value() (value.Value, error) {
+	v, err := value.Decode(dec.d)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// Record the definition def of struct type id.
+func (dec *Decoder) define(id int64, def value.Value) error {
+	l, ok := def.([]value.Value)
+	if !ok || len(l) != 3 || l[0] != "struct" {
+		return errDefinition
+	}
+	name, ok := l[1].(string)
+	fields, ok2 := l[2].([]value.Value)
+	if !ok || !ok2 {
+		return errDefinition
+	}
+	sd := &structDef{name: name}
+	for _, f := range fields {
+		fl, ok := f.([]value.Value)
+		if !ok || len(fl) != 2 {
+			return errDefinition
+		}
+		fname, ok := fl[0].(string)
+		if !ok {
+			return errDefinition
+		}
+		sd.fields = append(sd.fields, fieldDef{fname, fl[1]})
+	}
+	dec.types[id] = sd
+	return nil
+}
+
+// Decode the next record into a dynamic value,
+// with structs represented as maps from field names to values.
+// Returns io.EOF at the end of the stream.
+func (dec *Decoder) DecodeValue() (value.Value, error) {
+	ref, data, err := dec.next()
+	if err != nil {
+		return nil, err
+	}
+	return dec.dynamic(ref, data)
+}
+
+// Convert data of the type ref to a dynamic value.
+func (dec *Decoder) dynamic(ref, data value.Value) (value.Value, error) {
+	switch r := ref.(type) {
+	case string:
+		if err := checkBasic(r, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case int64:
+		sd, l, err := dec.structData(r, data)
+		if err != nil {
+			return nil, err
+		}
+		m := value.Map{}
+		for i, f := range sd.fields {
+			v, err := dec.dynamic(f.ref, l[i])
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, value.Pair{Key: f.name, Value: v})
+		}
+		return m, nil
+	}
+	kind, args, err := composite(ref)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "ptr":
+		if data == nil {
+			return nil, nil
+		}
+		return dec.dynamic(args[0], data)
+	case "list":
+		l, ok := data.([]value.Value)
+		if !ok {
+			return nil, errData
+		}
+		out := make([]value.Value, len(l))
+		for i, elt := range l {
+			if out[i], err = dec.dynamic(args[0], elt); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default: // "map"
+		m, ok := data.(value.Map)
+		if !ok {
+			return nil, errData
+		}
+		out := make(value.Map, len(m))
+		for i, p := range m {
+			k, err := dec.dynamic(args[0], p.Key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := dec.dynamic(args[1], p.Value)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = value.Pair{Key: k, Value: v}
+		}
+		return out, nil
+	}
+}
+
+// Look up struct type id and check that data matches it.
+func (dec *Decoder) structData(id int64, data value.Value) (*structDef,
+	[]value.Value, error) {
+
+	sd, ok := dec.types[id]
+	if !ok {
+		return nil, nil, errUndefined
+	}
+	l, ok := data.([]value.Value)
+	if !ok || len(l) != len(sd.fields) {
+		return nil, nil, errData
+	}
+	return sd, l, nil
+}
+
+// Split a composite type reference into its kind and arguments.
+func composite(ref value.Value) (string, []value.Value, error) {
+	l, ok := ref.([]value.Value)
+	if ok && len(l) > 0 {
+		switch kind, _ := l[0].(string); {
+		case (kind == "list" || kind == "ptr") && len(l) == 2,
+			kind == "map" && len(l) == 3:
+			return kind, l[1:], nil
+		}
+	}
+	return "", nil, errTypeRef
+}
+
+// Check that data is valid for the basic type named name.
+func checkBasic(name string, data value.Value) error {
+	ok := false
+	switch name {
+	case typeBool:
+		_, ok = data.(bool)
+	case typeInt:
+		_, ok = data.(int64)
+	case typeUint:
+		switch d := data.(type) {
+		case int64:
+			ok = d >= 0
+		case *big.Int:
+			ok = d.Sign() >= 0 && d.IsUint64()
+		}
+	case typeFloat:
+		_, ok = data.(float64)
+	case typeString:
+		_, ok = data.(string)
+	case typeBytes:
+		_, ok = data.([]byte)
+	case typeAny:
+		ok = true
+	default:
+		return errTypeRef
+	}
+	if !ok {
+		return errData
+	}
+	return nil
+}
+
+// Decode the next record into the Go value that v points to.
+// Returns io.EOF at the end of the stream.
+func (dec *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errPointer
+	}
+	ref, data, err := dec.next()
+	if err != nil {
+		return err
+	}
+	return dec.decodeInto(ref, data, rv.Elem())
+}
+
+// Decode data of the type ref into rv.
+func (dec *Decoder) decodeInto(ref, data value.Value, rv reflect.Value) error {
+	t := rv.Type()
+
+	// Pointers in the stream and in the Go type may differ.
+	if kind, args, err := composite(ref); err == nil && kind == "ptr" {
+		if data == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		ref = args[0]
+	}
+	if t.Kind() == reflect.Ptr && !t.Implements(binaryUnmarshaler) {
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return dec.decodeInto(ref, data, rv.Elem())
+	}
+
+	// Dynamic values
+	if t.Kind() == reflect.Interface {
+		dv, err := dec.dynamic(ref, data)
+		if err != nil {
+			return err
+		}
+		if dv == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		if !reflect.TypeOf(dv).AssignableTo(t) {
+			return mismatch(ref, t)
+		}
+		rv.Set(reflect.ValueOf(dv))
+		return nil
+	}
+
+	if id, ok := ref.(int64); ok {
+		if t.Kind() != reflect.Struct {
+			return mismatch(ref, t)
+		}
+		sd, l, err := dec.structData(id, data)
+		if err != nil {
+			return err
+		}
+		for i, f := range sd.fields {
+			sf, ok := t.FieldByName(f.name)
+			if !ok || !sf.IsExported() || len(sf.Index) != 1 {
+				continue // not present in the Go type
+			}
+			err := dec.decodeInto(f.ref, l[i], rv.Field(sf.Index[0]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if name, ok := ref.(string); ok {
+		if err := checkBasic(name, data); err != nil {
+			return err
+		}
+		return setBasic(name, data, rv)
+	}
+
+	kind, args, err := composite(ref)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == "list" && t.Kind() == reflect.Slice:
+		l, ok := data.([]value.Value)
+		if !ok {
+			return errData
+		}
+		s := reflect.MakeSlice(t, len(l), len(l))
+		for i, elt := range l {
+			if err := dec.decodeInto(args[0], elt, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+		return nil
+
+	case kind == "list" && t.Kind() == reflect.Array:
+		l, ok := data.([]value.Value)
+		if !ok || len(l) != t.Len() {
+			return errData
+		}
+		for i, elt := range l {
+			if err := dec.decodeInto(args[0], elt, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case kind == "map" && t.Kind() == reflect.Map:
+		m, ok := data.(value.Map)
+		if !ok {
+			return errData
+		}
+		gm := reflect.MakeMapWithSize(t, len(m))
+		for _, p := range m {
+			k := reflect.New(t.Key()).Elem()
+			if err := dec.decodeInto(args[0], p.Key, k); err != nil {
+				return err
+			}
+			v := reflect.New(t.Elem()).Elem()
+			if err := dec.decodeInto(args[1], p.Value, v); err != nil {
+				return err
+			}
+			gm.SetMapIndex(k, v)
+		}
+		rv.Set(gm)
+		return nil
+	}
+	return mismatch(ref, t)
+}
+
+// Set rv to data of the basic type name, already checked for validity.
+func setBasic(name string, data value.Value, rv reflect.Value) error {
+	t := rv.Type()
+	if name == typeBytes {
+		if reflect.PtrTo(t).Implements(binaryUnmarshaler) {
+			u := rv.Addr().Interface().(encoding.BinaryUnmarshaler)
+			return u.UnmarshalBinary(data.([]byte))
+		}
+		if t.Implements(binaryUnmarshaler) && t.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				rv.Set(reflect.New(t.Elem()))
+			}
+			u := rv.Interface().(encoding.BinaryUnmarshaler)
+			return u.UnmarshalBinary(data.([]byte))
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if name == typeBool {
+			rv.SetBool(data.(bool))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		if i, ok := data.(int64); ok {
+			if rv.OverflowInt(i) {
+				return errRange
+			}
+			rv.SetInt(i)
+			return nil
+		}
+		if name == typeUint {
+			return errRange // a *big.Int beyond int64
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch d := data.(type) {
+		case int64:
+			if d < 0 {
+				return errRange
+			}
+			u = uint64(d)
+		case *big.Int:
+			u = d.Uint64()
+		default:
+			return mismatch(name, t)
+		}
+		if rv.OverflowUint(u) {
+			return errRange
+		}
+		rv.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if f, ok := data.(float64); ok {
+			if t.Kind() == reflect.Float32 && !math.IsInf(f, 0) &&
+				rv.OverflowFloat(f) {
+				return errRange
+			}
+			rv.SetFloat(f)
+			return nil
+		}
+	case reflect.String:
+		if s, ok := data.(string); ok {
+			rv.SetString(s)
+			return nil
+		}
+	case reflect.Slice:
+		if b, ok := data.([]byte); ok && t.Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+	case reflect.Array:
+		if b, ok := data.([]byte); ok && t.Elem().Kind() == reflect.Uint8 {
+			if len(b) != t.Len() {
+				return errData
+			}
+			reflect.Copy(rv, reflect.ValueOf(b))
+			return nil
+		}
+	}
+	return mismatch(name, t)
+}
+
+func mismatch(ref value.Value, t reflect.Type) error {
+	return fmt.Errorf("cannot decode %v into %v", ref, t)
+}
+
+var errNil = errors.New("cannot encode nil")
+var errDepth = errors.New("values nested too deeply")
+var errPointer = errors.New("Decode requires a non-nil pointer")
+var errMessage = errors.New("invalid message type")
+var errDefinition = errors.New("malformed type definition")
+var errUndefined = errors.New("reference to undefined type")
+var errTypeRef = errors.New("malformed type reference")
+var errData = errors.New("data does not match its type")
+var errRange = errors.New("value out of range for Go type")
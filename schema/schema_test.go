@@ -0,0 +1,198 @@
+package schema
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bford/cofo/value"
+)
+
+type point struct {
+	X, Y   int
+	Label  string
+	hidden int
+}
+
+type shape struct {
+	Name   string
+	Points []point
+	Tags   map[string]uint8
+	Next   *shape
+	Extra  interface{}
+	Addr   net.IP
+	Digest [4]byte
+}
+
+// A different Go type reading the same stream,
+// with a field missing, a field added, and a field of a wider type.
+type shapeView struct {
+	Name   string
+	Points []struct{ X, Y int64 }
+	Next   *shapeView
+	Color  string
+	Extra  interface{}
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := shape{
+		Name:   "tri",
+		Points: []point{{1, 2, "a", 7}, {-3, 4, "", 0}},
+		Tags:   map[string]uint8{"x": 1, "y": 255},
+		Next: &shape{Name: "inner", Points: []point{},
+			Tags: map[string]uint8{}, Addr: net.IP{}},
+		Extra:  []value.Value{int64(1), "two"},
+		Addr:   net.IPv4(10, 0, 0, 1),
+		Digest: [4]byte{1, 2, 3, 4},
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range []interface{}{in, in, 42, []string{"a", "b"}} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := buf.Bytes()
+
+	// Decode into the original types
+	dec := NewDecoder(bytes.NewReader(stream))
+	var out shape
+	for i := 0; i < 2; i++ {
+		out = shape{}
+		if err := dec.Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		want := in
+		want.Points = []point{{1, 2, "a", 0}, {-3, 4, "", 0}}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("decoded %+v", out)
+		}
+	}
+	var n int8
+	var ss []string
+	if err := dec.Decode(&n); err != nil || n != 42 {
+		t.Errorf("decoded %v, %v", n, err)
+	}
+	if err := dec.Decode(&ss); err != nil || len(ss) != 2 || ss[1] != "b" {
+		t.Errorf("decoded %v, %v", ss, err)
+	}
+	if err := dec.Decode(&n); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	// Decode into a different but compatible type
+	dec = NewDecoder(bytes.NewReader(stream))
+	var view shapeView
+	if err := dec.Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if view.Name != "tri" || len(view.Points) != 2 ||
+		view.Points[1].X != -3 || view.Next == nil ||
+		view.Next.Name != "inner" || view.Next.Next != nil {
+		t.Errorf("decoded view %+v", view)
+	}
+
+	// Decode without any Go types
+	dec = NewDecoder(bytes.NewReader(stream))
+	v, err := dec.DecodeValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := v.(value.Map)
+	if name, _ := m.Get("Name"); name != "tri" {
+		t.Errorf("dynamic Name %v", name)
+	}
+	pts, _ := m.Get("Points")
+	want := []value.Value{
+		value.Map{{Key: "X", Value: int64(1)}, {Key: "Y", Value: int64(2)},
+			{Key: "Label", Value: "a"}},
+		value.Map{{Key: "X", Value: int64(-3)}, {Key: "Y", Value: int64(4)},
+			{Key: "Label", Value: ""}},
+	}
+	if !value.Equal(pts, want) {
+		t.Errorf("dynamic Points %v", pts)
+	}
+	if next, _ := m.Get("Next"); next == nil {
+		t.Error("dynamic Next is nil")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if enc.Encode(nil) == nil {
+		t.Error("encoded nil")
+	}
+	if enc.Encode(struct{ C chan int }{}) == nil {
+		t.Error("encoded a channel")
+	}
+	enc.Encode(300)
+	enc.Encode(-1)
+	enc.Encode("s")
+
+	dec := NewDecoder(&buf)
+	var b uint8
+	var u uint
+	var i int
+	if dec.Decode(b) == nil {
+		t.Error("decoded into a non-pointer")
+	}
+	if dec.Decode(&b) == nil {
+		t.Error("decoded 300 into uint8")
+	}
+	if dec.Decode(&u) == nil {
+		t.Error("decoded -1 into uint")
+	}
+	if dec.Decode(&i) == nil {
+		t.Error("decoded string into int")
+	}
+
+	// A record referring to a type never defined
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	enc.e.Int64(0)
+	value.Encode(enc.e, int64(5))
+	value.Encode(enc.e, []value.Value{})
+	if _, err := NewDecoder(&buf).DecodeValue(); err != errUndefined {
+		t.Errorf("expected errUndefined, got %v", err)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(shape{}),
+		reflect.TypeOf(map[interface{}][]*point{}),
+		reflect.TypeOf(struct {
+			A int8
+			B uint16
+			C float32
+			D [3]string
+		}{}),
+	} {
+		for i := 0; i < 100; i++ {
+			in, err := Random(typ, r, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(in.Interface()); err != nil {
+				t.Fatalf("%v: %v", typ, err)
+			}
+			out := reflect.New(typ)
+			if err := NewDecoder(&buf).Decode(out.Interface()); err != nil {
+				t.Fatalf("%v: %v", typ, err)
+			}
+			if !reflect.DeepEqual(in.Interface(), out.Elem().Interface()) {
+				t.Fatalf("%v: encoded %+v\ndecoded %+v", typ,
+					in.Interface(), out.Elem().Interface())
+			}
+		}
+	}
+	if _, err := Random(reflect.TypeOf(struct{ C chan int }{}), r, 5); err == nil {
+		t.Error("generated a channel")
+	}
+}
@@ -0,0 +1,249 @@
+// Package seal encrypts and authenticates CBE blob content chunk by chunk,
+// with keys obtained from a pluggable KeyProvider,
+// so that encrypted blob archives can rotate keys
+// without changing their framing.
+//
+// An AEADTransform is a cbe.ChunkTransform
+// that seals each chunk with AES-GCM under the provider's current key.
+// Each sealed chunk consists of a CBE blob containing the key ID,
+// followed by a random 12-byte nonce and the AES-GCM ciphertext,
+// authenticated together with the key-ID blob.
+// A decoder looks up the key for each chunk by its key ID,
+// so chunks sealed under different keys can be mixed freely.
+// Each chunk is authenticated individually:
+// the transform alone does not detect chunks reordered or dropped
+// within a blob.
+//
+// For blobs whose chunks must not be reordered or dropped,
+// an EncryptedEncoder instead seals a blob's content in segments
+// following the STREAM construction,
+// deriving each segment's nonce from a per-blob random prefix
+// and the segment's position, and marking the last segment,
+// so that an EncryptedDecoder detects any rearrangement or truncation.
+// It uses a single cipher.AEAD rather than a KeyProvider.
+//
+// Key providers include a StaticKey, a KeyRing supporting rotation,
+// and EnvelopeKeys, which protects a random data key
+// with a KeyWrapper such as an adapter to a cloud KMS or age recipient.
+//
+// Early unstable prototype code.
+//
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// KeyProvider supplies keys for sealing and opening chunks.
+// Keys must be 16, 24, or 32 bytes long, selecting AES-128, -192, or -256.
+type KeyProvider interface {
+
+	// EncryptionKey returns the ID and value of the key
+	// with which to seal new chunks.
+	EncryptionKey() (id, key []byte, err error)
+
+	// DecryptionKey returns the value of the key with the given ID,
+	// or an error wrapping ErrUnknownKey if it is not available.
+	DecryptionKey(id []byte) (key []byte, err error)
+}
+
+// StaticKey is a KeyProvider with a single fixed key.
+type StaticKey struct {
+	ID  []byte
+	Key []byte
+}
+
+func (k StaticKey) EncryptionKey() (id, key []byte, err error) {
+	return k.ID, k.Key, nil
+}
+
+func (k StaticKey) DecryptionKey(id []byte) ([]byte, error) {
+	if string(id) != string(k.ID) {
+		return nil, ErrUnknownKey
+	}
+	return k.Key, nil
+}
+
+// KeyRing is a KeyProvider holding several keys by ID,
+// one of which is current for sealing.
+// Rotating to a new key leaves old keys available for opening
+// chunks sealed under them.
+// A KeyRing is safe for concurrent use.
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// Add a key to the ring, replacing any existing key with the same ID.
+func (r *KeyRing) Add(id, key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keys == nil {
+		r.keys = make(map[string][]byte)
+	}
+	r.keys[string(id)] = append([]byte{}, key...)
+}
+
+// Add a key to the ring and make it current for sealing.
+func (r *KeyRing) Rotate(id, key []byte) {
+	r.Add(id, key)
+	r.mu.Lock()
+	r.current = string(id)
+	r.mu.Unlock()
+}
+
+// Remove the key with the given ID, if present.
+// Chunks sealed under it can no longer be opened.
+func (r *KeyRing) Remove(id []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, string(id))
+}
+
+func (r *KeyRing) EncryptionKey() (id, key []byte, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[r.current]
+	if !ok {
+		return nil, nil, errNoCurrent
+	}
+	return []byte(r.current), key, nil
+}
+
+func (r *KeyRing) DecryptionKey(id []byte) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[string(id)]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// KeyWrapper protects data keys with a key held elsewhere,
+// such as in a cloud key management service or by an age identity.
+// Adapters to such services implement this interface.
+type KeyWrapper interface {
+	Wrap(dataKey []byte) (wrapped []byte, err error)
+	Unwrap(wrapped []byte) (dataKey []byte, err error)
+}
+
+// EnvelopeKeys is a KeyProvider implementing envelope encryption:
+// it seals chunks with a random data key,
+// whose wrapped form serves as the key ID,
+// and opens chunks by unwrapping the data key from the key ID.
+// Unwrapped keys are cached, so each is unwrapped only once.
+// EnvelopeKeys is safe for concurrent use.
+type EnvelopeKeys struct {
+	w       KeyWrapper
+	id, key []byte
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// Create an EnvelopeKeys provider using w,
+// generating and wrapping a fresh 32-byte data key for sealing.
+func NewEnvelopeKeys(w KeyWrapper) (*EnvelopeKeys, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	id, err := w.Wrap(key)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvelopeKeys{w: w, id: id, key: key,
+		cache: map[string][]byte{string(id): key}}, nil
+}
+
+func (e *EnvelopeKeys) EncryptionKey() (id, key []byte, err error) {
+	return e.id, e.key, nil
+}
+
+func (e *EnvelopeKeys) DecryptionKey(id []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if key, ok := e.cache[string(id)]; ok {
+		return key, nil
+	}
+	key, err := e.w.Unwrap(id)
+	if err != nil {
+		return nil, err
+	}
+	e.cache[string(id)] = key
+	return key, nil
+}
+
+// AEADTransform is a cbe.ChunkTransform sealing each chunk with AES-GCM
+// under keys from Keys.
+type AEADTransform struct {
+	Keys KeyProvider
+}
+
+const nonceLen = 12
+
+func (t AEADTransform) Encode(dst, chunk []byte) ([]byte, error) {
+	id, key, err := t.Keys.EncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	start := len(dst)
+	dst = cbe.Encode(dst, id)
+	ad := dst[start:]
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, chunk, ad), nil
+}
+
+func (t AEADTransform) Decode(dst, chunk []byte) ([]byte, error) {
+	id, rest, err := cbe.Decode(chunk)
+	if err != nil || len(rest) < nonceLen {
+		return nil, ErrOpen
+	}
+	ad := chunk[:len(chunk)-len(rest)]
+	key, err := t.Keys.DecryptionKey(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := aead.Open(dst, rest[:nonceLen], rest[nonceLen:], ad)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return out, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ErrUnknownKey indicates that the key a chunk was sealed under
+// is not available.
+var ErrUnknownKey = errors.New("unknown key ID")
+
+// ErrOpen indicates that a sealed chunk is corrupt or forged.
+var ErrOpen = errors.New("sealed chunk failed authentication")
+
+var errNoCurrent = errors.New("key ring has no current key")
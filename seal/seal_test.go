@@ -0,0 +1,180 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Encode content through an AEADTransform using keys.
+func sealBlob(t *testing.T, keys KeyProvider, content []byte) []byte {
+	var buf bytes.Buffer
+	te := cbe.NewTransformEncoder(cbe.NewEncoder(&buf), AEADTransform{keys})
+	te.SetChunkLen(100)
+	if err := te.Bytes(content); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openBlob(keys KeyProvider, sealed []byte) ([]byte, error) {
+	d := cbe.NewDecoder(bytes.NewReader(sealed))
+	return cbe.NewTransformDecoder(d, AEADTransform{keys}).Bytes()
+}
+
+func TestKeyRing(t *testing.T) {
+	content := bytes.Repeat([]byte("secret "), 50)
+	var ring KeyRing
+	if _, _, err := ring.EncryptionKey(); err == nil {
+		t.Error("empty key ring has a current key")
+	}
+	ring.Rotate([]byte("k1"), bytes.Repeat([]byte{1}, 32))
+	old := sealBlob(t, &ring, content)
+	ring.Rotate([]byte("k2"), bytes.Repeat([]byte{2}, 16))
+	cur := sealBlob(t, &ring, content)
+
+	if bytes.Contains(old, []byte("secret")) {
+		t.Error("sealed blob contains plaintext")
+	}
+	for _, sealed := range [][]byte{old, cur} {
+		got, err := openBlob(&ring, sealed)
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("open gave %v", err)
+		}
+	}
+
+	// Retired keys can no longer open their chunks
+	ring.Remove([]byte("k1"))
+	if _, err := openBlob(&ring, old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("open with removed key gave %v", err)
+	}
+
+	// Tampering is detected
+	bad := append([]byte{}, cur...)
+	bad[len(bad)/2] ^= 1
+	if _, err := openBlob(&ring, bad); err == nil {
+		t.Error("opened tampered blob")
+	}
+
+	// A static key opens only its own chunks
+	sk := StaticKey{[]byte("k2"), bytes.Repeat([]byte{2}, 16)}
+	if got, err := openBlob(sk, cur); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("static key open gave %v", err)
+	}
+	if _, err := openBlob(StaticKey{[]byte("x"), sk.Key}, cur); !errors.Is(err,
+		ErrUnknownKey) {
+		t.Errorf("wrong static key gave %v", err)
+	}
+}
+
+// A KeyWrapper standing in for a KMS, wrapping keys with a master key.
+type testWrapper struct {
+	aead    cipher.AEAD
+	unwraps int
+}
+
+func newTestWrapper() *testWrapper {
+	block, _ := aes.NewCipher(make([]byte, 32))
+	aead, _ := cipher.NewGCM(block)
+	return &testWrapper{aead: aead}
+}
+
+func (w *testWrapper) Wrap(key []byte) ([]byte, error) {
+	return w.aead.Seal(nil, make([]byte, 12), key, nil), nil
+}
+
+func (w *testWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	w.unwraps++
+	return w.aead.Open(nil, make([]byte, 12), wrapped, nil)
+}
+
+func TestEnvelope(t *testing.T) {
+	w := newTestWrapper()
+	ek, err := NewEnvelopeKeys(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("data"), 100)
+	sealed := sealBlob(t, ek, content)
+
+	// A separate reader with access to the wrapper can open the blob
+	reader, _ := NewEnvelopeKeys(w)
+	got, err := openBlob(reader, sealed)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("envelope open gave %v", err)
+	}
+	if w.unwraps != 1 {
+		t.Errorf("unwrapped data key %v times", w.unwraps)
+	}
+}
+
+func TestStream(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	aead, _ := cipher.NewGCM(block)
+	encrypt := func(contents ...[]byte) []byte {
+		var buf bytes.Buffer
+		se := NewEncryptedEncoder(cbe.NewEncoder(&buf), aead)
+		se.SetChunkLen(10)
+		for _, c := range contents {
+			if err := se.Bytes(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buf.Bytes()
+	}
+	decrypt := func(enc []byte) ([][]byte, error) {
+		sd := NewEncryptedDecoder(cbe.NewDecoder(bytes.NewReader(enc)), aead)
+		var got [][]byte
+		for {
+			b, err := sd.Bytes()
+			if err == io.EOF {
+				return got, nil
+			} else if err != nil {
+				return got, err
+			}
+			got = append(got, b)
+		}
+	}
+
+	contents := [][]byte{{}, []byte("short"), []byte("exactly 10"),
+		bytes.Repeat([]byte("long content "), 10)}
+	enc := encrypt(contents...)
+	got, err := decrypt(enc)
+	if err != nil || len(got) != len(contents) {
+		t.Fatalf("decrypted %v blobs, %v", len(got), err)
+	}
+	for i := range contents {
+		if !bytes.Equal(got[i], contents[i]) {
+			t.Errorf("blob %v decrypted as %q", i, got[i])
+		}
+	}
+
+	// Tampering with the segments of a multi-segment blob is detected
+	segs, err := cbe.DecodeAll(encrypt(contents[3]))
+	if err != nil || len(segs) != 1+13 {
+		t.Fatalf("got %v segments, %v", len(segs), err)
+	}
+	reencode := func(segs ...[]byte) []byte {
+		var b []byte
+		for _, s := range segs {
+			b = cbe.Encode(b, s)
+		}
+		return b
+	}
+	for name, bad := range map[string][]byte{
+		"swapped":   reencode(append(segs[:1:1], segs[2], segs[1])...),
+		"truncated": reencode(segs[:len(segs)-1]...),
+		"dropped":   reencode(append(segs[:3:3], segs[4:]...)...),
+		"flagged": reencode(segs[0], append([]byte{lastSegment},
+			segs[1][1:]...)),
+	} {
+		if _, err := decrypt(bad); err != ErrOpen {
+			t.Errorf("%s segments gave %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// An EncryptedEncoder encrypts and authenticates blobs of any length
+// in streaming fashion with an AEAD,
+// following the STREAM construction of Hoang, Reyhanitabar, Rogaway,
+// and Vizár, so that a decoder detects any segments
+// that were modified, reordered, dropped, or truncated from the end.
+//
+// Each encrypted blob is a series of CBE blobs:
+// a blob containing a random 7-byte nonce prefix,
+// followed by one or more segment blobs.
+// Each segment blob contains a flag byte, 1 for the last segment
+// and 0 for the others, followed by the AEAD ciphertext
+// of up to ChunkLen bytes of content.
+// The 12-byte nonce for each segment consists of the nonce prefix,
+// the segment's 4-byte big-endian index, and the flag byte.
+type EncryptedEncoder struct {
+	e        *cbe.Encoder
+	aead     cipher.AEAD
+	chunkLen int
+	in, out  []byte // plaintext and ciphertext segment buffers
+}
+
+const (
+	streamPrefixLen = 7 // length of the random nonce prefix
+	lastSegment     = 1 // flag byte marking the last segment
+)
+
+// Create an EncryptedEncoder that writes to e,
+// sealing content with aead, which must use 12-byte nonces,
+// as for AES-GCM and ChaCha20-Poly1305.
+// Panics if aead uses a different nonce size.
+func NewEncryptedEncoder(e *cbe.Encoder, aead cipher.AEAD) *EncryptedEncoder {
+	checkNonceSize(aead)
+	return &EncryptedEncoder{e: e, aead: aead,
+		chunkLen: cbe.DefaultTransformChunkLen}
+}
+
+// Set the maximum length of the content sealed in each segment.
+// Panics if chunkLen is not between 1 and cbe.MaxChunkLen.
+func (s *EncryptedEncoder) SetChunkLen(chunkLen int) {
+	if chunkLen < 1 || chunkLen > cbe.MaxChunkLen {
+		panic("invalid encrypted chunk length")
+	}
+	s.chunkLen = chunkLen
+}
+
+// Encrypt a blob with content read from r until EOF,
+// buffering only one segment at a time.
+func (s *EncryptedEncoder) ReadFrom(r io.Reader) (n int64, err error) {
+	var nonce [nonceLen]byte
+	if _, err := rand.Read(nonce[:streamPrefixLen]); err != nil {
+		return 0, err
+	}
+	if err := s.e.Bytes(nonce[:streamPrefixLen]); err != nil {
+		return 0, err
+	}
+
+	// Read one byte beyond each segment to learn whether it is the last
+	if cap(s.in) < s.chunkLen+1 {
+		s.in = make([]byte, s.chunkLen+1)
+	}
+	in := s.in[:s.chunkLen+1]
+	have := 0
+	tot := int64(0)
+	for i := uint64(0); ; i++ {
+		l, err := io.ReadFull(r, in[have:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return tot, err
+		}
+		l += have
+		flag := byte(0)
+		if l <= s.chunkLen {
+			flag = lastSegment
+		} else {
+			l = s.chunkLen
+		}
+		if i > 1<<32-1 {
+			return tot, errTooLong
+		}
+		binary.BigEndian.PutUint32(nonce[streamPrefixLen:], uint32(i))
+		nonce[nonceLen-1] = flag
+		s.out = append(s.out[:0], flag)
+		s.out = s.aead.Seal(s.out, nonce[:], in[:l], nil)
+		if err := s.e.Bytes(s.out); err != nil {
+			return tot, err
+		}
+		tot += int64(l)
+		if flag == lastSegment {
+			return tot, nil
+		}
+		in[0], have = in[l], 1
+	}
+}
+
+// Encrypt a byte slice as a blob.
+func (s *EncryptedEncoder) Bytes(b []byte) error {
+	_, err := s.ReadFrom(bytes.NewReader(b))
+	return err
+}
+
+// An EncryptedDecoder decrypts and authenticates blobs
+// encrypted by an EncryptedEncoder.
+type EncryptedDecoder struct {
+	d        *cbe.Decoder
+	aead     cipher.AEAD
+	seg, out []byte // ciphertext and plaintext segment buffers
+}
+
+// Create an EncryptedDecoder that reads from d,
+// opening content with aead, which must use 12-byte nonces.
+// Panics if aead uses a different nonce size.
+func NewEncryptedDecoder(d *cbe.Decoder, aead cipher.AEAD) *EncryptedDecoder {
+	checkNonceSize(aead)
+	return &EncryptedDecoder{d: d, aead: aead}
+}
+
+// Decrypt the next encrypted blob and write its content to w,
+// one segment at a time as each is authenticated.
+// Returns ErrOpen, after writing the content of any preceding segments,
+// if a segment fails authentication
+// or the input ends before the blob's last segment.
+func (s *EncryptedDecoder) WriteTo(w io.Writer) (n int64, err error) {
+	var nonce [nonceLen]byte
+	prefix, err := s.d.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	if len(prefix) != streamPrefixLen {
+		return 0, ErrOpen
+	}
+	copy(nonce[:], prefix)
+
+	tot := int64(0)
+	for i := uint64(0); i <= 1<<32-1; i++ {
+		s.seg, err = s.d.BytesAppend(s.seg[:0])
+		if err == io.EOF || errors.Is(err, cbe.ErrTruncated) {
+			return tot, ErrOpen // truncated before the last segment
+		} else if err != nil {
+			return tot, err
+		}
+		if len(s.seg) == 0 || s.seg[0] > lastSegment {
+			return tot, ErrOpen
+		}
+		flag := s.seg[0]
+		binary.BigEndian.PutUint32(nonce[streamPrefixLen:], uint32(i))
+		nonce[nonceLen-1] = flag
+		s.out, err = s.aead.Open(s.out[:0], nonce[:], s.seg[1:], nil)
+		if err != nil {
+			return tot, ErrOpen
+		}
+		l, err := w.Write(s.out)
+		tot += int64(l)
+		if err != nil {
+			return tot, err
+		}
+		if flag == lastSegment {
+			return tot, nil
+		}
+	}
+	return tot, errTooLong
+}
+
+// Decrypt the next encrypted blob into a byte slice.
+func (s *EncryptedDecoder) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func checkNonceSize(aead cipher.AEAD) {
+	if aead.NonceSize() != nonceLen {
+		panic("seal: AEAD nonce size must be 12 bytes")
+	}
+}
+
+var errTooLong = errors.New("encrypted blob has too many segments")
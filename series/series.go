@@ -0,0 +1,294 @@
+// Package series implements a simple time-series record file format
+// built on Composable Binary Encoding (CBE),
+// for capturing metrics, events, and other timestamped data.
+//
+// A series file consists of a sequence of records,
+// each a CBE-encoded signed integer timestamp blob,
+// holding nanoseconds since the Unix epoch,
+// followed by a CBE-encoded payload blob.
+// Timestamps never decrease from one record to the next.
+// The records are followed by a trailing sparse index blob
+// and a fixed 8-byte big-endian footer holding the index blob's offset,
+// as in package kv.
+// The index contains the timestamp and the unsigned integer byte offset
+// of every Nth record, starting with the first,
+// so that readers can seek to a time range
+// while reading only a few records outside it.
+//
+// Early unstable prototype code.
+//
+package series
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bford/cofo/cbe"
+)
+
+const footerLen = 8
+
+// Default number of records per index entry.
+const DefaultIndexInterval = 64
+
+// An index entry locating one record.
+type entry struct {
+	t   int64 // timestamp in Unix nanoseconds
+	ofs int64 // byte offset of the record
+}
+
+// A Writer writes a series file to an underlying output stream.
+// Records must be added in non-decreasing timestamp order.
+type Writer struct {
+	w        countWriter
+	enc      *cbe.Encoder
+	interval int
+	n        int   // number of records written
+	last     int64 // timestamp of the last record
+	idx      []entry
+	done     bool
+}
+
+// Create a new Writer that writes a series file to w.
+func NewWriter(w io.Writer) *Writer {
+	sw := &Writer{w: countWriter{w: w}, interval: DefaultIndexInterval}
+	sw.enc = cbe.NewEncoder(&sw.w)
+	return sw
+}
+
+// Set the number of records per index entry,
+// trading index size for the number of records
+// a reader may scan when seeking.
+// Panics if n is less than 1 or records have already been written.
+func (w *Writer) SetIndexInterval(n int) {
+	if n < 1 || w.n > 0 {
+		panic("invalid index interval")
+	}
+	w.interval = n
+}
+
+// Add a record with timestamp t and the given payload to the file.
+// Returns an error if t is before the previous record's timestamp.
+func (w *Writer) Write(t time.Time, payload []byte) error {
+	if w.done {
+		return errClosed
+	}
+	ts := t.UnixNano()
+	if w.n > 0 && ts < w.last {
+		return errOrder
+	}
+	if w.n%w.interval == 0 {
+		w.idx = append(w.idx, entry{ts, w.w.n})
+	}
+	w.n++
+	w.last = ts
+
+	if err := w.enc.Int64(ts); err != nil {
+		return err
+	}
+	return w.enc.Bytes(payload)
+}
+
+// Finish the file by writing its index and footer.
+// Does not close the underlying output stream.
+func (w *Writer) Close() error {
+	if w.done {
+		return errClosed
+	}
+	w.done = true
+
+	// Build and write the index blob
+	var idx bytes.Buffer
+	ienc := cbe.NewEncoder(&idx)
+	for _, ent := range w.idx {
+		if err := ienc.Int64(ent.t); err != nil {
+			return err
+		}
+		if err := ienc.Uint64(uint64(ent.ofs)); err != nil {
+			return err
+		}
+	}
+	idxOfs := w.w.n
+	if err := w.enc.Bytes(idx.Bytes()); err != nil {
+		return err
+	}
+
+	// Write the footer locating the index blob
+	var foot [footerLen]byte
+	binary.BigEndian.PutUint64(foot[:], uint64(idxOfs))
+	_, err := w.w.Write(foot[:])
+	return err
+}
+
+// A Reader provides time-range access to the records in a series file.
+type Reader struct {
+	r      io.ReaderAt
+	idx    []entry
+	idxOfs int64
+}
+
+// Open a series file of the given total size for reading from r.
+// Reads the file's sparse index into memory but no records.
+func Open(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < footerLen {
+		return nil, errFormat
+	}
+
+	// Read the footer to locate the index blob
+	var foot [footerLen]byte
+	if _, err := r.ReadAt(foot[:], size-footerLen); err != nil {
+		return nil, err
+	}
+	idxOfs := int64(binary.BigEndian.Uint64(foot[:]))
+	if idxOfs < 0 || idxOfs >= size-footerLen {
+		return nil, errFormat
+	}
+
+	// Read and decode the index blob
+	sr := io.NewSectionReader(r, idxOfs, size-footerLen-idxOfs)
+	b, err := cbe.NewDecoder(sr).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var idx []entry
+	dec := cbe.NewDecoder(bytes.NewReader(b))
+	for {
+		t, err := dec.Int64()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ofs, err := dec.Uint64()
+		if err != nil {
+			return nil, err
+		}
+		if int64(ofs) >= idxOfs || (len(idx) == 0 && ofs != 0) ||
+			(len(idx) > 0 && (int64(ofs) <= idx[len(idx)-1].ofs ||
+				t < idx[len(idx)-1].t)) {
+			return nil, errFormat
+		}
+		idx = append(idx, entry{t, int64(ofs)})
+	}
+	if len(idx) == 0 && idxOfs != 0 {
+		return nil, errFormat
+	}
+
+	return &Reader{r: r, idx: idx, idxOfs: idxOfs}, nil
+}
+
+// Returns an iterator over the records with timestamps
+// at or after start and before end.
+// A zero start or end leaves the range unbounded on that side.
+// The iterator begins reading at the last index entry before start,
+// so it scans at most one index interval of earlier records.
+func (r *Reader) Range(start, end time.Time) *Iter {
+	it := &Iter{}
+	if !start.IsZero() {
+		it.start, it.bounded = start.UnixNano(), true
+	}
+	if !end.IsZero() {
+		it.end, it.ended = end.UnixNano(), true
+	}
+
+	// Find the last index entry strictly before start.
+	// Records at start itself may precede an entry with an equal timestamp.
+	ofs := int64(0)
+	lo, hi := 0, len(r.idx)
+	for it.bounded && lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if r.idx[mid].t < it.start {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo > 0 {
+		ofs = r.idx[lo-1].ofs
+	}
+	it.dec = cbe.NewDecoder(io.NewSectionReader(r.r, ofs, r.idxOfs-ofs))
+	return it
+}
+
+// Returns an iterator over all records in the file.
+func (r *Reader) All() *Iter {
+	return r.Range(time.Time{}, time.Time{})
+}
+
+// Iter iterates over the records in a time range of a series file.
+type Iter struct {
+	dec     *cbe.Decoder
+	start   int64
+	end     int64
+	bounded bool // start is set
+	ended   bool // end is set
+	t       int64
+	payload []byte
+	err     error
+}
+
+// Advance to the next record in the range,
+// returning false at the end of the range or on error.
+func (it *Iter) Next() bool {
+	for it.dec != nil {
+		t, err := it.dec.Int64()
+		if err != nil {
+			if err != io.EOF {
+				it.err = err
+			}
+			break
+		}
+		payload, err := it.dec.Bytes()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			it.err = err
+			break
+		}
+		if it.ended && t >= it.end {
+			break
+		}
+		if it.bounded && t < it.start {
+			continue
+		}
+		it.t, it.payload = t, payload
+		return true
+	}
+	it.dec, it.payload = nil, nil
+	return false
+}
+
+// Returns the timestamp of the current record.
+func (it *Iter) Time() time.Time {
+	return time.Unix(0, it.t)
+}
+
+// Returns the payload of the current record.
+func (it *Iter) Payload() []byte {
+	return it.payload
+}
+
+// Returns the error, if any, that ended the iteration.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+var errOrder = errors.New("timestamps not in non-decreasing order")
+var errClosed = errors.New("writer already closed")
+var errFormat = errors.New("invalid series file format")
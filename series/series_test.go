@@ -0,0 +1,100 @@
+package series
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// countReaderAt counts the bytes read through it.
+type countReaderAt struct {
+	r *bytes.Reader
+	n int64
+}
+
+func (c *countReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestSeries(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	at := func(i int) time.Time { // three records per second
+		return base.Add(time.Duration(i/3) * time.Second)
+	}
+	const n = 10000
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetIndexInterval(10)
+	for i := 0; i < n; i++ {
+		if err := w.Write(at(i), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(at(0), nil); err != errOrder {
+		t.Errorf("expected errOrder, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(at(n), nil); err != errClosed {
+		t.Errorf("expected errClosed, got %v", err)
+	}
+
+	cr := &countReaderAt{r: bytes.NewReader(buf.Bytes())}
+	r, err := Open(cr, int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		start, end time.Time
+		first, cnt int
+	}{
+		{time.Time{}, time.Time{}, 0, n},
+		{at(300), at(330), 300, 30},
+		{at(301), at(303), 300, 3}, // equal timestamps straddle entries
+		{at(30), time.Time{}, 30, n - 30},
+		{time.Time{}, at(3), 0, 3},
+		{at(n + 10), time.Time{}, 0, 0},
+		{at(500), at(500), 0, 0},
+	} {
+		cr.n = 0
+		it := r.Range(c.start, c.end)
+		i := c.first
+		for it.Next() {
+			if !it.Time().Equal(at(i)) || string(it.Payload()) != strconv.Itoa(i) {
+				t.Errorf("record %d: got %v %q", i, it.Time(), it.Payload())
+			}
+			i++
+		}
+		if it.Err() != nil || i-c.first != c.cnt {
+			t.Errorf("range %v-%v gave %d records, %v",
+				c.start, c.end, i-c.first, it.Err())
+		}
+
+		// Short ranges read only a little of the file
+		if c.cnt > 0 && c.cnt <= 30 && cr.n > int64(buf.Len())/8 {
+			t.Errorf("range %v-%v read %d of %d bytes",
+				c.start, c.end, cr.n, buf.Len())
+		}
+	}
+
+	// An empty file has no records
+	buf.Reset()
+	if err := NewWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.All().Next() {
+		t.Error("record in empty file")
+	}
+	if _, err := Open(bytes.NewReader([]byte("short")), 5); err != errFormat {
+		t.Errorf("expected errFormat, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Package sign defines a signed-blob envelope for CBE,
+// so that applications exchanging signed content
+// share one interoperable convention rather than inventing their own.
+//
+// An envelope is a single CBE blob whose content consists of three blobs:
+// the signed content, an Ed25519 signature, and the ID of the signing key.
+// The key ID is the first 8 bytes of the SHA-256 hash of the public key,
+// letting a verifier holding several keys select the right one.
+// The signature covers the CBE encoding of a fixed context string
+// followed by the CBE encoding of the content,
+// so that envelope signatures cannot be confused
+// with signatures the same key makes for other purposes.
+// Envelopes must be canonically encoded,
+// so that each signed content has exactly one envelope per signature.
+//
+// Early unstable prototype code.
+//
+package sign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/coerr"
+)
+
+// Context string bound into every envelope signature.
+const context = "cofo signed blob v1"
+
+// Length of a key ID in bytes.
+const KeyIDLen = 8
+
+// Envelope holds the parts of a decoded signed-blob envelope.
+type Envelope struct {
+	Content   []byte // signed content
+	Signature []byte // Ed25519 signature
+	KeyID     []byte // ID of the signing key
+}
+
+// Return the key ID identifying the public key pub in envelopes.
+func KeyID(pub ed25519.PublicKey) []byte {
+	h := sha256.Sum256(pub)
+	return h[:KeyIDLen]
+}
+
+// Sign content with priv, returning the encoded envelope blob.
+func Sign(priv ed25519.PrivateKey, content []byte) []byte {
+	sig := ed25519.Sign(priv, message(content))
+	id := KeyID(priv.Public().(ed25519.PublicKey))
+	var inner []byte
+	inner = cbe.Encode(inner, content)
+	inner = cbe.Encode(inner, sig)
+	inner = cbe.Encode(inner, id)
+	return cbe.Encode(nil, inner)
+}
+
+// Decode an encoded envelope blob without verifying its signature,
+// for example to look up the verification key by KeyID.
+// The returned slices may alias blob.
+func Parse(blob []byte) (*Envelope, error) {
+	d := cbe.NewBytesDecoder(blob)
+	d.SetStrict(true)
+	inner, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Remaining()) != 0 {
+		return nil, errTrailing.At(int64(d.Offset()))
+	}
+	ed := cbe.NewBytesDecoder(inner)
+	ed.SetStrict(true)
+	var env Envelope
+	for _, p := range []*[]byte{&env.Content, &env.Signature, &env.KeyID} {
+		if *p, err = ed.Bytes(); err != nil {
+			return nil, errEnvelope
+		}
+	}
+	if len(ed.Remaining()) != 0 ||
+		len(env.Signature) != ed25519.SignatureSize ||
+		len(env.KeyID) != KeyIDLen {
+		return nil, errEnvelope
+	}
+	return &env, nil
+}
+
+// Verify that blob is an envelope signed by the key pub,
+// returning the signed content.
+// Returns ErrUnknownKey if the envelope names a different key,
+// and ErrSignature if the signature is invalid.
+func Verify(pub ed25519.PublicKey, blob []byte) ([]byte, error) {
+	env, err := Parse(blob)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(env.KeyID, KeyID(pub)) {
+		return nil, ErrUnknownKey
+	}
+	if !ed25519.Verify(pub, message(env.Content), env.Signature) {
+		return nil, ErrSignature
+	}
+	return env.Content, nil
+}
+
+// Return the message that an envelope's signature covers.
+func message(content []byte) []byte {
+	m := cbe.Encode(nil, []byte(context))
+	return cbe.Encode(m, content)
+}
+
+// ErrUnknownKey indicates that an envelope was signed by a different key
+// than the one it is verified against.
+var ErrUnknownKey = errors.New("envelope signed by a different key")
+
+// ErrSignature indicates that an envelope's signature is invalid.
+var ErrSignature = errors.New("invalid envelope signature")
+
+var errEnvelope = coerr.New(coerr.Syntax, "sign", -1, "malformed envelope")
+var errTrailing = coerr.New(coerr.Syntax, "sign", -1,
+	"data after envelope")
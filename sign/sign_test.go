@@ -0,0 +1,60 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/coerr"
+)
+
+func TestSign(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	priv := ed25519.NewKeyFromSeed(seed)
+	pub := priv.Public().(ed25519.PublicKey)
+	other := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+	for _, content := range [][]byte{
+		nil, []byte("hello"), bytes.Repeat([]byte("x"), 5*cbe.MinChunkLen),
+	} {
+		blob := Sign(priv, content)
+		if err := cbe.Verify(blob); err != nil {
+			t.Errorf("envelope not canonical: %v", err)
+		}
+		got, err := Verify(pub, blob)
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("Verify gave %v", err)
+		}
+		env, err := Parse(blob)
+		if err != nil || !bytes.Equal(env.KeyID, KeyID(pub)) {
+			t.Errorf("Parse gave %v", err)
+		}
+		_, err = Verify(other.Public().(ed25519.PublicKey), blob)
+		if err != ErrUnknownKey {
+			t.Errorf("Verify with other key gave %v", err)
+		}
+	}
+
+	// Tampering with the content or signature is detected
+	blob := Sign(priv, []byte("hello world"))
+	for _, i := range []int{3, len(blob) - 20} {
+		bad := append([]byte{}, blob...)
+		bad[i] ^= 1
+		if _, err := Verify(pub, bad); err != ErrSignature {
+			t.Errorf("Verify of tampered byte %v gave %v", i, err)
+		}
+	}
+
+	// Malformed envelopes are syntax errors
+	for _, bad := range [][]byte{
+		append(blob, 0),
+		cbe.Encode(nil, []byte("hello")),
+		cbe.Encode(nil, cbe.Encode(nil, []byte("hello"))),
+	} {
+		if _, err := Verify(pub, bad); !errors.Is(err, coerr.Syntax) {
+			t.Errorf("Verify of %x gave %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package testvectors
+
+import (
+	"bytes"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Generate an exhaustive suite of boundary-case test vectors,
+// using this repository's Go implementations as the reference.
+//
+// The generated CBE vectors cover every header size transition,
+// streaming chunk boundaries, and truncated encodings;
+// the CTS vectors cover bracket nesting and mismatch edge cases;
+// and the CRI vectors cover the legacy and nested IP literal forms
+// converted to each target form.
+//
+func Generate() *Suite {
+	s := &Suite{}
+	genCBE(s)
+	genCTS(s)
+	genCRI(s)
+	return s
+}
+
+// Content lengths at which CBE header encodings change.
+var cbeBoundaries = []int{
+	0, 1, 2, 3, 62, 63, 64, 65,
+	16446, 16447, 16448, 16449,
+	cbe.MaxChunkLen - 1, cbe.MaxChunkLen, cbe.MaxChunkLen + 1,
+	2 * cbe.MaxChunkLen,
+}
+
+func genCBE(s *Suite) {
+	add := func(content []byte) {
+		enc := cbe.Encode(nil, content)
+		s.CBE = append(s.CBE, CBEVector{Content: content, Encoding: enc})
+
+		// Every proper prefix of a non-empty encoding must fail to decode,
+		// but testing only the longest keeps the suite manageable.
+		if len(enc) > 0 {
+			s.CBE = append(s.CBE, CBEVector{Content: Bytes{},
+				Encoding: enc[:len(enc)-1], Error: true})
+		}
+	}
+
+	// All the 1-byte blobs, in and out of the header
+	for v := 0; v < 256; v++ {
+		add([]byte{byte(v)})
+	}
+
+	// Header size transitions
+	for _, n := range cbeBoundaries {
+		if n != 1 {
+			add(bytes.Repeat([]byte{0x5a}, n))
+		}
+	}
+
+	// Non-canonical streaming encodings in minimum-size chunks
+	for _, n := range []int{cbe.MinChunkLen, cbe.MinChunkLen + 1,
+		2 * cbe.MinChunkLen, 3*cbe.MinChunkLen + 100} {
+
+		content := bytes.Repeat([]byte{0xc3}, n)
+		var buf bytes.Buffer
+		enc := cbe.NewEncoder(&buf)
+		enc.SetChunkLen(cbe.MinChunkLen)
+		if err := enc.Bytes(content); err != nil {
+			panic(err)
+		}
+		s.CBE = append(s.CBE, CBEVector{Content: content,
+			Encoding: buf.Bytes(), Noncanon: true})
+	}
+}
+
+// Inputs for the CTS vectors, by bracket configuration.
+var ctsInputs = map[string][]string{
+	"": {
+		"[]", "x[]", "[]x", "[[]]", "[[[[[[[[]]]]]]]]",
+		"a[b]c[d]e", "a[b[c]d]e", "a[[b][c]]", "a[b]]",
+		"", "x", "]", "x]", "[", "x[", "[[]", "x[y[z]",
+		"(a[b)c]", "{[}]",
+	},
+	"()[]{}": {
+		"f(x)", "f[x]", "f{x}", "f(a[b]{c})r", "f(a(b)c)",
+		"f(]", "f(a]b)", "f)", "f(a", "f{a(b}c)",
+	},
+	"「」〈〉": {
+		"a「b」c", "a〈b「c」d〉e", "a「b〉", "「」",
+	},
+}
+
+func genCTS(s *Suite) {
+	for _, br := range []string{"", "()[]{}", "「」〈〉"} {
+		for _, in := range ctsInputs[br] {
+			head, open, tail, close, rest, err := GoCTS.Decode(br, in)
+			s.CTS = append(s.CTS, CTSVector{Brackets: br, Input: in,
+				Head: head, Open: open, Tail: tail, Close: close,
+				Rest: rest, Error: err != nil})
+		}
+	}
+}
+
+// Inputs for the CRI vectors.
+var criInputs = []string{
+	"https://foo.bar/", "https[//foo.bar/]",
+	"https://0.0.0.0/", "https://255.255.255.255:443/x",
+	"https://1.2.3/", "https://1.2.3.4.5/", "https://1234.1.1.1/",
+	"https://user@12.34.56.78/", "https://user:pw@12.34.56.78:80/",
+	"https://ip4[12.34.56.78]/", "https://IP4[12.34.56.78]/",
+	"https://[::]/", "https://[::1]:8080/", "https://[a:b::c:d]/",
+	"https://[::ffff:1.2.3.4]/", "https://[a:b::c:d/",
+	"https://ip6[a:b::c:d]/", "https://IP6[A:B::C:D]/",
+	"https[//12.34.56.78/]", "https[//ip6[a:b::c:d]/]",
+	"mailto:user@foo.bar", "urn:isbn:0451450523",
+	"//12.34.56.78/", "no-scheme",
+}
+
+func genCRI(s *Suite) {
+	for _, in := range criInputs {
+		for _, form := range []string{"uri", "iri", "cri"} {
+			for _, lazy := range []bool{false, true} {
+				out, err := GoCRI.Convert(form, lazy, in)
+				s.CRI = append(s.CRI, CRIVector{Input: in,
+					Form: form, Lazy: lazy, Output: out,
+					Error: err != nil})
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package testvectors
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/cri"
+	"github.com/bford/cofo/cts"
+)
+
+// Adapters running the suite against this repository's Go implementations.
+var (
+	GoCBE BlobCodec   = goCBE{}
+	GoCTS TextDecoder = goCTS{}
+	GoCRI Converter   = goCRI{}
+)
+
+type goCBE struct{}
+
+func (goCBE) Encode(content []byte) ([]byte, error) {
+	return cbe.Encode(nil, content), nil
+}
+
+func (goCBE) Decode(blob []byte) (content, rest []byte, err error) {
+	return cbe.Decode(blob)
+}
+
+type goCTS struct{}
+
+func (goCTS) Decode(brackets, in string) (head, open, tail, close,
+	rest string, err error) {
+
+	c := cts.Config{Brackets: cts.Brackets(brackets)}
+	d := c.NewDecoder(strings.NewReader(in))
+	h, o, t, c2, err := d.Decode()
+	if err != nil {
+		return "", "", "", "", "", err
+	}
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, d.Buffered()); err != nil {
+		return "", "", "", "", "", err
+	}
+	return h, string(o), t, string(c2), sb.String(), nil
+}
+
+type goCRI struct{}
+
+func (goCRI) Convert(form string, lazy bool, ri string) (string, error) {
+	var f cri.Form
+	switch form {
+	case "uri":
+		f = *cri.URI
+	case "iri":
+		f = *cri.IRI
+	case "cri":
+		f = *cri.CRI
+	default:
+		return "", errors.New("unknown form " + form)
+	}
+	f.Lazy = lazy
+	return f.From(ri)
+}
@@ -0,0 +1,55 @@
+package testvectors
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+)
+
+// HostileVector is an adversarial input for hardening decoders.
+// A decoder given a hostile input may succeed or fail,
+// but must do so without crashing
+// and with time and memory proportional to the input's actual length,
+// whatever lengths, counts, or nesting depths the input declares.
+//
+// The Format of a vector is one of the following:
+//
+//	"cbe"      a stream of blobs
+//	"value"    a stream of values of package value
+//	"cts"      CTS text
+//	"cts-xml"  a CTS element tree in XML form
+//	"cri"      a resource identifier
+//	"json"     JSON text for package value
+//	"msgpack"  a MessagePack stream
+//	"cbor"     a CBOR stream
+//	"bencode"  a bencode stream
+//	"schema"   a stream of package schema
+//	"kv"       a kv file
+//
+type HostileVector struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Note   string `json:"note"` // what makes the input hostile
+	Input  Bytes  `json:"input"`
+	Repeat int    `json:"repeat,omitempty"` // times to repeat Input, if > 1
+}
+
+// Returns the complete input of the vector.
+func (v HostileVector) Data() []byte {
+	if v.Repeat <= 1 {
+		return v.Input
+	}
+	return bytes.Repeat(v.Input, v.Repeat)
+}
+
+//go:embed testdata/hostile.json
+var hostileVectors []byte
+
+// Returns the corpus of hostile inputs included with this package.
+func Hostile() []HostileVector {
+	var vs []HostileVector
+	if err := json.Unmarshal(hostileVectors, &vs); err != nil {
+		panic("invalid built-in hostile vectors: " + err.Error())
+	}
+	return vs
+}
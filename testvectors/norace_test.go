@@ -0,0 +1,5 @@
+//go:build !race
+
+package testvectors
+
+const raceEnabled = false
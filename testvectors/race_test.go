@@ -0,0 +1,7 @@
+//go:build race
+
+package testvectors
+
+// The race detector randomly bypasses sync.Pool,
+// so allocation totals do not reflect the decoders' own use.
+const raceEnabled = true
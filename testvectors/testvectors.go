@@ -0,0 +1,279 @@
+// Package testvectors provides a machine-readable conformance suite
+// of test vectors for the composable formats in this repository,
+// and a harness that checks any implementation against it.
+//
+// The suite is a JSON document with one section per format.
+// Alternative implementations in other languages can load
+// testdata/vectors.json directly,
+// while Go implementations can run the suite via the Check methods
+// by providing adapters satisfying the small interfaces below.
+//
+// Binary data in the suite is represented either as a hex string,
+// or, for long data, as an array of pieces each of which is
+// either a hex string or a {"repeat": hex, "count": n} object
+// denoting the given hex bytes repeated n times.
+//
+// A separate corpus, testdata/hostile.json,
+// contains adversarial inputs for hardening decoders
+// against resource exhaustion, as described by HostileVector.
+//
+// Early unstable prototype code.
+//
+package testvectors
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Suite is a complete set of test vectors.
+type Suite struct {
+	CBE []CBEVector `json:"cbe"`
+	CTS []CTSVector `json:"cts"`
+	CRI []CRIVector `json:"cri"`
+}
+
+// CBEVector is a test vector for CBE blob encoding and decoding.
+type CBEVector struct {
+	Content  Bytes `json:"content"`            // blob content
+	Encoding Bytes `json:"encoding"`           // encoded blob
+	Error    bool  `json:"error,omitempty"`    // decoding must fail
+	Noncanon bool  `json:"noncanon,omitempty"` // not the canonical encoding
+}
+
+// CTSVector is a test vector for decoding one delimited CTS value.
+type CTSVector struct {
+	Brackets string `json:"brackets,omitempty"` // sensitive brackets
+	Input    string `json:"input"`
+	Head     string `json:"head"`
+	Open     string `json:"open"`
+	Tail     string `json:"tail"`
+	Close    string `json:"close"`
+	Rest     string `json:"rest"`
+	Error    bool   `json:"error,omitempty"` // decoding must fail
+}
+
+// CRIVector is a test vector for resource identifier conversion.
+type CRIVector struct {
+	Input  string `json:"input"`
+	Form   string `json:"form"` // target form: "uri", "iri", or "cri"
+	Lazy   bool   `json:"lazy,omitempty"`
+	Output string `json:"output"`
+	Error  bool   `json:"error,omitempty"` // conversion must fail
+}
+
+// BlobCodec is the interface a CBE implementation provides for testing.
+type BlobCodec interface {
+	Encode(content []byte) ([]byte, error)
+	Decode(blob []byte) (content, rest []byte, err error)
+}
+
+// TextDecoder is the interface a CTS implementation provides for testing.
+// It decodes one delimited value from in with the given sensitive brackets,
+// returning the head, brackets, tail, and remaining unread input.
+type TextDecoder interface {
+	Decode(brackets, in string) (head, open, tail, close, rest string,
+		err error)
+}
+
+// Converter is the interface a CRI implementation provides for testing.
+type Converter interface {
+	Convert(form string, lazy bool, ri string) (string, error)
+}
+
+// Failure describes one test vector that an implementation failed.
+type Failure struct {
+	Section string // "cbe", "cts", or "cri"
+	Index   int    // index of the vector within its section
+	Msg     string // description of the failure
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("%s vector %d: %s", f.Section, f.Index, f.Msg)
+}
+
+//go:embed testdata/vectors.json
+var defaultVectors []byte
+
+// Returns the test suite included with this package.
+func Default() *Suite {
+	s, err := Load(bytes.NewReader(defaultVectors))
+	if err != nil {
+		panic("invalid built-in test vectors: " + err.Error())
+	}
+	return s
+}
+
+// Load a test suite in JSON form from r.
+func Load(r io.Reader) (*Suite, error) {
+	s := &Suite{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write the test suite in JSON form to w.
+func (s *Suite) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(s)
+}
+
+// Check a CBE implementation against the suite's CBE vectors.
+func (s *Suite) CheckCBE(c BlobCodec) (fails []Failure) {
+	fail := func(i int, format string, args ...interface{}) {
+		fails = append(fails, Failure{"cbe", i, fmt.Sprintf(format, args...)})
+	}
+	for i, v := range s.CBE {
+		content, rest, err := c.Decode(v.Encoding)
+		switch {
+		case v.Error && err == nil:
+			fail(i, "decoding should have failed")
+		case !v.Error && err != nil:
+			fail(i, "decoding failed: %v", err)
+		case !v.Error && !bytes.Equal(content, v.Content):
+			fail(i, "decoded wrong content")
+		case !v.Error && len(rest) != 0:
+			fail(i, "decoding left %d bytes unconsumed", len(rest))
+		}
+		if v.Error || v.Noncanon {
+			continue
+		}
+
+		enc, err := c.Encode(v.Content)
+		if err != nil {
+			fail(i, "encoding failed: %v", err)
+		} else if !bytes.Equal(enc, v.Encoding) {
+			fail(i, "encoded incorrectly")
+		}
+	}
+	return fails
+}
+
+// Check a CTS implementation against the suite's CTS vectors.
+func (s *Suite) CheckCTS(d TextDecoder) (fails []Failure) {
+	for i, v := range s.CTS {
+		head, open, tail, close, rest, err := d.Decode(v.Brackets, v.Input)
+		msg := ""
+		switch {
+		case v.Error && err == nil:
+			msg = "decoding should have failed"
+		case !v.Error && err != nil:
+			msg = fmt.Sprintf("decoding failed: %v", err)
+		case !v.Error && (head != v.Head || open != v.Open ||
+			tail != v.Tail || close != v.Close || rest != v.Rest):
+			msg = fmt.Sprintf("decoded %q %q %q %q %q",
+				head, open, tail, close, rest)
+		}
+		if msg != "" {
+			fails = append(fails, Failure{"cts", i, msg})
+		}
+	}
+	return fails
+}
+
+// Check a CRI implementation against the suite's CRI vectors.
+func (s *Suite) CheckCRI(c Converter) (fails []Failure) {
+	for i, v := range s.CRI {
+		out, err := c.Convert(v.Form, v.Lazy, v.Input)
+		msg := ""
+		switch {
+		case v.Error && err == nil:
+			msg = "conversion should have failed"
+		case !v.Error && err != nil:
+			msg = fmt.Sprintf("conversion failed: %v", err)
+		case !v.Error && out != v.Output:
+			msg = fmt.Sprintf("converted to %q", out)
+		}
+		if msg != "" {
+			fails = append(fails, Failure{"cri", i, msg})
+		}
+	}
+	return fails
+}
+
+// Bytes is binary data in a test vector,
+// represented in JSON compactly as described in the package documentation.
+type Bytes []byte
+
+// Minimum length of a run of identical bytes
+// that MarshalJSON represents as a repeat piece.
+const minRun = 64
+
+// Marshal b as a hex string, or as an array of pieces if b has long runs.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	var pieces []interface{}
+	lit := 0 // start of pending literal piece
+	for i := 0; i < len(b); {
+		j := i + 1
+		for j < len(b) && b[j] == b[i] {
+			j++
+		}
+		if j-i >= minRun {
+			if lit < i {
+				pieces = append(pieces, hex.EncodeToString(b[lit:i]))
+			}
+			pieces = append(pieces, repeat{
+				Repeat: hex.EncodeToString(b[i : i+1]),
+				Count:  j - i})
+			lit = j
+		}
+		i = j
+	}
+	if len(pieces) == 0 {
+		return json.Marshal(hex.EncodeToString(b))
+	}
+	if lit < len(b) {
+		pieces = append(pieces, hex.EncodeToString(b[lit:]))
+	}
+	return json.Marshal(pieces)
+}
+
+// Unmarshal b from a hex string or an array of pieces.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b, err = hex.DecodeString(s)
+		return err
+	}
+
+	var pieces []json.RawMessage
+	if err := json.Unmarshal(data, &pieces); err != nil {
+		return err
+	}
+	var buf []byte
+	for _, p := range pieces {
+		if err := json.Unmarshal(p, &s); err == nil {
+			h, err := hex.DecodeString(s)
+			if err != nil {
+				return err
+			}
+			buf = append(buf, h...)
+			continue
+		}
+		var r repeat
+		if err := json.Unmarshal(p, &r); err != nil {
+			return err
+		}
+		h, err := hex.DecodeString(r.Repeat)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, bytes.Repeat(h, r.Count)...)
+	}
+	*b = buf
+	return nil
+}
+
+// repeat is a JSON piece denoting repeated bytes.
+type repeat struct {
+	Repeat string `json:"repeat"`
+	Count  int    `json:"count"`
+}
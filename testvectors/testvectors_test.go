@@ -0,0 +1,314 @@
+package testvectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"runtime"
+	"testing"
+	"testing/quick"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/conv"
+	"github.com/bford/cofo/cri"
+	"github.com/bford/cofo/cts"
+	"github.com/bford/cofo/kv"
+	"github.com/bford/cofo/schema"
+	"github.com/bford/cofo/value"
+)
+
+func TestGo(t *testing.T) {
+	s := Default()
+	if len(s.CBE) == 0 || len(s.CTS) == 0 || len(s.CRI) == 0 {
+		t.Fatal("missing test vectors")
+	}
+	fails := s.CheckCBE(GoCBE)
+	fails = append(fails, s.CheckCTS(GoCTS)...)
+	fails = append(fails, s.CheckCRI(GoCRI)...)
+	for _, f := range fails {
+		t.Error(f)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	for _, b := range []Bytes{{}, {1, 2, 3},
+		append(bytes.Repeat([]byte{7}, 100), 1, 2),
+		append([]byte{1, 2}, bytes.Repeat([]byte{7}, 100)...)} {
+
+		j, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d Bytes
+		if err := json.Unmarshal(j, &d); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, d) {
+			t.Errorf("%s did not round-trip", j)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s := Generate()
+
+	// The generated suite must survive a round-trip through JSON
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and be consistent with the implementations that generated it
+	fails := s.CheckCBE(GoCBE)
+	fails = append(fails, s.CheckCTS(GoCTS)...)
+	fails = append(fails, s.CheckCRI(GoCRI)...)
+	for _, f := range fails {
+		t.Error(f)
+	}
+}
+
+// Equivalence properties across the codecs.
+// There is no cbs package in this tree,
+// so the properties relate the several CBE code paths
+// and the codecs layered on the value model.
+
+// Every way of encoding a blob must produce content that
+// every way of decoding recovers,
+// and small blobs must have identical encodings on all paths.
+func TestBlobEquivalence(t *testing.T) {
+	prop := func(content []byte, large bool) bool {
+		if large {
+			content = bytes.Repeat(content, cbe.MinChunkLen/(len(content)+1)+2)
+		}
+		slice := cbe.Encode(nil, content)
+		var buf bytes.Buffer
+		cbe.NewEncoder(&buf).Bytes(content)
+		if len(content) < cbe.MinChunkLen && !bytes.Equal(slice, buf.Bytes()) {
+			return false
+		}
+		for _, enc := range [][]byte{slice, buf.Bytes()} {
+			d1, rest, err := cbe.Decode(enc)
+			if err != nil || len(rest) != 0 || !bytes.Equal(d1, content) {
+				return false
+			}
+			d2, err := cbe.NewDecoder(bytes.NewReader(enc)).Bytes()
+			if err != nil || !bytes.Equal(d2, content) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
+
+// Every codec for the value model must agree on each value.
+func TestValueEquivalence(t *testing.T) {
+	prop := func(a value.Arbitrary) bool {
+		v := a.V
+		b, err := value.Marshal(v)
+		if err != nil {
+			return false
+		}
+		var buf bytes.Buffer
+		if value.Encode(cbe.NewEncoder(&buf), v) != nil ||
+			!bytes.Equal(buf.Bytes(), b) {
+			return false
+		}
+		if d, err := value.Unmarshal(b); err != nil || !value.Equal(d, v) {
+			return false
+		}
+
+		// JSON represents every value
+		j, err := value.MarshalJSON(v)
+		if err != nil {
+			return false
+		}
+		if d, err := value.UnmarshalJSON(j); err != nil || !value.Equal(d, v) {
+			return false
+		}
+
+		// MessagePack represents values without huge integers
+		var mp, back bytes.Buffer
+		if conv.CBEToMsgpack(&mp, cbe.NewDecoder(bytes.NewReader(b)),
+			false) == nil {
+			if conv.MsgpackToCBE(cbe.NewEncoder(&back), &mp, false) != nil {
+				return false
+			}
+			d, err := value.Unmarshal(back.Bytes())
+			if err != nil || !value.Equal(d, v) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+// Decoders run against each format of hostile vector.
+var hostileDecoders = map[string][]func([]byte) error{
+	"cbe": {
+		func(b []byte) error { _, _, err := cbe.Decode(b); return err },
+		func(b []byte) error {
+			d := cbe.NewDecoder(bytes.NewReader(b))
+			for {
+				if _, err := d.WriteTo(io.Discard); err != nil {
+					return err
+				}
+			}
+		},
+		func(b []byte) error {
+			d := cbe.NewDecoder(bytes.NewReader(b))
+			for {
+				if _, err := d.Bytes(); err != nil {
+					return err
+				}
+			}
+		},
+		func(b []byte) error {
+			d := cbe.NewDecoder(bytes.NewReader(b))
+			d.SetAllocator(cbe.NewArena(0))
+			for {
+				if _, err := d.Bytes(); err != nil {
+					return err
+				}
+			}
+		},
+	},
+	"value": {
+		func(b []byte) error { _, err := value.Unmarshal(b); return err },
+		func(b []byte) error {
+			d := cbe.NewDecoder(bytes.NewReader(b))
+			for {
+				if _, err := value.Decode(d); err != nil {
+					return err
+				}
+			}
+		},
+	},
+	"cts": {
+		func(b []byte) error {
+			d := (&cts.Config{Brackets: cts.AsciiBrackets}).NewDecoder(
+				bytes.NewReader(b))
+			for {
+				if _, _, _, _, err := d.Decode(); err != nil {
+					return err
+				}
+			}
+		},
+		func(b []byte) error {
+			c := &cts.Config{Brackets: cts.AsciiBrackets}
+			nodes, err := c.Parse(bytes.NewReader(b))
+			if err != nil {
+				return err
+			}
+			return c.Format(io.Discard, nodes)
+		},
+		func(b []byte) error {
+			c := &cts.Config{Brackets: cts.AsciiBrackets}
+			nodes, err := c.Parse(bytes.NewReader(b))
+			if err != nil {
+				return err
+			}
+			return cts.WriteXML(io.Discard, nodes)
+		},
+	},
+	"cts-xml": {
+		func(b []byte) error {
+			c := &cts.Config{Brackets: cts.AsciiBrackets}
+			_, err := c.ReadXML(bytes.NewReader(b))
+			return err
+		},
+	},
+	"cri": {
+		func(b []byte) error { return cri.CRI.Check(string(b)) },
+		func(b []byte) error { _, err := cri.URI.From(string(b)); return err },
+		func(b []byte) error { _, err := cri.CRI.From(string(b)); return err },
+	},
+	"json": {
+		func(b []byte) error { _, err := value.UnmarshalJSON(b); return err },
+	},
+	"msgpack": {
+		func(b []byte) error {
+			return conv.MsgpackToCBE(cbe.NewEncoder(io.Discard),
+				bytes.NewReader(b), false)
+		},
+	},
+	"cbor": {
+		func(b []byte) error {
+			return conv.CBORToCBE(cbe.NewEncoder(io.Discard),
+				bytes.NewReader(b), false)
+		},
+		func(b []byte) error {
+			return conv.CBORToCBE(cbe.NewEncoder(io.Discard),
+				bytes.NewReader(b), true)
+		},
+	},
+	"bencode": {
+		func(b []byte) error {
+			return conv.BencodeToCBE(cbe.NewEncoder(io.Discard),
+				bytes.NewReader(b), false)
+		},
+	},
+	"schema": {
+		func(b []byte) error {
+			_, err := schema.NewDecoder(bytes.NewReader(b)).DecodeValue()
+			return err
+		},
+		func(b []byte) error {
+			var v interface{}
+			return schema.NewDecoder(bytes.NewReader(b)).Decode(&v)
+		},
+	},
+	"kv": {
+		func(b []byte) error {
+			_, err := kv.Open(bytes.NewReader(b), int64(len(b)))
+			return err
+		},
+	},
+}
+
+// Every decoder must survive the hostile corpus
+// using memory in proportion to each input's actual length,
+// a budget checked only without the race detector.
+func TestHostile(t *testing.T) {
+	vs := Hostile()
+	if len(vs) == 0 {
+		t.Fatal("missing hostile vectors")
+	}
+	for _, v := range vs {
+		decs, ok := hostileDecoders[v.Format]
+		if !ok {
+			t.Errorf("%s: unknown format %q", v.Name, v.Format)
+			continue
+		}
+		in := v.Data()
+		budget := 256*uint64(len(in)) + 16<<20
+		for i, dec := range decs {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s: decoder %d panicked: %v",
+							v.Name, i, r)
+					}
+				}()
+				dec(in)
+			}()
+			runtime.ReadMemStats(&after)
+			n := after.TotalAlloc - before.TotalAlloc
+			if n > budget && !raceEnabled {
+				t.Errorf("%s: decoder %d allocated %d bytes for %d input",
+					v.Name, i, n, len(in))
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package value
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// ChangeKind describes how a value differs between two versions.
+type ChangeKind int
+
+const (
+	Changed ChangeKind = iota // value differs
+	Added                     // value present only in the new version
+	Removed                   // value present only in the old version
+)
+
+func (k ChangeKind) String() string {
+	return [...]string{"changed", "added", "removed"}[k]
+}
+
+// Change is one difference found by Diff or DiffStreams.
+type Change struct {
+	Record int        // index of the top-level record, for DiffStreams
+	Path   string     // path to the differing value within the record
+	Kind   ChangeKind // kind of difference
+	Old    Value      // old value, unless added
+	New    Value      // new value, unless removed
+}
+
+// Formats the change in a line for human consumption.
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "."
+	}
+	show := func(v Value) string {
+		j, err := MarshalJSON(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(j)
+	}
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("record %d: %s added: %s",
+			c.Record, path, show(c.New))
+	case Removed:
+		return fmt.Sprintf("record %d: %s removed: %s",
+			c.Record, path, show(c.Old))
+	default:
+		return fmt.Sprintf("record %d: %s changed: %s -> %s",
+			c.Record, path, show(c.Old), show(c.New))
+	}
+}
+
+// Returns the differences between an old value a and a new value b,
+// descending into lists and maps that both versions contain
+// so as to report the smallest differing components.
+// List elements are compared by position
+// and map entries by key.
+// A path such as [2]["name"] locates each change:
+// list indexes are in brackets,
+// and map keys are in brackets in their JSON form.
+func Diff(a, b Value) []Change {
+	return diff(nil, "", a, b)
+}
+
+func diff(cs []Change, path string, a, b Value) []Change {
+	switch a := a.(type) {
+	case []Value:
+		if b, ok := b.([]Value); ok {
+			for i := 0; i < len(a) || i < len(b); i++ {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(b):
+					cs = append(cs, Change{Path: p, Kind: Removed,
+						Old: a[i]})
+				case i >= len(a):
+					cs = append(cs, Change{Path: p, Kind: Added,
+						New: b[i]})
+				default:
+					cs = diff(cs, p, a[i], b[i])
+				}
+			}
+			return cs
+		}
+	case Map:
+		if b, ok := b.(Map); ok {
+			return diffMaps(cs, path, a, b)
+		}
+	}
+	if !Equal(a, b) {
+		cs = append(cs, Change{Path: path, Kind: Changed, Old: a, New: b})
+	}
+	return cs
+}
+
+func diffMaps(cs []Change, path string, a, b Map) []Change {
+	// Visit keys in canonical order for deterministic output
+	var keys []Value
+	seen := make(map[string]bool)
+	for _, m := range []Map{a, b} {
+		for _, p := range m {
+			enc, _ := Marshal(p.Key)
+			if !seen[string(enc)] {
+				seen[string(enc)] = true
+				keys = append(keys, p.Key)
+			}
+		}
+	}
+	encs := make([]string, len(keys))
+	for i, k := range keys {
+		enc, _ := Marshal(k)
+		encs[i] = string(enc)
+	}
+	sort.Sort(byEncoding{keys, encs})
+
+	for _, k := range keys {
+		j, err := MarshalJSON(k)
+		if err != nil {
+			j = []byte(fmt.Sprint(k))
+		}
+		p := path + "[" + string(j) + "]"
+		av, ina := a.Get(k)
+		bv, inb := b.Get(k)
+		switch {
+		case !inb:
+			cs = append(cs, Change{Path: p, Kind: Removed, Old: av})
+		case !ina:
+			cs = append(cs, Change{Path: p, Kind: Added, New: bv})
+		default:
+			cs = diff(cs, p, av, bv)
+		}
+	}
+	return cs
+}
+
+type byEncoding struct {
+	keys []Value
+	encs []string
+}
+
+func (s byEncoding) Len() int           { return len(s.keys) }
+func (s byEncoding) Less(i, j int) bool { return s.encs[i] < s.encs[j] }
+func (s byEncoding) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.encs[i], s.encs[j] = s.encs[j], s.encs[i]
+}
+
+// Returns the differences between two streams of encoded values,
+// comparing the records at corresponding positions as Diff does,
+// and reporting records present in only one stream as added or removed.
+func DiffStreams(a, b *cbe.Decoder) ([]Change, error) {
+	var cs []Change
+	for rec := 0; ; rec++ {
+		av, erra := Decode(a)
+		bv, errb := Decode(b)
+		if erra != nil && erra != io.EOF {
+			return cs, erra
+		}
+		if errb != nil && errb != io.EOF {
+			return cs, errb
+		}
+		switch {
+		case erra == io.EOF && errb == io.EOF:
+			return cs, nil
+		case errb == io.EOF:
+			cs = append(cs, Change{Record: rec, Kind: Removed, Old: av})
+		case erra == io.EOF:
+			cs = append(cs, Change{Record: rec, Kind: Added, New: bv})
+		default:
+			n := len(cs)
+			cs = diff(cs, "", av, bv)
+			for i := n; i < len(cs); i++ {
+				cs[i].Record = rec
+			}
+		}
+	}
+}
+
+// Returns the changes formatted one per line.
+func FormatChanges(cs []Change) string {
+	var sb strings.Builder
+	for _, c := range cs {
+		sb.WriteString(c.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,345 @@
+package value
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bford/cofo/coerr"
+)
+
+// JSON conversion follows these rules,
+// under which every Value converts to JSON and back unchanged:
+//
+//	nil, bool  JSON null, true, and false
+//	integers   JSON numbers without a fraction or exponent,
+//	           of up to MaxDigits digits
+//	float64    JSON numbers always containing a '.' or exponent;
+//	           NaN and infinities are not representable
+//	string     JSON strings
+//	[]byte     {"$bytes": "<standard base64>"}
+//	[]Value    JSON arrays
+//	Map        JSON objects with keys sorted, if all keys are strings;
+//	           otherwise {"$map": [[key, value], ...]},
+//	           with entries in canonical encoding order
+//
+// Maps whose only key is "$bytes" or "$map" use the "$map" form
+// to avoid ambiguity.
+// JSON objects with duplicate keys are rejected.
+
+// Convert v to compact JSON.
+func MarshalJSON(v Value) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, v, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Convert a single JSON value to a Value.
+// Returns an error if data contains anything beyond the JSON value.
+func UnmarshalJSON(data []byte) (Value, error) {
+	dec := NewJSONDecoder(bytes.NewReader(data))
+	v, err := dec.Decode()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if _, err := dec.d.Token(); err != io.EOF {
+		return nil, errTrailing
+	}
+	return v, nil
+}
+
+// JSONDecoder reads a stream of JSON values as Values.
+type JSONDecoder struct {
+	d *json.Decoder
+}
+
+// Create a JSONDecoder reading JSON values from r.
+func NewJSONDecoder(r io.Reader) *JSONDecoder {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return &JSONDecoder{d}
+}
+
+// Decode the next JSON value in the stream.
+// Returns io.EOF at the end of the stream.
+func (jd *JSONDecoder) Decode() (Value, error) {
+	tok, err := jd.d.Token()
+	if err != nil {
+		return nil, err
+	}
+	return jd.value(tok, 0)
+}
+
+func (jd *JSONDecoder) value(tok json.Token, depth int) (Value, error) {
+	if depth > MaxDepth {
+		return nil, errDepth
+	}
+	switch tok := tok.(type) {
+	case nil, bool, string:
+		return tok, nil
+	case json.Number:
+		return parseJSONNumber(string(tok))
+	case json.Delim:
+		if tok == '[' {
+			l, err := jd.list(depth)
+			if err != nil {
+				return nil, err
+			}
+			return l, nil
+		}
+		return jd.object(depth)
+	}
+	return nil, errJSON
+}
+
+// Decode the remaining elements of a JSON array.
+func (jd *JSONDecoder) list(depth int) ([]Value, error) {
+	l := []Value{}
+	for {
+		tok, err := jd.token()
+		if err != nil {
+			return nil, err
+		}
+		if tok == json.Delim(']') {
+			return l, nil
+		}
+		v, err := jd.value(tok, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+}
+
+// Decode the remaining members of a JSON object.
+func (jd *JSONDecoder) object(depth int) (Value, error) {
+	m := Map{}
+	seen := make(map[string]bool)
+	for {
+		tok, err := jd.token()
+		if err != nil {
+			return nil, err
+		}
+		if tok == json.Delim('}') {
+			break
+		}
+		key := tok.(string) // the json package guarantees string keys
+		if seen[key] {
+			return nil, errDupKey
+		}
+		seen[key] = true
+		if tok, err = jd.token(); err != nil {
+			return nil, err
+		}
+		v, err := jd.value(tok, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, Pair{key, v})
+	}
+	if len(m) != 1 {
+		return m, nil
+	}
+
+	// Recognize the special single-member forms
+	switch m[0].Key {
+	case "$bytes":
+		s, ok := m[0].Value.(string)
+		if !ok {
+			return nil, errJSON
+		}
+		return base64.StdEncoding.Strict().DecodeString(s)
+	case "$map":
+		ents, ok := m[0].Value.([]Value)
+		if !ok {
+			return nil, errJSON
+		}
+		m = Map{}
+		for _, ent := range ents {
+			kv, ok := ent.([]Value)
+			if !ok || len(kv) != 2 {
+				return nil, errJSON
+			}
+			m = append(m, Pair{kv[0], kv[1]})
+		}
+		if _, err := Marshal(m); err != nil {
+			return nil, err // duplicate keys
+		}
+	}
+	return m, nil
+}
+
+// Read the next token, treating EOF as unexpected.
+func (jd *JSONDecoder) token() (json.Token, error) {
+	tok, err := jd.d.Token()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return tok, err
+}
+
+// Parse a JSON number as an integer if it has no fraction or exponent,
+// and as a float64 otherwise.
+func parseJSONNumber(s string) (Value, error) {
+	if strings.ContainsAny(s, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if len(s) > MaxDigits+1 { // allowing for a sign
+		return nil, errDigits
+	}
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, errJSON
+	}
+	return i, nil
+}
+
+func writeJSON(buf *bytes.Buffer, v Value, depth int) error {
+	if depth > MaxDepth {
+		return errDepth
+	}
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case uint64:
+		buf.WriteString(strconv.FormatUint(v, 10))
+	case *big.Int:
+		buf.WriteString(v.String())
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("NaN or infinity not representable in JSON")
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		buf.WriteString(s)
+	case string:
+		return writeJSONString(buf, v)
+	case []byte:
+		buf.WriteString(`{"$bytes":"`)
+		buf.WriteString(base64.StdEncoding.EncodeToString(v))
+		buf.WriteString(`"}`)
+	case []Value:
+		buf.WriteByte('[')
+		for i, elt := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, elt, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case Map:
+		return writeJSONMap(buf, v, depth)
+	default:
+		return errType
+	}
+	return nil
+}
+
+func writeJSONMap(buf *bytes.Buffer, m Map, depth int) error {
+
+	// Use an object if all keys are strings and the form is unambiguous
+	object := len(m) != 1 || (m[0].Key != "$bytes" && m[0].Key != "$map")
+	keys := make([]string, len(m))
+	for i, p := range m {
+		s, ok := p.Key.(string)
+		object = object && ok
+		keys[i] = s
+	}
+	if object {
+		idx := make([]int, len(m))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.Slice(idx, func(i, j int) bool {
+			return keys[idx[i]] < keys[idx[j]]
+		})
+		buf.WriteByte('{')
+		for i, j := range idx {
+			if i > 0 {
+				if keys[idx[i-1]] == keys[j] {
+					return errDupKey
+				}
+				buf.WriteByte(',')
+			}
+			if err := writeJSONString(buf, keys[j]); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSON(buf, m[j].Value, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	}
+
+	// Otherwise list the entries in canonical order
+	b, err := appendValue(nil, m, depth)
+	if err != nil {
+		return err
+	}
+	v, _, err := parseValue(b, depth)
+	if err != nil {
+		return err
+	}
+	buf.WriteString(`{"$map":[`)
+	for i, p := range v.(Map) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('[')
+		if err := writeJSON(buf, p.Key, depth+1); err != nil {
+			return err
+		}
+		buf.WriteByte(',')
+		if err := writeJSON(buf, p.Value, depth+1); err != nil {
+			return err
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteString(`]}`)
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1) // remove the newline Encode appends
+	return nil
+}
+
+var errJSON = errors.New("invalid JSON form of value")
+var errDigits = coerr.New(coerr.TooLarge, "value", -1,
+	"integer has too many digits")
@@ -0,0 +1,127 @@
+package value
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"sort"
+)
+
+// Returns a random Value for property-based testing,
+// in the style of testing/quick.
+// The size bounds the lengths of strings, byte strings, lists, and maps,
+// and roughly halves with each level of nesting, limiting the depth.
+// Every generated value encodes successfully:
+// strings are valid UTF-8, map keys are distinct,
+// and floats are never NaN or infinite, so values also convert to JSON.
+// As in decoded values, integers are int64 when they fit
+// and *big.Int otherwise, and map entries are in canonical order.
+func Random(r *rand.Rand, size int) Value {
+	if size < 0 {
+		size = 0
+	}
+	n := 6 // scalar kinds
+	if size > 0 {
+		n = 8 // also lists and maps
+	}
+	switch r.Intn(n) {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 1
+	case 2:
+		return randomInt(r)
+	case 3:
+		f := math.Float64frombits(r.Uint64())
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			f = r.NormFloat64()
+		}
+		return f
+	case 4:
+		b := make([]byte, r.Intn(size+1))
+		r.Read(b)
+		return b
+	case 5:
+		return RandomString(r, size)
+	case 6:
+		l := make([]Value, r.Intn(size+1))
+		for i := range l {
+			l[i] = Random(r, size/2)
+		}
+		return l
+	}
+	m := Map{}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		k := Random(r, size/2)
+		if _, dup := m.Get(k); !dup {
+			m = append(m, Pair{k, Random(r, size/2)})
+		}
+	}
+
+	// Sort the entries into canonical order by their key encodings
+	keys := make([][]byte, len(m))
+	for i := range m {
+		keys[i], _ = Marshal(m[i].Key)
+	}
+	idx := make([]int, len(m))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return bytes.Compare(keys[idx[i]], keys[idx[j]]) < 0
+	})
+	sorted := make(Map, len(m))
+	for i, j := range idx {
+		sorted[i] = m[j]
+	}
+	return sorted
+}
+
+// Returns a random integer, favoring small magnitudes and boundary cases.
+func randomInt(r *rand.Rand) Value {
+	switch r.Intn(4) {
+	case 0:
+		return int64(r.Intn(256) - 128)
+	case 1:
+		return []int64{0, -1, math.MaxInt64, math.MinInt64}[r.Intn(4)]
+	case 2:
+		return int64(r.Uint64())
+	}
+	// A big integer beyond 64 bits
+	i := new(big.Int).Lsh(big.NewInt(1), 64+uint(r.Intn(128)))
+	i.Add(i, new(big.Int).SetUint64(r.Uint64()))
+	if r.Intn(2) == 1 {
+		i.Neg(i)
+	}
+	return i
+}
+
+// Returns a random valid UTF-8 string of up to size characters,
+// mixing ASCII with multi-byte characters.
+func RandomString(r *rand.Rand, size int) string {
+	rs := make([]rune, r.Intn(size+1))
+	for i := range rs {
+		switch r.Intn(4) {
+		case 0:
+			rs[i] = rune(0x80 + r.Intn(0xd800-0x80))
+		case 1:
+			rs[i] = rune(0x10000 + r.Intn(0x100000))
+		default:
+			rs[i] = rune(r.Intn(0x80))
+		}
+	}
+	return string(rs)
+}
+
+// Arbitrary holds a Value and implements testing/quick's Generator,
+// so that quick.Check can generate Value arguments of this type.
+type Arbitrary struct {
+	V Value
+}
+
+// Returns an Arbitrary holding a random Value of the given size.
+func (Arbitrary) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(Arbitrary{Random(r, size)})
+}
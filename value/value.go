@@ -0,0 +1,388 @@
+// Package value implements a dynamic, self-describing value model
+// encoded as CBE blobs.
+//
+// A Value is one of the following Go types:
+//
+//	nil        the null value
+//	bool       a boolean
+//	int64      an integer that fits in 64 bits
+//	*big.Int   an arbitrary-precision integer
+//	float64    an IEEE 754 double-precision number
+//	[]byte     a binary byte string
+//	string     a UTF-8 text string
+//	[]Value    a list of values
+//	Map        an ordered map of key-value pairs
+//
+// Encoding also accepts the types int and uint64 as integers.
+// Decoding produces an int64 for every integer that fits in 64 bits,
+// and a *big.Int otherwise.
+//
+// Each value is encoded as a pair of consecutive CBE blobs:
+// a type-code blob containing a single ASCII character,
+// followed by a content blob as follows:
+//
+//	'n'  nil: empty content
+//	'b'  bool: one byte, 0 or 1
+//	'i'  integer: big-endian zigzag-encoded signed integer
+//	'f'  float64: 8 bytes in big-endian IEEE 754 format
+//	'd'  []byte: the bytes themselves
+//	's'  string: the UTF-8 bytes of the string
+//	'l'  list: the concatenated encodings of the elements
+//	'm'  map: the concatenated encodings of the keys and values
+//
+// Encoding is canonical: integers are minimal,
+// and map entries are sorted by the encodings of their keys,
+// which must be distinct.
+// Each distinct value thus has exactly one encoding,
+// as long as its blobs are small enough to have only one encoding,
+// which is the case for values whose encoding is less than 16448 bytes.
+//
+// Early unstable prototype code.
+//
+package value
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/bford/cofo/cbe"
+	"github.com/bford/cofo/coerr"
+)
+
+// Value is a dynamically-typed value of one of the types listed above.
+type Value = interface{}
+
+// Map is an ordered sequence of key-value pairs.
+type Map []Pair
+
+// Pair is one key-value pair in a Map.
+type Pair struct {
+	Key, Value Value
+}
+
+// Look up key in the map.
+func (m Map) Get(key Value) (Value, bool) {
+	for _, p := range m {
+		if Equal(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Type codes.
+const (
+	codeNil    = 'n'
+	codeBool   = 'b'
+	codeInt    = 'i'
+	codeFloat  = 'f'
+	codeBytes  = 'd'
+	codeString = 's'
+	codeList   = 'l'
+	codeMap    = 'm'
+)
+
+// Maximum nesting depth of lists and maps accepted when decoding.
+const MaxDepth = 1000
+
+// Maximum number of decimal digits accepted in an integer
+// when decoding text formats such as JSON,
+// since decimal-to-binary conversion takes time and memory
+// superlinear in the number of digits.
+const MaxDigits = 10000
+
+// Append the encoding of v to dst.
+func Append(dst []byte, v Value) ([]byte, error) {
+	return appendValue(dst, v, 0)
+}
+
+// Marshal v into its encoding.
+func Marshal(v Value) ([]byte, error) {
+	return Append(nil, v)
+}
+
+// Encode v to e.
+func Encode(e *cbe.Encoder, v Value) error {
+	code, content, err := valueContent(v, 0)
+	if err != nil {
+		return err
+	}
+	if err := e.Bytes([]byte{code}); err != nil {
+		return err
+	}
+	return e.Bytes(content)
+}
+
+func appendValue(dst []byte, v Value, depth int) ([]byte, error) {
+	code, content, err := valueContent(v, depth)
+	if err != nil {
+		return nil, err
+	}
+	dst = cbe.Encode(dst, []byte{code})
+	return cbe.Encode(dst, content), nil
+}
+
+// Returns the type code and content blob for a value.
+func valueContent(v Value, depth int) (code byte, content []byte, err error) {
+	if depth > MaxDepth {
+		return 0, nil, errDepth
+	}
+	switch v := v.(type) {
+	case nil:
+		code = codeNil
+	case bool:
+		code = codeBool
+		content = []byte{0}
+		if v {
+			content[0] = 1
+		}
+	case int:
+		code, content = codeInt, intContent(int64(v))
+	case int64:
+		code, content = codeInt, intContent(v)
+	case uint64:
+		code = codeInt
+		content = bigContent(new(big.Int).SetUint64(v))
+	case *big.Int:
+		code, content = codeInt, bigContent(v)
+	case float64:
+		code = codeFloat
+		content = binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+	case []byte:
+		code, content = codeBytes, v
+	case string:
+		if !utf8.ValidString(v) {
+			return 0, nil, errUTF8
+		}
+		code, content = codeString, []byte(v)
+	case []Value:
+		code = codeList
+		for _, elt := range v {
+			var err error
+			content, err = appendValue(content, elt, depth+1)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+	case Map:
+		code = codeMap
+		ents := make([][2][]byte, len(v))
+		for i, p := range v {
+			k, err := appendValue(nil, p.Key, depth+1)
+			if err != nil {
+				return 0, nil, err
+			}
+			e, err := appendValue(nil, p.Value, depth+1)
+			if err != nil {
+				return 0, nil, err
+			}
+			ents[i] = [2][]byte{k, e}
+		}
+		sort.Slice(ents, func(i, j int) bool {
+			return bytes.Compare(ents[i][0], ents[j][0]) < 0
+		})
+		for i, ent := range ents {
+			if i > 0 && bytes.Equal(ents[i-1][0], ent[0]) {
+				return 0, nil, errDupKey
+			}
+			content = append(content, ent[0]...)
+			content = append(content, ent[1]...)
+		}
+	default:
+		return 0, nil, errType
+	}
+	return code, content, nil
+}
+
+// Returns the zigzag-encoded minimal big-endian content of an integer.
+func intContent(v int64) []byte {
+	b := cbe.AppendInt64(nil, v)
+	content, _, _ := cbe.Decode(b)
+	return content
+}
+
+func bigContent(v *big.Int) []byte {
+	if v.IsInt64() {
+		return intContent(v.Int64())
+	}
+	u := new(big.Int)
+	if v.Sign() >= 0 {
+		u.Lsh(v, 1)
+	} else {
+		u.Not(v) // -v-1
+		u.Lsh(u, 1)
+		u.SetBit(u, 0, 1)
+	}
+	return u.Bytes()
+}
+
+// Unmarshal a value from its encoding,
+// which must contain nothing after the value.
+func Unmarshal(b []byte) (Value, error) {
+	v, rest, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errTrailing
+	}
+	return v, nil
+}
+
+// Parse one value from the start of b,
+// returning the value and the remainder of b following it.
+// Byte strings in the returned value may share memory with b.
+func Parse(b []byte) (v Value, rest []byte, err error) {
+	return parseValue(b, 0)
+}
+
+// Decode one value from d.
+func Decode(d *cbe.Decoder) (Value, error) {
+	typ, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	content, err := d.Bytes()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return parseContent(typ, content, 0)
+}
+
+func parseValue(b []byte, depth int) (v Value, rest []byte, err error) {
+	typ, b, err := cbe.Decode(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, rest, err := cbe.Decode(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err = parseContent(typ, content, depth)
+	return v, rest, err
+}
+
+func parseContent(typ, content []byte, depth int) (Value, error) {
+	if depth > MaxDepth {
+		return nil, errDepth
+	}
+	if len(typ) != 1 {
+		return nil, errCode
+	}
+	switch typ[0] {
+	case codeNil:
+		if len(content) != 0 {
+			return nil, errContent
+		}
+		return nil, nil
+
+	case codeBool:
+		if len(content) != 1 || content[0] > 1 {
+			return nil, errContent
+		}
+		return content[0] == 1, nil
+
+	case codeInt:
+		if len(content) > 0 && content[0] == 0 {
+			return nil, errIntMinimal
+		}
+		if len(content) <= 8 {
+			var b8 [8]byte
+			copy(b8[8-len(content):], content)
+			u := binary.BigEndian.Uint64(b8[:])
+			if u&1 == 0 {
+				return int64(u >> 1), nil
+			}
+			return -1 - int64(u>>1), nil
+		}
+		v := new(big.Int).SetBytes(content)
+		neg := v.Bit(0) != 0
+		v.Rsh(v, 1)
+		if neg {
+			v.Not(v)
+		}
+		return v, nil
+
+	case codeFloat:
+		if len(content) != 8 {
+			return nil, errContent
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(content)), nil
+
+	case codeBytes:
+		return content, nil
+
+	case codeString:
+		if !utf8.Valid(content) {
+			return nil, errUTF8
+		}
+		return string(content), nil
+
+	case codeList:
+		l := []Value{}
+		for len(content) > 0 {
+			v, rest, err := parseValue(content, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+			content = rest
+		}
+		return l, nil
+
+	case codeMap:
+		m := Map{}
+		var prev []byte
+		for len(content) > 0 {
+			k, rest, err := parseValue(content, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			kenc := content[:len(content)-len(rest)]
+			if prev != nil && bytes.Compare(prev, kenc) >= 0 {
+				return nil, errKeyOrder
+			}
+			prev = kenc
+			v, rest, err := parseValue(rest, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, Pair{k, v})
+			content = rest
+		}
+		return m, nil
+
+	default:
+		return nil, errCode
+	}
+}
+
+// Returns true if a and b are the same value,
+// which is the case if they have the same canonical encoding.
+// Values that cannot be encoded are equal to nothing.
+func Equal(a, b Value) bool {
+	ea, erra := Marshal(a)
+	eb, errb := Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ea, eb)
+}
+
+var errType = errors.New("unsupported type for value encoding")
+var errUTF8 = errors.New("string is not valid UTF-8")
+var errDupKey = errors.New("duplicate map key")
+var errKeyOrder = coerr.New(coerr.NonCanonical, "value", -1,
+	"map keys not in canonical order")
+var errDepth = errors.New("values nested too deeply")
+var errCode = errors.New("invalid value type code")
+var errContent = errors.New("invalid value content")
+var errIntMinimal = coerr.New(coerr.NonCanonical, "value", -1,
+	"non-minimal integer")
+var errTrailing = errors.New("unexpected data after value")
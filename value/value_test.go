@@ -0,0 +1,232 @@
+package value
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+	"testing/quick"
+
+	"github.com/bford/cofo/cbe"
+)
+
+var bigNeg, _ = new(big.Int).SetString(
+	"-100000000000000000000000000000000000000000000000000", 10)
+
+var testValues = []Value{
+	nil, true, false,
+	int64(0), int64(1), int64(-1), int64(1 << 62), int64(-1 << 63),
+	bigNeg,
+	0.0, 1.5, -1e300,
+	[]byte{}, []byte{0, 1, 2, 0xff},
+	"", "hello", "ünïcödé",
+	[]Value{}, []Value{int64(1), "two", []Value{3.0, nil}},
+	Map{}, Map{{"b", int64(2)}, {"a", int64(1)}, {int64(3), []Value{}}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for i, v := range testValues {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := Unmarshal(b)
+		if err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if !Equal(d, v) {
+			t.Errorf("case %v: %v decoded as %v", i, v, d)
+		}
+
+		// Streaming encoding and decoding should agree
+		var buf bytes.Buffer
+		if err := Encode(cbe.NewEncoder(&buf), v); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), b) {
+			t.Errorf("case %v: Encode and Marshal differ", i)
+		}
+		d, err = Decode(cbe.NewDecoder(&buf))
+		if err != nil || !Equal(d, v) {
+			t.Errorf("case %v: Decode gave %v, %v", i, d, err)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	// Integers decode to int64 whenever they fit
+	v, _ := Unmarshal(mustMarshal(t, big.NewInt(-5)))
+	if v != int64(-5) {
+		t.Errorf("small big.Int decoded as %T %v", v, v)
+	}
+	if !Equal(int(7), uint64(7)) || Equal(int64(7), 7.0) {
+		t.Error("integer equality broken")
+	}
+
+	// Map entry order must not matter
+	m1 := Map{{"a", int64(1)}, {"b", int64(2)}}
+	m2 := Map{{"b", int64(2)}, {"a", int64(1)}}
+	if !bytes.Equal(mustMarshal(t, m1), mustMarshal(t, m2)) {
+		t.Error("map encoding depends on entry order")
+	}
+	if _, err := Marshal(Map{{"a", nil}, {"a", nil}}); err == nil {
+		t.Error("encoded map with duplicate keys")
+	}
+
+	// Non-canonical encodings must be rejected
+	bad := [][]byte{
+		{'i', 0x81, 0x00},                      // non-minimal integer
+		{'b', 0x02},                            // invalid bool
+		{'m', 0x84, 's', 0x81, 'b', 'n', 0x80}, // truncated map
+		{'x', 0x80},                            // unknown type code
+	}
+	for i, b := range bad {
+		if _, err := Unmarshal(b); err == nil {
+			t.Errorf("accepted bad encoding %v", i)
+		}
+	}
+	var unsorted []byte
+	for _, k := range []string{"b", "a"} {
+		unsorted = append(unsorted, mustMarshal(t, k)...)
+		unsorted = append(unsorted, mustMarshal(t, nil)...)
+	}
+	enc := cbe.Encode(cbe.Encode(nil, []byte{'m'}), unsorted)
+	if _, err := Unmarshal(enc); err == nil {
+		t.Error("accepted map with unsorted keys")
+	}
+}
+
+func TestDepth(t *testing.T) {
+	var v Value = []Value{}
+	for i := 0; i < MaxDepth+1; i++ {
+		v = []Value{v}
+	}
+	if _, err := Marshal(v); err == nil {
+		t.Error("encoded value nested too deeply")
+	}
+}
+
+func mustMarshal(t *testing.T, v Value) []byte {
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestJSON(t *testing.T) {
+	for i, v := range testValues {
+		j, err := MarshalJSON(v)
+		if err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		d, err := UnmarshalJSON(j)
+		if err != nil {
+			t.Fatalf("case %v: %s: %v", i, j, err)
+		}
+		if !Equal(d, v) {
+			t.Errorf("case %v: %s decoded as %v", i, j, d)
+		}
+	}
+
+	// Check the exact JSON forms
+	for _, c := range []struct {
+		v Value
+		j string
+	}{
+		{int64(-3), `-3`},
+		{bigNeg, bigNeg.String()},
+		{2.0, `2.0`},
+		{1e21, `1e+21`},
+		{[]byte("hi"), `{"$bytes":"aGk="}`},
+		{"<&>", `"<&>"`},
+		{Map{{"b", nil}, {"a", true}}, `{"a":true,"b":null}`},
+		{Map{{int64(1), "x"}}, `{"$map":[[1,"x"]]}`},
+		{Map{{"$bytes", "x"}}, `{"$map":[["$bytes","x"]]}`},
+	} {
+		j, err := MarshalJSON(c.v)
+		if err != nil || string(j) != c.j {
+			t.Errorf("%v converted to %s, %v; want %s", c.v, j, err, c.j)
+		}
+	}
+
+	// Invalid or unrepresentable JSON must be rejected
+	for _, j := range []string{
+		`{"a":1,"a":2}`, `{"$bytes":1}`, `{"$bytes":"!"}`,
+		`{"$map":[[1,2],[1,3]]}`, `[1,`, `1 2`, `{"$map":[[1]]}`,
+	} {
+		if _, err := UnmarshalJSON([]byte(j)); err == nil {
+			t.Errorf("accepted invalid JSON %s", j)
+		}
+	}
+	if _, err := MarshalJSON(math.NaN()); err == nil {
+		t.Error("converted NaN to JSON")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := Map{{"name", "x"}, {"tags", []Value{"a", "b"}}, {"n", int64(1)}}
+	b := Map{{"name", "y"}, {"tags", []Value{"a"}}, {"m", nil}, {"n", int64(1)}}
+	got := FormatChanges(Diff(a, b))
+	want := `record 0: ["m"] added: null
+record 0: ["name"] changed: "x" -> "y"
+record 0: ["tags"][1] removed: "b"
+`
+	if got != want {
+		t.Errorf("Diff gave:\n%s\nwant:\n%s", got, want)
+	}
+	if cs := Diff(a, a); len(cs) != 0 {
+		t.Errorf("identical values differ: %v", cs)
+	}
+
+	var sa, sb bytes.Buffer
+	for _, v := range []Value{int64(1), a, "same"} {
+		Encode(cbe.NewEncoder(&sa), v)
+	}
+	for _, v := range []Value{int64(2), a, "same", true} {
+		Encode(cbe.NewEncoder(&sb), v)
+	}
+	cs, err := DiffStreams(cbe.NewDecoder(&sa), cbe.NewDecoder(&sb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = FormatChanges(cs)
+	want = `record 0: . changed: 1 -> 2
+record 3: . added: true
+`
+	if got != want {
+		t.Errorf("DiffStreams gave:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	roundTrip := func(a Arbitrary) bool {
+		b, err := Marshal(a.V)
+		if err != nil {
+			t.Logf("Marshal: %v", err)
+			return false
+		}
+		v, err := Unmarshal(b)
+		if err != nil || !Equal(v, a.V) {
+			return false
+		}
+		j, err := MarshalJSON(a.V)
+		if err != nil {
+			t.Logf("MarshalJSON: %v", err)
+			return false
+		}
+		v, err = UnmarshalJSON(j)
+		return err == nil && Equal(v, a.V)
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+
+	// Generation is deterministic given the source
+	a := Random(rand.New(rand.NewSource(1)), 20)
+	b := Random(rand.New(rand.NewSource(1)), 20)
+	if !Equal(a, b) {
+		t.Error("same seed gave different values")
+	}
+}
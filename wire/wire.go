@@ -0,0 +1,135 @@
+// Package wire frames messages over a network connection
+// as a stream of CBE-encoded blobs, one blob per message.
+//
+// It handles the boilerplate that network users of package cbe
+// would otherwise need to rewrite for each protocol:
+// per-message read and write deadlines, a maximum message size
+// to protect against unbounded buffering of untrusted input,
+// and optional flushing after every message.
+//
+// Early unstable prototype code.
+//
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/bford/cofo/cbe"
+)
+
+// Config holds the configuration options for a message connection.
+// The zero Config imposes no deadlines or size limits
+// and leaves flushing to the caller.
+type Config struct {
+	ReadTimeout  time.Duration // deadline for reading each message
+	WriteTimeout time.Duration // deadline for writing each message
+
+	// Maximum length of a received message's content, or 0 for no limit.
+	MaxMessageLen int64
+
+	// Flush buffered output after writing every message.
+	// Otherwise the caller must call Flush to push out written messages.
+	AutoFlush bool
+}
+
+// Conn reads and writes blob-framed messages on a network connection.
+type Conn struct {
+	c   net.Conn
+	cfg Config
+	bw  *bufio.Writer
+	enc *cbe.Encoder
+	dec *cbe.Decoder
+}
+
+// Create a new message Conn that communicates over nc.
+func (c *Config) NewConn(nc net.Conn) *Conn {
+	bw := bufio.NewWriter(nc)
+	return &Conn{c: nc, cfg: *c, bw: bw,
+		enc: cbe.NewEncoder(bw),
+		dec: cbe.NewDecoder(nc)}
+}
+
+// Write one message to the connection.
+// Unless AutoFlush is configured, the message may remain buffered
+// until the next call to Flush.
+func (c *Conn) WriteMessage(msg []byte) error {
+	if c.cfg.WriteTimeout != 0 {
+		err := c.c.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.enc.Bytes(msg); err != nil {
+		return err
+	}
+	if c.cfg.AutoFlush {
+		return c.bw.Flush()
+	}
+	return nil
+}
+
+// Flush any buffered messages to the underlying connection.
+func (c *Conn) Flush() error {
+	if c.cfg.WriteTimeout != 0 {
+		err := c.c.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+		if err != nil {
+			return err
+		}
+	}
+	return c.bw.Flush()
+}
+
+// Read the next message from the connection.
+//
+// Returns ErrTooLarge if the message's content exceeds MaxMessageLen.
+// The connection is then no longer positioned at a message boundary,
+// so the caller should close it.
+//
+func (c *Conn) ReadMessage() ([]byte, error) {
+	if c.cfg.ReadTimeout != 0 {
+		err := c.c.SetReadDeadline(time.Now().Add(c.cfg.ReadTimeout))
+		if err != nil {
+			return nil, err
+		}
+	}
+	lb := limitBuffer{max: c.cfg.MaxMessageLen}
+	if _, err := c.dec.WriteTo(&lb); err != nil {
+		return nil, err
+	}
+	return lb.buf.Bytes(), nil
+}
+
+// Returns the underlying network connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.c
+}
+
+// Flush any buffered messages and close the underlying connection.
+func (c *Conn) Close() error {
+	err := c.bw.Flush()
+	if cerr := c.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// limitBuffer is a bytes.Buffer that refuses to grow beyond max bytes,
+// unless max is zero.
+type limitBuffer struct {
+	buf bytes.Buffer
+	max int64
+}
+
+func (lb *limitBuffer) Write(p []byte) (int, error) {
+	if lb.max != 0 && int64(lb.buf.Len())+int64(len(p)) > lb.max {
+		return 0, ErrTooLarge
+	}
+	return lb.buf.Write(p)
+}
+
+// ErrTooLarge is returned when a received message exceeds MaxMessageLen.
+var ErrTooLarge = errors.New("message too large")
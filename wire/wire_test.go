@@ -0,0 +1,63 @@
+package wire
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+var testMessages = [][]byte{
+	{},
+	[]byte("x"),
+	[]byte("hello, world"),
+	bytes.Repeat([]byte("0123456789"), 5000), // large multi-chunk
+}
+
+func TestMessages(t *testing.T) {
+	a, b := net.Pipe()
+	wc := (&Config{AutoFlush: true, WriteTimeout: time.Second}).NewConn(a)
+	rc := (&Config{ReadTimeout: time.Second}).NewConn(b)
+
+	go func() {
+		for _, msg := range testMessages {
+			if err := wc.WriteMessage(msg); err != nil {
+				t.Error(err)
+			}
+		}
+		wc.Close()
+	}()
+
+	for i, want := range testMessages {
+		msg, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, want) {
+			t.Errorf("wrong message %v", i)
+		}
+	}
+	if _, err := rc.ReadMessage(); err == nil {
+		t.Error("expected error at end of stream")
+	}
+}
+
+func TestMaxMessageLen(t *testing.T) {
+	a, b := net.Pipe()
+	wc := (&Config{}).NewConn(a)
+	rc := (&Config{MaxMessageLen: 10}).NewConn(b)
+
+	go func() {
+		wc.WriteMessage([]byte("short"))
+		wc.WriteMessage([]byte("much too long"))
+		wc.Close()
+	}()
+
+	if msg, err := rc.ReadMessage(); err != nil || string(msg) != "short" {
+		t.Errorf("got %q, %v", msg, err)
+	}
+	if _, err := rc.ReadMessage(); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge but got %v", err)
+	}
+	rc.Close()
+}